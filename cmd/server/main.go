@@ -65,8 +65,6 @@ func setKiroIncognitoMode(cfg *config.Config, useIncognito, noIncognito bool) {
 // It parses command-line flags, loads configuration, and starts the appropriate
 // service based on the provided flags (login, codex-login, or server mode).
 func main() {
-	fmt.Printf("CLIProxyAPI Version: %s, Commit: %s, BuiltAt: %s\n", buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate)
-
 	// Command-line flags to control the application's behavior.
 	var login bool
 	var codexLogin bool
@@ -82,7 +80,10 @@ func main() {
 	var kiroGoogleLogin bool
 	var kiroAWSLogin bool
 	var kiroAWSAuthCode bool
+	var kiroCognitoLogin bool
 	var kiroImport bool
+	var kiroJSON bool
+	var kiroAccountLabel string
 	var githubCopilotLogin bool
 	var projectID string
 	var vertexImport string
@@ -108,7 +109,10 @@ func main() {
 	flag.BoolVar(&kiroGoogleLogin, "kiro-google-login", false, "Login to Kiro using Google OAuth (same as --kiro-login)")
 	flag.BoolVar(&kiroAWSLogin, "kiro-aws-login", false, "Login to Kiro using AWS Builder ID (device code flow)")
 	flag.BoolVar(&kiroAWSAuthCode, "kiro-aws-authcode", false, "Login to Kiro using AWS Builder ID (authorization code flow, better UX)")
+	flag.BoolVar(&kiroCognitoLogin, "kiro-cognito-login", false, "Login to Kiro with an email/password account (Cognito sign-in page)")
 	flag.BoolVar(&kiroImport, "kiro-import", false, "Import Kiro token from Kiro IDE (~/.aws/sso/cache/kiro-auth-token.json)")
+	flag.BoolVar(&kiroJSON, "json", false, "Kiro login: print only JSON events (verification URL, token or error) to stdout; messages go to stderr")
+	flag.StringVar(&kiroAccountLabel, "account-label", "", "Kiro social login: account label used when the token has no email, instead of prompting")
 	flag.BoolVar(&githubCopilotLogin, "github-copilot-login", false, "Login to GitHub Copilot using device flow")
 	flag.StringVar(&projectID, "project_id", "", "Project ID (Gemini only, not required)")
 	flag.StringVar(&configPath, "config", DefaultConfigPath, "Configure File Path")
@@ -145,6 +149,13 @@ func main() {
 	// Parse the command-line flags.
 	flag.Parse()
 
+	// In JSON mode stdout is reserved for login events.
+	bannerOut := os.Stdout
+	if kiroJSON {
+		bannerOut = os.Stderr
+	}
+	fmt.Fprintf(bannerOut, "CLIProxyAPI Version: %s, Commit: %s, BuiltAt: %s\n", buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate)
+
 	// Core application variables.
 	var err error
 	var cfg *config.Config
@@ -443,6 +454,16 @@ func main() {
 		log.Errorf("failed to configure log output: %v", err)
 		return
 	}
+	if kiroJSON {
+		cfg.KiroAuth.JSONOutput = true
+	}
+	if kiroAccountLabel != "" {
+		cfg.KiroAuth.AccountLabel = kiroAccountLabel
+	}
+	if cfg.KiroAuth.JSONOutput && !cfg.LoggingToFile && (kiroLogin || kiroGoogleLogin || kiroAWSLogin || kiroAWSAuthCode || kiroCognitoLogin) {
+		// Keep stdout for the login's JSON events.
+		log.SetOutput(os.Stderr)
+	}
 
 	log.Infof("CLIProxyAPI Version: %s, Commit: %s, BuiltAt: %s", buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate)
 
@@ -527,6 +548,10 @@ func main() {
 		// For Kiro auth with authorization code flow (better UX)
 		setKiroIncognitoMode(cfg, useIncognito, noIncognito)
 		cmd.DoKiroAWSAuthCodeLogin(cfg, options)
+	} else if kiroCognitoLogin {
+		// For Kiro email/password accounts (Cognito hosted UI)
+		setKiroIncognitoMode(cfg, useIncognito, noIncognito)
+		cmd.DoKiroCognitoLogin(cfg, options)
 	} else if kiroImport {
 		cmd.DoKiroImport(cfg, options)
 	} else {
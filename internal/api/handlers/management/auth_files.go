@@ -430,6 +430,14 @@ func (h *Handler) buildAuthFileEntry(auth *coreauth.Auth) gin.H {
 	if claims := extractCodexIDTokenClaims(auth); claims != nil {
 		entry["id_token"] = claims
 	}
+	if strings.EqualFold(strings.TrimSpace(auth.Provider), "kiro") {
+		if provenance := kiroTokenProvenance(auth, time.Now()); provenance != nil {
+			entry["provenance"] = provenance
+		}
+		if backfill := kiroProfileArnBackfill(auth); backfill != nil {
+			entry["profile_arn_backfill"] = backfill
+		}
+	}
 	return entry
 }
 
@@ -2477,6 +2485,12 @@ func (h *Handler) RequestKiroToken(c *gin.Context) {
 							"last_refresh":  now.Format(time.RFC3339),
 						},
 					}
+					for key, value := range kiroauth.StampLoginProvenance(&kiroauth.KiroTokenData{}, kiroauth.LoginMethodWebDeviceCode).ProvenanceMetadata() {
+						record.Metadata[key] = value
+					}
+					if tokenResp.RefreshToken == "" {
+						record.Metadata["non_refreshable"] = true
+					}
 
 					savedPath, errSave := h.saveTokenRecord(ctx, record)
 					if errSave != nil {
@@ -2604,6 +2618,9 @@ func (h *Handler) RequestKiroToken(c *gin.Context) {
 					}
 					expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 					email := kiroauth.ExtractEmailFromJWT(tokenResp.AccessToken)
+					if email == "" {
+						email = kiroauth.FallbackAccountLabel(h.cfg.KiroAuth.FallbackLabelTemplate, provider, tokenResp.AccessToken)
+					}
 
 					idPart := kiroauth.SanitizeEmailForFilename(email)
 					if idPart == "" {
@@ -2629,6 +2646,9 @@ func (h *Handler) RequestKiroToken(c *gin.Context) {
 							"last_refresh":  now.Format(time.RFC3339),
 						},
 					}
+					for key, value := range kiroauth.StampLoginProvenance(&kiroauth.KiroTokenData{}, kiroauth.LoginMethodWebSocial).ProvenanceMetadata() {
+						record.Metadata[key] = value
+					}
 
 					savedPath, errSave := h.saveTokenRecord(ctx, record)
 					if errSave != nil {
@@ -0,0 +1,100 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kiroauth "github.com/router-for-me/CLIProxyAPI/v6/internal/auth/kiro"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+)
+
+// kiroOIDCClientStore returns the OIDC client store in the configured auth directory.
+func (h *Handler) kiroOIDCClientStore() (*kiroauth.OIDCClientStore, error) {
+	authDir, err := util.ResolveAuthDir(h.cfg.AuthDir)
+	if err != nil {
+		return nil, err
+	}
+	return kiroauth.NewOIDCClientStore(authDir), nil
+}
+
+// ListKiroOIDCClients lists the OIDC clients this proxy has registered. Secrets are omitted.
+// GET /v0/management/kiro/oidc-clients
+func (h *Handler) ListKiroOIDCClients(c *gin.Context) {
+	store, err := h.kiroOIDCClientStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := store.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	clients := make([]gin.H, 0, len(records))
+	for _, record := range records {
+		entry := gin.H{
+			"client_id":  record.ClientID,
+			"endpoint":   record.Endpoint,
+			"grant_type": record.GrantType,
+			"issued_at":  record.IssuedAt,
+			"expired":    record.Expired(now),
+		}
+		if record.RedirectURI != "" {
+			entry["redirect_uri"] = record.RedirectURI
+		}
+		if record.StartURL != "" {
+			entry["start_url"] = record.StartURL
+		}
+		if len(record.Scopes) > 0 {
+			entry["scopes"] = record.Scopes
+		}
+		if !record.ExpiresAt.IsZero() {
+			entry["expires_at"] = record.ExpiresAt
+		}
+		clients = append(clients, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// DeleteKiroOIDCClients forgets a registered OIDC client (?client-id=...) or, with
+// ?expired=true, prunes every client whose secret has expired. AWS SSO OIDC offers no API to
+// revoke a client, so this only stops the proxy from reusing it; the registration lapses on
+// its own when the secret expires.
+// DELETE /v0/management/kiro/oidc-clients
+func (h *Handler) DeleteKiroOIDCClients(c *gin.Context) {
+	store, err := h.kiroOIDCClientStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if clientID := strings.TrimSpace(c.Query("client-id")); clientID != "" {
+		removed, errRemove := store.Remove(clientID)
+		if errRemove != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errRemove.Error()})
+			return
+		}
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "removed": 1})
+		return
+	}
+
+	if strings.EqualFold(strings.TrimSpace(c.Query("expired")), "true") {
+		removed, errPrune := store.PruneExpired(time.Now())
+		if errPrune != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errPrune.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "removed": removed})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "client-id or expired=true is required"})
+}
@@ -2,9 +2,11 @@ package management
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 )
 
@@ -14,7 +16,7 @@ type UnavailableModelInfo struct {
 	ModelName  string    `json:"model_name"`
 	Provider   string    `json:"provider"`
 	ClientID   string    `json:"client_id"`
-	Reason     string    `json:"reason"`      // "quota_exceeded", "suspended", "cooldown"
+	Reason     string    `json:"reason"`      // "quota_exceeded", "suspended", "cooldown", "manual"
 	ReasonText string    `json:"reason_text"` // 详细原因描述
 	Since      time.Time `json:"since"`       // 不可用开始时间
 }
@@ -30,10 +32,32 @@ func (h *Handler) GetUnavailableModels(c *gin.Context) {
 	now := time.Now()
 	quotaExpiredDuration := 5 * time.Minute
 
+	// 手动禁用的模型始终报告为不可用
+	for _, modelID := range reg.DeniedModels() {
+		info := UnavailableModelInfo{
+			ModelID:    modelID,
+			ModelName:  modelID,
+			Reason:     "manual",
+			ReasonText: "已手动禁用",
+		}
+		if registration := findRegistration(models, modelID); registration != nil {
+			info.ModelID = registration.Info.ID
+			info.Since = registration.LastUpdated
+			if registration.Info.DisplayName != "" {
+				info.ModelName = registration.Info.DisplayName
+			}
+		}
+		unavailableModels = append(unavailableModels, info)
+	}
+
 	for modelID, registration := range models {
 		if registration == nil {
 			continue
 		}
+		// 手动禁用或手动放行的模型忽略配额与暂停信号
+		if reg.IsModelDenied(modelID) || reg.IsModelAllowed(modelID) {
+			continue
+		}
 
 		// 检查配额超限的客户端
 		if registration.QuotaExceededClients != nil {
@@ -86,9 +110,15 @@ func (h *Handler) GetUnavailableModels(c *gin.Context) {
 		}
 	}
 
+	concurrency := reg.ModelConcurrencyStatuses()
+	if concurrency == nil {
+		concurrency = make([]registry.ModelConcurrencyStatus, 0)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"models": unavailableModels,
-		"count":  len(unavailableModels),
+		"models":      unavailableModels,
+		"count":       len(unavailableModels),
+		"concurrency": concurrency,
 	})
 }
 
@@ -127,3 +157,102 @@ func (h *Handler) ResetModelAvailability(c *gin.Context) {
 		"client_id": req.ClientID,
 	})
 }
+
+// findRegistration 按不区分大小写的模型 ID 查找注册信息
+func findRegistration(models map[string]*registry.ModelRegistration, modelID string) *registry.ModelRegistration {
+	for id, registration := range models {
+		if registration != nil && registration.Info != nil && strings.EqualFold(id, modelID) {
+			return registration
+		}
+	}
+	return nil
+}
+
+// GetModelOverrides 返回手动禁用/放行的模型列表
+// GET /v0/management/model-overrides
+func (h *Handler) GetModelOverrides(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"deny":  nonNilStrings(h.cfg.ModelAvailability.Deny),
+		"allow": nonNilStrings(h.cfg.ModelAvailability.Allow),
+	})
+}
+
+// ModelOverrideRequest 设置模型手动覆盖请求
+type ModelOverrideRequest struct {
+	Model  string `json:"model" binding:"required"`
+	Action string `json:"action" binding:"required"` // "deny" 或 "allow"
+}
+
+// SetModelOverride 将模型加入手动禁用列表或放行列表，并持久化到配置
+// POST /v0/management/model-overrides
+func (h *Handler) SetModelOverride(c *gin.Context) {
+	var req ModelOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	model := strings.ToLower(strings.TrimSpace(req.Model))
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	overrides := &h.cfg.ModelAvailability
+	switch strings.ToLower(strings.TrimSpace(req.Action)) {
+	case "deny":
+		overrides.Allow = removeModel(overrides.Allow, model)
+		overrides.Deny = config.NormalizeExcludedModels(append(overrides.Deny, model))
+	case "allow":
+		overrides.Deny = removeModel(overrides.Deny, model)
+		overrides.Allow = config.NormalizeExcludedModels(append(overrides.Allow, model))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be deny or allow"})
+		return
+	}
+	h.applyModelOverrides()
+	h.persist(c)
+}
+
+// DeleteModelOverride 移除模型的手动覆盖
+// DELETE /v0/management/model-overrides?model=xxx
+func (h *Handler) DeleteModelOverride(c *gin.Context) {
+	model := strings.ToLower(strings.TrimSpace(c.Query("model")))
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing model"})
+		return
+	}
+	overrides := &h.cfg.ModelAvailability
+	deny := removeModel(overrides.Deny, model)
+	allow := removeModel(overrides.Allow, model)
+	if len(deny) == len(overrides.Deny) && len(allow) == len(overrides.Allow) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model override not found"})
+		return
+	}
+	overrides.Deny, overrides.Allow = deny, allow
+	h.applyModelOverrides()
+	h.persist(c)
+}
+
+func (h *Handler) applyModelOverrides() {
+	registry.GetGlobalRegistry().SetModelOverrides(h.cfg.ModelAvailability.Deny, h.cfg.ModelAvailability.Allow)
+}
+
+func removeModel(models []string, model string) []string {
+	out := make([]string, 0, len(models))
+	for _, existing := range models {
+		if !strings.EqualFold(existing, model) {
+			out = append(out, existing)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
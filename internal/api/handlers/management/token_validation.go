@@ -0,0 +1,150 @@
+package management
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kiroauth "github.com/router-for-me/CLIProxyAPI/v6/internal/auth/kiro"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+// tokenValidationTimeout bounds the upstream probe made by ValidateToken.
+const tokenValidationTimeout = 30 * time.Second
+
+// ValidateToken checks a single stored credential against its upstream on demand.
+// POST /v0/management/tokens/:id/validate
+func (h *Handler) ValidateToken(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+
+	auth := h.findAuthByIDOrName(id)
+	if auth == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+
+	provider := strings.ToLower(strings.TrimSpace(auth.Provider))
+	if provider != "kiro" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validation is not supported for provider " + auth.Provider})
+		return
+	}
+
+	tokenData := kiroTokenDataFromAuth(auth)
+	if tokenData.AccessToken == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "token has no access token"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), tokenValidationTimeout)
+	defer cancel()
+
+	result := kiroauth.NewKiroAuth(h.cfg).CheckToken(ctx, tokenData)
+	response := gin.H{
+		"id":         auth.ID,
+		"provider":   auth.Provider,
+		"expires_at": tokenData.ExpiresAt,
+		"result":     result,
+	}
+	if provenance := kiroTokenProvenance(auth, time.Now()); provenance != nil {
+		response["provenance"] = provenance
+	}
+	if backfill := kiroProfileArnBackfill(auth); backfill != nil {
+		response["profile_arn_backfill"] = backfill
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// kiroTokenProvenance reports when, where and how a Kiro token was obtained, plus its age.
+// It returns nil when the auth carries no provenance, e.g. tokens imported from the IDE.
+func kiroTokenProvenance(auth *coreauth.Auth, now time.Time) gin.H {
+	issuedAt := authMetadataString(auth, "issued_at", "issuedAt")
+	loginHost := authMetadataString(auth, "login_host", "loginHost")
+	loginMethod := authMetadataString(auth, "login_method", "loginMethod")
+	if issuedAt == "" && loginHost == "" && loginMethod == "" {
+		return nil
+	}
+	provenance := gin.H{}
+	if issuedAt != "" {
+		provenance["issued_at"] = issuedAt
+		if issued, err := time.Parse(time.RFC3339, issuedAt); err == nil {
+			provenance["age_seconds"] = int64(now.Sub(issued).Seconds())
+		}
+	}
+	if loginHost != "" {
+		provenance["login_host"] = loginHost
+	}
+	if loginMethod != "" {
+		provenance["login_method"] = loginMethod
+	}
+	return provenance
+}
+
+// kiroProfileArnBackfill reports the outcome of the executor's profile ARN lookup for a token
+// stored without one. It returns nil when no backfill was attempted.
+func kiroProfileArnBackfill(auth *coreauth.Auth) gin.H {
+	status := authMetadataString(auth, "profile_arn_backfill")
+	if status == "" {
+		return nil
+	}
+	backfill := gin.H{
+		"status":    status,
+		"succeeded": status == "succeeded",
+	}
+	if attemptedAt := authMetadataString(auth, "profile_arn_backfill_at"); attemptedAt != "" {
+		backfill["attempted_at"] = attemptedAt
+	}
+	return backfill
+}
+
+// findAuthByIDOrName resolves an auth by its ID, falling back to the auth file name.
+func (h *Handler) findAuthByIDOrName(name string) *coreauth.Auth {
+	if auth, ok := h.authManager.GetByID(name); ok {
+		return auth
+	}
+	for _, auth := range h.authManager.List() {
+		if auth.FileName == name {
+			return auth
+		}
+	}
+	return nil
+}
+
+// kiroTokenDataFromAuth extracts the Kiro token fields stored in auth metadata.
+func kiroTokenDataFromAuth(auth *coreauth.Auth) *kiroauth.KiroTokenData {
+	tokenData := &kiroauth.KiroTokenData{
+		AccessToken: authMetadataString(auth, "access_token", "accessToken"),
+		ProfileArn:  authMetadataString(auth, "profile_arn", "profileArn"),
+		ExpiresAt:   authMetadataString(auth, "expires_at", "expiresAt"),
+		AuthMethod:  authMetadataString(auth, "auth_method", "authMethod"),
+	}
+	if tokenData.AccessToken == "" {
+		tokenData.AccessToken = authAttribute(auth, "access_token")
+	}
+	if tokenData.ProfileArn == "" {
+		tokenData.ProfileArn = authAttribute(auth, "profile_arn")
+	}
+	return tokenData
+}
+
+func authMetadataString(auth *coreauth.Auth, keys ...string) string {
+	if auth == nil || auth.Metadata == nil {
+		return ""
+	}
+	for _, key := range keys {
+		if v, ok := auth.Metadata[key].(string); ok && strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -9,12 +9,6 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 )
 
-type usageExportPayload struct {
-	Version    int                      `json:"version"`
-	ExportedAt time.Time                `json:"exported_at"`
-	Usage      usage.StatisticsSnapshot `json:"usage"`
-}
-
 type usageImportPayload struct {
 	Version int                      `json:"version"`
 	Usage   usage.StatisticsSnapshot `json:"usage"`
@@ -32,17 +26,61 @@ func (h *Handler) GetUsageStatistics(c *gin.Context) {
 	})
 }
 
+// GetUsageRange returns per-day and per-model usage for a date range.
+// Query parameters from and to are inclusive dates ("2006-01-02"); to defaults to today
+// and from to 29 days before to.
+func (h *Handler) GetUsageRange(c *gin.Context) {
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, raw, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -29)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, raw, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = parsed
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	var stats usage.RangeStats
+	if h != nil && h.usageStats != nil {
+		stats = h.usageStats.QueryRange(from, to)
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 // ExportUsageStatistics returns a complete usage snapshot for backup/migration.
+// The response has the shape {"version":1,"exported_at":...,"usage":{...}}.
 func (h *Handler) ExportUsageStatistics(c *gin.Context) {
 	var snapshot usage.StatisticsSnapshot
 	if h != nil && h.usageStats != nil {
 		snapshot = h.usageStats.Snapshot()
 	}
-	c.JSON(http.StatusOK, usageExportPayload{
-		Version:    1,
-		ExportedAt: time.Now().UTC(),
-		Usage:      snapshot,
-	})
+	exportedAt, _ := json.Marshal(time.Now().UTC())
+
+	// Stream the payload so large detail histories are never buffered as a single blob.
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := c.Writer
+	if _, err := w.WriteString(`{"version":1,"exported_at":` + string(exportedAt) + `,"usage":`); err != nil {
+		return
+	}
+	if err := usage.WriteSnapshotJSON(w, snapshot); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	_, _ = w.WriteString("}")
 }
 
 // ImportUsageStatistics merges a previously exported usage snapshot into memory.
@@ -29,6 +29,8 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/managementasset"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
@@ -206,6 +208,23 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	}
 	// Initialize usage stats storage
 	usage.InitStatsStorage(cfg.UsageStatisticsCache)
+	usage.SetPriceTable(cfg.UsagePricing)
+	usage.SetAPIKeyPlans(cfg.UsageAPIKeys, cfg.UsagePlans)
+	usage.SetMetricsAPIKeyLabel(cfg.UsageMetrics.APIKeyLabel)
+	usage.ConfigureMetricsPush(cfg.UsageMetricsPush)
+	usage.RegisterCounter("cliproxy_kiro_missing_expiry_total", "Kiro token responses without a valid expiresIn, for which a 1 hour expiry was assumed.", func() float64 {
+		return float64(kiro.MissingExpiryCount())
+	})
+	notify.Configure(cfg.Notifications)
+	kiro.GetGlobalRateLimiter().SetOnSuspended(notify.TokenSuspended)
+	registry.GetGlobalRegistry().SetAvailabilityHook(notify.ProviderAvailabilityHook())
+	kiro.GetRefreshManager().SetOnRefreshCycle(notify.RefreshCycle)
+	registry.GetGlobalRegistry().SetModelOverrides(cfg.ModelAvailability.Deny, cfg.ModelAvailability.Allow)
+	registry.GetGlobalRegistry().SetModelConcurrencyLimits(cfg.ModelAvailability.MaxConcurrency)
+	kiro.GetGlobalRateLimiter().SetDailyIdleDecay(cfg.KiroRateLimit.DailyIdleDecayPerMinute)
+	kiro.GetGlobalRateLimiter().SetSuspendStatusCodes(cfg.KiroRateLimit.SuspendStatusCodes)
+	kiro.GetGlobalRateLimiter().SetDailyResetCheckInterval(cfg.KiroRateLimit.DailyResetCheckInterval)
+	kiro.GetGlobalRateLimiter().SetSharedState(cfg.KiroRateLimit.SharedState)
 
 	// Create gin engine
 	engine := gin.New()
@@ -214,6 +233,12 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	}
 
 	// Add middleware
+	logging.SetAIAPIPaths(cfg.RequestIDPaths)
+	logging.SetRequestIDHeader(cfg.RequestIDHeader)
+	logging.SetLogRedaction(cfg.LogRedaction.Enable, cfg.LogRedaction.HashKey)
+	logging.SetSlowRequestThreshold(time.Duration(cfg.SlowRequestThreshold) * time.Second)
+	logging.SetModelPeekKB(cfg.ModelPeekKB)
+	logging.SetBodyLogging(cfg.BodyLogging.Enable, cfg.BodyLogging.MaxKB)
 	engine.Use(logging.GinLogrusLogger())
 	engine.Use(logging.GinLogrusRecovery())
 	for _, mw := range optionState.extraMiddleware {
@@ -366,6 +391,9 @@ func (s *Server) setupRoutes() {
 		})
 	})
 
+	// Prometheus scrape endpoint for usage statistics, served only when usage-metrics is enabled
+	s.engine.GET("/metrics", s.serveUsageMetrics)
+
 	// Event logging endpoint - handles Claude Code telemetry requests
 	// Returns 200 OK to prevent 404 errors in logs
 	s.engine.POST("/api/event_logging/batch", func(c *gin.Context) {
@@ -515,6 +543,7 @@ func (s *Server) registerManagementRoutes() {
 	{
 		mgmt.GET("/usage", s.mgmt.GetUsageStatistics)
 		mgmt.GET("/usage/export", s.mgmt.ExportUsageStatistics)
+		mgmt.GET("/usage/range", s.mgmt.GetUsageRange)
 		mgmt.POST("/usage/import", s.mgmt.ImportUsageStatistics)
 		mgmt.GET("/config", s.mgmt.GetConfig)
 		mgmt.GET("/config.yaml", s.mgmt.GetConfigYAML)
@@ -654,6 +683,9 @@ func (s *Server) registerManagementRoutes() {
 		mgmt.POST("/auth-files", s.mgmt.UploadAuthFile)
 		mgmt.DELETE("/auth-files", s.mgmt.DeleteAuthFile)
 		mgmt.PATCH("/auth-files/status", s.mgmt.PatchAuthFileStatus)
+		mgmt.POST("/tokens/:id/validate", s.mgmt.ValidateToken)
+		mgmt.GET("/kiro/oidc-clients", s.mgmt.ListKiroOIDCClients)
+		mgmt.DELETE("/kiro/oidc-clients", s.mgmt.DeleteKiroOIDCClients)
 		mgmt.POST("/vertex/import", s.mgmt.ImportVertexCredential)
 
 		mgmt.GET("/anthropic-auth-url", s.mgmt.RequestAnthropicToken)
@@ -672,6 +704,9 @@ func (s *Server) registerManagementRoutes() {
 		// Model availability endpoints
 		mgmt.GET("/model-availability", s.mgmt.GetUnavailableModels)
 		mgmt.POST("/model-availability/:model_id/reset", s.mgmt.ResetModelAvailability)
+		mgmt.GET("/model-overrides", s.mgmt.GetModelOverrides)
+		mgmt.POST("/model-overrides", s.mgmt.SetModelOverride)
+		mgmt.DELETE("/model-overrides", s.mgmt.DeleteModelOverride)
 	}
 }
 
@@ -685,6 +720,21 @@ func (s *Server) managementAvailabilityMiddleware() gin.HandlerFunc {
 	}
 }
 
+// serveUsageMetrics renders the usage statistics in the Prometheus text exposition format.
+func (s *Server) serveUsageMetrics(c *gin.Context) {
+	cfg := s.cfg
+	if cfg == nil || !cfg.UsageMetrics.Enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := usage.WritePrometheusScrape(c.Writer, usage.GetStatsStorage().Snapshot()); err != nil {
+		log.WithError(err).Warn("failed to write usage metrics")
+	}
+}
+
 func (s *Server) serveManagementControlPanel(c *gin.Context) {
 	cfg := s.cfg
 	if cfg == nil || cfg.RemoteManagement.DisableControlPanel {
@@ -846,6 +896,8 @@ func (s *Server) Stop(ctx context.Context) error {
 		}
 	}
 
+	usage.StopMetricsPush()
+
 	// Shutdown the HTTP server.
 	if err := s.server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown HTTP server: %v", err)
@@ -910,16 +962,38 @@ func (s *Server) UpdateClients(cfg *config.Config) {
 		}
 	}
 
-	if oldCfg == nil || oldCfg.LoggingToFile != cfg.LoggingToFile || oldCfg.LogsMaxTotalSizeMB != cfg.LogsMaxTotalSizeMB {
+	if oldCfg == nil || oldCfg.LoggingToFile != cfg.LoggingToFile || oldCfg.LogsMaxTotalSizeMB != cfg.LogsMaxTotalSizeMB || oldCfg.LogFormat != cfg.LogFormat {
 		if err := logging.ConfigureLogOutput(cfg); err != nil {
 			log.Errorf("failed to reconfigure log output: %v", err)
 		}
 	}
 
+	logging.SetAIAPIPaths(cfg.RequestIDPaths)
+	logging.SetRequestIDHeader(cfg.RequestIDHeader)
+	if oldCfg == nil || oldCfg.LogRedaction != cfg.LogRedaction {
+		logging.SetLogRedaction(cfg.LogRedaction.Enable, cfg.LogRedaction.HashKey)
+	}
+	logging.SetSlowRequestThreshold(time.Duration(cfg.SlowRequestThreshold) * time.Second)
+	logging.SetModelPeekKB(cfg.ModelPeekKB)
+	logging.SetBodyLogging(cfg.BodyLogging.Enable, cfg.BodyLogging.MaxKB)
+
 	if oldCfg == nil || oldCfg.UsageStatisticsEnabled != cfg.UsageStatisticsEnabled {
 		usage.SetStatisticsEnabled(cfg.UsageStatisticsEnabled)
 	}
 
+	usage.SetPriceTable(cfg.UsagePricing)
+	usage.SetAPIKeyPlans(cfg.UsageAPIKeys, cfg.UsagePlans)
+	usage.SetMetricsAPIKeyLabel(cfg.UsageMetrics.APIKeyLabel)
+	usage.ConfigureMetricsPush(cfg.UsageMetricsPush)
+	notify.Configure(cfg.Notifications)
+	registry.GetGlobalRegistry().SetAvailabilityHook(notify.ProviderAvailabilityHook())
+	registry.GetGlobalRegistry().SetModelOverrides(cfg.ModelAvailability.Deny, cfg.ModelAvailability.Allow)
+	registry.GetGlobalRegistry().SetModelConcurrencyLimits(cfg.ModelAvailability.MaxConcurrency)
+	kiro.GetGlobalRateLimiter().SetDailyIdleDecay(cfg.KiroRateLimit.DailyIdleDecayPerMinute)
+	kiro.GetGlobalRateLimiter().SetSuspendStatusCodes(cfg.KiroRateLimit.SuspendStatusCodes)
+	kiro.GetGlobalRateLimiter().SetDailyResetCheckInterval(cfg.KiroRateLimit.DailyResetCheckInterval)
+	kiro.GetGlobalRateLimiter().SetSharedState(cfg.KiroRateLimit.SharedState)
+
 	if s.requestLogger != nil && (oldCfg == nil || oldCfg.ErrorLogsMaxFiles != cfg.ErrorLogsMaxFiles) {
 		if setter, ok := s.requestLogger.(interface{ SetErrorLogsMaxFiles(int) }); ok {
 			setter.SetErrorLogsMaxFiles(cfg.ErrorLogsMaxFiles)
@@ -1053,6 +1127,7 @@ func AuthMiddleware(manager *sdkaccess.Manager) gin.HandlerFunc {
 			if result != nil {
 				c.Set("apiKey", result.Principal)
 				c.Set("accessProvider", result.Provider)
+				usage.AttributeRequest(c, result.Principal)
 				if len(result.Metadata) > 0 {
 					c.Set("accessMetadata", result.Metadata)
 				}
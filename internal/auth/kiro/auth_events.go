@@ -0,0 +1,152 @@
+package kiro
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+// AuthEventHandler observes the progress of a login flow, e.g. so a GUI can render the device
+// code itself. Callbacks run synchronously on the login goroutine and should return quickly.
+// SSOOIDCClient and SocialAuthClient use terminal output (plus JSON events in JSON output mode)
+// unless Events is set.
+type AuthEventHandler interface {
+	// OnClientRegistered is called once an OIDC client registration is available.
+	OnClientRegistered(clientID string)
+	// OnDeviceCodeIssued is called when a device-code flow has a user code to confirm.
+	OnDeviceCodeIssued(auth *StartDeviceAuthResponse)
+	// OnAuthorizationURL is called when a browser-based flow has its authorize URL.
+	OnAuthorizationURL(url string)
+	// OnPollTick is called after each device-code poll that is still waiting, with
+	// ErrAuthorizationPending or ErrSlowDown.
+	OnPollTick(err error)
+	// OnTokenIssued is called when the login completes.
+	OnTokenIssued(token *KiroTokenData)
+	// OnError is called when the login fails.
+	OnError(err error)
+}
+
+// defaultAuthEvents is the handler used when a client has none set.
+func defaultAuthEvents(cfg *config.Config) AuthEventHandler {
+	terminal := terminalAuthEvents{out: LoginOutput(cfg)}
+	if jsonLoginOutput(cfg) {
+		return authEventFanout{terminal, jsonAuthEvents{cfg: cfg}}
+	}
+	return terminal
+}
+
+// terminalAuthEvents prints login progress for CLI users.
+type terminalAuthEvents struct {
+	out io.Writer
+}
+
+func (terminalAuthEvents) OnClientRegistered(string) {}
+
+func (h terminalAuthEvents) OnDeviceCodeIssued(auth *StartDeviceAuthResponse) {
+	fmt.Fprintf(h.out, "\n")
+	fmt.Fprintln(h.out, "════════════════════════════════════════════════════════════")
+	fmt.Fprintf(h.out, "  Open this URL in your browser:\n")
+	fmt.Fprintf(h.out, "  %s\n", auth.VerificationURIComplete)
+	fmt.Fprintln(h.out, "════════════════════════════════════════════════════════════")
+	fmt.Fprintf(h.out, "\n  Or go to: %s\n", auth.VerificationURI)
+	fmt.Fprintf(h.out, "  And enter code: %s\n\n", auth.UserCode)
+}
+
+func (h terminalAuthEvents) OnAuthorizationURL(url string) {
+	fmt.Fprintf(h.out, "\n  URL: %s\n\n", url)
+}
+
+func (h terminalAuthEvents) OnPollTick(err error) {
+	if errors.Is(err, ErrAuthorizationPending) {
+		fmt.Fprint(h.out, ".")
+	}
+}
+
+func (terminalAuthEvents) OnTokenIssued(*KiroTokenData) {}
+
+func (h terminalAuthEvents) OnError(err error) {
+	if hint := tokenErrorHint(err); hint != "" {
+		fmt.Fprintf(h.out, "\n\n✗ %s\n", hint)
+	}
+}
+
+// jsonAuthEvents writes login events as JSON lines to stdout.
+type jsonAuthEvents struct {
+	cfg *config.Config
+}
+
+func (jsonAuthEvents) OnClientRegistered(string) {}
+
+func (h jsonAuthEvents) OnDeviceCodeIssued(auth *StartDeviceAuthResponse) {
+	emitLoginEvent(h.cfg, LoginEvent{
+		Event:                   LoginEventVerification,
+		VerificationURI:         auth.VerificationURI,
+		VerificationURIComplete: auth.VerificationURIComplete,
+		UserCode:                auth.UserCode,
+		ExpiresIn:               auth.ExpiresIn,
+	})
+}
+
+func (h jsonAuthEvents) OnAuthorizationURL(url string) {
+	emitLoginEvent(h.cfg, LoginEvent{Event: LoginEventAuthorizeURL, URL: url})
+}
+
+func (jsonAuthEvents) OnPollTick(error) {}
+
+func (h jsonAuthEvents) OnTokenIssued(token *KiroTokenData) {
+	emitLoginEvent(h.cfg, LoginEvent{Event: LoginEventToken, Token: token})
+}
+
+func (h jsonAuthEvents) OnError(err error) {
+	emitLoginEvent(h.cfg, LoginEvent{Event: LoginEventError, Error: err.Error()})
+}
+
+// authEventFanout forwards each event to every handler in order.
+type authEventFanout []AuthEventHandler
+
+func (f authEventFanout) OnClientRegistered(clientID string) {
+	for _, h := range f {
+		h.OnClientRegistered(clientID)
+	}
+}
+
+func (f authEventFanout) OnDeviceCodeIssued(auth *StartDeviceAuthResponse) {
+	for _, h := range f {
+		h.OnDeviceCodeIssued(auth)
+	}
+}
+
+func (f authEventFanout) OnAuthorizationURL(url string) {
+	for _, h := range f {
+		h.OnAuthorizationURL(url)
+	}
+}
+
+func (f authEventFanout) OnPollTick(err error) {
+	for _, h := range f {
+		h.OnPollTick(err)
+	}
+}
+
+func (f authEventFanout) OnTokenIssued(token *KiroTokenData) {
+	for _, h := range f {
+		h.OnTokenIssued(token)
+	}
+}
+
+func (f authEventFanout) OnError(err error) {
+	for _, h := range f {
+		h.OnError(err)
+	}
+}
+
+// finishLogin reports the outcome of a login flow to events.
+func finishLogin(events AuthEventHandler, token *KiroTokenData, err error) {
+	if err != nil {
+		events.OnError(err)
+		return
+	}
+	events.OnTokenIssued(token)
+}
@@ -3,7 +3,9 @@
 package kiro
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -49,6 +51,57 @@ type KiroTokenData struct {
 	StartURL string `json:"startUrl,omitempty"`
 	// Region is the AWS region for IDC authentication (only for IDC auth method)
 	Region string `json:"region,omitempty"`
+	// IssuedAt is when the token was first obtained by an interactive login (RFC3339)
+	IssuedAt string `json:"issuedAt,omitempty"`
+	// LoginHost is the hostname of the machine that performed the login
+	LoginHost string `json:"loginHost,omitempty"`
+	// LoginMethod is the login flow that produced the token (e.g., "device-code", "auth-code", "social")
+	LoginMethod string `json:"loginMethod,omitempty"`
+	// NonRefreshable marks a token issued without a refresh token; it must be replaced by a new login
+	NonRefreshable bool `json:"nonRefreshable,omitempty"`
+	// LastRefreshedAt is when the token was last successfully refreshed (RFC3339)
+	LastRefreshedAt string `json:"lastRefreshedAt,omitempty"`
+}
+
+// Login flows recorded in KiroTokenData.LoginMethod.
+const (
+	LoginMethodDeviceCode    = "device-code"
+	LoginMethodAuthCode      = "auth-code"
+	LoginMethodSocial        = "social"
+	LoginMethodWebDeviceCode = "web-device-code"
+	LoginMethodWebSocial     = "web-social"
+)
+
+// StampLoginProvenance records when, where and how a token was obtained by a login flow.
+// It returns tokenData for use in return statements.
+func StampLoginProvenance(tokenData *KiroTokenData, loginMethod string) *KiroTokenData {
+	if tokenData == nil {
+		return nil
+	}
+	tokenData.IssuedAt = time.Now().UTC().Format(time.RFC3339)
+	tokenData.LoginMethod = loginMethod
+	if host, err := os.Hostname(); err == nil {
+		tokenData.LoginHost = host
+	}
+	return tokenData
+}
+
+// ProvenanceMetadata returns the login provenance as auth metadata entries; unset fields are omitted.
+func (d *KiroTokenData) ProvenanceMetadata() map[string]any {
+	metadata := make(map[string]any, 3)
+	if d == nil {
+		return metadata
+	}
+	if d.IssuedAt != "" {
+		metadata["issued_at"] = d.IssuedAt
+	}
+	if d.LoginHost != "" {
+		metadata["login_host"] = d.LoginHost
+	}
+	if d.LoginMethod != "" {
+		metadata["login_method"] = d.LoginMethod
+	}
+	return metadata
 }
 
 // KiroAuthBundle aggregates authentication data after OAuth flow completion
@@ -358,18 +411,18 @@ type JWTClaims struct {
 	Iss           string `json:"iss,omitempty"`
 }
 
-// ExtractEmailFromJWT extracts the user's email from a JWT access token.
+// ParseJWTClaims decodes the payload of a JWT access token without verifying its signature.
 // JWT tokens typically have format: header.payload.signature
 // The payload is base64url-encoded JSON containing user claims.
-func ExtractEmailFromJWT(accessToken string) string {
+func ParseJWTClaims(accessToken string) (*JWTClaims, bool) {
 	if accessToken == "" {
-		return ""
+		return nil, false
 	}
 
 	// JWT format: header.payload.signature
 	parts := strings.Split(accessToken, ".")
 	if len(parts) != 3 {
-		return ""
+		return nil, false
 	}
 
 	// Decode the payload (second part)
@@ -388,12 +441,21 @@ func ExtractEmailFromJWT(accessToken string) string {
 		// Try RawURLEncoding (no padding)
 		decoded, err = base64.RawURLEncoding.DecodeString(parts[1])
 		if err != nil {
-			return ""
+			return nil, false
 		}
 	}
 
 	var claims JWTClaims
 	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// ExtractEmailFromJWT extracts the user's email from a JWT access token.
+func ExtractEmailFromJWT(accessToken string) string {
+	claims, ok := ParseJWTClaims(accessToken)
+	if !ok {
 		return ""
 	}
 
@@ -415,6 +477,48 @@ func ExtractEmailFromJWT(accessToken string) string {
 	return ""
 }
 
+// FallbackAccountLabel renders the configured label template for a token whose JWT and
+// userinfo carry no email. Supported placeholders are {provider}, {sub} and
+// {preferred_username}, e.g. "{provider}-user-{sub}@example.internal".
+// It returns "" when the template is empty, has no per-account placeholder ({sub} or
+// {preferred_username}) or uses a claim the token does not carry, so callers fall back to
+// their default naming instead of giving every account the same label.
+func FallbackAccountLabel(template, provider, accessToken string) string {
+	template = strings.TrimSpace(template)
+	if !strings.Contains(template, "{sub}") && !strings.Contains(template, "{preferred_username}") {
+		return ""
+	}
+	claims, ok := ParseJWTClaims(accessToken)
+	if !ok {
+		claims = &JWTClaims{}
+	}
+	values := map[string]string{
+		"{provider}":           strings.ToLower(strings.TrimSpace(provider)),
+		"{sub}":                strings.TrimSpace(claims.Sub),
+		"{preferred_username}": strings.TrimSpace(claims.PreferredUser),
+	}
+	pairs := make([]string, 0, len(values)*2)
+	for placeholder, value := range values {
+		if strings.Contains(template, placeholder) && value == "" {
+			return ""
+		}
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// derivedAccountLabel returns a deterministic label for a token without an email, used in
+// non-interactive logins so file names stay unique: the provider plus the JWT sub claim, or
+// plus a short hash of the access token when the token carries no sub.
+func derivedAccountLabel(provider, accessToken string) string {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if claims, ok := ParseJWTClaims(accessToken); ok && strings.TrimSpace(claims.Sub) != "" {
+		return provider + "-" + strings.TrimSpace(claims.Sub)
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	return provider + "-" + hex.EncodeToString(sum[:6])
+}
+
 // SanitizeEmailForFilename sanitizes an email address for use in a filename.
 // Replaces special characters with underscores and prevents path traversal attacks.
 // Also handles URL-encoded characters to prevent encoded path traversal attempts.
@@ -5,6 +5,7 @@ package kiro
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,7 +15,6 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
-	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,6 +30,37 @@ const (
 	targetGenerateChat  = "AmazonCodeWhispererStreamingService.GenerateAssistantResponse"
 )
 
+// APIError is returned when a CodeWhisperer API call completes with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Token validation outcomes reported by CheckToken.
+const (
+	TokenStatusValid     = "valid"
+	TokenStatusExpired   = "expired"
+	TokenStatusSuspended = "suspended"
+	TokenStatusInvalid   = "invalid"
+	TokenStatusError     = "error"
+)
+
+// TokenValidationResult describes the health of a token as observed by a live API call.
+type TokenValidationResult struct {
+	// Status is one of the TokenStatus* values.
+	Status string `json:"status"`
+	// UpstreamStatus is the HTTP status returned by CodeWhisperer, 0 if the request never completed.
+	UpstreamStatus int `json:"upstream_status,omitempty"`
+	// Message carries the upstream error body or transport error, if any.
+	Message string `json:"message,omitempty"`
+	// Usage is populated when the token is valid.
+	Usage *KiroUsageInfo `json:"usage,omitempty"`
+}
+
 // KiroAuth handles AWS CodeWhisperer authentication and API communication.
 // It provides methods for loading tokens, refreshing expired tokens,
 // and communicating with the CodeWhisperer API.
@@ -48,7 +79,7 @@ type KiroAuth struct {
 //   - *KiroAuth: A new Kiro authentication service instance
 func NewKiroAuth(cfg *config.Config) *KiroAuth {
 	return &KiroAuth{
-		httpClient: util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: 120 * time.Second}),
+		httpClient: newAuthHTTPClient(cfg, 120*time.Second),
 		endpoint:   awsKiroEndpoint,
 	}
 }
@@ -154,7 +185,7 @@ func (k *KiroAuth) makeRequest(ctx context.Context, target string, accessToken s
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
@@ -306,6 +337,45 @@ func (k *KiroAuth) ValidateToken(ctx context.Context, tokenData *KiroTokenData)
 	return err
 }
 
+// CheckToken validates the token with a lightweight GetUsageLimits call and classifies the outcome.
+// Unlike ValidateToken, it reports whether a failing token is expired, suspended or otherwise invalid,
+// together with the raw upstream status. Throttling (429) and upstream failures (5xx) say nothing
+// about the token and are reported as an error; only other 4xx rejections mark it invalid.
+//
+// Parameters:
+//   - ctx: The context for the request
+//   - tokenData: The token data to validate
+//
+// Returns:
+//   - *TokenValidationResult: The classified validation outcome
+func (k *KiroAuth) CheckToken(ctx context.Context, tokenData *KiroTokenData) *TokenValidationResult {
+	usage, err := k.GetUsageLimits(ctx, tokenData)
+	if err == nil {
+		return &TokenValidationResult{Status: TokenStatusValid, UpstreamStatus: http.StatusOK, Usage: usage}
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return &TokenValidationResult{Status: TokenStatusError, Message: err.Error()}
+	}
+
+	result := &TokenValidationResult{
+		Status:         TokenStatusInvalid,
+		UpstreamStatus: apiErr.StatusCode,
+		Message:        apiErr.Body,
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.StatusCode < 400, apiErr.StatusCode >= 500:
+		result.Status = TokenStatusError
+	case isSuspendedMessage(apiErr.Body):
+		result.Status = TokenStatusSuspended
+	case apiErr.StatusCode == http.StatusUnauthorized,
+		apiErr.StatusCode == http.StatusForbidden && strings.Contains(strings.ToLower(apiErr.Body), "expired"):
+		result.Status = TokenStatusExpired
+	}
+	return result
+}
+
 // UpdateTokenStorage updates an existing token storage with new token data.
 // This method refreshes the token storage with newly obtained access and refresh tokens.
 //
@@ -0,0 +1,67 @@
+package kiro
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus string
+	}{
+		{name: "valid", status: http.StatusOK, body: `{"subscriptionInfo":{"subscriptionTitle":"KIRO FREE"}}`, wantStatus: TokenStatusValid},
+		{name: "expired", status: http.StatusForbidden, body: `{"message":"The bearer token included in the request is expired"}`, wantStatus: TokenStatusExpired},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{}`, wantStatus: TokenStatusExpired},
+		{name: "suspended", status: http.StatusForbidden, body: `{"message":"Your account has been suspended"}`, wantStatus: TokenStatusSuspended},
+		{name: "invalid", status: http.StatusBadRequest, body: `{"message":"bad profile"}`, wantStatus: TokenStatusInvalid},
+		{name: "rate limited body on 4xx", status: http.StatusForbidden, body: `{"message":"Rate limit exceeded"}`, wantStatus: TokenStatusInvalid},
+		{name: "too many requests", status: http.StatusTooManyRequests, body: `{"message":"Too many requests"}`, wantStatus: TokenStatusError},
+		{name: "internal error", status: http.StatusInternalServerError, body: `{"message":"internal error"}`, wantStatus: TokenStatusError},
+		{name: "bad gateway", status: http.StatusBadGateway, body: ``, wantStatus: TokenStatusError},
+		{name: "unavailable", status: http.StatusServiceUnavailable, body: `{"message":"Your account has been suspended"}`, wantStatus: TokenStatusError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Authorization = %q, want %q", got, "Bearer token")
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			k := &KiroAuth{httpClient: srv.Client(), endpoint: srv.URL}
+			result := k.CheckToken(context.Background(), &KiroTokenData{AccessToken: "token"})
+			if result.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", result.Status, tt.wantStatus)
+			}
+			if result.UpstreamStatus != tt.status {
+				t.Errorf("UpstreamStatus = %d, want %d", result.UpstreamStatus, tt.status)
+			}
+		})
+	}
+}
+
+func TestIsSuspendedMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{msg: "Your account has been suspended", want: true},
+		{msg: "Account disabled", want: true},
+		{msg: "Rate limit exceeded", want: false},
+		{msg: "Too many requests", want: false},
+		{msg: "internal server error", want: false},
+	}
+	for _, tt := range tests {
+		if got := isSuspendedMessage(tt.msg); got != tt.want {
+			t.Errorf("isSuspendedMessage(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package kiro
 import (
 	"encoding/base64"
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -309,3 +310,70 @@ func TestGenerateTokenFileName(t *testing.T) {
 		})
 	}
 }
+
+func TestStampLoginProvenance(t *testing.T) {
+	tokenData := StampLoginProvenance(&KiroTokenData{AuthMethod: "builder-id"}, LoginMethodDeviceCode)
+	if tokenData.IssuedAt == "" {
+		t.Fatal("expected IssuedAt to be set")
+	}
+	if tokenData.LoginMethod != LoginMethodDeviceCode {
+		t.Errorf("LoginMethod = %q, want %q", tokenData.LoginMethod, LoginMethodDeviceCode)
+	}
+
+	metadata := tokenData.ProvenanceMetadata()
+	if metadata["issued_at"] != tokenData.IssuedAt || metadata["login_method"] != LoginMethodDeviceCode {
+		t.Errorf("ProvenanceMetadata() = %v", metadata)
+	}
+
+	storage := &KiroTokenStorage{IssuedAt: tokenData.IssuedAt, LoginHost: "host", LoginMethod: tokenData.LoginMethod}
+	restored := storage.ToTokenData()
+	if restored.IssuedAt != tokenData.IssuedAt || restored.LoginHost != "host" || restored.LoginMethod != LoginMethodDeviceCode {
+		t.Errorf("ToTokenData() lost provenance: %+v", restored)
+	}
+
+	if len((&KiroTokenData{}).ProvenanceMetadata()) != 0 {
+		t.Error("expected no provenance metadata for a token without login info")
+	}
+}
+
+func TestFallbackAccountLabel(t *testing.T) {
+	token := createTestJWT(map[string]any{"sub": "12345", "preferred_username": "octocat"})
+	tests := []struct {
+		name     string
+		template string
+		token    string
+		expected string
+	}{
+		{"Empty template", "", token, ""},
+		{"Sub and provider", "{provider}-user-{sub}@example.internal", token, "github-user-12345@example.internal"},
+		{"Preferred username", "{preferred_username}@example.internal", token, "octocat@example.internal"},
+		{"Missing claim", "{provider}-{sub}", createTestJWT(map[string]any{"iss": "x"}), ""},
+		{"Invalid token", "{provider}-{sub}", "not-a-jwt", ""},
+		{"Provider only", "{provider}-shared", token, ""},
+		{"Static label", "shared@example.internal", token, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FallbackAccountLabel(tt.template, "Github", tt.token); got != tt.expected {
+				t.Errorf("FallbackAccountLabel() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDerivedAccountLabel(t *testing.T) {
+	if got := derivedAccountLabel("Github", createTestJWT(map[string]any{"sub": "12345"})); got != "github-12345" {
+		t.Errorf("derivedAccountLabel() with sub = %q, want %q", got, "github-12345")
+	}
+	first := derivedAccountLabel("Google", "opaque-token-a")
+	if first != derivedAccountLabel("Google", "opaque-token-a") {
+		t.Errorf("derivedAccountLabel() is not deterministic")
+	}
+	if !strings.HasPrefix(first, "google-") || len(first) != len("google-")+12 {
+		t.Errorf("derivedAccountLabel() without sub = %q, want google-<12 hex chars>", first)
+	}
+	if first == derivedAccountLabel("Google", "opaque-token-b") {
+		t.Errorf("derivedAccountLabel() should differ between tokens")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -23,6 +24,11 @@ type Token struct {
 	Provider     string
 	StartURL     string
 	Region       string
+	IssuedAt     time.Time
+	LoginHost    string
+	LoginMethod  string
+	// NonRefreshable tokens were issued without a refresh token and are never refreshed.
+	NonRefreshable bool
 }
 
 type TokenRepository interface {
@@ -30,6 +36,32 @@ type TokenRepository interface {
 	UpdateToken(token *Token) error
 }
 
+// RefreshBatchResult 汇总一轮批量刷新的结果
+type RefreshBatchResult struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	// Attempted 实际发起刷新的 token 数
+	Attempted int
+	Succeeded int
+	// Failed 刷新失败或写回失败的 token 数
+	Failed int
+	// FallbackUsed 刷新失败但旧 token 仍有效、继续沿用的 token 数
+	FallbackUsed int
+	// SkippedSuspended 因账号被暂停而跳过的 token 数
+	SkippedSuspended int
+	// Deferred 因停止或取消而留到下一轮的 token 数
+	Deferred int
+}
+
+// refreshOutcome 单个 token 的刷新结果
+type refreshOutcome int
+
+const (
+	refreshSucceeded refreshOutcome = iota
+	refreshFailed
+	refreshFallback
+)
+
 type RefresherOption func(*BackgroundRefresher)
 
 func WithInterval(interval time.Duration) RefresherOption {
@@ -61,6 +93,10 @@ type BackgroundRefresher struct {
 	ssoClient        *SSOOIDCClient
 	callbackMu       sync.RWMutex                                   // 保护回调函数的并发访问
 	onTokenRefreshed func(tokenID string, tokenData *KiroTokenData) // 刷新成功回调
+	onCycle          func(result RefreshBatchResult)                // 每轮刷新结束回调
+	rateLimiter      *RateLimiter                                   // 用于跳过已暂停的 token
+	lastCycleMu      sync.RWMutex
+	lastCycle        *RefreshBatchResult
 }
 
 func NewBackgroundRefresher(repo TokenRepository, opts ...RefresherOption) *BackgroundRefresher {
@@ -72,6 +108,7 @@ func NewBackgroundRefresher(repo TokenRepository, opts ...RefresherOption) *Back
 		stopCh:      make(chan struct{}),
 		oauth:       nil, // Lazy init - will be set when config available
 		ssoClient:   nil, // Lazy init - will be set when config available
+		rateLimiter: GetGlobalRateLimiter(),
 	}
 	for _, opt := range opts {
 		opt(r)
@@ -80,10 +117,12 @@ func NewBackgroundRefresher(repo TokenRepository, opts ...RefresherOption) *Back
 }
 
 // WithConfig sets the configuration for OAuth and SSO clients.
+// Both share one pooled transport so bulk refreshes reuse connections to the OIDC and Kiro hosts.
 func WithConfig(cfg *config.Config) RefresherOption {
 	return func(r *BackgroundRefresher) {
-		r.oauth = NewKiroOAuth(cfg)
-		r.ssoClient = NewSSOOIDCClient(cfg)
+		transport := WithTransport(pooledAuthTransport(cfg))
+		r.oauth = NewKiroOAuth(cfg, transport)
+		r.ssoClient = NewSSOOIDCClient(cfg, transport)
 	}
 }
 
@@ -98,6 +137,32 @@ func WithOnTokenRefreshed(callback func(tokenID string, tokenData *KiroTokenData
 	}
 }
 
+// WithOnRefreshCycle sets a callback invoked with the summary of every refresh cycle.
+func WithOnRefreshCycle(callback func(result RefreshBatchResult)) RefresherOption {
+	return func(r *BackgroundRefresher) {
+		r.callbackMu.Lock()
+		r.onCycle = callback
+		r.callbackMu.Unlock()
+	}
+}
+
+// WithRateLimiter sets the rate limiter consulted to skip suspended tokens.
+func WithRateLimiter(rl *RateLimiter) RefresherOption {
+	return func(r *BackgroundRefresher) {
+		r.rateLimiter = rl
+	}
+}
+
+// LastCycle returns the summary of the most recent refresh cycle, if any has run.
+func (r *BackgroundRefresher) LastCycle() (RefreshBatchResult, bool) {
+	r.lastCycleMu.RLock()
+	defer r.lastCycleMu.RUnlock()
+	if r.lastCycle == nil {
+		return RefreshBatchResult{}, false
+	}
+	return *r.lastCycle, true
+}
+
 func (r *BackgroundRefresher) Start(ctx context.Context) {
 	r.wg.Add(1)
 	go func() {
@@ -125,42 +190,107 @@ func (r *BackgroundRefresher) Stop() {
 	r.wg.Wait()
 }
 
-func (r *BackgroundRefresher) refreshBatch(ctx context.Context) {
+// refreshBatch 刷新一批最久未验证的 token，返回本轮汇总并通知 onCycle 回调
+func (r *BackgroundRefresher) refreshBatch(ctx context.Context) (result RefreshBatchResult) {
+	result.StartedAt = time.Now()
+	defer func() {
+		result.Duration = time.Since(result.StartedAt)
+		r.finishCycle(result)
+	}()
+
 	tokens := r.tokenRepo.FindOldestUnverified(r.batchSize)
 	if len(tokens) == 0 {
-		return
+		return result
 	}
 
 	sem := semaphore.NewWeighted(int64(r.concurrency))
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	record := func(outcome refreshOutcome) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch outcome {
+		case refreshSucceeded:
+			result.Succeeded++
+		case refreshFallback:
+			result.FallbackUsed++
+		default:
+			result.Failed++
+		}
+	}
 
+	launched := 0
 	for i, token := range tokens {
-		if i > 0 {
+		if r.isSuspended(token) {
+			result.SkippedSuspended++
+			continue
+		}
+
+		if launched > 0 {
 			select {
 			case <-ctx.Done():
-				return
+				result.Deferred = len(tokens) - i
+				wg.Wait()
+				return result
 			case <-r.stopCh:
-				return
+				result.Deferred = len(tokens) - i
+				wg.Wait()
+				return result
 			case <-time.After(100 * time.Millisecond):
 			}
 		}
 
-		if err := sem.Acquire(ctx, 1); err != nil {
-			return
+		// Acquire may succeed on an already cancelled context, so check it first.
+		if ctx.Err() != nil || sem.Acquire(ctx, 1) != nil {
+			result.Deferred = len(tokens) - i
+			wg.Wait()
+			return result
 		}
 
+		launched++
+		result.Attempted++
 		wg.Add(1)
 		go func(t *Token) {
 			defer wg.Done()
 			defer sem.Release(1)
-			r.refreshSingle(ctx, t)
+			record(r.refreshSingle(ctx, t))
 		}(token)
 	}
 
 	wg.Wait()
+	return result
 }
 
-func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) {
+// isSuspended 判断 token 对应的账号是否已被标记为暂停
+func (r *BackgroundRefresher) isSuspended(token *Token) bool {
+	if r.rateLimiter == nil {
+		return false
+	}
+	state := r.rateLimiter.GetTokenState(token.ID)
+	return state != nil && state.IsSuspended
+}
+
+// finishCycle 记录本轮结果并调用 onCycle 回调
+func (r *BackgroundRefresher) finishCycle(result RefreshBatchResult) {
+	r.lastCycleMu.Lock()
+	r.lastCycle = &result
+	r.lastCycleMu.Unlock()
+
+	r.callbackMu.RLock()
+	callback := r.onCycle
+	r.callbackMu.RUnlock()
+	if callback == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("background refresh: cycle callback panic: %v", rec)
+		}
+	}()
+	callback(result)
+}
+
+func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) refreshOutcome {
 	// Normalize auth method to lowercase for case-insensitive matching
 	authMethod := strings.ToLower(token.AuthMethod)
 
@@ -184,21 +314,24 @@ func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) {
 				token.RefreshToken,
 			)
 		default:
-			return r.oauth.RefreshTokenWithFingerprint(ctx, token.RefreshToken, token.ID)
+			return r.oauth.RefreshTokenWithFingerprint(ctx, token.RefreshToken, token.ID, token.Provider)
 		}
 	}
 
 	// Use graceful degradation for better reliability
+	startedAt := time.Now()
 	result := RefreshWithGracefulDegradation(
 		ctx,
 		refreshFunc,
 		token.AccessToken,
 		token.ExpiresAt,
 	)
+	// 记录刷新结果到 usage 统计；回退到旧 token 也视为刷新失败
+	coreusage.PublishRefresh(context.WithoutCancel(ctx), "kiro", token.ID, "", startedAt, result.Error != nil || result.UsedFallback)
 
 	if result.Error != nil {
 		log.Printf("failed to refresh token %s: %v", token.ID, result.Error)
-		return
+		return refreshFailed
 	}
 
 	newTokenData := result.TokenData
@@ -207,7 +340,7 @@ func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) {
 		// Don't update the token file if we're using fallback
 		// Just update LastVerified to prevent immediate re-check
 		token.LastVerified = time.Now()
-		return
+		return refreshFallback
 	}
 
 	token.AccessToken = newTokenData.AccessToken
@@ -224,7 +357,7 @@ func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) {
 
 	if err := r.tokenRepo.UpdateToken(token); err != nil {
 		log.Printf("failed to update token %s: %v", token.ID, err)
-		return
+		return refreshFailed
 	}
 
 	// 方案 A: 刷新成功后触发回调，通知 Watcher 更新内存中的 Auth 对象
@@ -244,4 +377,5 @@ func (r *BackgroundRefresher) refreshSingle(ctx context.Context, token *Token) {
 			callback(token.ID, newTokenData)
 		}()
 	}
+	return refreshSucceeded
 }
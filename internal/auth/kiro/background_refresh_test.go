@@ -0,0 +1,159 @@
+package kiro
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeTokenRepository struct {
+	mu      sync.Mutex
+	tokens  []*Token
+	updated []string
+}
+
+func (r *fakeTokenRepository) FindOldestUnverified(limit int) []*Token {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens
+}
+
+func (r *fakeTokenRepository) UpdateToken(token *Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updated = append(r.updated, token.ID)
+	return nil
+}
+
+func TestRefreshBatchReportsCycleResult(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	fake.expiredSecret = "stale-secret"
+
+	builderID := func(id, secret string, expiresAt time.Time) *Token {
+		return &Token{
+			ID:           id,
+			AccessToken:  "access-" + id,
+			RefreshToken: "refresh-" + id,
+			ExpiresAt:    expiresAt,
+			ClientID:     "client-id",
+			ClientSecret: secret,
+			AuthMethod:   "builder-id",
+		}
+	}
+	repo := &fakeTokenRepository{tokens: []*Token{
+		builderID("ok.json", "client-secret", time.Now().Add(-time.Minute)),
+		builderID("fallback.json", "stale-secret", time.Now().Add(time.Hour)),
+		builderID("failed.json", "stale-secret", time.Now().Add(-time.Minute)),
+		builderID("suspended.json", "client-secret", time.Now().Add(time.Hour)),
+	}}
+
+	rl := NewRateLimiter()
+	rl.CheckAndMarkSuspended("suspended.json", "account suspended")
+
+	var cycles []RefreshBatchResult
+	r := NewBackgroundRefresher(repo,
+		WithRateLimiter(rl),
+		WithOnRefreshCycle(func(result RefreshBatchResult) { cycles = append(cycles, result) }),
+	)
+	r.ssoClient = fake.client()
+
+	result := r.refreshBatch(context.Background())
+	if result.Attempted != 3 || result.Succeeded != 1 || result.FallbackUsed != 1 || result.Failed != 1 {
+		t.Fatalf("result = %+v, want 3 attempted / 1 succeeded / 1 fallback / 1 failed", result)
+	}
+	if result.SkippedSuspended != 1 || result.Deferred != 0 {
+		t.Fatalf("result = %+v, want 1 skipped-suspended and nothing deferred", result)
+	}
+	if len(repo.updated) != 1 || repo.updated[0] != "ok.json" {
+		t.Fatalf("updated tokens = %v, want [ok.json]", repo.updated)
+	}
+	if len(cycles) != 1 || cycles[0] != result {
+		t.Fatalf("cycle callback got %+v, want one call with %+v", cycles, result)
+	}
+	if last, ok := r.LastCycle(); !ok || last != result {
+		t.Fatalf("LastCycle() = %+v, %v; want %+v", last, ok, result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	repo.tokens = repo.tokens[:3]
+	if deferred := r.refreshBatch(ctx); deferred.Attempted != 0 || deferred.Deferred != 3 {
+		t.Fatalf("cancelled cycle = %+v, want nothing attempted and 3 deferred", deferred)
+	}
+}
+
+func TestRefreshSinglePreservesSocialProvider(t *testing.T) {
+	oauth := NewKiroOAuth(nil, WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"accessToken":"new-access","refreshToken":"new-refresh","expiresIn":3600}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}))
+
+	var refreshed *KiroTokenData
+	repo := &fakeTokenRepository{}
+	r := NewBackgroundRefresher(repo, WithOnTokenRefreshed(func(_ string, tokenData *KiroTokenData) { refreshed = tokenData }))
+	r.oauth = oauth
+
+	token := &Token{
+		ID:           "google.json",
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+		AuthMethod:   "social",
+		Provider:     string(ProviderGoogle),
+	}
+	if outcome := r.refreshSingle(context.Background(), token); outcome != refreshSucceeded {
+		t.Fatalf("refreshSingle() = %v, want refreshSucceeded", outcome)
+	}
+	if refreshed == nil || refreshed.Provider != string(ProviderGoogle) {
+		t.Fatalf("refreshed token = %+v, want provider %q", refreshed, ProviderGoogle)
+	}
+	if token.Provider != string(ProviderGoogle) || token.AccessToken != "new-access" {
+		t.Fatalf("token after refresh = %+v, want Google token with new access token", token)
+	}
+}
+
+func TestConcurrentRefreshesShareOneRequest(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	oauth := NewKiroOAuth(nil, WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		body := `{"accessToken":"new-access","refreshToken":"new-refresh","expiresIn":3600}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}))
+
+	const n = 8
+	results := make([]*KiroTokenData, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = oauth.RefreshTokenWithFingerprint(context.Background(), "shared-refresh", "", "Google")
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("refresh requests = %d, want 1", got)
+	}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("caller %d error = %v", i, errs[i])
+		}
+		if results[i].AccessToken != "new-access" || results[i].RefreshToken != "new-refresh" {
+			t.Fatalf("caller %d got %+v, want the shared refresh result", i, results[i])
+		}
+		if i > 0 && results[i] == results[0] {
+			t.Fatalf("callers share one *KiroTokenData; each should get its own copy")
+		}
+	}
+}
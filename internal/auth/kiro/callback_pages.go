@@ -0,0 +1,81 @@
+package kiro
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// callbackPageData is passed to custom callback page templates.
+type callbackPageData struct {
+	// Error is the failure reason; empty on the success page.
+	Error string
+}
+
+// writeCallbackSuccess answers a successful login callback with the configured redirect or
+// success template, falling back to the built-in page.
+func writeCallbackSuccess(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	pages := callbackPages(cfg)
+	if pages.SuccessRedirectURL != "" {
+		http.Redirect(w, r, pages.SuccessRedirectURL, http.StatusFound)
+		return
+	}
+	if writeCallbackTemplate(w, http.StatusOK, pages.SuccessTemplate, callbackPageData{}) {
+		return
+	}
+	closeScript := "\n<script>window.close();</script>"
+	if pages.KeepWindowOpen {
+		closeScript = ""
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><title>Login Successful</title></head>
+<body><h1>Login Successful!</h1><p>You can close this window and return to the terminal.</p>%s</body></html>`, closeScript)
+}
+
+// writeCallbackFailure answers a failed login callback with the configured failure template,
+// falling back to the built-in page.
+func writeCallbackFailure(w http.ResponseWriter, cfg *config.Config, reason string) {
+	if writeCallbackTemplate(w, http.StatusBadRequest, callbackPages(cfg).FailureTemplate, callbackPageData{Error: reason}) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><title>Login Failed</title></head>
+<body><h1>Login Failed</h1><p>%s</p><p>You can close this window.</p></body></html>`, html.EscapeString(reason))
+}
+
+// writeCallbackTemplate renders the template file at path and reports whether it did. An
+// unreadable or failing template is logged and left to the built-in page.
+func writeCallbackTemplate(w http.ResponseWriter, status int, path string, data callbackPageData) bool {
+	if path == "" {
+		return false
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		log.Warnf("kiro: failed to load callback page template %s, using the built-in page: %v", path, err)
+		return false
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Warnf("kiro: failed to render callback page template %s, using the built-in page: %v", path, err)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+	return true
+}
+
+func callbackPages(cfg *config.Config) config.KiroCallbackPagesConfig {
+	if cfg == nil {
+		return config.KiroCallbackPagesConfig{}
+	}
+	return cfg.KiroAuth.CallbackPages
+}
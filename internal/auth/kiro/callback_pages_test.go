@@ -0,0 +1,57 @@
+package kiro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestCallbackPages(t *testing.T) {
+	dir := t.TempDir()
+	failureTemplate := filepath.Join(dir, "failure.html")
+	if err := os.WriteFile(failureTemplate, []byte(`<p>Sorry: {{.Error}}</p>`), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/oauth/callback?code=abc", nil)
+
+	rec := httptest.NewRecorder()
+	writeCallbackSuccess(rec, req, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "window.close()") {
+		t.Fatalf("default success page = %d %q", rec.Code, rec.Body.String())
+	}
+
+	cfg := &config.Config{KiroAuth: config.KiroAuthConfig{CallbackPages: config.KiroCallbackPagesConfig{
+		FailureTemplate: failureTemplate,
+		KeepWindowOpen:  true,
+	}}}
+	rec = httptest.NewRecorder()
+	writeCallbackSuccess(rec, req, cfg)
+	if strings.Contains(rec.Body.String(), "window.close()") {
+		t.Fatalf("keep-window-open success page still closes the window: %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	writeCallbackFailure(rec, cfg, "<denied>")
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "<p>Sorry: &lt;denied&gt;</p>" {
+		t.Fatalf("custom failure page = %d %q", rec.Code, rec.Body.String())
+	}
+
+	cfg.KiroAuth.CallbackPages.SuccessRedirectURL = "myapp://logged-in"
+	rec = httptest.NewRecorder()
+	writeCallbackSuccess(rec, req, cfg)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "myapp://logged-in" {
+		t.Fatalf("success redirect = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	cfg.KiroAuth.CallbackPages.FailureTemplate = filepath.Join(dir, "missing.html")
+	rec = httptest.NewRecorder()
+	writeCallbackFailure(rec, cfg, "denied")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Login Failed") {
+		t.Fatalf("missing template should fall back to the built-in page, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,71 @@
+package kiro
+
+import "time"
+
+// CircuitState Token 熔断器状态
+type CircuitState int
+
+const (
+	// CircuitClosed 正常放行请求
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 失败后的退避期，拒绝所有请求
+	CircuitOpen
+	// CircuitHalfOpen 退避期结束，只放行一个试探请求：成功则关闭，失败则以更长的退避重新打开
+	CircuitHalfOpen
+)
+
+// halfOpenProbeTimeout 试探请求迟迟没有结果（未调用 MarkTokenSuccess/MarkTokenFailed，
+// 也未通过 AcquireToken 返回的 release 释放）时，超过该时长后允许再发一个试探请求，
+// 避免 Token 永久卡在半开状态
+const halfOpenProbeTimeout = 5 * time.Minute
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// currentCircuit 返回 now 时刻的熔断器状态：打开状态在退避期结束后即视为半开
+func currentCircuit(state *TokenState, now time.Time) CircuitState {
+	if state.Circuit == CircuitOpen && !now.Before(state.CooldownEnd) {
+		return CircuitHalfOpen
+	}
+	return state.Circuit
+}
+
+// updateCircuitLocked 退避期结束时将熔断器从打开转为半开，调用方需持有写锁
+func (rl *RateLimiter) updateCircuitLocked(state *TokenState, now time.Time) {
+	if circuit := currentCircuit(state, now); circuit != state.Circuit {
+		state.Circuit = circuit
+		state.probeStartedAt = time.Time{}
+	}
+}
+
+// probeInFlightLocked 半开状态下是否已有未完成的试探请求
+func (rl *RateLimiter) probeInFlightLocked(state *TokenState, now time.Time) bool {
+	return state.Circuit == CircuitHalfOpen && !state.probeStartedAt.IsZero() &&
+		now.Before(state.probeStartedAt.Add(halfOpenProbeTimeout))
+}
+
+// claimProbeLocked 半开状态下将本次请求登记为试探请求，返回登记时间；非试探请求返回零值
+func (rl *RateLimiter) claimProbeLocked(state *TokenState, now time.Time) time.Time {
+	if state.Circuit != CircuitHalfOpen {
+		return time.Time{}
+	}
+	state.probeStartedAt = now
+	return now
+}
+
+// releaseProbeLocked 释放 probe 登记的、尚未给出结果的试探请求，让下一个请求可以立即试探。
+// 试探已由 MarkTokenSuccess/MarkTokenFailed 结束或已被之后的试探请求取代时不做任何事
+func (rl *RateLimiter) releaseProbeLocked(state *TokenState, probe time.Time) {
+	if probe.IsZero() || state.Circuit != CircuitHalfOpen || !state.probeStartedAt.Equal(probe) {
+		return
+	}
+	state.probeStartedAt = time.Time{}
+}
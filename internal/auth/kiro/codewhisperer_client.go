@@ -11,7 +11,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
-	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -60,10 +59,7 @@ type UsageBreakdown struct {
 
 // NewCodeWhispererClient creates a new CodeWhisperer client.
 func NewCodeWhispererClient(cfg *config.Config, machineID string) *CodeWhispererClient {
-	client := &http.Client{Timeout: 30 * time.Second}
-	if cfg != nil {
-		client = util.SetProxy(&cfg.SDKConfig, client)
-	}
+	client := newAuthHTTPClient(cfg, 30*time.Second)
 	if machineID == "" {
 		machineID = uuid.New().String()
 	}
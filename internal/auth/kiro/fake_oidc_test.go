@@ -0,0 +1,133 @@
+package kiro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeOIDCResponse is a canned reply from the fake OIDC server.
+type fakeOIDCResponse struct {
+	status int
+	body   any
+}
+
+func oidcOK(body any) fakeOIDCResponse {
+	return fakeOIDCResponse{status: http.StatusOK, body: body}
+}
+
+func oidcError(code string) fakeOIDCResponse {
+	return fakeOIDCResponse{status: http.StatusBadRequest, body: map[string]string{"error": code, "error_description": code}}
+}
+
+// fakeOIDCServer emulates the AWS SSO OIDC endpoints used by SSOOIDCClient.
+// Each endpoint replays its queued responses in order and repeats the last one.
+type fakeOIDCServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	register   []fakeOIDCResponse
+	deviceAuth []fakeOIDCResponse
+	token      []fakeOIDCResponse
+	userInfo   []fakeOIDCResponse
+	// expiredSecret makes /token reject this client secret with invalid_client.
+	expiredSecret  string
+	calls          map[string]int
+	tokenRequests  []map[string]string
+	registerScopes [][]string
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	t.Helper()
+	f := &fakeOIDCServer{
+		register: []fakeOIDCResponse{oidcOK(RegisterClientResponse{
+			ClientID:              "client-id",
+			ClientSecret:          "client-secret",
+			ClientSecretExpiresAt: 4102444800,
+		})},
+		deviceAuth: []fakeOIDCResponse{oidcOK(StartDeviceAuthResponse{
+			DeviceCode:              "device-code",
+			UserCode:                "ABCD-EFGH",
+			VerificationURI:         "https://device.example.com",
+			VerificationURIComplete: "https://device.example.com?code=ABCD-EFGH",
+			ExpiresIn:               600,
+			Interval:                1,
+		})},
+		token:    []fakeOIDCResponse{oidcOK(CreateTokenResponse{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600})},
+		userInfo: []fakeOIDCResponse{oidcOK(map[string]string{"email": "user@example.com"})},
+		calls:    make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/client/register", f.handleRegister)
+	mux.HandleFunc("/device_authorization", func(w http.ResponseWriter, r *http.Request) { f.reply(w, "device_authorization", &f.deviceAuth) })
+	mux.HandleFunc("/token", f.handleToken)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) { f.reply(w, "userinfo", &f.userInfo) })
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns an SSOOIDCClient pointed at the fake server.
+func (f *fakeOIDCServer) client() *SSOOIDCClient {
+	return &SSOOIDCClient{httpClient: f.Client(), endpoint: f.URL}
+}
+
+func (f *fakeOIDCServer) setToken(responses ...fakeOIDCResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.token = responses
+}
+
+func (f *fakeOIDCServer) callCount(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[endpoint]
+}
+
+func (f *fakeOIDCServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Scopes []string `json:"scopes"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	f.mu.Lock()
+	f.registerScopes = append(f.registerScopes, payload.Scopes)
+	f.mu.Unlock()
+	f.reply(w, "register", &f.register)
+}
+
+func (f *fakeOIDCServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	f.mu.Lock()
+	f.tokenRequests = append(f.tokenRequests, payload)
+	expired := f.expiredSecret != "" && payload["clientSecret"] == f.expiredSecret
+	f.mu.Unlock()
+	if expired {
+		expiredReply := []fakeOIDCResponse{oidcError("invalid_client")}
+		f.reply(w, "token", &expiredReply)
+		return
+	}
+	f.reply(w, "token", &f.token)
+}
+
+func (f *fakeOIDCServer) reply(w http.ResponseWriter, endpoint string, queue *[]fakeOIDCResponse) {
+	f.mu.Lock()
+	call := f.calls[endpoint]
+	f.calls[endpoint] = call + 1
+	responses := *queue
+	f.mu.Unlock()
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if call >= len(responses) {
+		call = len(responses) - 1
+	}
+	resp := responses[call]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	_ = json.NewEncoder(w).Encode(resp.body)
+}
@@ -0,0 +1,185 @@
+package kiro
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+// defaultTokenRequestTimeout bounds a single token exchange or refresh request.
+const defaultTokenRequestTimeout = 15 * time.Second
+
+// withTokenRequestTimeout derives the context for one token exchange or refresh request from
+// kiro-auth.token-request-timeout. The caller must call cancel once the response is read.
+func withTokenRequestTimeout(ctx context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	timeout := defaultTokenRequestTimeout
+	if cfg != nil && cfg.KiroAuth.TokenRequestTimeout != 0 {
+		if cfg.KiroAuth.TokenRequestTimeout < 0 {
+			return context.WithCancel(ctx)
+		}
+		timeout = time.Duration(cfg.KiroAuth.TokenRequestTimeout) * time.Second
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Defaults for the shared auth transport when kiro-auth.transport is configured.
+const (
+	defaultTransportMaxIdleConns        = 100
+	defaultTransportMaxIdleConnsPerHost = 16
+	defaultTransportIdleConnTimeout     = 90 * time.Second
+	defaultTransportKeepAlive           = 30 * time.Second
+	defaultTransportDialTimeout         = 30 * time.Second
+)
+
+// sharedTransportKey identifies a pooled transport: clients share one only when both the
+// proxy and the tuning match.
+type sharedTransportKey struct {
+	proxyURL string
+	tuning   config.KiroTransportConfig
+}
+
+var (
+	sharedTransportsMu sync.Mutex
+	sharedTransports   = make(map[sharedTransportKey]*http.Transport)
+)
+
+// newAuthHTTPClient returns the HTTP client used by the Kiro/AWS auth clients.
+// Without transport tuning each client gets its own transport from util.SetProxy, as before.
+// With tuning, clients share a pooled transport per proxy setting so bulk refreshes reuse
+// connections to the OIDC and Kiro auth hosts.
+func newAuthHTTPClient(cfg *config.Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if cfg == nil {
+		return client
+	}
+	tuning := cfg.KiroAuth.Transport
+	if tuning.IsZero() {
+		return util.SetProxy(&cfg.SDKConfig, client)
+	}
+	client.Transport = sharedAuthTransport(&cfg.SDKConfig, tuning)
+	return client
+}
+
+// ClientOption customizes the HTTP client of NewSSOOIDCClient, NewSocialAuthClient and
+// NewKiroOAuth. Without options each builds its own client with newAuthHTTPClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+}
+
+// WithHTTPClient makes the auth client use httpClient as is, e.g. one client shared by many.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(o *clientOptions) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTransport keeps the auth client's default timeout but sends requests through transport,
+// so several clients can share one connection pool.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// resolveAuthHTTPClient returns the client selected by opts, falling back to newAuthHTTPClient.
+func resolveAuthHTTPClient(cfg *config.Config, timeout time.Duration, opts []ClientOption) *http.Client {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpClient != nil {
+		return o.httpClient
+	}
+	if o.transport != nil {
+		return &http.Client{Timeout: timeout, Transport: o.transport}
+	}
+	return newAuthHTTPClient(cfg, timeout)
+}
+
+// pooledAuthTransport returns the shared transport for cfg's proxy and kiro-auth.transport
+// tuning, using the pool defaults (e.g. 16 idle connections per host) when no tuning is set.
+func pooledAuthTransport(cfg *config.Config) *http.Transport {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	return sharedAuthTransport(&cfg.SDKConfig, cfg.KiroAuth.Transport)
+}
+
+// sharedAuthTransport returns the pooled transport for the proxy and tuning, creating it once.
+func sharedAuthTransport(sdkCfg *config.SDKConfig, tuning config.KiroTransportConfig) *http.Transport {
+	key := sharedTransportKey{proxyURL: sdkCfg.ProxyURL, tuning: tuning}
+	sharedTransportsMu.Lock()
+	defer sharedTransportsMu.Unlock()
+	if transport, ok := sharedTransports[key]; ok {
+		return transport
+	}
+	transport := buildAuthTransport(sdkCfg, tuning)
+	sharedTransports[key] = transport
+	return transport
+}
+
+// buildAuthTransport starts from the proxy transport util.SetProxy would install, or a clone of
+// the default transport for direct connections, and applies the connection tuning.
+func buildAuthTransport(sdkCfg *config.SDKConfig, tuning config.KiroTransportConfig) *http.Transport {
+	var transport *http.Transport
+	if proxied, ok := util.SetProxy(sdkCfg, &http.Client{}).Transport.(*http.Transport); ok && proxied != nil {
+		transport = proxied
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = nil
+	}
+
+	keepAlive := defaultTransportKeepAlive
+	if tuning.KeepAlive > 0 {
+		keepAlive = time.Duration(tuning.KeepAlive) * time.Second
+	} else if tuning.KeepAlive < 0 {
+		keepAlive = -1
+	}
+	// SOCKS5 transports already dial through the proxy; keep their dialer.
+	if transport.DialContext == nil {
+		dialer := &net.Dialer{Timeout: defaultTransportDialTimeout, KeepAlive: keepAlive}
+		transport.DialContext = dialer.DialContext
+	}
+
+	transport.MaxIdleConns = defaultTransportMaxIdleConns
+	if tuning.MaxIdleConns > 0 {
+		transport.MaxIdleConns = tuning.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = defaultTransportMaxIdleConnsPerHost
+	if tuning.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = tuning.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = defaultTransportIdleConnTimeout
+	if tuning.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(tuning.IdleConnTimeout) * time.Second
+	}
+	transport.DisableKeepAlives = false
+
+	if tuning.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		// A non-nil empty map disables the automatic HTTP/2 upgrade.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		return transport
+	}
+	h2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		log.Warnf("kiro: failed to configure HTTP/2 on auth transport: %v", err)
+		transport.ForceAttemptHTTP2 = true
+		return transport
+	}
+	if tuning.HTTP2ReadIdleTimeout > 0 {
+		h2.ReadIdleTimeout = time.Duration(tuning.HTTP2ReadIdleTimeout) * time.Second
+	}
+	return transport
+}
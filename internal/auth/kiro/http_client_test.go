@@ -0,0 +1,110 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestNewAuthHTTPClientSharesTunedTransport(t *testing.T) {
+	cfg := &config.Config{}
+	if client := newAuthHTTPClient(cfg, time.Second); client.Transport != nil {
+		t.Fatalf("untuned client transport = %T, want default", client.Transport)
+	}
+
+	cfg.KiroAuth.Transport = config.KiroTransportConfig{MaxIdleConnsPerHost: 32, IdleConnTimeout: 5}
+	a := newAuthHTTPClient(cfg, 30*time.Second)
+	b := newAuthHTTPClient(cfg, 120*time.Second)
+	if a.Transport == nil || a.Transport != b.Transport {
+		t.Fatal("tuned clients do not share a transport")
+	}
+	if b.Timeout != 120*time.Second {
+		t.Fatalf("Timeout = %v, want per-client value", b.Timeout)
+	}
+	transport := a.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 32 || transport.IdleConnTimeout != 5*time.Second || transport.MaxIdleConns != defaultTransportMaxIdleConns {
+		t.Fatalf("tuning not applied: perHost=%d idle=%v max=%d", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.MaxIdleConns)
+	}
+	if _, ok := transport.TLSNextProto["h2"]; !ok {
+		t.Fatal("HTTP/2 not configured on shared transport")
+	}
+
+	cfg.ProxyURL = "http://proxy.example.com:8080"
+	proxied := newAuthHTTPClient(cfg, time.Second).Transport.(*http.Transport)
+	if proxied == transport {
+		t.Fatal("proxied client reused the direct transport")
+	}
+	req := &http.Request{URL: &url.URL{Scheme: "https", Host: "oidc.us-east-1.amazonaws.com"}}
+	if proxyURL, err := proxied.Proxy(req); err != nil || proxyURL == nil || proxyURL.Host != "proxy.example.com:8080" {
+		t.Fatalf("proxy = %v, %v; want proxy.example.com:8080", proxyURL, err)
+	}
+
+	cfg.ProxyURL = ""
+	cfg.KiroAuth.Transport.DisableHTTP2 = true
+	h1 := newAuthHTTPClient(cfg, time.Second).Transport.(*http.Transport)
+	if h1.TLSNextProto == nil || len(h1.TLSNextProto) != 0 || h1.ForceAttemptHTTP2 {
+		t.Fatal("HTTP/2 not disabled")
+	}
+}
+
+func TestAuthClientOptions(t *testing.T) {
+	cfg := &config.Config{}
+	shared := &http.Client{Timeout: 5 * time.Second}
+	if got := NewSSOOIDCClient(cfg, WithHTTPClient(shared)).httpClient; got != shared {
+		t.Fatal("WithHTTPClient not used by SSOOIDCClient")
+	}
+	if got := NewSocialAuthClient(cfg, WithHTTPClient(shared)).httpClient; got != shared {
+		t.Fatal("WithHTTPClient not used by SocialAuthClient")
+	}
+
+	transport := pooledAuthTransport(cfg)
+	if transport.MaxIdleConnsPerHost != defaultTransportMaxIdleConnsPerHost {
+		t.Fatalf("pooled MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, defaultTransportMaxIdleConnsPerHost)
+	}
+	oauth := NewKiroOAuth(cfg, WithTransport(transport))
+	if oauth.httpClient.Transport != transport || oauth.httpClient.Timeout != 30*time.Second {
+		t.Fatalf("WithTransport client = %+v", oauth.httpClient)
+	}
+
+	refresher := NewBackgroundRefresher(nil, WithConfig(cfg))
+	if refresher.oauth.httpClient.Transport != transport || refresher.ssoClient.httpClient.Transport != transport {
+		t.Fatal("background refresher clients do not share the pooled transport")
+	}
+}
+
+func TestTokenRequestTimeout(t *testing.T) {
+	ctx, cancel := withTokenRequestTimeout(context.Background(), nil)
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > defaultTokenRequestTimeout {
+		t.Fatalf("default deadline = %v, %v; want within %v", deadline, ok, defaultTokenRequestTimeout)
+	}
+	ctx, cancel = withTokenRequestTimeout(context.Background(), &config.Config{KiroAuth: config.KiroAuthConfig{TokenRequestTimeout: -1}})
+	_, ok = ctx.Deadline()
+	cancel()
+	if ok {
+		t.Fatalf("negative token-request-timeout should not set a deadline")
+	}
+
+	client := &SSOOIDCClient{
+		cfg:      &config.Config{KiroAuth: config.KiroAuthConfig{TokenRequestTimeout: 1}},
+		endpoint: "https://oidc.example.com",
+		httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})},
+	}
+	start := time.Now()
+	_, err := client.CreateToken(context.Background(), "client-id", "client-secret", "device-code")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreateToken() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("CreateToken() took %v, want it bounded by the 1s request timeout", elapsed)
+	}
+}
@@ -0,0 +1,55 @@
+package kiro
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Login events written to stdout in JSON output mode, one JSON object per line.
+const (
+	LoginEventVerification = "verification"
+	LoginEventAuthorizeURL = "authorize_url"
+	LoginEventToken        = "token"
+	LoginEventError        = "error"
+)
+
+// LoginEvent is a machine-readable login progress or result line. Only the fields relevant
+// to Event are set.
+type LoginEvent struct {
+	Event                   string         `json:"event"`
+	VerificationURI         string         `json:"verification_uri,omitempty"`
+	VerificationURIComplete string         `json:"verification_uri_complete,omitempty"`
+	UserCode                string         `json:"user_code,omitempty"`
+	ExpiresIn               int            `json:"expires_in,omitempty"`
+	URL                     string         `json:"url,omitempty"`
+	Token                   *KiroTokenData `json:"token,omitempty"`
+	Error                   string         `json:"error,omitempty"`
+}
+
+// jsonLoginOutput reports whether login flows emit JSON events instead of human output.
+func jsonLoginOutput(cfg *config.Config) bool {
+	return cfg != nil && cfg.KiroAuth.JSONOutput
+}
+
+// LoginOutput returns where login flows print human-readable messages: stdout normally,
+// stderr in JSON output mode so stdout carries only JSON events.
+func LoginOutput(cfg *config.Config) io.Writer {
+	if jsonLoginOutput(cfg) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// emitLoginEvent writes event to stdout in JSON output mode and is a no-op otherwise.
+func emitLoginEvent(cfg *config.Config, event LoginEvent) {
+	if !jsonLoginOutput(cfg) {
+		return
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(event); err != nil {
+		log.Warnf("kiro: failed to write login event: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package kiro
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	_ = w.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestJSONLoginOutput(t *testing.T) {
+	cfg := &config.Config{}
+	if LoginOutput(cfg) != os.Stdout {
+		t.Fatal("expected human output on stdout by default")
+	}
+	if got := captureStdout(t, func() { emitLoginEvent(cfg, LoginEvent{Event: LoginEventToken}) }); got != "" {
+		t.Fatalf("expected no JSON events by default, got %q", got)
+	}
+
+	cfg.KiroAuth.JSONOutput = true
+	if LoginOutput(cfg) != os.Stderr {
+		t.Fatal("expected human output on stderr in JSON mode")
+	}
+	handler := jsonAuthEvents{cfg: cfg}
+	got := captureStdout(t, func() {
+		handler.OnDeviceCodeIssued(&StartDeviceAuthResponse{UserCode: "ABCD-EFGH", VerificationURIComplete: "https://example.com/?code=ABCD-EFGH"})
+		finishLogin(handler, &KiroTokenData{AccessToken: "access", RefreshToken: "refresh"}, nil)
+		finishLogin(handler, nil, errors.New("authorization timed out"))
+	})
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 JSON lines, got %q", got)
+	}
+	var events [3]LoginEvent
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &events[i]); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i+1, err)
+		}
+	}
+	if events[0].UserCode != "ABCD-EFGH" || events[1].Event != LoginEventToken || events[1].Token.AccessToken != "access" ||
+		events[2].Event != LoginEventError || events[2].Error != "authorization timed out" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+type recordingAuthEvents struct {
+	terminalAuthEvents
+	events []string
+}
+
+func (r *recordingAuthEvents) OnPollTick(err error) { r.events = append(r.events, "poll:"+err.Error()) }
+func (r *recordingAuthEvents) OnTokenIssued(token *KiroTokenData) {
+	r.events = append(r.events, "token")
+}
+func (r *recordingAuthEvents) OnError(err error) { r.events = append(r.events, "error") }
+
+func TestAuthEventHandlerOverridesDefault(t *testing.T) {
+	if _, ok := defaultAuthEvents(&config.Config{}).(terminalAuthEvents); !ok {
+		t.Fatal("expected terminal events by default")
+	}
+	if _, ok := defaultAuthEvents(&config.Config{KiroAuth: config.KiroAuthConfig{JSONOutput: true}}).(authEventFanout); !ok {
+		t.Fatal("expected terminal and JSON events in JSON output mode")
+	}
+
+	recorder := &recordingAuthEvents{terminalAuthEvents: terminalAuthEvents{out: io.Discard}}
+	client := &SSOOIDCClient{Events: recorder}
+	if client.events() != AuthEventHandler(recorder) {
+		t.Fatal("expected Events to replace the default handler")
+	}
+	client.events().OnPollTick(ErrSlowDown)
+	finishLogin(client.events(), &KiroTokenData{}, nil)
+	finishLogin(client.events(), nil, errors.New("boom"))
+	if strings.Join(recorder.events, ",") != "poll:slow_down,token,error" {
+		t.Fatalf("unexpected events: %v", recorder.events)
+	}
+}
@@ -3,21 +3,19 @@ package kiro
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
-	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -27,8 +25,8 @@ const (
 	// Default callback port
 	defaultCallbackPort = 9876
 	
-	// Auth timeout
-	authTimeout = 10 * time.Minute
+	// Default max age of a pending login session
+	defaultLoginSessionMaxAge = 10 * time.Minute
 )
 
 // KiroTokenResponse represents the response from Kiro token endpoint.
@@ -46,39 +44,77 @@ type KiroOAuth struct {
 }
 
 // NewKiroOAuth creates a new Kiro OAuth handler.
-func NewKiroOAuth(cfg *config.Config) *KiroOAuth {
-	client := &http.Client{Timeout: 30 * time.Second}
-	if cfg != nil {
-		client = util.SetProxy(&cfg.SDKConfig, client)
-	}
+func NewKiroOAuth(cfg *config.Config, opts ...ClientOption) *KiroOAuth {
+	client := resolveAuthHTTPClient(cfg, 30*time.Second, opts)
 	return &KiroOAuth{
 		httpClient: client,
 		cfg:        cfg,
 	}
 }
 
-// generateCodeVerifier generates a random code verifier for PKCE.
-func generateCodeVerifier() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// loginSessionMaxAge returns how long a pending login stays alive before it is abandoned.
+// It bounds the callback servers, device-code polling and web login sessions alike.
+func loginSessionMaxAge(cfg *config.Config) time.Duration {
+	if cfg == nil || cfg.KiroAuth.LoginSessionMaxAge <= 0 {
+		return defaultLoginSessionMaxAge
+	}
+	return time.Duration(cfg.KiroAuth.LoginSessionMaxAge) * time.Second
+}
+
+// callbackHost returns the host the local OAuth callback servers bind to and put in their
+// redirect URI, so the browser is sent to exactly the address that is listening. It comes
+// from kiro-auth.callback-host (e.g. "::1" for IPv6-only machines); fallback applies when unset.
+func callbackHost(cfg *config.Config, fallback string) string {
+	if cfg == nil {
+		return fallback
+	}
+	host := strings.Trim(strings.TrimSpace(cfg.KiroAuth.CallbackHost), "[]")
+	if host == "" {
+		return fallback
 	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
+	return host
 }
 
-// generateCodeChallenge generates the code challenge from verifier.
-func generateCodeChallenge(verifier string) string {
-	h := sha256.Sum256([]byte(verifier))
-	return base64.RawURLEncoding.EncodeToString(h[:])
+// callbackAddr joins host and port for listening and redirect URIs, bracketing IPv6 literals.
+func callbackAddr(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
-// generateState generates a random state parameter.
-func generateState() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// callbackSelfCheckTimeout bounds the VerifyCallbackReachable self-connect.
+const callbackSelfCheckTimeout = 3 * time.Second
+
+// VerifyCallbackReachable sends a HEAD request to the local callback listener at redirectURI,
+// so a firewall or loopback problem is reported before the user authenticates in the browser.
+// The callback servers answer HEAD with 204 without consuming the login result.
+func VerifyCallbackReachable(ctx context.Context, redirectURI string) error {
+	ctx, cancel := context.WithTimeout(ctx, callbackSelfCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, redirectURI, nil)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL %s: %w", redirectURI, err)
+	}
+	// The callback is local: never send the self-check through a configured proxy.
+	client := &http.Client{Transport: &http.Transport{Proxy: nil, DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("callback %s is not reachable: %w", redirectURI, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("callback %s answered with status %d", redirectURI, resp.StatusCode)
+	}
+	return nil
+}
+
+// warnUnreachableCallback runs VerifyCallbackReachable and tells the user how to fix a failure
+// before the browser is opened. The login continues either way.
+func warnUnreachableCallback(ctx context.Context, out io.Writer, redirectURI string) {
+	if err := VerifyCallbackReachable(ctx, redirectURI); err != nil {
+		log.Errorf("kiro: login callback self-check failed: %v", err)
+		fmt.Fprintf(out, "  ✗ The login callback %s is not reachable, so the browser redirect will fail.\n", redirectURI)
+		fmt.Fprintln(out, "    Check firewall/loopback rules, or set kiro-auth.callback-host (e.g. \"127.0.0.1\") and retry.")
 	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // AuthResult contains the authorization code and state from callback.
@@ -90,12 +126,13 @@ type AuthResult struct {
 
 // startCallbackServer starts a local HTTP server to receive the OAuth callback.
 func (o *KiroOAuth) startCallbackServer(ctx context.Context, expectedState string) (string, <-chan AuthResult, error) {
-	// Try to find an available port - use localhost like Kiro does
-	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", defaultCallbackPort))
+	// Try to find an available port - use localhost like Kiro does unless a host is configured
+	host := callbackHost(o.cfg, "localhost")
+	listener, err := net.Listen("tcp", callbackAddr(host, defaultCallbackPort))
 	if err != nil {
 		// Try with dynamic port (RFC 8252 allows dynamic ports for native apps)
 		log.Warnf("kiro oauth: default port %d is busy, falling back to dynamic port", defaultCallbackPort)
-		listener, err = net.Listen("tcp", "localhost:0")
+		listener, err = net.Listen("tcp", callbackAddr(host, 0))
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to start callback server: %w", err)
 		}
@@ -103,7 +140,7 @@ func (o *KiroOAuth) startCallbackServer(ctx context.Context, expectedState strin
 
 	port := listener.Addr().(*net.TCPAddr).Port
 	// Use http scheme for local callback server
-	redirectURI := fmt.Sprintf("http://localhost:%d/oauth/callback", port)
+	redirectURI := fmt.Sprintf("http://%s/oauth/callback", callbackAddr(host, port))
 	resultChan := make(chan AuthResult, 1)
 
 	server := &http.Server{
@@ -117,23 +154,18 @@ func (o *KiroOAuth) startCallbackServer(ctx context.Context, expectedState strin
 		errParam := r.URL.Query().Get("error")
 
 		if errParam != "" {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `<html><body><h1>Login Failed</h1><p>%s</p><p>You can close this window.</p></body></html>`, html.EscapeString(errParam))
+			writeCallbackFailure(w, o.cfg, errParam)
 			resultChan <- AuthResult{Error: errParam}
 			return
 		}
 
 		if state != expectedState {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, `<html><body><h1>Login Failed</h1><p>Invalid state parameter</p><p>You can close this window.</p></body></html>`)
+			writeCallbackFailure(w, o.cfg, "Invalid state parameter")
 			resultChan <- AuthResult{Error: "state mismatch"}
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprint(w, `<html><body><h1>Login Successful!</h1><p>You can close this window and return to the terminal.</p></body></html>`)
+		writeCallbackSuccess(w, r, o.cfg)
 		resultChan <- AuthResult{Code: code, State: state}
 	})
 
@@ -148,7 +180,7 @@ func (o *KiroOAuth) startCallbackServer(ctx context.Context, expectedState strin
 	go func() {
 		select {
 		case <-ctx.Done():
-		case <-time.After(authTimeout):
+		case <-time.After(loginSessionMaxAge(o.cfg)):
 		case <-resultChan:
 		}
 		_ = server.Shutdown(context.Background())
@@ -172,6 +204,9 @@ func (o *KiroOAuth) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTokenD
 
 // exchangeCodeForToken exchanges the authorization code for tokens.
 func (o *KiroOAuth) exchangeCodeForToken(ctx context.Context, code, codeVerifier, redirectURI string) (*KiroTokenData, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, o.cfg)
+	defer cancel()
+
 	payload := map[string]string{
 		"code":          code,
 		"code_verifier": codeVerifier,
@@ -214,10 +249,7 @@ func (o *KiroOAuth) exchangeCodeForToken(ctx context.Context, code, codeVerifier
 	}
 
 	// Validate ExpiresIn - use default 1 hour if invalid
-	expiresIn := tokenResp.ExpiresIn
-	if expiresIn <= 0 {
-		expiresIn = 3600
-	}
+	expiresIn := normalizeExpiresIn("code exchange", tokenResp.ExpiresIn)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
 	return &KiroTokenData{
@@ -233,13 +265,25 @@ func (o *KiroOAuth) exchangeCodeForToken(ctx context.Context, code, codeVerifier
 
 // RefreshToken refreshes an expired access token.
 // Uses KiroIDE-style User-Agent to match official Kiro IDE behavior.
+// The returned token has no Provider; callers keep the stored one.
 func (o *KiroOAuth) RefreshToken(ctx context.Context, refreshToken string) (*KiroTokenData, error) {
-	return o.RefreshTokenWithFingerprint(ctx, refreshToken, "")
+	return o.RefreshTokenWithFingerprint(ctx, refreshToken, "", "")
 }
 
 // RefreshTokenWithFingerprint refreshes an expired access token with a specific fingerprint.
 // tokenKey is used to generate a consistent fingerprint for the token.
-func (o *KiroOAuth) RefreshTokenWithFingerprint(ctx context.Context, refreshToken, tokenKey string) (*KiroTokenData, error) {
+// provider is the token's original provider (e.g. "Google") and is set on the result.
+// Concurrent refreshes of the same refresh token share one request (see sharedRefresh).
+func (o *KiroOAuth) RefreshTokenWithFingerprint(ctx context.Context, refreshToken, tokenKey, provider string) (*KiroTokenData, error) {
+	return sharedRefresh(ctx, refreshToken, func(ctx context.Context) (*KiroTokenData, error) {
+		return o.refreshTokenWithFingerprint(ctx, refreshToken, tokenKey, provider)
+	})
+}
+
+func (o *KiroOAuth) refreshTokenWithFingerprint(ctx context.Context, refreshToken, tokenKey, provider string) (*KiroTokenData, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, o.cfg)
+	defer cancel()
+
 	payload := map[string]string{
 		"refreshToken": refreshToken,
 	}
@@ -259,7 +303,7 @@ func (o *KiroOAuth) RefreshTokenWithFingerprint(ctx context.Context, refreshToke
 
 	// Use KiroIDE-style User-Agent to match official Kiro IDE behavior
 	// This helps avoid 403 errors from server-side User-Agent validation
-	userAgent := buildKiroUserAgent(tokenKey)
+	userAgent := buildKiroUserAgent(o.cfg, tokenKey)
 	req.Header.Set("User-Agent", userAgent)
 
 	resp, err := o.httpClient.Do(req)
@@ -284,46 +328,193 @@ func (o *KiroOAuth) RefreshTokenWithFingerprint(ctx context.Context, refreshToke
 	}
 
 	// Validate ExpiresIn - use default 1 hour if invalid
-	expiresIn := tokenResp.ExpiresIn
-	if expiresIn <= 0 {
-		expiresIn = 3600
-	}
+	expiresIn := normalizeExpiresIn("token refresh", tokenResp.ExpiresIn)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
-	return &KiroTokenData{
+	return finishRefresh("token refresh", &KiroTokenData{
 		AccessToken:  tokenResp.AccessToken,
 		RefreshToken: tokenResp.RefreshToken,
 		ProfileArn:   tokenResp.ProfileArn,
 		ExpiresAt:    expiresAt.Format(time.RFC3339),
 		AuthMethod:   "social",
-		Provider:     "", // Caller should preserve original provider
+		Provider:     provider,
 		Region:       "us-east-1",
-	}, nil
+	}, refreshToken), nil
+}
+
+// EnsureFresh returns tokenData unchanged while it is valid for more than five minutes, and
+// otherwise refreshes it the way its AuthMethod requires (IDC, Builder ID or social). It lets
+// one-shot tools get a usable token without running a BackgroundRefresher. Fields the refresh
+// response does not carry (email, provider, login provenance, ...) are kept from tokenData.
+func (o *KiroOAuth) EnsureFresh(ctx context.Context, tokenData *KiroTokenData) (*KiroTokenData, error) {
+	if tokenData == nil {
+		return nil, fmt.Errorf("kiro: no token to refresh")
+	}
+	expiresAt := ParseExpiresAt(tokenData.ExpiresAt)
+	if !expiresAt.IsZero() && !IsTokenExpiringSoon(expiresAt, 0) {
+		return tokenData, nil
+	}
+	if tokenData.RefreshToken == "" {
+		if !expiresAt.IsZero() && !IsTokenExpired(expiresAt) {
+			return tokenData, nil
+		}
+		return nil, fmt.Errorf("kiro: token expired and has no refresh token, please log in again")
+	}
+
+	var (
+		refreshed *KiroTokenData
+		err       error
+	)
+	ssoClient := NewSSOOIDCClient(o.cfg, WithHTTPClient(o.httpClient))
+	switch {
+	case tokenData.ClientID != "" && tokenData.ClientSecret != "" && tokenData.AuthMethod == "idc" && tokenData.Region != "":
+		refreshed, err = ssoClient.RefreshTokenWithRegion(ctx, tokenData.ClientID, tokenData.ClientSecret, tokenData.RefreshToken, tokenData.Region, tokenData.StartURL)
+	case tokenData.ClientID != "" && tokenData.ClientSecret != "" && tokenData.AuthMethod == "builder-id":
+		refreshed, err = ssoClient.RefreshToken(ctx, tokenData.ClientID, tokenData.ClientSecret, tokenData.RefreshToken)
+	default:
+		refreshed, err = o.RefreshTokenWithFingerprint(ctx, tokenData.RefreshToken, "", tokenData.Provider)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kiro: token refresh failed: %w", err)
+	}
+
+	fresh := *tokenData
+	fresh.AccessToken = refreshed.AccessToken
+	fresh.RefreshToken = refreshed.RefreshToken
+	fresh.ExpiresAt = refreshed.ExpiresAt
+	fresh.LastRefreshedAt = refreshed.LastRefreshedAt
+	if refreshed.ProfileArn != "" {
+		fresh.ProfileArn = refreshed.ProfileArn
+	}
+	return &fresh, nil
+}
+
+// defaultTokenExpiresIn is assumed (in seconds) when a token response carries no usable expiresIn.
+const defaultTokenExpiresIn = 3600
+
+// missingExpiryWarnInterval rate-limits the warning logged by normalizeExpiresIn.
+const missingExpiryWarnInterval = 10 * time.Minute
+
+var (
+	missingExpiryCount    atomic.Int64
+	lastMissingExpiryWarn atomic.Int64
+)
+
+// normalizeExpiresIn returns expiresIn, or defaultTokenExpiresIn when the upstream did not send
+// a positive value. Each fallback is counted and logged (rate-limited) so that an upstream
+// contract change does not go unnoticed.
+func normalizeExpiresIn(source string, expiresIn int) int {
+	if expiresIn > 0 {
+		return expiresIn
+	}
+	count := missingExpiryCount.Add(1)
+	now := time.Now().UnixNano()
+	last := lastMissingExpiryWarn.Load()
+	if now-last >= int64(missingExpiryWarnInterval) && lastMissingExpiryWarn.CompareAndSwap(last, now) {
+		log.Warnf("kiro %s: response had no valid expiresIn (%d), assuming %ds (%d occurrences so far)", source, expiresIn, defaultTokenExpiresIn, count)
+	}
+	return defaultTokenExpiresIn
+}
+
+// MissingExpiryCount returns how many token responses lacked a valid expiresIn since startup.
+// The server exports it as cliproxy_kiro_missing_expiry_total on /metrics.
+func MissingExpiryCount() int64 {
+	return missingExpiryCount.Load()
+}
+
+// refreshFlights collapses concurrent refreshes of one token into a single upstream call.
+var refreshFlights singleflight.Group
+
+// sharedRefresh runs refresh once per refresh token at a time: callers arriving while a
+// refresh of the same token is in flight wait for it and get a copy of its result instead of
+// sending their own request, which with rotation enabled would invalidate the other's token.
+// The refresh token is the key because it is what identifies the token to the upstream.
+// The shared call is detached from the first caller's cancellation; each caller still stops
+// waiting when its own ctx is done.
+func sharedRefresh(ctx context.Context, refreshToken string, refresh func(context.Context) (*KiroTokenData, error)) (*KiroTokenData, error) {
+	if refreshToken == "" {
+		return refresh(ctx)
+	}
+	results := refreshFlights.DoChan(refreshToken, func() (interface{}, error) {
+		return refresh(context.WithoutCancel(ctx))
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-results:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		tokenData, _ := res.Val.(*KiroTokenData)
+		if tokenData == nil {
+			return nil, nil
+		}
+		shared := *tokenData
+		return &shared, nil
+	}
+}
+
+// finishRefresh stamps tokenData with the refresh time. When the upstream response carried no
+// refresh token, the previous one is kept and a warning is logged, since a token that is never
+// rotated keeps working only as long as the upstream tolerates reuse.
+func finishRefresh(source string, tokenData *KiroTokenData, previousRefreshToken string) *KiroTokenData {
+	if tokenData.RefreshToken == "" {
+		log.Warnf("kiro %s: response did not rotate the refresh token, reusing the previous one", source)
+		tokenData.RefreshToken = previousRefreshToken
+	}
+	tokenData.LastRefreshedAt = time.Now().UTC().Format(time.RFC3339)
+	return tokenData
+}
+
+// defaultKiroUserAgentVersion is the Kiro version in the default KiroIDE User-Agent.
+const defaultKiroUserAgentVersion = "0.7.45"
+
+// configuredKiroVersion returns the Kiro version configured for auth User-Agents, or ""
+// to keep the built-in ones.
+func configuredKiroVersion(cfg *config.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	return strings.TrimSpace(cfg.KiroAuth.UserAgentVersion)
 }
 
 // buildKiroUserAgent builds a KiroIDE-style User-Agent string.
 // If tokenKey is provided, uses fingerprint manager for consistent fingerprint.
 // Otherwise generates a simple KiroIDE User-Agent.
-func buildKiroUserAgent(tokenKey string) string {
+// A Kiro version configured in cfg replaces the fingerprint's or default version.
+func buildKiroUserAgent(cfg *config.Config, tokenKey string) string {
+	version := configuredKiroVersion(cfg)
 	if tokenKey != "" {
 		fm := NewFingerprintManager()
 		fp := fm.GetFingerprint(tokenKey)
-		return fmt.Sprintf("KiroIDE-%s-%s", fp.KiroVersion, fp.KiroHash[:16])
+		if version == "" {
+			version = fp.KiroVersion
+		}
+		return fmt.Sprintf("KiroIDE-%s-%s", version, fp.KiroHash[:16])
+	}
+	if version == "" {
+		version = defaultKiroUserAgentVersion
 	}
 	// Default KiroIDE User-Agent matching kiro-openai-gateway format
-	return "KiroIDE-0.7.45-cli-proxy-api"
+	return fmt.Sprintf("KiroIDE-%s-cli-proxy-api", version)
 }
 
 // LoginWithGoogle performs OAuth login with Google using Kiro's social auth.
 // This uses a custom protocol handler (kiro://) to receive the callback.
-func (o *KiroOAuth) LoginWithGoogle(ctx context.Context) (*KiroTokenData, error) {
+func (o *KiroOAuth) LoginWithGoogle(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
 	socialClient := NewSocialAuthClient(o.cfg)
-	return socialClient.LoginWithGoogle(ctx)
+	return socialClient.LoginWithGoogle(ctx, opts...)
 }
 
 // LoginWithGitHub performs OAuth login with GitHub using Kiro's social auth.
 // This uses a custom protocol handler (kiro://) to receive the callback.
-func (o *KiroOAuth) LoginWithGitHub(ctx context.Context) (*KiroTokenData, error) {
+func (o *KiroOAuth) LoginWithGitHub(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
+	socialClient := NewSocialAuthClient(o.cfg)
+	return socialClient.LoginWithGitHub(ctx, opts...)
+}
+
+// LoginWithCognito performs OAuth login for a Kiro email/password account using Kiro's social auth.
+func (o *KiroOAuth) LoginWithCognito(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
 	socialClient := NewSocialAuthClient(o.cfg)
-	return socialClient.LoginWithGitHub(ctx)
+	return socialClient.LoginWithCognito(ctx, opts...)
 }
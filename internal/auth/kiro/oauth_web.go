@@ -3,9 +3,8 @@ package kiro
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -22,8 +21,7 @@ import (
 )
 
 const (
-	defaultSessionExpiry = 10 * time.Minute
-	pollIntervalSeconds  = 5
+	pollIntervalSeconds = 5
 )
 
 type authSessionStatus string
@@ -90,14 +88,6 @@ func (h *OAuthWebHandler) RegisterRoutes(router gin.IRouter) {
 	}
 }
 
-func generateStateID() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
-}
-
 func (h *OAuthWebHandler) handleSelect(c *gin.Context) {
 	h.renderSelectPage(c)
 }
@@ -125,17 +115,18 @@ func (h *OAuthWebHandler) handleStart(c *gin.Context) {
 }
 
 func (h *OAuthWebHandler) startSocialAuth(c *gin.Context, method string) {
-	stateID, err := generateStateID()
+	stateID, err := NewState(stateBytes)
 	if err != nil {
 		h.renderError(c, "Failed to generate state parameter")
 		return
 	}
 
-	codeVerifier, codeChallenge, err := generatePKCE()
+	pkce, err := NewPKCE()
 	if err != nil {
 		h.renderError(c, "Failed to generate PKCE parameters")
 		return
 	}
+	codeVerifier, codeChallenge := pkce.CodeVerifier, pkce.CodeChallenge
 
 	socialClient := NewSocialAuthClient(h.cfg)
 	
@@ -147,7 +138,7 @@ func (h *OAuthWebHandler) startSocialAuth(c *gin.Context, method string) {
 	}
 
 	redirectURI := h.getSocialCallbackURL(c)
-	authURL := socialClient.buildLoginURL(provider, redirectURI, codeChallenge, stateID)
+	authURL := socialClient.buildLoginURL(provider, redirectURI, codeChallenge, stateID, SocialPromptSelectAccount)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 
@@ -190,7 +181,7 @@ func (h *OAuthWebHandler) getSocialCallbackURL(c *gin.Context) string {
 }
 
 func (h *OAuthWebHandler) startBuilderIDAuth(c *gin.Context) {
-	stateID, err := generateStateID()
+	stateID, err := NewState(stateBytes)
 	if err != nil {
 		h.renderError(c, "Failed to generate state parameter")
 		return
@@ -201,7 +192,7 @@ func (h *OAuthWebHandler) startBuilderIDAuth(c *gin.Context) {
 
 	ssoClient := NewSSOOIDCClient(h.cfg)
 
-	regResp, err := ssoClient.RegisterClientWithRegion(c.Request.Context(), region)
+	regResp, err := ssoClient.GetOrRegisterClient(c.Request.Context(), region, startURL)
 	if err != nil {
 		log.Errorf("OAuth Web: failed to register client: %v", err)
 		h.renderError(c, fmt.Sprintf("Failed to register client: %v", err))
@@ -259,11 +250,15 @@ func (h *OAuthWebHandler) startIDCAuth(c *gin.Context) {
 		h.renderError(c, "Missing startUrl parameter for IDC authentication")
 		return
 	}
+	if err := validateStartURL(startURL); err != nil {
+		h.renderError(c, err.Error())
+		return
+	}
 	if region == "" {
 		region = defaultIDCRegion
 	}
 
-	stateID, err := generateStateID()
+	stateID, err := NewState(stateBytes)
 	if err != nil {
 		h.renderError(c, "Failed to generate state parameter")
 		return
@@ -271,7 +266,7 @@ func (h *OAuthWebHandler) startIDCAuth(c *gin.Context) {
 
 	ssoClient := NewSSOOIDCClient(h.cfg)
 
-	regResp, err := ssoClient.RegisterClientWithRegion(c.Request.Context(), region)
+	regResp, err := ssoClient.GetOrRegisterClient(c.Request.Context(), region, startURL)
 	if err != nil {
 		log.Errorf("OAuth Web: failed to register client: %v", err)
 		h.renderError(c, fmt.Sprintf("Failed to register client: %v", err))
@@ -324,12 +319,9 @@ func (h *OAuthWebHandler) startIDCAuth(c *gin.Context) {
 func (h *OAuthWebHandler) pollForToken(ctx context.Context, session *webAuthSession) {
 	defer session.cancelFunc()
 
-	interval := time.Duration(session.interval) * time.Second
-	if interval < time.Duration(pollIntervalSeconds)*time.Second {
-		interval = time.Duration(pollIntervalSeconds) * time.Second
-	}
+	interval := newDevicePollInterval(max(session.interval, pollIntervalSeconds))
 
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(interval.current)
 	defer ticker.Stop()
 
 	for {
@@ -352,15 +344,18 @@ func (h *OAuthWebHandler) pollForToken(ctx context.Context, session *webAuthSess
 			)
 
 			if err != nil {
-				errStr := err.Error()
-				if errStr == ErrAuthorizationPending.Error() {
+				if errors.Is(err, ErrAuthorizationPending) {
+					interval.pending()
+					ticker.Reset(interval.current)
 					continue
 				}
-				if errStr == ErrSlowDown.Error() {
-					interval += 5 * time.Second
-					ticker.Reset(interval)
-					continue
+				if errors.Is(err, ErrSlowDown) {
+					if err = interval.slowDown(); err == nil {
+						ticker.Reset(interval.current)
+						continue
+					}
 				}
+				errStr := err.Error()
 
 				h.mu.Lock()
 				session.status = statusFailed
@@ -373,7 +368,7 @@ func (h *OAuthWebHandler) pollForToken(ctx context.Context, session *webAuthSess
 			}
 
 			expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-			profileArn := session.ssoClient.fetchProfileArn(ctx, tokenResp.AccessToken)
+			profileArn := session.ssoClient.fetchProfileArn(ctx, tokenResp.AccessToken, session.region)
 			email := FetchUserEmailWithFallback(ctx, h.cfg, tokenResp.AccessToken)
 
 			tokenData := &KiroTokenData{
@@ -389,6 +384,8 @@ func (h *OAuthWebHandler) pollForToken(ctx context.Context, session *webAuthSess
 					Region:       session.region,
 					StartURL:     session.startURL,
 				}
+			tokenData.NonRefreshable = tokenData.RefreshToken == ""
+			StampLoginProvenance(tokenData, LoginMethodWebDeviceCode)
 
 			h.mu.Lock()
 			session.status = statusSuccess
@@ -445,19 +442,23 @@ func (h *OAuthWebHandler) saveTokenToFile(tokenData *KiroTokenData) {
 	
 	// Convert to storage format and save
 	storage := &KiroTokenStorage{
-		Type:         "kiro",
-		AccessToken:  tokenData.AccessToken,
-		RefreshToken: tokenData.RefreshToken,
-		ProfileArn:   tokenData.ProfileArn,
-		ExpiresAt:    tokenData.ExpiresAt,
-		AuthMethod:   tokenData.AuthMethod,
-		Provider:     tokenData.Provider,
-		LastRefresh:  time.Now().Format(time.RFC3339),
-		ClientID:     tokenData.ClientID,
-		ClientSecret: tokenData.ClientSecret,
-		Region:       tokenData.Region,
-		StartURL:     tokenData.StartURL,
-		Email:        tokenData.Email,
+		Type:           "kiro",
+		AccessToken:    tokenData.AccessToken,
+		RefreshToken:   tokenData.RefreshToken,
+		ProfileArn:     tokenData.ProfileArn,
+		ExpiresAt:      tokenData.ExpiresAt,
+		AuthMethod:     tokenData.AuthMethod,
+		Provider:       tokenData.Provider,
+		LastRefresh:    time.Now().Format(time.RFC3339),
+		ClientID:       tokenData.ClientID,
+		ClientSecret:   tokenData.ClientSecret,
+		Region:         tokenData.Region,
+		StartURL:       tokenData.StartURL,
+		Email:          tokenData.Email,
+		IssuedAt:       tokenData.IssuedAt,
+		LoginHost:      tokenData.LoginHost,
+		LoginMethod:    tokenData.LoginMethod,
+		NonRefreshable: tokenData.NonRefreshable,
 	}
 	
 	if err := storage.SaveTokenToFile(authFilePath); err != nil {
@@ -559,10 +560,7 @@ func (h *OAuthWebHandler) handleSocialCallback(c *gin.Context) {
 		return
 	}
 
-	expiresIn := tokenResp.ExpiresIn
-	if expiresIn <= 0 {
-		expiresIn = 3600
-	}
+	expiresIn := normalizeExpiresIn("web login", tokenResp.ExpiresIn)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
 	email := ExtractEmailFromJWT(tokenResp.AccessToken)
@@ -573,6 +571,9 @@ func (h *OAuthWebHandler) handleSocialCallback(c *gin.Context) {
 	} else {
 		provider = string(ProviderGitHub)
 	}
+	if email == "" && h.cfg != nil {
+		email = FallbackAccountLabel(h.cfg.KiroAuth.FallbackLabelTemplate, provider, tokenResp.AccessToken)
+	}
 
 	tokenData := &KiroTokenData{
 		AccessToken:  tokenResp.AccessToken,
@@ -584,6 +585,7 @@ func (h *OAuthWebHandler) handleSocialCallback(c *gin.Context) {
 		Email:        email,
 		Region:       "us-east-1",
 	}
+	StampLoginProvenance(tokenData, LoginMethodWebSocial)
 
 	h.mu.Lock()
 	session.status = statusSuccess
@@ -726,7 +728,7 @@ func (h *OAuthWebHandler) CleanupExpiredSessions() {
 	for id, session := range h.sessions {
 		if session.status != statusPending && now.Sub(session.completedAt) > 30*time.Minute {
 			delete(h.sessions, id)
-		} else if session.status == statusPending && now.Sub(session.startedAt) > defaultSessionExpiry {
+		} else if session.status == statusPending && now.Sub(session.startedAt) > loginSessionMaxAge(h.cfg) {
 			session.cancelFunc()
 			delete(h.sessions, id)
 		}
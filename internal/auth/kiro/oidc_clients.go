@@ -0,0 +1,234 @@
+package kiro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OIDC client grant kinds recorded in the client store.
+const (
+	OIDCGrantDeviceCode = "device_code"
+	OIDCGrantAuthCode   = "authorization_code"
+)
+
+// oidcClientStoreFile is the client registry kept in the auth directory. It deliberately does
+// not end in .json so the auth watcher does not treat it as a credential.
+const oidcClientStoreFile = "kiro-oidc-clients.store"
+
+// oidcClientReuseMargin is how long a stored client secret must remain valid to be reused
+// for a new login. Tokens from such a login stop refreshing when the secret expires, so a
+// login close to the end of the secret's lifetime has to be repeated soon after.
+const oidcClientReuseMargin = 24 * time.Hour
+
+// OIDCClientRecord is an OIDC client registration made by this proxy.
+type OIDCClientRecord struct {
+	ClientID     string    `json:"clientId"`
+	ClientSecret string    `json:"clientSecret"`
+	Endpoint     string    `json:"endpoint"`
+	GrantType    string    `json:"grantType"`
+	RedirectURI  string    `json:"redirectUri,omitempty"`
+	StartURL     string    `json:"startUrl,omitempty"`
+	Scopes       []string  `json:"scopes,omitempty"`
+	IssuedAt     time.Time `json:"issuedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the client secret has expired at now.
+func (r OIDCClientRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// OIDCClientStore persists the OIDC clients registered by this proxy so logins can reuse a
+// registration instead of creating a new one every time. AWS SSO OIDC has no API to delete
+// a client; registrations lapse when their secret expires, so cleanup only prunes records.
+type OIDCClientStore struct {
+	path string
+}
+
+// oidcClientStoreMu serializes read-modify-write cycles on the store file.
+var oidcClientStoreMu sync.Mutex
+
+// NewOIDCClientStore returns the client store kept in authDir.
+func NewOIDCClientStore(authDir string) *OIDCClientStore {
+	return &OIDCClientStore{path: filepath.Join(authDir, oidcClientStoreFile)}
+}
+
+// List returns every stored registration.
+func (s *OIDCClientStore) List() ([]OIDCClientRecord, error) {
+	oidcClientStoreMu.Lock()
+	defer oidcClientStoreMu.Unlock()
+	return s.load()
+}
+
+// Find returns a stored registration for the endpoint, grant, redirect URI, start URL and
+// scopes whose secret stays valid for at least oidcClientReuseMargin, or nil.
+func (s *OIDCClientStore) Find(endpoint, grantType, redirectURI, startURL string, scopes []string, now time.Time) *OIDCClientRecord {
+	records, err := s.List()
+	if err != nil {
+		return nil
+	}
+	var best *OIDCClientRecord
+	for i := range records {
+		record := &records[i]
+		if record.Endpoint != endpoint || record.GrantType != grantType || record.RedirectURI != redirectURI || record.StartURL != startURL {
+			continue
+		}
+		if !sameScopes(record.Scopes, scopes) {
+			continue
+		}
+		if record.ClientID == "" || record.ClientSecret == "" || record.Expired(now.Add(oidcClientReuseMargin)) {
+			continue
+		}
+		if best == nil || record.ExpiresAt.After(best.ExpiresAt) {
+			best = record
+		}
+	}
+	return best
+}
+
+// Save adds or replaces the registration with the same client ID.
+func (s *OIDCClientStore) Save(record OIDCClientRecord) error {
+	oidcClientStoreMu.Lock()
+	defer oidcClientStoreMu.Unlock()
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range records {
+		if records[i].ClientID == record.ClientID {
+			records[i] = record
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		records = append(records, record)
+	}
+	return s.store(records)
+}
+
+// Remove deletes the registration with clientID and reports whether it existed.
+func (s *OIDCClientStore) Remove(clientID string) (bool, error) {
+	return s.removeWhere(func(record OIDCClientRecord) bool { return record.ClientID == clientID })
+}
+
+// PruneExpired deletes registrations whose secret has expired and returns how many were removed.
+func (s *OIDCClientStore) PruneExpired(now time.Time) (int, error) {
+	oidcClientStoreMu.Lock()
+	defer oidcClientStoreMu.Unlock()
+	records, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	kept := records[:0]
+	for _, record := range records {
+		if !record.Expired(now) {
+			kept = append(kept, record)
+		}
+	}
+	removed := len(records) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.store(kept)
+}
+
+func (s *OIDCClientStore) removeWhere(match func(OIDCClientRecord) bool) (bool, error) {
+	oidcClientStoreMu.Lock()
+	defer oidcClientStoreMu.Unlock()
+	records, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	kept := records[:0]
+	for _, record := range records {
+		if !match(record) {
+			kept = append(kept, record)
+		}
+	}
+	if len(kept) == len(records) {
+		return false, nil
+	}
+	return true, s.store(kept)
+}
+
+func (s *OIDCClientStore) load() ([]OIDCClientRecord, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read OIDC client store: %w", err)
+	}
+	var records []OIDCClientRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse OIDC client store: %w", err)
+	}
+	return records, nil
+}
+
+func (s *OIDCClientStore) store(records []OIDCClientRecord) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create OIDC client store directory: %w", err)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write OIDC client store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// sameScopes reports whether two scope lists hold the same scopes in any order. Records
+// stored without scopes were registered with DefaultOIDCScopes.
+func sameScopes(recorded, requested []string) bool {
+	if len(recorded) == 0 {
+		recorded = DefaultOIDCScopes
+	}
+	if len(requested) == 0 {
+		requested = DefaultOIDCScopes
+	}
+	if len(recorded) != len(requested) {
+		return false
+	}
+	want := make(map[string]struct{}, len(requested))
+	for _, scope := range requested {
+		want[scope] = struct{}{}
+	}
+	for _, scope := range recorded {
+		if _, ok := want[scope]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// recordFromRegistration converts a RegisterClient response into a store record.
+func recordFromRegistration(resp *RegisterClientResponse, endpoint, grantType, redirectURI, startURL string, scopes []string, now time.Time) OIDCClientRecord {
+	record := OIDCClientRecord{
+		ClientID:     resp.ClientID,
+		ClientSecret: resp.ClientSecret,
+		Endpoint:     endpoint,
+		GrantType:    grantType,
+		RedirectURI:  redirectURI,
+		StartURL:     startURL,
+		Scopes:       scopes,
+		IssuedAt:     now,
+	}
+	if resp.ClientIDIssuedAt > 0 {
+		record.IssuedAt = time.Unix(resp.ClientIDIssuedAt, 0)
+	}
+	if resp.ClientSecretExpiresAt > 0 {
+		record.ExpiresAt = time.Unix(resp.ClientSecretExpiresAt, 0)
+	}
+	return record
+}
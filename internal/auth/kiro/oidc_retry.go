@@ -0,0 +1,119 @@
+package kiro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Retry policy for OIDC client registration and device authorization requests.
+const (
+	defaultOIDCRequestAttempts = 3
+	oidcRetryBaseBackoff       = 500 * time.Millisecond
+	oidcRetryMaxBackoff        = 10 * time.Second
+	oidcRetryJitterPercent     = 0.3
+)
+
+// oidcRequestAttempts returns how many times a retryable OIDC request is attempted.
+func (c *SSOOIDCClient) oidcRequestAttempts() int {
+	if c.cfg == nil || c.cfg.KiroAuth.OIDCRequestAttempts == 0 {
+		return defaultOIDCRequestAttempts
+	}
+	if c.cfg.KiroAuth.OIDCRequestAttempts < 0 {
+		return 1
+	}
+	return c.cfg.KiroAuth.OIDCRequestAttempts
+}
+
+// isRetryableOIDCStatus reports whether an OIDC response status is worth retrying.
+// Other errors, e.g. 400 invalid_client_metadata, fail fast.
+func isRetryableOIDCStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// oidcRetryBackoff returns the exponential backoff with jitter before retry number attempt (1-based).
+func oidcRetryBackoff(attempt int) time.Duration {
+	backoff := float64(oidcRetryBaseBackoff) * float64(int64(1)<<(attempt-1))
+	backoff += backoff * oidcRetryJitterPercent * (rand.Float64()*2 - 1)
+	if time.Duration(backoff) > oidcRetryMaxBackoff {
+		return oidcRetryMaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// postOIDCWithRetry POSTs a JSON body to url and returns the body of a 200 response.
+// Throttling, 5xx responses and transport errors are retried with backoff up to
+// oidcRequestAttempts; the final error names op and includes the last status code.
+func (c *SSOOIDCClient) postOIDCWithRetry(ctx context.Context, op, url string, body []byte) ([]byte, error) {
+	attempts := c.oidcRequestAttempts()
+	for attempt := 1; ; attempt++ {
+		respBody, status, retryAfter, err := c.postOIDC(ctx, url, body)
+		if err == nil && status == http.StatusOK {
+			return respBody, nil
+		}
+
+		var lastErr error
+		retryable := false
+		if err != nil {
+			lastErr = fmt.Errorf("%s failed: %w", op, err)
+			retryable = ctx.Err() == nil
+		} else {
+			log.Debugf("%s failed (status %d): %s", op, status, string(respBody))
+			lastErr = fmt.Errorf("%s failed (status %d)", op, status)
+			retryable = isRetryableOIDCStatus(status)
+		}
+		if !retryable {
+			return nil, lastErr
+		}
+		if attempt >= attempts {
+			if attempts > 1 {
+				return nil, fmt.Errorf("%w after %d attempts", lastErr, attempts)
+			}
+			return nil, lastErr
+		}
+
+		wait := oidcRetryBackoff(attempt)
+		if retryAfter > 0 && retryAfter < oidcRetryMaxBackoff {
+			wait = retryAfter
+		}
+		log.Debugf("%s: %v, retrying in %v (attempt %d/%d)", op, lastErr, wait, attempt+1, attempts)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// postOIDC performs a single JSON POST against an OIDC endpoint.
+func (c *SSOOIDCClient) postOIDC(ctx context.Context, url string, body []byte) (respBody []byte, status int, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, 0, err
+	}
+	return respBody, resp.StatusCode, parseRetryAfter(resp.Header.Get("Retry-After")), nil
+}
@@ -0,0 +1,51 @@
+package kiro
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// pkceVerifierBytes of randomness give a 43-character code verifier, the RFC 7636 minimum.
+	pkceVerifierBytes = 32
+	// stateBytes is the randomness of the login flows' state parameter.
+	stateBytes = 16
+)
+
+// NewPKCE generates a PKCE code verifier and its S256 code challenge for the social,
+// Builder ID auth-code and web login flows. Both are unpadded base64url strings.
+func NewPKCE() (*PKCECodes, error) {
+	verifier, err := randomURLString(pkceVerifierBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+	return &PKCECodes{
+		CodeVerifier:  verifier,
+		CodeChallenge: pkceChallenge(verifier),
+	}, nil
+}
+
+// NewState generates a random OAuth state parameter from n random bytes.
+func NewState(n int) (string, error) {
+	state, err := randomURLString(n)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	return state, nil
+}
+
+// pkceChallenge returns the S256 code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
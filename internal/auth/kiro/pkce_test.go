@@ -0,0 +1,53 @@
+package kiro
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"testing"
+)
+
+var base64URLNoPadding = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func TestNewPKCE(t *testing.T) {
+	codes, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte(codes.CodeVerifier))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); codes.CodeChallenge != want {
+		t.Fatalf("CodeChallenge = %q, want base64url(sha256(verifier)) %q", codes.CodeChallenge, want)
+	}
+	if len(codes.CodeVerifier) < 43 || len(codes.CodeVerifier) > 128 {
+		t.Fatalf("CodeVerifier length = %d, want 43-128 (RFC 7636)", len(codes.CodeVerifier))
+	}
+	for _, value := range []string{codes.CodeVerifier, codes.CodeChallenge} {
+		if !base64URLNoPadding.MatchString(value) {
+			t.Fatalf("%q is not unpadded base64url", value)
+		}
+	}
+
+	again, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE() error = %v", err)
+	}
+	if again.CodeVerifier == codes.CodeVerifier {
+		t.Fatalf("NewPKCE() returned the same verifier twice")
+	}
+}
+
+func TestNewState(t *testing.T) {
+	state, err := NewState(stateBytes)
+	if err != nil {
+		t.Fatalf("NewState() error = %v", err)
+	}
+	if want := base64.RawURLEncoding.EncodedLen(stateBytes); len(state) != want {
+		t.Fatalf("len(state) = %d, want %d", len(state), want)
+	}
+	if !base64URLNoPadding.MatchString(state) {
+		t.Fatalf("state %q is not unpadded base64url", state)
+	}
+	if other, _ := NewState(stateBytes); other == state {
+		t.Fatalf("NewState() returned the same state twice")
+	}
+}
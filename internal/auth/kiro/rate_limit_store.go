@@ -0,0 +1,289 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimitStoreTimeout 单次共享状态读写的超时
+const rateLimitStoreTimeout = 2 * time.Second
+
+// DefaultSharedSyncInterval 同一 Token 两次读取共享状态的最小间隔。
+// 间隔内的检查使用本地状态，其他实例的变化最多延迟这么久才生效。
+const DefaultSharedSyncInterval = time.Second
+
+// rateLimitKeyPrefix 共享限流状态在 Redis 中的键前缀（位于 usage-statistics-cache.key-prefix 之后）
+const rateLimitKeyPrefix = "kiro:rate-limit:"
+
+var errRateLimitStoreUnavailable = errors.New("kiro rate limit store: redis client unavailable")
+
+// SharedTokenState 多实例共享的 Token 限流状态
+type SharedTokenState struct {
+	DailyRequests  int
+	CooldownEnd    time.Time
+	SuspendedUntil time.Time
+	SuspendReason  string
+}
+
+// RateLimitStateStore 多实例共享的限流状态存储。
+// 启用后每日计数、冷却和暂停以存储为准；存储出错时 RateLimiter 退回本地状态。
+type RateLimitStateStore interface {
+	// Load 读取 Token 的共享状态，不存在时返回零值
+	Load(tokenKey string) (SharedTokenState, error)
+	// IncrDaily 累加每日计数并返回新值，计数在 resetAt 过期
+	IncrDaily(tokenKey string, resetAt time.Time) (int, error)
+	// SetCooldown 设置冷却截止时间，零值或已过去的时间表示清除
+	SetCooldown(tokenKey string, until time.Time) error
+	// Suspend 标记 Token 暂停，cooldown 后自动解除
+	Suspend(tokenKey, reason string, cooldown time.Duration) error
+	// ClearSuspension 清除暂停和冷却
+	ClearSuspension(tokenKey string) error
+}
+
+// redisRateLimitStore 基于 Redis 的共享状态存储，复用 usage-statistics-cache 的连接：
+// 每日计数用 INCR 并在下一个每日重置时间过期，冷却和暂停是以剩余时长为 TTL 的键。
+type redisRateLimitStore struct{}
+
+// NewRedisRateLimitStore 创建基于 cache.GetClient 的共享状态存储
+func NewRedisRateLimitStore() RateLimitStateStore {
+	return redisRateLimitStore{}
+}
+
+func (redisRateLimitStore) key(kind, tokenKey string) string {
+	return cache.GetConfig().KeyPrefix + rateLimitKeyPrefix + kind + ":" + tokenKey
+}
+
+func (s redisRateLimitStore) Load(tokenKey string) (SharedTokenState, error) {
+	client := cache.GetClient()
+	if client == nil {
+		return SharedTokenState{}, errRateLimitStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	var daily, reason *redis.StringCmd
+	var cooldownTTL, suspendTTL *redis.DurationCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		daily = pipe.Get(ctx, s.key("daily", tokenKey))
+		cooldownTTL = pipe.PTTL(ctx, s.key("cooldown", tokenKey))
+		reason = pipe.Get(ctx, s.key("suspended", tokenKey))
+		suspendTTL = pipe.PTTL(ctx, s.key("suspended", tokenKey))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return SharedTokenState{}, err
+	}
+
+	now := time.Now()
+	var state SharedTokenState
+	if value, errGet := daily.Result(); errGet == nil {
+		state.DailyRequests, _ = strconv.Atoi(value)
+	}
+	// PTTL 对不存在或无过期时间的键返回负值
+	if ttl := cooldownTTL.Val(); ttl > 0 {
+		state.CooldownEnd = now.Add(ttl)
+	}
+	if ttl := suspendTTL.Val(); ttl > 0 {
+		state.SuspendedUntil = now.Add(ttl)
+		state.SuspendReason = reason.Val()
+	}
+	return state, nil
+}
+
+func (s redisRateLimitStore) IncrDaily(tokenKey string, resetAt time.Time) (int, error) {
+	client := cache.GetClient()
+	if client == nil {
+		return 0, errRateLimitStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	key := s.key("daily", tokenKey)
+	var incr *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, resetAt)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+func (s redisRateLimitStore) SetCooldown(tokenKey string, until time.Time) error {
+	client := cache.GetClient()
+	if client == nil {
+		return errRateLimitStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	key := s.key("cooldown", tokenKey)
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return client.Del(ctx, key).Err()
+	}
+	return client.Set(ctx, key, until.Unix(), ttl).Err()
+}
+
+func (s redisRateLimitStore) Suspend(tokenKey, reason string, cooldown time.Duration) error {
+	client := cache.GetClient()
+	if client == nil {
+		return errRateLimitStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	return client.Set(ctx, s.key("suspended", tokenKey), reason, cooldown).Err()
+}
+
+func (s redisRateLimitStore) ClearSuspension(tokenKey string) error {
+	client := cache.GetClient()
+	if client == nil {
+		return errRateLimitStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	return client.Del(ctx, s.key("suspended", tokenKey), s.key("cooldown", tokenKey)).Err()
+}
+
+// SetStateStore 设置多实例共享的状态存储，nil 表示只使用本地状态
+func (rl *RateLimiter) SetStateStore(store RateLimitStateStore) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.store = store
+}
+
+// SetSharedState 按配置开启或关闭基于 Redis 的共享状态
+func (rl *RateLimiter) SetSharedState(enabled bool) {
+	if enabled {
+		rl.SetStateStore(NewRedisRateLimitStore())
+		return
+	}
+	rl.SetStateStore(nil)
+}
+
+// stateStore 返回当前的共享状态存储
+func (rl *RateLimiter) stateStore() RateLimitStateStore {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.store
+}
+
+// sharedSyncState 记录一个 Token 的共享状态读取情况
+type sharedSyncState struct {
+	lastSync time.Time
+	inFlight bool
+}
+
+// claimSharedSync 判断 tokenKey 是否需要读取共享状态：距上次读取不足 sharedSyncInterval
+// 或已有读取在进行时返回 false，否则标记为读取中并返回 true
+func (rl *RateLimiter) claimSharedSync(tokenKey string) bool {
+	rl.syncMu.Lock()
+	defer rl.syncMu.Unlock()
+	if rl.sharedSyncs == nil {
+		rl.sharedSyncs = make(map[string]*sharedSyncState)
+	}
+	entry, ok := rl.sharedSyncs[tokenKey]
+	if !ok {
+		entry = &sharedSyncState{}
+		rl.sharedSyncs[tokenKey] = entry
+	}
+	if entry.inFlight || time.Since(entry.lastSync) < rl.sharedSyncInterval {
+		return false
+	}
+	entry.inFlight = true
+	return true
+}
+
+// finishSharedSync 结束 claimSharedSync 标记的读取。读取失败也计入间隔，
+// 存储不可用时不会让每次检查都等待超时。
+func (rl *RateLimiter) finishSharedSync(tokenKey string) {
+	rl.syncMu.Lock()
+	defer rl.syncMu.Unlock()
+	if entry, ok := rl.sharedSyncs[tokenKey]; ok {
+		entry.lastSync = time.Now()
+		entry.inFlight = false
+	}
+}
+
+// syncShared 在间隔到期时同步读取共享状态，用于本身就会等待的路径（WaitForTokenContext）
+func (rl *RateLimiter) syncShared(tokenKey string) {
+	store := rl.stateStore()
+	if store == nil || !rl.claimSharedSync(tokenKey) {
+		return
+	}
+	defer rl.finishSharedSync(tokenKey)
+	rl.applyShared(tokenKey, store)
+}
+
+// syncSharedAsync 在间隔到期时于后台读取共享状态，调用方立即返回并使用本地状态
+func (rl *RateLimiter) syncSharedAsync(tokenKey string) {
+	store := rl.stateStore()
+	if store == nil || !rl.claimSharedSync(tokenKey) {
+		return
+	}
+	go func() {
+		defer rl.finishSharedSync(tokenKey)
+		rl.applyShared(tokenKey, store)
+	}()
+}
+
+// applyShared 用共享状态覆盖本地的每日计数、冷却和暂停；存储出错时保留本地状态。
+// 共享计数以存储为准，因此空闲衰减不会降低多实例共享的每日计数。
+func (rl *RateLimiter) applyShared(tokenKey string, store RateLimitStateStore) {
+	shared, err := store.Load(tokenKey)
+	if err != nil {
+		logStoreError("load", tokenKey, err)
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	state := rl.getOrCreateState(tokenKey)
+	rl.resetDailyIfNeeded(state)
+	if !rl.slidingWindow {
+		state.DailyRequests = shared.DailyRequests
+	}
+	state.CooldownEnd = shared.CooldownEnd
+	if shared.SuspendedUntil.After(time.Now()) {
+		state.IsSuspended = true
+		state.SuspendReason = shared.SuspendReason
+		state.SuspendedAt = shared.SuspendedUntil.Add(-rl.suspendCooldown)
+		if state.CooldownEnd.Before(shared.SuspendedUntil) {
+			state.CooldownEnd = shared.SuspendedUntil
+		}
+	} else if state.IsSuspended {
+		// 暂停已到期（进入观察期）或已在其他实例上重置
+		rl.endSuspensionIfElapsed(state, time.Now())
+		if state.IsSuspended {
+			state.IsSuspended = false
+			state.SuspendedAt = time.Time{}
+			state.SuspendReason = ""
+		}
+	}
+}
+
+// recordSharedRequest 在共享存储中累加每日计数，并同步到本地
+func (rl *RateLimiter) recordSharedRequest(tokenKey string, store RateLimitStateStore, resetAt time.Time) {
+	count, err := store.IncrDaily(tokenKey, resetAt)
+	if err != nil {
+		logStoreError("increment daily count", tokenKey, err)
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.getOrCreateState(tokenKey).DailyRequests = count
+}
+
+func logStoreError(op, tokenKey string, err error) {
+	if err != nil {
+		log.Warnf("kiro rate limit: shared state %s failed for %s, using local state: %v", op, tokenKey, err)
+	}
+}
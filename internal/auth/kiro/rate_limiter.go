@@ -1,8 +1,12 @@
 package kiro
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"math/rand"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +21,12 @@ const (
 	DefaultBackoffMax        = 5 * time.Minute
 	DefaultBackoffMultiplier = 1.5
 	DefaultSuspendCooldown   = 1 * time.Hour
+	// DefaultDailyResetCheckInterval 后台检查每日计数重置的默认间隔
+	DefaultDailyResetCheckInterval = 1 * time.Minute
+	// DefaultProbationFactor 暂停结束后观察期开始时的容量比例
+	DefaultProbationFactor = 0.1
+	// DefaultFailureRateWindow 计算近期失败率所用的最近请求数
+	DefaultFailureRateWindow = 20
 )
 
 // TokenState Token 状态
@@ -30,6 +40,23 @@ type TokenState struct {
 	IsSuspended    bool
 	SuspendedAt    time.Time
 	SuspendReason  string
+	// DailyDecayedAt 上次空闲衰减结算到的时间
+	DailyDecayedAt time.Time
+	// window 滑动窗口模式下最近 24 小时的请求时间
+	window *slidingWindow
+	// ProbationStart 暂停自然结束、进入观察期的时间；零值表示不在观察期
+	ProbationStart time.Time
+	// TotalSuccess/TotalFailure 累计成功和失败次数，不随成功或暂停重置
+	TotalSuccess int
+	TotalFailure int
+	// RecentFailureRate 最近 N 次请求结果（见 RateLimiterConfig.FailureRateWindow）中的失败比例
+	RecentFailureRate float64
+	// recentFailures 最近 N 次请求结果，true 表示失败
+	recentFailures []bool
+	// Circuit 熔断器状态：失败后打开，退避结束后半开并只放行一个试探请求
+	Circuit CircuitState
+	// probeStartedAt 半开状态下试探请求的开始时间，零值表示尚未发出
+	probeStartedAt time.Time
 }
 
 // RateLimiter 频率限制器
@@ -44,7 +71,36 @@ type RateLimiter struct {
 	backoffMax        time.Duration
 	backoffMultiplier float64
 	suspendCooldown   time.Duration
-	rng               *rand.Rand
+	// resetLocation/resetHour 每日计数重置的时区和整点，默认 UTC 零点
+	resetLocation *time.Location
+	resetHour     int
+	// slidingWindow 为 true 时每日限额作用于任意连续 24 小时，而不是固定的自然日
+	slidingWindow bool
+	// probationDuration/probationFactor 暂停结束后的观察期：容量从 probationFactor 线性恢复到 100%
+	probationDuration time.Duration
+	probationFactor   float64
+	// failureRateWindow 计算近期失败率的请求数
+	failureRateWindow int
+	// idleDecayPerMinute 每空闲一分钟从每日计数中扣除的请求数，0 表示不衰减
+	idleDecayPerMinute int
+	// suspendStatuses 直接判定为账号暂停的 HTTP 状态码（不看响应体）
+	suspendStatuses map[int]struct{}
+	// suspendKeywords/suspendPatterns 自定义的暂停判定规则，均为空时使用默认关键词
+	suspendKeywords []string
+	suspendPatterns []*regexp.Regexp
+	rng             *rand.Rand
+	// dailyResetInterval 后台重置检查的间隔，dailyResetStop 关闭时停止该 goroutine
+	dailyResetInterval time.Duration
+	dailyResetStop     chan struct{}
+	// onSuspended Token 进入暂停状态时的异步回调（用于告警通知）
+	onSuspended func(tokenKey, reason string)
+	// store 多实例共享的状态存储，nil 表示只使用本地状态
+	store RateLimitStateStore
+	// sharedSyncInterval 同一 Token 两次读取共享状态的最小间隔；sharedSyncs 记录每个 Token
+	// 上次读取的时间和是否正在读取，由 syncMu 保护
+	sharedSyncInterval time.Duration
+	syncMu             sync.Mutex
+	sharedSyncs        map[string]*sharedSyncState
 }
 
 // NewRateLimiter 创建默认配置的频率限制器
@@ -59,7 +115,11 @@ func NewRateLimiter() *RateLimiter {
 		backoffMax:        DefaultBackoffMax,
 		backoffMultiplier: DefaultBackoffMultiplier,
 		suspendCooldown:   DefaultSuspendCooldown,
+		failureRateWindow: DefaultFailureRateWindow,
 		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		sharedSyncInterval: DefaultSharedSyncInterval,
+		sharedSyncs:        make(map[string]*sharedSyncState),
 	}
 }
 
@@ -73,6 +133,31 @@ type RateLimiterConfig struct {
 	BackoffMax        time.Duration
 	BackoffMultiplier float64
 	SuspendCooldown   time.Duration
+	// IdleDecayPerMinute 每空闲一分钟扣除的每日请求数
+	IdleDecayPerMinute int
+	// ResetLocation 每日计数重置所在时区（如服务商按美国太平洋时间零点重置额度），nil 表示 UTC
+	ResetLocation *time.Location
+	// ResetHour 每日计数在 ResetLocation 的几点重置（0-23），默认 0 点
+	ResetHour int
+	// ProbationDuration 暂停冷却结束后的观察期时长，0 表示不设观察期（立即恢复全部容量）。
+	// 观察期内每日限额按比例缩小、最小请求间隔按比例拉长，并在观察期内线性恢复到正常值。
+	ProbationDuration time.Duration
+	// ProbationFactor 观察期开始时的容量比例（0-1），默认 DefaultProbationFactor
+	ProbationFactor float64
+	// FailureRateWindow 计算近期失败率时统计的最近请求数，默认 DefaultFailureRateWindow
+	FailureRateWindow int
+	// Rand 计算间隔抖动和退避抖动使用的随机数生成器，nil 时以当前时间为种子创建；
+	// 测试中可传入固定种子的生成器得到确定的结果。调用方不应在其他地方并发使用它。
+	Rand *rand.Rand
+	// WindowMode 每日限额的计数方式：WindowModeFixed（默认）在重置时间点清零；
+	// WindowModeSliding 限制任意连续 24 小时内的请求数，此时 ResetLocation/ResetHour 与空闲衰减不生效，
+	// 共享状态存储也只同步冷却和暂停，每日计数只在本实例统计。
+	WindowMode string
+	// SuspendKeywords 表示账号暂停的关键词（不区分大小写的子串匹配）
+	SuspendKeywords []string
+	// SuspendPatterns 表示账号暂停的正则表达式，可用于其他语言或措辞不同的错误信息。
+	// SuspendKeywords 和 SuspendPatterns 均为空时使用默认关键词，否则只使用配置的规则。
+	SuspendPatterns []*regexp.Regexp
 }
 
 // NewRateLimiterWithConfig 使用自定义配置创建频率限制器
@@ -102,15 +187,127 @@ func NewRateLimiterWithConfig(cfg RateLimiterConfig) *RateLimiter {
 	if cfg.SuspendCooldown > 0 {
 		rl.suspendCooldown = cfg.SuspendCooldown
 	}
+	if cfg.IdleDecayPerMinute > 0 {
+		rl.idleDecayPerMinute = cfg.IdleDecayPerMinute
+	}
+	if cfg.Rand != nil {
+		rl.rng = cfg.Rand
+	}
+	if cfg.FailureRateWindow > 0 {
+		rl.failureRateWindow = cfg.FailureRateWindow
+	}
+	if cfg.ProbationDuration > 0 {
+		rl.probationDuration = cfg.ProbationDuration
+		rl.probationFactor = DefaultProbationFactor
+		if cfg.ProbationFactor > 0 && cfg.ProbationFactor < 1 {
+			rl.probationFactor = cfg.ProbationFactor
+		}
+	}
+	if cfg.ResetLocation != nil {
+		rl.resetLocation = cfg.ResetLocation
+	}
+	if cfg.ResetHour > 0 && cfg.ResetHour < 24 {
+		rl.resetHour = cfg.ResetHour
+	}
+	if strings.EqualFold(strings.TrimSpace(cfg.WindowMode), WindowModeSliding) {
+		rl.slidingWindow = true
+	}
+	for _, keyword := range cfg.SuspendKeywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			rl.suspendKeywords = append(rl.suspendKeywords, keyword)
+		}
+	}
+	for _, pattern := range cfg.SuspendPatterns {
+		if pattern != nil {
+			rl.suspendPatterns = append(rl.suspendPatterns, pattern)
+		}
+	}
 	return rl
 }
 
+// NewRateLimiterWithRand 创建使用指定随机数生成器的默认配置频率限制器，便于测试中得到确定的间隔和退避
+func NewRateLimiterWithRand(rng *rand.Rand) *RateLimiter {
+	return NewRateLimiterWithConfig(RateLimiterConfig{Rand: rng})
+}
+
+// SetDailyIdleDecay 设置空闲衰减速率（每分钟扣除的每日请求数），非正数表示关闭
+func (rl *RateLimiter) SetDailyIdleDecay(perMinute int) {
+	if perMinute < 0 {
+		perMinute = 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.idleDecayPerMinute = perMinute
+}
+
+// SetDailyResetCheckInterval 设置后台每日重置检查的间隔（秒）：0 使用默认值，负数关闭。
+// 空闲的 Token 不会触发 resetDailyIfNeeded，后台检查保证计数在跨日时及时清零。
+func (rl *RateLimiter) SetDailyResetCheckInterval(seconds int) {
+	interval := DefaultDailyResetCheckInterval
+	if seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	} else if seconds < 0 {
+		interval = 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.dailyResetStop != nil && rl.dailyResetInterval == interval {
+		return
+	}
+	if rl.dailyResetStop != nil {
+		close(rl.dailyResetStop)
+		rl.dailyResetStop = nil
+	}
+	rl.dailyResetInterval = interval
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	rl.dailyResetStop = stop
+	go rl.runDailyReset(interval, stop)
+}
+
+// StopDailyReset 停止后台每日重置检查
+func (rl *RateLimiter) StopDailyReset() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.dailyResetStop != nil {
+		close(rl.dailyResetStop)
+		rl.dailyResetStop = nil
+	}
+	rl.dailyResetInterval = 0
+}
+
+// runDailyReset 定期对所有已跟踪的 Token 执行每日重置检查
+func (rl *RateLimiter) runDailyReset(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rl.resetAllDaily()
+		}
+	}
+}
+
+// resetAllDaily 对所有 Token 状态执行 resetDailyIfNeeded
+func (rl *RateLimiter) resetAllDaily() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for _, state := range rl.states {
+		rl.resetDailyIfNeeded(state)
+	}
+}
+
 // getOrCreateState 获取或创建 Token 状态
 func (rl *RateLimiter) getOrCreateState(tokenKey string) *TokenState {
 	state, exists := rl.states[tokenKey]
 	if !exists {
 		state = &TokenState{
-			DailyResetTime: time.Now().Truncate(24 * time.Hour).Add(24 * time.Hour),
+			DailyResetTime: rl.nextDailyReset(time.Now()),
 		}
 		rl.states[tokenKey] = state
 	}
@@ -120,10 +317,75 @@ func (rl *RateLimiter) getOrCreateState(tokenKey string) *TokenState {
 // resetDailyIfNeeded 如果需要则重置每日计数
 func (rl *RateLimiter) resetDailyIfNeeded(state *TokenState) {
 	now := time.Now()
+	if rl.slidingWindow {
+		rl.slideWindow(state, now)
+		return
+	}
 	if now.After(state.DailyResetTime) {
 		state.DailyRequests = 0
-		state.DailyResetTime = now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		state.DailyResetTime = rl.nextDailyReset(now)
+		return
+	}
+	rl.decayDailyIfIdle(state, now)
+}
+
+// slideWindow 移出滑动窗口外的请求，并据此更新每日计数；
+// DailyResetTime 为窗口内最早一次请求移出窗口的时间
+func (rl *RateLimiter) slideWindow(state *TokenState, now time.Time) {
+	if state.window == nil {
+		state.window = newSlidingWindow(rl.dailyMaxRequests)
+	}
+	state.window.prune(now.Add(-slidingWindowSpan))
+	state.DailyRequests = state.window.size
+	if oldest := state.window.oldest(); !oldest.IsZero() {
+		state.DailyResetTime = oldest.Add(slidingWindowSpan)
+	}
+}
+
+// recordDailyLocked 将一次请求计入每日限额，调用方需持有写锁
+func (rl *RateLimiter) recordDailyLocked(state *TokenState, now time.Time) {
+	if !rl.slidingWindow {
+		state.DailyRequests++
+		return
+	}
+	rl.slideWindow(state, now)
+	state.window.add(now)
+	rl.slideWindow(state, now)
+}
+
+// nextDailyReset 返回 now 之后的下一个每日重置时间（resetLocation 的 resetHour 点）
+func (rl *RateLimiter) nextDailyReset(now time.Time) time.Time {
+	loc := rl.resetLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	reset := time.Date(local.Year(), local.Month(), local.Day(), rl.resetHour, 0, 0, 0, loc)
+	if !reset.After(now) {
+		reset = time.Date(local.Year(), local.Month(), local.Day()+1, rl.resetHour, 0, 0, 0, loc)
+	}
+	return reset
+}
+
+// decayDailyIfIdle 按空闲分钟数扣减每日计数（不低于 0），近似滚动窗口限额
+func (rl *RateLimiter) decayDailyIfIdle(state *TokenState, now time.Time) {
+	if rl.idleDecayPerMinute <= 0 || state.DailyRequests == 0 || state.LastRequest.IsZero() {
+		return
+	}
+	// 只结算上次请求或上次结算之后的空闲时间
+	since := state.LastRequest
+	if state.DailyDecayedAt.After(since) {
+		since = state.DailyDecayedAt
+	}
+	idleMinutes := int(now.Sub(since) / time.Minute)
+	if idleMinutes <= 0 {
+		return
+	}
+	state.DailyRequests -= idleMinutes * rl.idleDecayPerMinute
+	if state.DailyRequests < 0 {
+		state.DailyRequests = 0
 	}
+	state.DailyDecayedAt = since.Add(time.Duration(idleMinutes) * time.Minute)
 }
 
 // calculateInterval 计算带抖动的随机间隔
@@ -135,94 +397,308 @@ func (rl *RateLimiter) calculateInterval() time.Duration {
 
 // WaitForToken 等待 Token 可用（带抖动的随机间隔）
 func (rl *RateLimiter) WaitForToken(tokenKey string) {
+	_ = rl.WaitForTokenContext(context.Background(), tokenKey)
+}
+
+// WaitForTokenContext 同 WaitForToken，但在冷却或请求间隔等待期间 ctx 结束时立即返回 ctx.Err()，
+// 此时不记录本次请求。用于服务关闭时中断等待中的请求和后台任务。
+func (rl *RateLimiter) WaitForTokenContext(ctx context.Context, tokenKey string) error {
+	_, err := rl.waitForToken(ctx, tokenKey)
+	return err
+}
+
+// AcquireToken 同 WaitForTokenContext，成功时额外返回 release，调用方应在请求结束时调用（通常 defer）。
+// 本次请求是半开状态的试探请求、且退出前没有调用 MarkTokenSuccess/MarkTokenFailed 时，
+// release 释放试探名额，避免后续请求一直等到 halfOpenProbeTimeout；其余情况下 release 不做任何事。
+func (rl *RateLimiter) AcquireToken(ctx context.Context, tokenKey string) (release func(), err error) {
+	probe, err := rl.waitForToken(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	return func() { rl.releaseProbe(tokenKey, probe) }, nil
+}
+
+// releaseProbe 释放 tokenKey 上由 probe 登记的未完成试探请求
+func (rl *RateLimiter) releaseProbe(tokenKey string, probe time.Time) {
+	if probe.IsZero() {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if state, ok := rl.states[tokenKey]; ok {
+		rl.releaseProbeLocked(state, probe)
+	}
+}
+
+// waitForToken 实现 WaitForTokenContext，返回本次请求登记的试探时间（非试探请求为零值）
+func (rl *RateLimiter) waitForToken(ctx context.Context, tokenKey string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	rl.syncShared(tokenKey)
+
 	rl.mu.Lock()
 	state := rl.getOrCreateState(tokenKey)
 	rl.resetDailyIfNeeded(state)
 
 	now := time.Now()
-
-	// 检查是否在冷却期
-	if now.Before(state.CooldownEnd) {
-		waitTime := state.CooldownEnd.Sub(now)
+	rl.endSuspensionIfElapsed(state, now)
+
+	// 等待冷却期结束；熔断器半开且已有试探请求时，等待试探结果
+	for {
+		rl.updateCircuitLocked(state, now)
+		var waitTime time.Duration
+		if now.Before(state.CooldownEnd) {
+			waitTime = state.CooldownEnd.Sub(now)
+		} else if rl.probeInFlightLocked(state, now) {
+			waitTime = rl.minTokenInterval
+		}
+		if waitTime <= 0 {
+			break
+		}
 		rl.mu.Unlock()
-		time.Sleep(waitTime)
+		if err := sleepContext(ctx, waitTime); err != nil {
+			return time.Time{}, err
+		}
 		rl.mu.Lock()
 		state = rl.getOrCreateState(tokenKey)
 		now = time.Now()
 	}
+	probe := rl.claimProbeLocked(state, now)
 
-	// 计算距离上次请求的间隔
-	interval := rl.calculateInterval()
+	// 计算距离上次请求的间隔（观察期内按比例拉长）
+	interval := time.Duration(float64(rl.calculateInterval()) / rl.probationFactorLocked(state, now))
 	nextAllowedTime := state.LastRequest.Add(interval)
 
 	if now.Before(nextAllowedTime) {
 		waitTime := nextAllowedTime.Sub(now)
 		rl.mu.Unlock()
-		time.Sleep(waitTime)
+		if err := sleepContext(ctx, waitTime); err != nil {
+			// 请求不会发出，释放已登记的试探名额
+			rl.releaseProbe(tokenKey, probe)
+			return time.Time{}, err
+		}
 		rl.mu.Lock()
 		state = rl.getOrCreateState(tokenKey)
 	}
 
 	state.LastRequest = time.Now()
 	state.RequestCount++
-	state.DailyRequests++
+	rl.recordDailyLocked(state, state.LastRequest)
+	store, resetAt := rl.store, state.DailyResetTime
 	rl.mu.Unlock()
+
+	if store != nil && !rl.slidingWindow {
+		rl.recordSharedRequest(tokenKey, store, resetAt)
+	}
+	return probe, nil
+}
+
+// sleepContext 等待 d 或直到 ctx 结束
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 // MarkTokenFailed 标记 Token 失败
 func (rl *RateLimiter) MarkTokenFailed(tokenKey string) {
 	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	state := rl.getOrCreateState(tokenKey)
 	state.FailCount++
+	rl.recordOutcomeLocked(state, true)
 	state.CooldownEnd = time.Now().Add(rl.calculateBackoff(state.FailCount))
+	// 半开状态的试探失败时 FailCount 未清零，因此以更长的退避重新打开
+	state.Circuit = CircuitOpen
+	state.probeStartedAt = time.Time{}
+	store, cooldownEnd := rl.store, state.CooldownEnd
+	rl.mu.Unlock()
+
+	if store != nil {
+		logStoreError("set cooldown", tokenKey, store.SetCooldown(tokenKey, cooldownEnd))
+	}
 }
 
 // MarkTokenSuccess 标记 Token 成功
 func (rl *RateLimiter) MarkTokenSuccess(tokenKey string) {
 	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	state := rl.getOrCreateState(tokenKey)
+	hadCooldown := !state.CooldownEnd.IsZero()
 	state.FailCount = 0
+	rl.recordOutcomeLocked(state, false)
+	state.Circuit = CircuitClosed
+	state.probeStartedAt = time.Time{}
 	state.CooldownEnd = time.Time{}
+	store := rl.store
+	rl.mu.Unlock()
+
+	if store != nil && hadCooldown {
+		logStoreError("clear cooldown", tokenKey, store.SetCooldown(tokenKey, time.Time{}))
+	}
+}
+
+// recordOutcomeLocked 记录一次请求结果，更新累计计数和近期失败率，调用方需持有写锁
+func (rl *RateLimiter) recordOutcomeLocked(state *TokenState, failed bool) {
+	if failed {
+		state.TotalFailure++
+	} else {
+		state.TotalSuccess++
+	}
+	state.recentFailures = append(state.recentFailures, failed)
+	if n := len(state.recentFailures) - rl.failureRateWindow; n > 0 {
+		state.recentFailures = append([]bool(nil), state.recentFailures[n:]...)
+	}
+	failures := 0
+	for _, f := range state.recentFailures {
+		if f {
+			failures++
+		}
+	}
+	state.RecentFailureRate = float64(failures) / float64(len(state.recentFailures))
 }
 
 // CheckAndMarkSuspended 检测暂停错误并标记
 func (rl *RateLimiter) CheckAndMarkSuspended(tokenKey string, errorMsg string) bool {
-	suspendKeywords := []string{
-		"suspended",
-		"banned",
-		"disabled",
-		"account has been",
-		"access denied",
-		"rate limit exceeded",
-		"too many requests",
-		"quota exceeded",
+	if !rl.isSuspendedMessage(errorMsg) {
+		return false
+	}
+
+	rl.mu.Lock()
+	rl.markSuspendedLocked(tokenKey, errorMsg)
+	rl.mu.Unlock()
+
+	rl.publishSuspension(tokenKey, errorMsg)
+	return true
+}
+
+// SetSuspendStatusCodes 设置直接判定为暂停的 HTTP 状态码，空列表表示关闭
+func (rl *RateLimiter) SetSuspendStatusCodes(codes []int) {
+	var statuses map[int]struct{}
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			continue
+		}
+		if statuses == nil {
+			statuses = make(map[int]struct{}, len(codes))
+		}
+		statuses[code] = struct{}{}
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.suspendStatuses = statuses
+}
+
+// MarkSuspendedByStatus 若状态码在配置的暂停状态码中则标记 Token 暂停，不依赖响应体内容
+// （AWS/Kiro 有时只返回不带说明的 403/451）
+func (rl *RateLimiter) MarkSuspendedByStatus(tokenKey string, status int) bool {
+	rl.mu.Lock()
+	if _, ok := rl.suspendStatuses[status]; !ok {
+		rl.mu.Unlock()
+		return false
+	}
+	reason := fmt.Sprintf("HTTP %d", status)
+	rl.markSuspendedLocked(tokenKey, reason)
+	rl.mu.Unlock()
+
+	rl.publishSuspension(tokenKey, reason)
+	return true
+}
+
+// SetOnSuspended 设置 Token 新进入暂停状态时的回调，回调在独立 goroutine 中执行
+func (rl *RateLimiter) SetOnSuspended(callback func(tokenKey, reason string)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.onSuspended = callback
+}
+
+// markSuspendedLocked 标记 Token 暂停并进入暂停冷却，调用方需持有写锁
+func (rl *RateLimiter) markSuspendedLocked(tokenKey, reason string) {
+	now := time.Now()
+	state := rl.getOrCreateState(tokenKey)
+	wasSuspended := state.IsSuspended
+	state.IsSuspended = true
+	state.SuspendedAt = now
+	state.SuspendReason = reason
+	state.ProbationStart = time.Time{}
+	state.CooldownEnd = now.Add(rl.suspendCooldown)
+	if !wasSuspended && rl.onSuspended != nil {
+		go rl.onSuspended(tokenKey, reason)
 	}
+}
 
+// publishSuspension 将暂停写入共享存储，使其他实例也停止使用该 Token
+func (rl *RateLimiter) publishSuspension(tokenKey, reason string) {
+	rl.mu.RLock()
+	store, cooldown := rl.store, rl.suspendCooldown
+	rl.mu.RUnlock()
+	if store != nil {
+		logStoreError("suspend", tokenKey, store.Suspend(tokenKey, reason, cooldown))
+	}
+}
+
+// suspendKeywords 错误信息中表示账号被暂停或限制的关键词
+var suspendKeywords = []string{
+	"suspended",
+	"banned",
+	"disabled",
+	"account has been",
+	"access denied",
+	"quota exceeded",
+}
+
+// throttleKeywords 错误信息中表示限流的关键词：它们不代表账号被暂停，但未配置自定义规则时
+// 频率限制器仍会据此暂停 Token
+var throttleKeywords = []string{
+	"rate limit exceeded",
+	"too many requests",
+}
+
+// isSuspendedMessage 检查错误信息是否包含暂停关键词，限流信息不算暂停
+func isSuspendedMessage(errorMsg string) bool {
+	return containsKeyword(errorMsg, suspendKeywords)
+}
+
+// containsKeyword 不区分大小写地检查 errorMsg 是否包含任一（小写）关键词
+func containsKeyword(errorMsg string, keywords []string) bool {
 	lowerMsg := strings.ToLower(errorMsg)
-	for _, keyword := range suspendKeywords {
+	for _, keyword := range keywords {
 		if strings.Contains(lowerMsg, keyword) {
-			rl.mu.Lock()
-			defer rl.mu.Unlock()
-
-			state := rl.getOrCreateState(tokenKey)
-			state.IsSuspended = true
-			state.SuspendedAt = time.Now()
-			state.SuspendReason = errorMsg
-			state.CooldownEnd = time.Now().Add(rl.suspendCooldown)
 			return true
 		}
 	}
 	return false
 }
 
-// IsTokenAvailable 检查 Token 是否可用
+// isSuspendedMessage 按配置的关键词和正则判断错误信息是否表示暂停，未配置时使用默认关键词
+func (rl *RateLimiter) isSuspendedMessage(errorMsg string) bool {
+	if len(rl.suspendKeywords) == 0 && len(rl.suspendPatterns) == 0 {
+		return isSuspendedMessage(errorMsg) || containsKeyword(errorMsg, throttleKeywords)
+	}
+	if containsKeyword(errorMsg, rl.suspendKeywords) {
+		return true
+	}
+	for _, pattern := range rl.suspendPatterns {
+		if pattern.MatchString(errorMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTokenAvailable 检查 Token 是否可用。只读取本地状态，不会等待共享存储：
+// 调度在持有管理器锁时调用它，共享状态在后台刷新，供之后的检查使用。
 func (rl *RateLimiter) IsTokenAvailable(tokenKey string) bool {
-	rl.mu.RLock()
-	defer rl.mu.RUnlock()
+	rl.syncSharedAsync(tokenKey)
+
+	// resetDailyIfNeeded 会修改状态，整个检查都在写锁内完成
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
 	state, exists := rl.states[tokenKey]
 	if !exists {
@@ -231,33 +707,186 @@ func (rl *RateLimiter) IsTokenAvailable(tokenKey string) bool {
 
 	now := time.Now()
 
-	// 检查是否被暂停
+	// 检查是否被暂停，暂停到期则转入观察期
+	rl.endSuspensionIfElapsed(state, now)
 	if state.IsSuspended {
-		if now.After(state.SuspendedAt.Add(rl.suspendCooldown)) {
-			return true
-		}
 		return false
 	}
 
-	// 检查是否在冷却期
+	// 检查是否在冷却期，以及熔断器半开时是否已有试探请求
 	if now.Before(state.CooldownEnd) {
 		return false
 	}
+	rl.updateCircuitLocked(state, now)
+	if rl.probeInFlightLocked(state, now) {
+		return false
+	}
 
 	// 检查每日请求限制
-	rl.mu.RUnlock()
+	rl.resetDailyIfNeeded(state)
+	return state.DailyRequests < rl.effectiveDailyMaxLocked(state, now)
+}
+
+// endSuspensionIfElapsed 暂停冷却到期后解除暂停，并在配置了观察期时从到期时间开始观察期，调用方需持有写锁
+func (rl *RateLimiter) endSuspensionIfElapsed(state *TokenState, now time.Time) {
+	if !state.IsSuspended {
+		return
+	}
+	resumeAt := state.SuspendedAt.Add(rl.suspendCooldown)
+	if now.Before(resumeAt) {
+		return
+	}
+	state.IsSuspended = false
+	state.SuspendReason = ""
+	if rl.probationDuration > 0 {
+		state.ProbationStart = resumeAt
+	}
+}
+
+// probationFactorLocked 返回 Token 当前的容量比例：观察期内从 probationFactor 线性升至 1，其余时间为 1
+func (rl *RateLimiter) probationFactorLocked(state *TokenState, now time.Time) float64 {
+	if rl.probationDuration <= 0 || state.ProbationStart.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(state.ProbationStart)
+	if elapsed >= rl.probationDuration {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return rl.probationFactor + (1-rl.probationFactor)*float64(elapsed)/float64(rl.probationDuration)
+}
+
+// effectiveDailyMaxLocked 返回考虑观察期后的每日限额（至少为 1）
+func (rl *RateLimiter) effectiveDailyMaxLocked(state *TokenState, now time.Time) int {
+	dailyMax := int(float64(rl.dailyMaxRequests) * rl.probationFactorLocked(state, now))
+	if dailyMax < 1 {
+		dailyMax = 1
+	}
+	return dailyMax
+}
+
+// NextAvailableTime 返回 Token 恢复可用的时间；可用时返回零值
+func (rl *RateLimiter) NextAvailableTime(tokenKey string) time.Time {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	state, exists := rl.states[tokenKey]
+	if !exists {
+		return time.Time{}
+	}
+	return rl.nextAvailableLocked(state, time.Now())
+}
+
+// nextAvailableLocked 计算暂停、冷却或每日限额解除的时间，调用方需持有锁
+func (rl *RateLimiter) nextAvailableLocked(state *TokenState, now time.Time) time.Time {
+	if state.IsSuspended {
+		if resumeAt := state.SuspendedAt.Add(rl.suspendCooldown); now.Before(resumeAt) {
+			return resumeAt
+		}
+		return time.Time{}
+	}
+	if now.Before(state.CooldownEnd) {
+		return state.CooldownEnd
+	}
+	if rl.probeInFlightLocked(state, now) {
+		return state.probeStartedAt.Add(halfOpenProbeTimeout)
+	}
+	if state.DailyRequests >= rl.effectiveDailyMaxLocked(state, now) && now.Before(state.DailyResetTime) {
+		// 开启空闲衰减时，下一次结算即可恢复额度
+		if !rl.slidingWindow && rl.idleDecayPerMinute > 0 && !state.LastRequest.IsZero() {
+			since := state.LastRequest
+			if state.DailyDecayedAt.After(since) {
+				since = state.DailyDecayedAt
+			}
+			if next := since.Add(time.Minute); next.Before(state.DailyResetTime) {
+				return next
+			}
+		}
+		return state.DailyResetTime
+	}
+	return time.Time{}
+}
+
+// Allow 非阻塞版的 WaitForToken：检查暂停、冷却、每日限额和最小请求间隔，
+// Token 就绪时立即记录一次请求并返回 true；否则不做记录，返回 false 和预计还需等待的时长，
+// 便于调用方改选其他 Token 而不是阻塞在这一个上。共享状态与 IsTokenAvailable 一样在后台同步。
+func (rl *RateLimiter) Allow(tokenKey string) (bool, time.Duration) {
+	rl.syncSharedAsync(tokenKey)
+
 	rl.mu.Lock()
+	state := rl.getOrCreateState(tokenKey)
 	rl.resetDailyIfNeeded(state)
-	dailyRequests := state.DailyRequests
-	dailyMax := rl.dailyMaxRequests
+
+	now := time.Now()
+	rl.endSuspensionIfElapsed(state, now)
+	rl.updateCircuitLocked(state, now)
+	readyAt := rl.nextAvailableLocked(state, now)
+	if !state.LastRequest.IsZero() {
+		minInterval := time.Duration(float64(rl.minTokenInterval) / rl.probationFactorLocked(state, now))
+		if next := state.LastRequest.Add(minInterval); next.After(readyAt) {
+			readyAt = next
+		}
+	}
+	if now.Before(readyAt) {
+		rl.mu.Unlock()
+		return false, readyAt.Sub(now)
+	}
+
+	rl.claimProbeLocked(state, now)
+	state.LastRequest = now
+	state.RequestCount++
+	rl.recordDailyLocked(state, now)
+	store, resetAt := rl.store, state.DailyResetTime
 	rl.mu.Unlock()
-	rl.mu.RLock()
 
-	if dailyRequests >= dailyMax {
-		return false
+	if store != nil && !rl.slidingWindow {
+		rl.recordSharedRequest(tokenKey, store, resetAt)
 	}
+	return true, 0
+}
 
-	return true
+// SelectToken 从候选 Token 中挑选下一个要使用的 Token：优先每日剩余额度最多的，
+// 额度相同时选上次请求最早的，以便在账号池内均摊负载。按此顺序逐个调用 Allow，
+// 返回第一个就绪的 Token（已记录一次请求）；全部不可用时返回 false。
+func (rl *RateLimiter) SelectToken(candidates []string) (string, bool) {
+	type candidate struct {
+		tokenKey    string
+		remaining   int
+		lastRequest time.Time
+	}
+
+	now := time.Now()
+	rl.mu.RLock()
+	ranked := make([]candidate, 0, len(candidates))
+	seen := make(map[string]struct{}, len(candidates))
+	for _, tokenKey := range candidates {
+		if _, dup := seen[tokenKey]; dup {
+			continue
+		}
+		seen[tokenKey] = struct{}{}
+		c := candidate{tokenKey: tokenKey, remaining: rl.dailyMaxRequests}
+		if state, exists := rl.states[tokenKey]; exists {
+			c.remaining = rl.effectiveDailyMaxLocked(state, now) - state.DailyRequests
+			c.lastRequest = state.LastRequest
+		}
+		ranked = append(ranked, c)
+	}
+	rl.mu.RUnlock()
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].remaining != ranked[j].remaining {
+			return ranked[i].remaining > ranked[j].remaining
+		}
+		return ranked[i].lastRequest.Before(ranked[j].lastRequest)
+	})
+	for _, c := range ranked {
+		if ok, _ := rl.Allow(c.tokenKey); ok {
+			return c.tokenKey, true
+		}
+	}
+	return "", false
 }
 
 // calculateBackoff 计算指数退避时间
@@ -290,9 +919,70 @@ func (rl *RateLimiter) GetTokenState(tokenKey string) *TokenState {
 
 	// 返回副本以防止外部修改
 	stateCopy := *state
+	stateCopy.recentFailures = append([]bool(nil), state.recentFailures...)
+	stateCopy.Circuit = currentCircuit(state, time.Now())
 	return &stateCopy
 }
 
+// TokenStateSnapshot 单个 Token 限流状态的只读快照
+type TokenStateSnapshot struct {
+	TokenKey       string    `json:"token_key"`
+	DailyUsed      int       `json:"daily_used"`
+	DailyRemaining int       `json:"daily_remaining"`
+	DailyResetTime time.Time `json:"daily_reset_time"`
+	CooldownEnd    time.Time `json:"cooldown_end"`
+	InCooldown     bool      `json:"in_cooldown"`
+	FailCount      int       `json:"fail_count"`
+	IsSuspended    bool      `json:"is_suspended"`
+	SuspendedAt    time.Time `json:"suspended_at"`
+	SuspendReason  string    `json:"suspend_reason,omitempty"`
+	LastRequest    time.Time `json:"last_request"`
+	InProbation    bool      `json:"in_probation"`
+	Circuit        string    `json:"circuit"`
+}
+
+// Snapshot 返回所有已跟踪 Token 的状态快照（按 TokenKey 排序），供管理接口和监控展示。
+// 只持有读锁，不触发每日重置；已过重置时间的计数按 0 计算。
+func (rl *RateLimiter) Snapshot() []TokenStateSnapshot {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := time.Now()
+	snapshots := make([]TokenStateSnapshot, 0, len(rl.states))
+	for tokenKey, state := range rl.states {
+		used := state.DailyRequests
+		if rl.slidingWindow && state.window != nil {
+			used = state.window.countAfter(now.Add(-slidingWindowSpan))
+		} else if now.After(state.DailyResetTime) {
+			used = 0
+		}
+		remaining := rl.effectiveDailyMaxLocked(state, now) - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		suspended := state.IsSuspended && now.Before(state.SuspendedAt.Add(rl.suspendCooldown))
+		snapshots = append(snapshots, TokenStateSnapshot{
+			TokenKey:       tokenKey,
+			DailyUsed:      used,
+			DailyRemaining: remaining,
+			DailyResetTime: state.DailyResetTime,
+			CooldownEnd:    state.CooldownEnd,
+			InCooldown:     now.Before(state.CooldownEnd),
+			FailCount:      state.FailCount,
+			IsSuspended:    suspended,
+			SuspendedAt:    state.SuspendedAt,
+			SuspendReason:  state.SuspendReason,
+			LastRequest:    state.LastRequest,
+			InProbation:    rl.probationFactorLocked(state, now) < 1,
+			Circuit:        currentCircuit(state, now).String(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].TokenKey < snapshots[j].TokenKey
+	})
+	return snapshots
+}
+
 // ClearTokenState 清除 Token 状态
 func (rl *RateLimiter) ClearTokenState(tokenKey string) {
 	rl.mu.Lock()
@@ -303,8 +993,6 @@ func (rl *RateLimiter) ClearTokenState(tokenKey string) {
 // ResetSuspension 重置暂停状态
 func (rl *RateLimiter) ResetSuspension(tokenKey string) {
 	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	state, exists := rl.states[tokenKey]
 	if exists {
 		state.IsSuspended = false
@@ -312,5 +1000,14 @@ func (rl *RateLimiter) ResetSuspension(tokenKey string) {
 		state.SuspendReason = ""
 		state.CooldownEnd = time.Time{}
 		state.FailCount = 0
+		state.ProbationStart = time.Time{}
+		state.Circuit = CircuitClosed
+		state.probeStartedAt = time.Time{}
+	}
+	store := rl.store
+	rl.mu.Unlock()
+
+	if store != nil {
+		logStoreError("clear suspension", tokenKey, store.ClearSuspension(tokenKey))
 	}
 }
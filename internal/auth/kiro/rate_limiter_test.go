@@ -1,9 +1,18 @@
 package kiro
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 )
 
 func TestNewRateLimiter(t *testing.T) {
@@ -302,3 +311,872 @@ func TestCalculateInterval_WithinRange(t *testing.T) {
 		}
 	}
 }
+
+func TestDailyIdleDecay(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{DailyMaxRequests: 10, IdleDecayPerMinute: 2})
+	now := time.Now()
+	rl.states["token1"] = &TokenState{
+		LastRequest:    now.Add(-3*time.Minute - 30*time.Second),
+		DailyRequests:  10,
+		DailyResetTime: now.Add(time.Hour),
+	}
+
+	if !rl.IsTokenAvailable("token1") {
+		t.Fatal("expected token to regain headroom after idle decay")
+	}
+	state := rl.GetTokenState("token1")
+	if state.DailyRequests != 4 {
+		t.Errorf("expected DailyRequests 4 after 3 idle minutes, got %d", state.DailyRequests)
+	}
+
+	// The same idle window is not decayed twice.
+	rl.IsTokenAvailable("token1")
+	if state := rl.GetTokenState("token1"); state.DailyRequests != 4 {
+		t.Errorf("expected DailyRequests to stay 4, got %d", state.DailyRequests)
+	}
+
+	rl.states["token1"].DailyDecayedAt = now.Add(-time.Hour)
+	rl.states["token1"].LastRequest = now.Add(-time.Hour)
+	rl.IsTokenAvailable("token1")
+	if state := rl.GetTokenState("token1"); state.DailyRequests != 0 {
+		t.Errorf("expected DailyRequests floored at 0, got %d", state.DailyRequests)
+	}
+
+	rl.SetDailyIdleDecay(0)
+	rl.states["token1"].DailyRequests = 10
+	if rl.IsTokenAvailable("token1") {
+		t.Error("expected daily cap to hold with decay disabled")
+	}
+}
+
+func TestMarkSuspendedByStatus(t *testing.T) {
+	rl := NewRateLimiter()
+	if rl.MarkSuspendedByStatus("token1", 403) {
+		t.Fatal("expected no suspension without configured statuses")
+	}
+
+	rl.SetSuspendStatusCodes([]int{403, 451, 0})
+	if rl.MarkSuspendedByStatus("token1", 400) {
+		t.Error("expected 400 not to suspend")
+	}
+	if !rl.MarkSuspendedByStatus("token1", 451) {
+		t.Fatal("expected 451 to suspend")
+	}
+	state := rl.GetTokenState("token1")
+	if !state.IsSuspended || state.SuspendReason != "HTTP 451" {
+		t.Errorf("expected token suspended with reason HTTP 451, got %+v", state)
+	}
+	if rl.IsTokenAvailable("token1") {
+		t.Error("expected suspended token to be unavailable")
+	}
+
+	rl.SetSuspendStatusCodes(nil)
+	if rl.MarkSuspendedByStatus("token2", 451) {
+		t.Error("expected clearing the status list to disable suspension")
+	}
+}
+
+func TestDailyResetCheckResetsIdleTokens(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.mu.Lock()
+	state := rl.getOrCreateState("idle")
+	state.DailyRequests = 42
+	state.DailyResetTime = time.Now().Add(-time.Minute)
+	rl.mu.Unlock()
+
+	rl.SetDailyResetCheckInterval(1)
+	defer rl.StopDailyReset()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := rl.GetTokenState("idle"); got.DailyRequests == 0 && got.DailyResetTime.After(time.Now()) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected idle token's daily count to be reset by the background check, got %+v", rl.GetTokenState("idle"))
+}
+
+func TestSetDailyResetCheckIntervalDisable(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.SetDailyResetCheckInterval(0)
+	if rl.dailyResetStop == nil || rl.dailyResetInterval != DefaultDailyResetCheckInterval {
+		t.Fatalf("expected default interval %v, got %v", DefaultDailyResetCheckInterval, rl.dailyResetInterval)
+	}
+	rl.SetDailyResetCheckInterval(-1)
+	if rl.dailyResetStop != nil {
+		t.Fatal("expected a negative interval to stop the background check")
+	}
+}
+
+func TestOnSuspendedFiresOncePerSuspension(t *testing.T) {
+	rl := NewRateLimiter()
+	calls := make(chan string, 4)
+	rl.SetOnSuspended(func(tokenKey, reason string) { calls <- tokenKey + ":" + reason })
+	rl.SetSuspendStatusCodes([]int{403})
+
+	rl.MarkSuspendedByStatus("token1", 403)
+	rl.MarkSuspendedByStatus("token1", 403)
+
+	select {
+	case got := <-calls:
+		if got != "token1:HTTP 403" {
+			t.Errorf("unexpected callback %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected suspension callback")
+	}
+	select {
+	case got := <-calls:
+		t.Errorf("expected no callback for an already suspended token, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+type memoryRateLimitStore struct {
+	mu     sync.Mutex
+	states map[string]SharedTokenState
+}
+
+func (s *memoryRateLimitStore) update(tokenKey string, fn func(*SharedTokenState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]SharedTokenState)
+	}
+	state := s.states[tokenKey]
+	fn(&state)
+	s.states[tokenKey] = state
+}
+
+func (s *memoryRateLimitStore) Load(tokenKey string) (SharedTokenState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[tokenKey], nil
+}
+
+func (s *memoryRateLimitStore) IncrDaily(tokenKey string, _ time.Time) (int, error) {
+	var count int
+	s.update(tokenKey, func(state *SharedTokenState) {
+		state.DailyRequests++
+		count = state.DailyRequests
+	})
+	return count, nil
+}
+
+func (s *memoryRateLimitStore) SetCooldown(tokenKey string, until time.Time) error {
+	s.update(tokenKey, func(state *SharedTokenState) { state.CooldownEnd = until })
+	return nil
+}
+
+func (s *memoryRateLimitStore) Suspend(tokenKey, reason string, cooldown time.Duration) error {
+	s.update(tokenKey, func(state *SharedTokenState) {
+		state.SuspendedUntil = time.Now().Add(cooldown)
+		state.SuspendReason = reason
+	})
+	return nil
+}
+
+func (s *memoryRateLimitStore) ClearSuspension(tokenKey string) error {
+	s.update(tokenKey, func(state *SharedTokenState) {
+		state.SuspendedUntil = time.Time{}
+		state.SuspendReason = ""
+		state.CooldownEnd = time.Time{}
+	})
+	return nil
+}
+
+var (
+	testRedisOnce sync.Once
+	testRedis     *miniredis.Miniredis
+	testRedisErr  error
+)
+
+// startTestRedis points the shared Redis client at an in-process miniredis server, emptied
+// for the calling test. The client is initialized once per test binary.
+func startTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	testRedisOnce.Do(func() {
+		testRedis, testRedisErr = miniredis.Run()
+		if testRedisErr == nil {
+			testRedisErr = cache.InitRedisCache(config.RedisCacheConfig{Enable: true, Addr: testRedis.Addr()})
+		}
+	})
+	if testRedisErr != nil {
+		t.Fatalf("start test redis: %v", testRedisErr)
+	}
+	testRedis.FlushAll()
+	return testRedis
+}
+
+// waitTokenAvailable polls IsTokenAvailable until it reports want, as shared state is
+// synced in the background.
+func waitTokenAvailable(t *testing.T, rl *RateLimiter, tokenKey string, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for rl.IsTokenAvailable(tokenKey) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("IsTokenAvailable(%q) did not become %v", tokenKey, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSharedStateAcrossRateLimiters(t *testing.T) {
+	stores := map[string]func(t *testing.T) RateLimitStateStore{
+		"memory": func(t *testing.T) RateLimitStateStore { return &memoryRateLimitStore{} },
+		"redis": func(t *testing.T) RateLimitStateStore {
+			startTestRedis(t)
+			return NewRedisRateLimitStore()
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			newLimiter := func() *RateLimiter {
+				rl := NewRateLimiterWithConfig(RateLimiterConfig{
+					MinTokenInterval: time.Millisecond,
+					MaxTokenInterval: 2 * time.Millisecond,
+					DailyMaxRequests: 2,
+				})
+				rl.SetStateStore(store)
+				rl.sharedSyncInterval = 0
+				return rl
+			}
+			a, b := newLimiter(), newLimiter()
+
+			if !a.CheckAndMarkSuspended("suspended", "Account has been suspended") {
+				t.Fatal("CheckAndMarkSuspended() = false, want true")
+			}
+			waitTokenAvailable(t, b, "suspended", false)
+			if state := b.GetTokenState("suspended"); state == nil || !state.IsSuspended || state.SuspendReason != "Account has been suspended" {
+				t.Fatalf("synced state = %+v, want the shared suspension", state)
+			}
+			b.ResetSuspension("suspended")
+			waitTokenAvailable(t, a, "suspended", true)
+
+			a.MarkTokenFailed("failing")
+			waitTokenAvailable(t, b, "failing", false)
+			b.MarkTokenSuccess("failing")
+			waitTokenAvailable(t, a, "failing", true)
+
+			a.WaitForToken("daily")
+			b.WaitForToken("daily")
+			if state := a.GetTokenState("daily"); state == nil || state.DailyRequests != 1 {
+				t.Fatalf("limiter a daily count = %+v, want 1 before syncing", state)
+			}
+			waitTokenAvailable(t, a, "daily", false)
+			if state := a.GetTokenState("daily"); state.DailyRequests != 2 {
+				t.Fatalf("limiter a daily count after sync = %d, want 2", state.DailyRequests)
+			}
+		})
+	}
+}
+
+// slowRateLimitStore delays every Load, like a Redis server that stopped responding.
+type slowRateLimitStore struct {
+	memoryRateLimitStore
+	delay time.Duration
+	loads atomic.Int32
+}
+
+func (s *slowRateLimitStore) Load(tokenKey string) (SharedTokenState, error) {
+	s.loads.Add(1)
+	time.Sleep(s.delay)
+	return s.memoryRateLimitStore.Load(tokenKey)
+}
+
+func TestIsTokenAvailableDoesNotWaitForSharedState(t *testing.T) {
+	store := &slowRateLimitStore{delay: 300 * time.Millisecond}
+	rl := NewRateLimiter()
+	rl.SetStateStore(store)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if !rl.IsTokenAvailable("token") {
+			t.Fatal("IsTokenAvailable() = false, want the local state of an unused token")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("IsTokenAvailable() took %v, want it not to wait for the store", elapsed)
+	}
+	time.Sleep(2 * store.delay)
+	if loads := store.loads.Load(); loads != 1 {
+		t.Fatalf("store loads = %d, want a single background sync within the sync interval", loads)
+	}
+}
+
+func TestAllowDoesNotWaitForSharedState(t *testing.T) {
+	store := &slowRateLimitStore{delay: 300 * time.Millisecond}
+	rl := NewRateLimiter()
+	rl.SetStateStore(store)
+
+	start := time.Now()
+	if ok, _ := rl.Allow("token"); !ok {
+		t.Fatal("Allow() = false, want the local state of an unused token")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Allow() took %v, want it not to wait for the store", elapsed)
+	}
+	time.Sleep(2 * store.delay)
+	if loads := store.loads.Load(); loads != 1 {
+		t.Fatalf("store loads = %d, want a single background sync", loads)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Hour,
+		MaxTokenInterval: 2 * time.Hour,
+		DailyMaxRequests: 2,
+	})
+
+	if ok, retryAfter := rl.Allow("spaced"); !ok || retryAfter != 0 {
+		t.Fatalf("first Allow() = %v, %v; want true, 0", ok, retryAfter)
+	}
+	ok, retryAfter := rl.Allow("spaced")
+	if ok || retryAfter <= 0 || retryAfter > time.Hour {
+		t.Fatalf("Allow() within min interval = %v, %v; want false with a wait up to 1h", ok, retryAfter)
+	}
+	if state := rl.GetTokenState("spaced"); state.RequestCount != 1 {
+		t.Fatalf("RequestCount = %d, want refused calls not to be recorded", state.RequestCount)
+	}
+
+	rl.MarkTokenFailed("cooling")
+	ok, retryAfter = rl.Allow("cooling")
+	if ok || retryAfter < DefaultBackoffBase/2 {
+		t.Fatalf("Allow() during cooldown = %v, %v; want false with the backoff remaining", ok, retryAfter)
+	}
+
+	rl.CheckAndMarkSuspended("suspended", "account suspended")
+	ok, retryAfter = rl.Allow("suspended")
+	if ok || retryAfter < DefaultSuspendCooldown-time.Minute {
+		t.Fatalf("Allow() while suspended = %v, %v; want false with the suspension remaining", ok, retryAfter)
+	}
+
+	rl.mu.Lock()
+	state := rl.getOrCreateState("daily")
+	state.DailyRequests = 2
+	rl.mu.Unlock()
+	ok, retryAfter = rl.Allow("daily")
+	if ok || retryAfter <= 0 || retryAfter > 24*time.Hour {
+		t.Fatalf("Allow() over the daily limit = %v, %v; want false until the daily reset", ok, retryAfter)
+	}
+}
+
+func TestCustomSuspendPatterns(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		SuspendKeywords: []string{"Konto gesperrt"},
+		SuspendPatterns: []*regexp.Regexp{regexp.MustCompile(`(?i)temporar\w+ restrict`)},
+	})
+
+	if !rl.CheckAndMarkSuspended("regex", "Your access is Temporarily restricted") {
+		t.Fatal("custom regex did not trigger suspension")
+	}
+	if !rl.CheckAndMarkSuspended("keyword", "Fehler: KONTO GESPERRT") {
+		t.Fatal("custom keyword did not trigger suspension (case-insensitive)")
+	}
+	if rl.CheckAndMarkSuspended("default", "Account has been suspended") {
+		t.Fatal("default keywords should not apply once custom rules are configured")
+	}
+
+	if !NewRateLimiter().CheckAndMarkSuspended("default", "Account has been suspended") {
+		t.Fatal("default keywords should apply when no custom rules are configured")
+	}
+}
+
+func TestNextDailyReset(t *testing.T) {
+	pacific := time.FixedZone("PST", -8*60*60)
+	tests := []struct {
+		name string
+		cfg  RateLimiterConfig
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "default UTC midnight",
+			now:  time.Date(2026, 3, 10, 15, 30, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "exactly at the boundary rolls to the next day",
+			now:  time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Pacific midnight later today in UTC terms",
+			cfg:  RateLimiterConfig{ResetLocation: pacific},
+			now:  time.Date(2026, 3, 10, 5, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "reset hour in the configured zone",
+			cfg:  RateLimiterConfig{ResetLocation: pacific, ResetHour: 6},
+			now:  time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 11, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "out-of-range hour keeps midnight",
+			cfg:  RateLimiterConfig{ResetHour: 24},
+			now:  time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiterWithConfig(tt.cfg)
+			if got := rl.nextDailyReset(tt.now); !got.Equal(tt.want) {
+				t.Fatalf("nextDailyReset(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Millisecond,
+		MaxTokenInterval: 2 * time.Millisecond,
+		DailyMaxRequests: 10,
+	})
+	rl.WaitForToken("b-active")
+	rl.WaitForToken("b-active")
+	rl.MarkTokenFailed("c-failing")
+	rl.CheckAndMarkSuspended("a-suspended", "account suspended")
+
+	snapshots := rl.Snapshot()
+	if len(snapshots) != 3 {
+		t.Fatalf("Snapshot() returned %d tokens, want 3", len(snapshots))
+	}
+	if snapshots[0].TokenKey != "a-suspended" || snapshots[1].TokenKey != "b-active" || snapshots[2].TokenKey != "c-failing" {
+		t.Fatalf("Snapshot() order = %q, %q, %q; want sorted by token key", snapshots[0].TokenKey, snapshots[1].TokenKey, snapshots[2].TokenKey)
+	}
+	if s := snapshots[0]; !s.IsSuspended || s.SuspendReason != "account suspended" || !s.InCooldown {
+		t.Fatalf("suspended snapshot = %+v", s)
+	}
+	if s := snapshots[1]; s.DailyUsed != 2 || s.DailyRemaining != 8 || s.InCooldown || s.LastRequest.IsZero() {
+		t.Fatalf("active snapshot = %+v, want 2 used and 8 remaining", s)
+	}
+	if s := snapshots[2]; s.FailCount != 1 || !s.InCooldown || s.CooldownEnd.IsZero() {
+		t.Fatalf("failing snapshot = %+v, want one failure in cooldown", s)
+	}
+
+	snapshots[1].DailyUsed = 100
+	if state := rl.GetTokenState("b-active"); state.DailyRequests != 2 {
+		t.Fatalf("mutating a snapshot changed internal state: DailyRequests = %d", state.DailyRequests)
+	}
+}
+
+// Run with -race: IsTokenAvailable resets daily counters while WaitForToken updates them.
+func TestIsTokenAvailableConcurrentWithWaitForToken(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Microsecond,
+		MaxTokenInterval: 2 * time.Microsecond,
+		DailyMaxRequests: 1000,
+	})
+	rl.WaitForToken("shared")
+	rl.mu.Lock()
+	rl.states["shared"].DailyResetTime = time.Now().Add(-time.Second)
+	rl.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rl.WaitForToken("shared")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				rl.IsTokenAvailable("shared")
+				rl.IsTokenAvailable("untracked")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if state := rl.GetTokenState("shared"); state.RequestCount != 401 {
+		t.Fatalf("RequestCount = %d, want 401", state.RequestCount)
+	}
+}
+
+func TestSlidingWindowMode(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{DailyMaxRequests: 3, WindowMode: WindowModeSliding})
+	now := time.Now()
+
+	rl.mu.Lock()
+	state := rl.getOrCreateState("sliding")
+	for _, at := range []time.Time{now.Add(-25 * time.Hour), now.Add(-23 * time.Hour), now.Add(-time.Hour), now.Add(-time.Minute)} {
+		rl.recordDailyLocked(state, at)
+	}
+	rl.mu.Unlock()
+
+	if rl.IsTokenAvailable("sliding") {
+		t.Fatal("three requests in the trailing 24h should exhaust a limit of 3")
+	}
+	state = rl.GetTokenState("sliding")
+	if state.DailyRequests != 3 {
+		t.Fatalf("DailyRequests = %d, want 3 (the 25h-old request is outside the window)", state.DailyRequests)
+	}
+	if want := now.Add(time.Hour); !rl.NextAvailableTime("sliding").Equal(want) {
+		t.Fatalf("NextAvailableTime() = %v, want %v when the 23h-old request leaves the window", rl.NextAvailableTime("sliding"), want)
+	}
+	if snapshot := rl.Snapshot()[0]; snapshot.DailyUsed != 3 || snapshot.DailyRemaining != 0 {
+		t.Fatalf("Snapshot() = %+v, want 3 used and none remaining", snapshot)
+	}
+
+	// A crossed reset boundary clears the fixed-window counter, not the sliding one.
+	rl.mu.Lock()
+	rl.states["sliding"].DailyResetTime = now.Add(-time.Second)
+	rl.mu.Unlock()
+	if rl.IsTokenAvailable("sliding") {
+		t.Fatal("sliding window should not reset at a fixed boundary")
+	}
+}
+
+func TestSlidingWindowRing(t *testing.T) {
+	w := newSlidingWindow(2)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.add(base)
+	w.add(base.Add(time.Hour))
+	w.add(base.Add(2 * time.Hour))
+	if w.size != 2 || !w.oldest().Equal(base.Add(time.Hour)) {
+		t.Fatalf("full ring should drop the oldest entry: size=%d oldest=%v", w.size, w.oldest())
+	}
+	if got := w.countAfter(base.Add(90 * time.Minute)); got != 1 {
+		t.Fatalf("countAfter() = %d, want 1", got)
+	}
+	w.prune(base.Add(2 * time.Hour))
+	if w.size != 0 || !w.oldest().IsZero() {
+		t.Fatalf("prune() left size=%d oldest=%v, want empty", w.size, w.oldest())
+	}
+}
+
+func TestWaitForTokenContextCancelled(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.MarkTokenFailed("cooling")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := rl.WaitForTokenContext(ctx, "cooling")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForTokenContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("WaitForTokenContext() returned after %v, want it to stop at cancellation", elapsed)
+	}
+	if state := rl.GetTokenState("cooling"); state.RequestCount != 0 || state.DailyRequests != 0 {
+		t.Fatalf("cancelled wait recorded a request: %+v", state)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := rl.WaitForTokenContext(cancelled, "fresh"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitForTokenContext() with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestSelectToken(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Hour,
+		MaxTokenInterval: 2 * time.Hour,
+		DailyMaxRequests: 10,
+	})
+	now := time.Now()
+	rl.mu.Lock()
+	for tokenKey, used := range map[string]int{"busy": 8, "recent": 2, "idle": 2} {
+		state := rl.getOrCreateState(tokenKey)
+		state.DailyRequests = used
+		state.LastRequest = now.Add(-2 * time.Hour)
+	}
+	rl.states["recent"].LastRequest = now.Add(-90 * time.Minute)
+	rl.states["idle"].LastRequest = now.Add(-3 * time.Hour)
+	rl.mu.Unlock()
+	rl.MarkTokenFailed("cooling")
+
+	candidates := []string{"busy", "cooling", "recent", "idle"}
+	if got, ok := rl.SelectToken(candidates); !ok || got != "idle" {
+		t.Fatalf("SelectToken() = %q, %v; want the idle token with the most budget", got, ok)
+	}
+	// idle is now spaced out by the min interval, so the next pick falls to recent.
+	if got, ok := rl.SelectToken(candidates); !ok || got != "recent" {
+		t.Fatalf("second SelectToken() = %q, %v; want recent", got, ok)
+	}
+	if got, ok := rl.SelectToken(candidates); !ok || got != "busy" {
+		t.Fatalf("third SelectToken() = %q, %v; want busy", got, ok)
+	}
+	if got, ok := rl.SelectToken(candidates); ok {
+		t.Fatalf("SelectToken() = %q, want none available", got)
+	}
+	if _, ok := rl.SelectToken(nil); ok {
+		t.Fatal("SelectToken(nil) should report no token")
+	}
+}
+
+func TestBackoffWithSeededRand(t *testing.T) {
+	const seed = 42
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		BackoffBase:       10 * time.Second,
+		BackoffMultiplier: 2,
+		BackoffMax:        70 * time.Second,
+		Rand:              rand.New(rand.NewSource(seed)),
+	})
+
+	// 10s, 20s, 40s, 80s and 160s with ±30% jitter drawn from seed 42; the last is capped at BackoffMax.
+	want := []time.Duration{
+		9238170166 * time.Nanosecond,
+		14792005961 * time.Nanosecond,
+		42498252437 * time.Nanosecond,
+		66023297746 * time.Nanosecond,
+		70 * time.Second,
+	}
+	for i, w := range want {
+		failCount := i + 1
+		if got := rl.calculateBackoff(failCount); got != w {
+			t.Fatalf("calculateBackoff(%d) = %v, want %v", failCount, got, w)
+		}
+	}
+
+	a, b := NewRateLimiterWithRand(rand.New(rand.NewSource(seed))), NewRateLimiterWithRand(rand.New(rand.NewSource(seed)))
+	for i := 0; i < 5; i++ {
+		if x, y := a.calculateInterval(), b.calculateInterval(); x != y {
+			t.Fatalf("interval %d differs between equally seeded limiters: %v vs %v", i, x, y)
+		}
+	}
+}
+
+func TestProbationAfterSuspension(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval:  time.Second,
+		MaxTokenInterval:  2 * time.Second,
+		DailyMaxRequests:  100,
+		SuspendCooldown:   time.Hour,
+		ProbationDuration: time.Hour,
+	})
+	rl.CheckAndMarkSuspended("token", "account suspended")
+
+	// The suspension ended 30 minutes ago: halfway through probation the capacity is 10% + 90%/2 = 55%.
+	now := time.Now()
+	rl.mu.Lock()
+	state := rl.states["token"]
+	state.SuspendedAt = now.Add(-90 * time.Minute)
+	state.CooldownEnd = now.Add(-30 * time.Minute)
+	state.DailyRequests = 55
+	rl.mu.Unlock()
+
+	if rl.IsTokenAvailable("token") {
+		t.Fatal("55 requests should exhaust the reduced daily limit during probation")
+	}
+	got := rl.GetTokenState("token")
+	if got.IsSuspended || got.ProbationStart.IsZero() {
+		t.Fatalf("state after the suspension elapsed = %+v, want probation instead of suspension", got)
+	}
+	if snapshot := rl.Snapshot()[0]; !snapshot.InProbation || snapshot.DailyRemaining != 0 {
+		t.Fatalf("Snapshot() = %+v, want in probation with no remaining budget", snapshot)
+	}
+
+	rl.mu.Lock()
+	state.DailyRequests = 54
+	state.LastRequest = now.Add(-1500 * time.Millisecond)
+	rl.mu.Unlock()
+	if !rl.IsTokenAvailable("token") {
+		t.Fatal("54 requests should fit under the reduced daily limit")
+	}
+	// The 1s minimum interval is stretched to about 1.8s during probation.
+	if ok, retryAfter := rl.Allow("token"); ok || retryAfter <= 0 {
+		t.Fatalf("Allow() = %v, %v; want the stretched probation interval to apply", ok, retryAfter)
+	}
+
+	rl.mu.Lock()
+	state.ProbationStart = now.Add(-2 * time.Hour)
+	rl.mu.Unlock()
+	if ok, _ := rl.Allow("token"); !ok {
+		t.Fatal("full capacity should return once probation is over")
+	}
+}
+
+func TestNoProbationByDefault(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.CheckAndMarkSuspended("token", "account suspended")
+	rl.mu.Lock()
+	rl.states["token"].SuspendedAt = time.Now().Add(-2 * DefaultSuspendCooldown)
+	rl.states["token"].CooldownEnd = time.Now().Add(-DefaultSuspendCooldown)
+	rl.mu.Unlock()
+
+	if !rl.IsTokenAvailable("token") {
+		t.Fatal("token should be available once the suspension elapsed")
+	}
+	if state := rl.GetTokenState("token"); !state.ProbationStart.IsZero() {
+		t.Fatalf("ProbationStart = %v, want no probation when ProbationDuration is unset", state.ProbationStart)
+	}
+}
+
+func TestLifetimeCountersAndRecentFailureRate(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{FailureRateWindow: 4})
+
+	rl.MarkTokenFailed("token")
+	rl.MarkTokenFailed("token")
+	rl.MarkTokenSuccess("token")
+	state := rl.GetTokenState("token")
+	if state.FailCount != 0 || state.TotalFailure != 2 || state.TotalSuccess != 1 {
+		t.Fatalf("state = %+v, want FailCount reset but lifetime counters kept", state)
+	}
+	if want := 2.0 / 3.0; state.RecentFailureRate != want {
+		t.Fatalf("RecentFailureRate = %v, want %v", state.RecentFailureRate, want)
+	}
+
+	// Only the last four outcomes count: fail, success, success, success.
+	rl.MarkTokenSuccess("token")
+	rl.MarkTokenSuccess("token")
+	state = rl.GetTokenState("token")
+	if state.RecentFailureRate != 0.25 || state.TotalSuccess != 3 || state.TotalFailure != 2 {
+		t.Fatalf("state = %+v, want a 0.25 failure rate over the last 4 requests", state)
+	}
+
+	rl.ResetSuspension("token")
+	if state := rl.GetTokenState("token"); state.TotalFailure != 2 || state.TotalSuccess != 3 {
+		t.Fatalf("ResetSuspension() cleared lifetime counters: %+v", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Millisecond,
+		MaxTokenInterval: 2 * time.Millisecond,
+		Rand:             rand.New(rand.NewSource(1)),
+	})
+	expireCooldown := func() {
+		rl.mu.Lock()
+		rl.states["token"].CooldownEnd = time.Now().Add(-time.Millisecond)
+		rl.states["token"].LastRequest = time.Time{}
+		rl.mu.Unlock()
+	}
+
+	if state := rl.GetTokenState("token"); state != nil {
+		t.Fatalf("untracked token has state %+v", state)
+	}
+	rl.MarkTokenFailed("token")
+	if state := rl.GetTokenState("token"); state.Circuit != CircuitOpen {
+		t.Fatalf("circuit after failure = %v, want open", state.Circuit)
+	}
+	firstCooldown := rl.GetTokenState("token").CooldownEnd
+
+	expireCooldown()
+	if state := rl.GetTokenState("token"); state.Circuit != CircuitHalfOpen {
+		t.Fatalf("circuit after cooldown = %v, want half-open", state.Circuit)
+	}
+	if ok, _ := rl.Allow("token"); !ok {
+		t.Fatal("half-open circuit should let one trial request through")
+	}
+	if ok, retryAfter := rl.Allow("token"); ok || retryAfter <= 0 {
+		t.Fatalf("second Allow() while the trial is in flight = %v, %v; want refused", ok, retryAfter)
+	}
+	if rl.IsTokenAvailable("token") {
+		t.Fatal("token should be unavailable while the trial request is in flight")
+	}
+
+	// A failed trial reopens the circuit with a longer backoff.
+	rl.MarkTokenFailed("token")
+	state := rl.GetTokenState("token")
+	if state.Circuit != CircuitOpen || state.FailCount != 2 {
+		t.Fatalf("after failed trial: circuit=%v failCount=%d, want open with 2 failures", state.Circuit, state.FailCount)
+	}
+	if !state.CooldownEnd.After(firstCooldown) {
+		t.Fatalf("cooldown after failed trial %v should extend beyond the first %v", state.CooldownEnd, firstCooldown)
+	}
+
+	expireCooldown()
+	if err := rl.WaitForTokenContext(context.Background(), "token"); err != nil {
+		t.Fatalf("WaitForTokenContext() error = %v", err)
+	}
+	rl.MarkTokenSuccess("token")
+	if state := rl.GetTokenState("token"); state.Circuit != CircuitClosed || !rl.IsTokenAvailable("token") {
+		t.Fatalf("circuit after successful trial = %v, want closed and available", state.Circuit)
+	}
+	if got := rl.Snapshot()[0].Circuit; got != "closed" {
+		t.Fatalf("Snapshot().Circuit = %q, want closed", got)
+	}
+}
+
+func TestCircuitBreakerReleasesUnresolvedProbe(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Millisecond,
+		MaxTokenInterval: 2 * time.Millisecond,
+		Rand:             rand.New(rand.NewSource(1)),
+	})
+	openAndExpire := func() {
+		rl.MarkTokenFailed("token")
+		rl.mu.Lock()
+		rl.states["token"].CooldownEnd = time.Now().Add(-time.Millisecond)
+		rl.states["token"].LastRequest = time.Time{}
+		rl.mu.Unlock()
+	}
+
+	openAndExpire()
+	release, err := rl.AcquireToken(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("AcquireToken() error = %v", err)
+	}
+	if rl.IsTokenAvailable("token") {
+		t.Fatal("token should be unavailable while the trial request is in flight")
+	}
+	if wait := time.Until(rl.NextAvailableTime("token")); wait < time.Minute {
+		t.Fatalf("NextAvailableTime() with a trial in flight is %v away, want the probe timeout", wait)
+	}
+
+	// The request ends without MarkTokenSuccess/MarkTokenFailed, e.g. a local error or a panic.
+	release()
+	if !rl.IsTokenAvailable("token") {
+		t.Fatal("released trial should let the next request probe immediately")
+	}
+	if wait := time.Until(rl.NextAvailableTime("token")); wait > time.Second {
+		t.Fatalf("NextAvailableTime() after release is %v away, want now", wait)
+	}
+
+	// A stale release must not free a later request's probe.
+	second, err := rl.AcquireToken(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("AcquireToken() error = %v", err)
+	}
+	release()
+	if rl.IsTokenAvailable("token") {
+		t.Fatal("stale release freed another request's trial")
+	}
+	// Release after an outcome was recorded is a no-op.
+	rl.MarkTokenFailed("token")
+	second()
+	if state := rl.GetTokenState("token"); state.Circuit != CircuitOpen {
+		t.Fatalf("circuit after failed trial and release = %v, want open", state.Circuit)
+	}
+}
+
+func TestWaitForTokenContextCancelledReleasesProbe(t *testing.T) {
+	rl := NewRateLimiterWithConfig(RateLimiterConfig{
+		MinTokenInterval: time.Hour,
+		MaxTokenInterval: 2 * time.Hour,
+		Rand:             rand.New(rand.NewSource(1)),
+	})
+	rl.MarkTokenFailed("token")
+	rl.mu.Lock()
+	rl.states["token"].CooldownEnd = time.Now().Add(-time.Millisecond)
+	rl.states["token"].LastRequest = time.Now()
+	rl.mu.Unlock()
+
+	// The trial is claimed, then the request interval wait is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rl.AcquireToken(ctx, "token"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireToken() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	rl.mu.Lock()
+	state := rl.states["token"]
+	circuit, inFlight := state.Circuit, rl.probeInFlightLocked(state, time.Now())
+	rl.mu.Unlock()
+	if circuit != CircuitHalfOpen || inFlight {
+		t.Fatalf("after cancelled wait: circuit=%v probeInFlight=%v, want half-open with no trial in flight", circuit, inFlight)
+	}
+}
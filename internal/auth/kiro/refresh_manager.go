@@ -18,6 +18,7 @@ type RefreshManager struct {
 	cancel           context.CancelFunc
 	started          bool
 	onTokenRefreshed func(tokenID string, tokenData *KiroTokenData) // 刷新成功回调
+	onRefreshCycle   func(result RefreshBatchResult)                // 每轮刷新结束回调
 }
 
 var (
@@ -73,6 +74,9 @@ func (m *RefreshManager) Initialize(baseDir string, cfg *config.Config) error {
 	if m.onTokenRefreshed != nil {
 		opts = append(opts, WithOnTokenRefreshed(m.onTokenRefreshed))
 	}
+	if m.onRefreshCycle != nil {
+		opts = append(opts, WithOnRefreshCycle(m.onRefreshCycle))
+	}
 
 	m.refresher = NewBackgroundRefresher(repo, opts...)
 
@@ -162,6 +166,30 @@ func (m *RefreshManager) SetOnTokenRefreshed(callback func(tokenID string, token
 	log.Debug("refresh manager: token refresh callback registered")
 }
 
+// SetOnRefreshCycle 设置每轮批量刷新结束后的回调，接收本轮汇总
+func (m *RefreshManager) SetOnRefreshCycle(callback func(result RefreshBatchResult)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onRefreshCycle = callback
+	if m.refresher != nil {
+		m.refresher.callbackMu.Lock()
+		m.refresher.onCycle = callback
+		m.refresher.callbackMu.Unlock()
+	}
+}
+
+// LastRefreshCycle 返回最近一轮批量刷新的汇总，尚未运行时返回 false
+func (m *RefreshManager) LastRefreshCycle() (RefreshBatchResult, bool) {
+	m.mu.Lock()
+	refresher := m.refresher
+	m.mu.Unlock()
+	if refresher == nil {
+		return RefreshBatchResult{}, false
+	}
+	return refresher.LastCycle()
+}
+
 // InitializeAndStart 初始化并启动后台刷新（便捷方法）
 func InitializeAndStart(baseDir string, cfg *config.Config) {
 	manager := GetRefreshManager()
@@ -0,0 +1,66 @@
+package kiro
+
+import "time"
+
+const (
+	// WindowModeFixed 每日计数在固定时间点（默认 UTC 零点）清零
+	WindowModeFixed = "fixed"
+	// WindowModeSliding 在任意连续 24 小时内限制请求数，避免跨越重置点的突发请求
+	WindowModeSliding = "sliding"
+
+	// slidingWindowSpan 滑动窗口的长度
+	slidingWindowSpan = 24 * time.Hour
+)
+
+// slidingWindow 以环形缓冲区记录最近的请求时间，容量即窗口内允许的最大请求数
+type slidingWindow struct {
+	times []time.Time
+	// head 最早一条记录的位置
+	head int
+	size int
+}
+
+func newSlidingWindow(capacity int) *slidingWindow {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &slidingWindow{times: make([]time.Time, capacity)}
+}
+
+// prune 丢弃 cutoff 及之前的记录
+func (w *slidingWindow) prune(cutoff time.Time) {
+	for w.size > 0 && !w.times[w.head].After(cutoff) {
+		w.times[w.head] = time.Time{}
+		w.head = (w.head + 1) % len(w.times)
+		w.size--
+	}
+}
+
+// add 记录一次请求，缓冲区已满时覆盖最早的记录
+func (w *slidingWindow) add(at time.Time) {
+	if w.size == len(w.times) {
+		w.head = (w.head + 1) % len(w.times)
+		w.size--
+	}
+	w.times[(w.head+w.size)%len(w.times)] = at
+	w.size++
+}
+
+// oldest 返回最早一条记录的时间，没有记录时返回零值
+func (w *slidingWindow) oldest() time.Time {
+	if w.size == 0 {
+		return time.Time{}
+	}
+	return w.times[w.head]
+}
+
+// countAfter 返回 cutoff 之后的记录数，不修改缓冲区
+func (w *slidingWindow) countAfter(cutoff time.Time) int {
+	count := 0
+	for i := 0; i < w.size; i++ {
+		if w.times[(w.head+i)%len(w.times)].After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
@@ -4,25 +4,20 @@ package kiro
 import (
 	"bufio"
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/browser"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
-	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/term"
 )
@@ -31,13 +26,17 @@ const (
 	// Kiro AuthService endpoint
 	kiroAuthServiceEndpoint = "https://prod.us-east-1.auth.desktop.kiro.dev"
 
-	// OAuth timeout
-	socialAuthTimeout = 10 * time.Minute
-
 	// Default callback port for social auth HTTP server
 	socialAuthCallbackPort = 9876
+
+	// Environment variable supplying a Kiro invitation code for the social token exchange
+	envInvitationCode = "KIRO_INVITATION_CODE"
 )
 
+// ErrInvitationRequired is returned by CreateToken when Kiro only accepts the account with an
+// invitation code.
+var ErrInvitationRequired = errors.New("an invitation code is required for this account")
+
 // SocialProvider represents the social login provider.
 type SocialProvider string
 
@@ -46,6 +45,8 @@ const (
 	ProviderGoogle SocialProvider = "Google"
 	// ProviderGitHub is GitHub OAuth provider
 	ProviderGitHub SocialProvider = "Github"
+	// ProviderCognito is the Kiro email/password account provider (Cognito hosted UI)
+	ProviderCognito SocialProvider = "Cognito"
 	// Note: AWS Builder ID is NOT supported by Kiro's auth service.
 	// It only supports: Google, Github, Cognito
 	// AWS Builder ID must use device code flow via SSO OIDC.
@@ -84,14 +85,21 @@ type SocialAuthClient struct {
 	httpClient      *http.Client
 	cfg             *config.Config
 	protocolHandler *ProtocolHandler
+	// Events receives login progress; nil uses terminal output (see AuthEventHandler).
+	Events AuthEventHandler
 }
 
-// NewSocialAuthClient creates a new social auth client.
-func NewSocialAuthClient(cfg *config.Config) *SocialAuthClient {
-	client := &http.Client{Timeout: 30 * time.Second}
-	if cfg != nil {
-		client = util.SetProxy(&cfg.SDKConfig, client)
+// events returns the login event handler.
+func (c *SocialAuthClient) events() AuthEventHandler {
+	if c.Events != nil {
+		return c.Events
 	}
+	return defaultAuthEvents(c.cfg)
+}
+
+// NewSocialAuthClient creates a new social auth client.
+func NewSocialAuthClient(cfg *config.Config, opts ...ClientOption) *SocialAuthClient {
+	client := resolveAuthHTTPClient(cfg, 30*time.Second, opts)
 	return &SocialAuthClient{
 		httpClient:      client,
 		cfg:             cfg,
@@ -102,12 +110,13 @@ func NewSocialAuthClient(cfg *config.Config) *SocialAuthClient {
 // startWebCallbackServer starts a local HTTP server to receive the OAuth callback.
 // This is used instead of the kiro:// protocol handler to avoid redirect_mismatch errors.
 func (c *SocialAuthClient) startWebCallbackServer(ctx context.Context, expectedState string) (string, <-chan WebCallbackResult, error) {
-	// Try to find an available port - use localhost like Kiro does
-	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", socialAuthCallbackPort))
+	// Try to find an available port - use localhost like Kiro does unless a host is configured
+	host := callbackHost(c.cfg, "localhost")
+	listener, err := net.Listen("tcp", callbackAddr(host, socialAuthCallbackPort))
 	if err != nil {
 		// Try with dynamic port (RFC 8252 allows dynamic ports for native apps)
 		log.Warnf("kiro social auth: default port %d is busy, falling back to dynamic port", socialAuthCallbackPort)
-		listener, err = net.Listen("tcp", "localhost:0")
+		listener, err = net.Listen("tcp", callbackAddr(host, 0))
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to start callback server: %w", err)
 		}
@@ -115,8 +124,18 @@ func (c *SocialAuthClient) startWebCallbackServer(ctx context.Context, expectedS
 
 	port := listener.Addr().(*net.TCPAddr).Port
 	// Use http scheme for local callback server
-	redirectURI := fmt.Sprintf("http://localhost:%d/oauth/callback", port)
+	redirectURI := fmt.Sprintf("http://%s/oauth/callback", callbackAddr(host, port))
 	resultChan := make(chan WebCallbackResult, 1)
+	// delivered is closed with the first result, which stops the server. The shutdown goroutine
+	// waits on it rather than on resultChan so it cannot consume the result meant for the login.
+	delivered := make(chan struct{})
+	var deliverOnce sync.Once
+	deliver := func(result WebCallbackResult) {
+		deliverOnce.Do(func() {
+			resultChan <- result
+			close(delivered)
+		})
+	}
 
 	server := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
@@ -124,36 +143,29 @@ func (c *SocialAuthClient) startWebCallbackServer(ctx context.Context, expectedS
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/oauth/callback", func(w http.ResponseWriter, r *http.Request) {
+		// HEAD is the VerifyCallbackReachable self-check; it must not consume the login result.
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		code := r.URL.Query().Get("code")
 		state := r.URL.Query().Get("state")
 		errParam := r.URL.Query().Get("error")
 
 		if errParam != "" {
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `<!DOCTYPE html>
-<html><head><title>Login Failed</title></head>
-<body><h1>Login Failed</h1><p>%s</p><p>You can close this window.</p></body></html>`, html.EscapeString(errParam))
-			resultChan <- WebCallbackResult{Error: errParam}
+			writeCallbackFailure(w, c.cfg, errParam)
+			deliver(WebCallbackResult{Error: errParam})
 			return
 		}
 
 		if state != expectedState {
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, `<!DOCTYPE html>
-<html><head><title>Login Failed</title></head>
-<body><h1>Login Failed</h1><p>Invalid state parameter</p><p>You can close this window.</p></body></html>`)
-			resultChan <- WebCallbackResult{Error: "state mismatch"}
+			writeCallbackFailure(w, c.cfg, "Invalid state parameter")
+			deliver(WebCallbackResult{Error: "state mismatch"})
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, `<!DOCTYPE html>
-<html><head><title>Login Successful</title></head>
-<body><h1>Login Successful!</h1><p>You can close this window and return to the terminal.</p>
-<script>window.close();</script></body></html>`)
-		resultChan <- WebCallbackResult{Code: code, State: state}
+		writeCallbackSuccess(w, r, c.cfg)
+		deliver(WebCallbackResult{Code: code, State: state})
 	})
 
 	server.Handler = mux
@@ -167,8 +179,8 @@ func (c *SocialAuthClient) startWebCallbackServer(ctx context.Context, expectedS
 	go func() {
 		select {
 		case <-ctx.Done():
-		case <-time.After(socialAuthTimeout):
-		case <-resultChan:
+		case <-time.After(loginSessionMaxAge(c.cfg)):
+		case <-delivered:
 		}
 		_ = server.Shutdown(context.Background())
 	}()
@@ -176,47 +188,75 @@ func (c *SocialAuthClient) startWebCallbackServer(ctx context.Context, expectedS
 	return redirectURI, resultChan, nil
 }
 
-// generatePKCE generates PKCE code verifier and challenge.
-func generatePKCE() (verifier, challenge string, err error) {
-	// Generate 32 bytes of random data for verifier
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", "", fmt.Errorf("failed to generate random bytes: %w", err)
-	}
-	verifier = base64.RawURLEncoding.EncodeToString(b)
+// OIDC prompt values accepted by the Kiro login endpoint.
+const (
+	SocialPromptSelectAccount = "select_account"
+	SocialPromptLogin         = "login"
+	SocialPromptConsent       = "consent"
+	SocialPromptNone          = "none"
+)
+
+// SocialLoginOption customizes a social login started with LoginWithSocial.
+type SocialLoginOption func(*socialLoginOptions)
 
-	// Generate SHA256 hash of verifier for challenge
-	h := sha256.Sum256([]byte(verifier))
-	challenge = base64.RawURLEncoding.EncodeToString(h[:])
+type socialLoginOptions struct {
+	prompt         string
+	invitationCode string
+}
 
-	return verifier, challenge, nil
+// WithPrompt sets the OIDC prompt of the login URL: "select_account" (default) shows the
+// account picker, "login" forces full re-authentication and "none" fails fast unless the
+// user is already signed in.
+func WithPrompt(prompt string) SocialLoginOption {
+	return func(o *socialLoginOptions) {
+		o.prompt = prompt
+	}
 }
 
-// generateState generates a random state parameter.
-func generateStateParam() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// WithInvitationCode sends a Kiro invitation code with the token exchange. Without it,
+// KIRO_INVITATION_CODE is used when set.
+func WithInvitationCode(code string) SocialLoginOption {
+	return func(o *socialLoginOptions) {
+		o.invitationCode = code
 	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// resolveSocialLoginOptions applies opts over the defaults and validates the result.
+func resolveSocialLoginOptions(opts []SocialLoginOption) (socialLoginOptions, error) {
+	o := socialLoginOptions{
+		prompt:         SocialPromptSelectAccount,
+		invitationCode: strings.TrimSpace(os.Getenv(envInvitationCode)),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch o.prompt {
+	case SocialPromptSelectAccount, SocialPromptLogin, SocialPromptConsent, SocialPromptNone:
+		return o, nil
+	}
+	return o, fmt.Errorf("invalid prompt %q: must be one of select_account, login, consent or none", o.prompt)
 }
 
 // buildLoginURL constructs the Kiro OAuth login URL.
 // The login endpoint expects a GET request with query parameters.
 // Format: /login?idp=Google&redirect_uri=...&code_challenge=...&code_challenge_method=S256&state=...&prompt=select_account
-// The prompt=select_account parameter forces the account selection screen even if already logged in.
-func (c *SocialAuthClient) buildLoginURL(provider, redirectURI, codeChallenge, state string) string {
-	return fmt.Sprintf("%s/login?idp=%s&redirect_uri=%s&code_challenge=%s&code_challenge_method=S256&state=%s&prompt=select_account",
+// prompt=select_account forces the account selection screen even if already logged in.
+func (c *SocialAuthClient) buildLoginURL(provider, redirectURI, codeChallenge, state, prompt string) string {
+	return fmt.Sprintf("%s/login?idp=%s&redirect_uri=%s&code_challenge=%s&code_challenge_method=S256&state=%s&prompt=%s",
 		kiroAuthServiceEndpoint,
 		provider,
 		url.QueryEscape(redirectURI),
 		codeChallenge,
 		state,
+		prompt,
 	)
 }
 
 // CreateToken exchanges the authorization code for tokens.
 func (c *SocialAuthClient) CreateToken(ctx context.Context, req *CreateTokenRequest) (*SocialTokenResponse, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal token request: %w", err)
@@ -229,7 +269,7 @@ func (c *SocialAuthClient) CreateToken(ctx context.Context, req *CreateTokenRequ
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("User-Agent", "KiroIDE-0.7.45-cli-proxy-api")
+	httpReq.Header.Set("User-Agent", buildKiroUserAgent(c.cfg, ""))
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -244,6 +284,9 @@ func (c *SocialAuthClient) CreateToken(ctx context.Context, req *CreateTokenRequ
 
 	if resp.StatusCode != http.StatusOK {
 		log.Debugf("token exchange failed (status %d): %s", resp.StatusCode, string(respBody))
+		if invitationRequired(resp.StatusCode, respBody) {
+			return nil, fmt.Errorf("token exchange failed (status %d): %w", resp.StatusCode, ErrInvitationRequired)
+		}
 		return nil, fmt.Errorf("token exchange failed (status %d)", resp.StatusCode)
 	}
 
@@ -255,8 +298,34 @@ func (c *SocialAuthClient) CreateToken(ctx context.Context, req *CreateTokenRequ
 	return &tokenResp, nil
 }
 
+// invitationRequired reports whether a failed token exchange asks for an invitation code.
+func invitationRequired(status int, body []byte) bool {
+	if status != http.StatusBadRequest && status != http.StatusForbidden {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(body)), "invitation")
+}
+
 // RefreshSocialToken refreshes an expired social auth token.
+// The returned token has no Provider; use RefreshSocialTokenWithFingerprint to keep it.
 func (c *SocialAuthClient) RefreshSocialToken(ctx context.Context, refreshToken string) (*KiroTokenData, error) {
+	return c.RefreshSocialTokenWithFingerprint(ctx, refreshToken, "", "")
+}
+
+// RefreshSocialTokenWithFingerprint refreshes a social auth token, sending the same
+// KiroIDE-style User-Agent as KiroOAuth.RefreshTokenWithFingerprint for tokenKey.
+// provider is the token's original provider (e.g. "Google") and is set on the result.
+// Concurrent refreshes of the same refresh token share one request (see sharedRefresh).
+func (c *SocialAuthClient) RefreshSocialTokenWithFingerprint(ctx context.Context, refreshToken, tokenKey, provider string) (*KiroTokenData, error) {
+	return sharedRefresh(ctx, refreshToken, func(ctx context.Context) (*KiroTokenData, error) {
+		return c.refreshSocialTokenWithFingerprint(ctx, refreshToken, tokenKey, provider)
+	})
+}
+
+func (c *SocialAuthClient) refreshSocialTokenWithFingerprint(ctx context.Context, refreshToken, tokenKey, provider string) (*KiroTokenData, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
 	body, err := json.Marshal(&RefreshTokenRequest{RefreshToken: refreshToken})
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal refresh request: %w", err)
@@ -269,7 +338,8 @@ func (c *SocialAuthClient) RefreshSocialToken(ctx context.Context, refreshToken
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("User-Agent", "cli-proxy-api/1.0.0")
+	// Match the UA used for the initial token exchange to avoid server-side UA rejections
+	httpReq.Header.Set("User-Agent", buildKiroUserAgent(c.cfg, tokenKey))
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -293,46 +363,56 @@ func (c *SocialAuthClient) RefreshSocialToken(ctx context.Context, refreshToken
 	}
 
 	// Validate ExpiresIn - use default 1 hour if invalid
-	expiresIn := tokenResp.ExpiresIn
-	if expiresIn <= 0 {
-		expiresIn = 3600 // Default 1 hour
-	}
+	expiresIn := normalizeExpiresIn("social refresh", tokenResp.ExpiresIn)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
-	return &KiroTokenData{
+	return finishRefresh("social refresh", &KiroTokenData{
 		AccessToken:  tokenResp.AccessToken,
 		RefreshToken: tokenResp.RefreshToken,
 		ProfileArn:   tokenResp.ProfileArn,
 		ExpiresAt:    expiresAt.Format(time.RFC3339),
 		AuthMethod:   "social",
-		Provider:     "", // Caller should preserve original provider
+		Provider:     provider,
 		Region:       "us-east-1",
-	}, nil
+	}, refreshToken), nil
 }
 
 // LoginWithSocial performs OAuth login with Google or GitHub.
 // Uses local HTTP callback server instead of custom protocol handler to avoid redirect_mismatch errors.
-func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialProvider) (*KiroTokenData, error) {
+// The login URL prompts with select_account unless WithPrompt says otherwise.
+func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialProvider, opts ...SocialLoginOption) (*KiroTokenData, error) {
+	token, err := c.loginWithSocial(ctx, provider, opts)
+	finishLogin(c.events(), token, err)
+	return token, err
+}
+
+func (c *SocialAuthClient) loginWithSocial(ctx context.Context, provider SocialProvider, opts []SocialLoginOption) (*KiroTokenData, error) {
+	loginOpts, err := resolveSocialLoginOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	out := LoginOutput(c.cfg)
 	providerName := string(provider)
 
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Printf("║         Kiro Authentication (%s)                    ║\n", providerName)
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	fmt.Fprintln(out, "\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Fprintf(out, "║         Kiro Authentication (%s)                    ║\n", providerName)
+	fmt.Fprintln(out, "╚══════════════════════════════════════════════════════════╝")
 
 	// Step 1: Start local HTTP callback server (instead of kiro:// protocol handler)
 	// This avoids redirect_mismatch errors with AWS Cognito
-	fmt.Println("\nSetting up authentication...")
+	fmt.Fprintln(out, "\nSetting up authentication...")
 
 	// Step 2: Generate PKCE codes
-	codeVerifier, codeChallenge, err := generatePKCE()
+	pkce, err := NewPKCE()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate PKCE: %w", err)
 	}
+	codeVerifier, codeChallenge := pkce.CodeVerifier, pkce.CodeChallenge
 
 	// Step 3: Generate state
-	state, err := generateStateParam()
+	state, err := NewState(stateBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate state: %w", err)
+		return nil, err
 	}
 
 	// Step 4: Start local HTTP callback server
@@ -341,9 +421,10 @@ func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialP
 		return nil, fmt.Errorf("failed to start callback server: %w", err)
 	}
 	log.Debugf("kiro social auth: callback server started at %s", redirectURI)
+	warnUnreachableCallback(ctx, out, redirectURI)
 
 	// Step 5: Build the login URL using HTTP redirect URI
-	authURL := c.buildLoginURL(providerName, redirectURI, codeChallenge, state)
+	authURL := c.buildLoginURL(providerName, redirectURI, codeChallenge, state, loginOpts.prompt)
 
 	// Set incognito mode based on config (defaults to true for Kiro, can be overridden with --no-incognito)
 	// Incognito mode enables multi-account support by bypassing cached sessions
@@ -360,26 +441,26 @@ func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialP
 	}
 
 	// Step 6: Open browser for user authentication
-	fmt.Println("\n════════════════════════════════════════════════════════════")
-	fmt.Printf("  Opening browser for %s authentication...\n", providerName)
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("\n  URL: %s\n\n", authURL)
+	fmt.Fprintln(out, "\n════════════════════════════════════════════════════════════")
+	fmt.Fprintf(out, "  Opening browser for %s authentication...\n", providerName)
+	fmt.Fprintln(out, "════════════════════════════════════════════════════════════")
+	c.events().OnAuthorizationURL(authURL)
 
 	if err := browser.OpenURL(authURL); err != nil {
 		log.Warnf("Could not open browser automatically: %v", err)
-		fmt.Println("  ⚠ Could not open browser automatically.")
-		fmt.Println("  Please open the URL above in your browser manually.")
+		fmt.Fprintln(out, "  ⚠ Could not open browser automatically.")
+		fmt.Fprintln(out, "  Please open the URL above in your browser manually.")
 	} else {
-		fmt.Println("  (Browser opened automatically)")
+		fmt.Fprintln(out, "  (Browser opened automatically)")
 	}
 
-	fmt.Println("\n  Waiting for authentication callback...")
+	fmt.Fprintln(out, "\n  Waiting for authentication callback...")
 
 	// Step 7: Wait for callback from HTTP server
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case <-time.After(socialAuthTimeout):
+	case <-time.After(loginSessionMaxAge(c.cfg)):
 		return nil, fmt.Errorf("authentication timed out")
 	case callback := <-resultChan:
 		if callback.Error != "" {
@@ -391,23 +472,27 @@ func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialP
 			return nil, fmt.Errorf("no authorization code received")
 		}
 
-		fmt.Println("\n✓ Authorization received!")
+		fmt.Fprintln(out, "\n✓ Authorization received!")
 
 		// Step 8: Exchange code for tokens
-		fmt.Println("Exchanging code for tokens...")
+		fmt.Fprintln(out, "Exchanging code for tokens...")
 
 		tokenReq := &CreateTokenRequest{
-			Code:         callback.Code,
-			CodeVerifier: codeVerifier,
-			RedirectURI:  redirectURI, // Use HTTP redirect URI, not kiro:// protocol
+			Code:           callback.Code,
+			CodeVerifier:   codeVerifier,
+			RedirectURI:    redirectURI, // Use HTTP redirect URI, not kiro:// protocol
+			InvitationCode: loginOpts.invitationCode,
 		}
 
 		tokenResp, err := c.CreateToken(ctx, tokenReq)
 		if err != nil {
+			if errors.Is(err, ErrInvitationRequired) {
+				fmt.Fprintf(out, "\n✗ This Kiro account needs an invitation code. Set %s to the code from your invite and log in again.\n", envInvitationCode)
+			}
 			return nil, fmt.Errorf("failed to exchange code for tokens: %w", err)
 		}
 
-		fmt.Println("\n✓ Authentication successful!")
+		fmt.Fprintln(out, "\n✓ Authentication successful!")
 
 		// Close the browser window
 		if err := browser.CloseBrowser(); err != nil {
@@ -415,18 +500,19 @@ func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialP
 		}
 
 		// Validate ExpiresIn - use default 1 hour if invalid
-		expiresIn := tokenResp.ExpiresIn
-		if expiresIn <= 0 {
-			expiresIn = 3600
-		}
+		expiresIn := normalizeExpiresIn("social login", tokenResp.ExpiresIn)
 		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
 		// Try to extract email from JWT access token first
 		email := ExtractEmailFromJWT(tokenResp.AccessToken)
 
-		// If no email in JWT, ask user for account label (only in interactive mode)
-		if email == "" && isInteractiveTerminal() {
-			fmt.Print("\n  Enter account label for file naming (optional, press Enter to skip): ")
+		// If no email in JWT, use the configured label or ask the user (only in interactive mode)
+		interactive := isInteractiveTerminal()
+		if email == "" && c.cfg != nil {
+			email = strings.TrimSpace(c.cfg.KiroAuth.AccountLabel)
+		}
+		if email == "" && interactive {
+			fmt.Fprint(out, "\n  Enter account label for file naming (optional, press Enter to skip): ")
 			reader := bufio.NewReader(os.Stdin)
 			var err error
 			email, err = reader.ReadString('\n')
@@ -435,43 +521,39 @@ func (c *SocialAuthClient) LoginWithSocial(ctx context.Context, provider SocialP
 			}
 			email = strings.TrimSpace(email)
 		}
+		if email == "" && c.cfg != nil {
+			email = FallbackAccountLabel(c.cfg.KiroAuth.FallbackLabelTemplate, providerName, tokenResp.AccessToken)
+		}
+		if email == "" && !interactive {
+			email = derivedAccountLabel(providerName, tokenResp.AccessToken)
+		}
 
-		return &KiroTokenData{
+		return StampLoginProvenance(&KiroTokenData{
 			AccessToken:  tokenResp.AccessToken,
 			RefreshToken: tokenResp.RefreshToken,
 			ProfileArn:   tokenResp.ProfileArn,
 			ExpiresAt:    expiresAt.Format(time.RFC3339),
 			AuthMethod:   "social",
 			Provider:     providerName,
-			Email:        email, // JWT email or user-provided label
+			Email:        email, // JWT email, configured, user-provided or fallback label
 			Region:       "us-east-1",
-		}, nil
+		}, LoginMethodSocial), nil
 	}
 }
 
 // LoginWithGoogle performs OAuth login with Google.
-func (c *SocialAuthClient) LoginWithGoogle(ctx context.Context) (*KiroTokenData, error) {
-	return c.LoginWithSocial(ctx, ProviderGoogle)
+func (c *SocialAuthClient) LoginWithGoogle(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
+	return c.LoginWithSocial(ctx, ProviderGoogle, opts...)
 }
 
 // LoginWithGitHub performs OAuth login with GitHub.
-func (c *SocialAuthClient) LoginWithGitHub(ctx context.Context) (*KiroTokenData, error) {
-	return c.LoginWithSocial(ctx, ProviderGitHub)
+func (c *SocialAuthClient) LoginWithGitHub(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
+	return c.LoginWithSocial(ctx, ProviderGitHub, opts...)
 }
 
-// forceDefaultProtocolHandler sets our protocol handler as the default for kiro:// URLs.
-// This prevents the "Open with" dialog from appearing on Linux.
-// On non-Linux platforms, this is a no-op as they use different mechanisms.
-func forceDefaultProtocolHandler() {
-	if runtime.GOOS != "linux" {
-		return // Non-Linux platforms use different handler mechanisms
-	}
-	
-	// Set our handler as default using xdg-mime
-	cmd := exec.Command("xdg-mime", "default", "kiro-oauth-handler.desktop", "x-scheme-handler/kiro")
-	if err := cmd.Run(); err != nil {
-		log.Warnf("Failed to set default protocol handler: %v. You may see a handler selection dialog.", err)
-	}
+// LoginWithCognito performs OAuth login for a Kiro account created with email and password.
+func (c *SocialAuthClient) LoginWithCognito(ctx context.Context, opts ...SocialLoginOption) (*KiroTokenData, error) {
+	return c.LoginWithSocial(ctx, ProviderCognito, opts...)
 }
 
 // isInteractiveTerminal checks if stdin is connected to an interactive terminal.
@@ -0,0 +1,260 @@
+package kiro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRefreshSocialTokenUserAgent(t *testing.T) {
+	var gotUA string
+	client := &SocialAuthClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotUA = req.Header.Get("User-Agent")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"accessToken":"new","refreshToken":"rt","expiresIn":3600}`)),
+			Request:    req,
+		}, nil
+	})}}
+
+	if _, err := client.RefreshSocialToken(context.Background(), "rt"); err != nil {
+		t.Fatalf("RefreshSocialToken() error = %v", err)
+	}
+	if want := buildKiroUserAgent(nil, ""); gotUA != want {
+		t.Errorf("User-Agent = %q, want %q", gotUA, want)
+	}
+
+	if _, err := client.RefreshSocialTokenWithFingerprint(context.Background(), "rt", "token-key", ""); err != nil {
+		t.Fatalf("RefreshSocialTokenWithFingerprint() error = %v", err)
+	}
+	if !strings.HasPrefix(gotUA, "KiroIDE-") || gotUA == buildKiroUserAgent(nil, "") {
+		t.Errorf("User-Agent = %q, want fingerprinted KiroIDE- user agent", gotUA)
+	}
+}
+
+func TestNormalizeExpiresIn(t *testing.T) {
+	before := MissingExpiryCount()
+	if got := normalizeExpiresIn("test", 1800); got != 1800 {
+		t.Errorf("normalizeExpiresIn(1800) = %d, want 1800", got)
+	}
+	if got := normalizeExpiresIn("test", 0); got != defaultTokenExpiresIn {
+		t.Errorf("normalizeExpiresIn(0) = %d, want %d", got, defaultTokenExpiresIn)
+	}
+	if got := normalizeExpiresIn("test", -5); got != defaultTokenExpiresIn {
+		t.Errorf("normalizeExpiresIn(-5) = %d, want %d", got, defaultTokenExpiresIn)
+	}
+	if got := MissingExpiryCount() - before; got != 2 {
+		t.Errorf("MissingExpiryCount() increased by %d, want 2", got)
+	}
+}
+
+func TestCleanupExpiredSessionsUsesLoginSessionMaxAge(t *testing.T) {
+	cfg := &config.Config{KiroAuth: config.KiroAuthConfig{LoginSessionMaxAge: 60}}
+	if got := loginSessionMaxAge(cfg); got != time.Minute {
+		t.Fatalf("loginSessionMaxAge() = %v, want %v", got, time.Minute)
+	}
+	if got := loginSessionMaxAge(nil); got != defaultLoginSessionMaxAge {
+		t.Fatalf("loginSessionMaxAge(nil) = %v, want %v", got, defaultLoginSessionMaxAge)
+	}
+
+	h := NewOAuthWebHandler(cfg)
+	canceled := false
+	h.sessions["stale"] = &webAuthSession{status: statusPending, startedAt: time.Now().Add(-2 * time.Minute), cancelFunc: func() { canceled = true }}
+	h.sessions["fresh"] = &webAuthSession{status: statusPending, startedAt: time.Now(), cancelFunc: func() {}}
+
+	h.CleanupExpiredSessions()
+
+	if _, ok := h.sessions["stale"]; ok || !canceled {
+		t.Fatalf("stale session kept = %v, canceled = %v; want removed and canceled", ok, canceled)
+	}
+	if _, ok := h.sessions["fresh"]; !ok {
+		t.Fatal("fresh session was removed")
+	}
+}
+
+func TestConfiguredKiroUserAgentVersion(t *testing.T) {
+	cfg := &config.Config{KiroAuth: config.KiroAuthConfig{ClientName: "Custom IDE", UserAgentVersion: "0.9.1"}}
+	if got, want := buildKiroUserAgent(cfg, ""), "KiroIDE-0.9.1-cli-proxy-api"; got != want {
+		t.Fatalf("buildKiroUserAgent() = %q, want %q", got, want)
+	}
+	if got := buildKiroUserAgent(cfg, "token-key"); !strings.HasPrefix(got, "KiroIDE-0.9.1-") {
+		t.Fatalf("buildKiroUserAgent() with token key = %q, want configured version", got)
+	}
+
+	client := &SSOOIDCClient{cfg: cfg}
+	if got := client.clientName(); got != "Custom IDE" {
+		t.Fatalf("clientName() = %q, want %q", got, "Custom IDE")
+	}
+	if got := client.userAgent(); got != "KiroIDE-0.9.1" {
+		t.Fatalf("userAgent() = %q, want %q", got, "KiroIDE-0.9.1")
+	}
+	if got := client.amzUserAgent(); !strings.HasSuffix(got, " m/E KiroIDE-0.9.1") {
+		t.Fatalf("amzUserAgent() = %q, want KiroIDE-0.9.1 suffix", got)
+	}
+
+	defaults := &SSOOIDCClient{}
+	if defaults.clientName() != defaultOIDCClientName || defaults.userAgent() != kiroUserAgent || defaults.amzUserAgent() != idcAmzUserAgent {
+		t.Fatalf("unconfigured client should keep the built-in name and User-Agents")
+	}
+}
+
+func TestSocialLoginPrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []SocialLoginOption
+		want    string
+		wantErr bool
+	}{
+		{name: "default", want: "prompt=select_account"},
+		{name: "login", opts: []SocialLoginOption{WithPrompt(SocialPromptLogin)}, want: "prompt=login"},
+		{name: "none", opts: []SocialLoginOption{WithPrompt(SocialPromptNone)}, want: "prompt=none"},
+		{name: "invalid", opts: []SocialLoginOption{WithPrompt("always")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := resolveSocialLoginOptions(tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveSocialLoginOptions() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSocialLoginOptions() error = %v", err)
+			}
+			loginURL := (&SocialAuthClient{}).buildLoginURL("Google", "http://localhost:1234/oauth/callback", "challenge", "state", opts.prompt)
+			if !strings.HasSuffix(loginURL, "&"+tt.want) {
+				t.Fatalf("buildLoginURL() = %q, want %s", loginURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestCognitoLoginURL(t *testing.T) {
+	loginURL := (&SocialAuthClient{}).buildLoginURL(string(ProviderCognito), "http://localhost:1234/oauth/callback", "challenge", "state", SocialPromptSelectAccount)
+	if !strings.Contains(loginURL, "/login?idp=Cognito&") {
+		t.Fatalf("buildLoginURL() = %q, want idp=Cognito", loginURL)
+	}
+}
+
+func TestSocialCreateTokenInvitationCode(t *testing.T) {
+	t.Setenv(envInvitationCode, "env-code")
+	opts, err := resolveSocialLoginOptions(nil)
+	if err != nil || opts.invitationCode != "env-code" {
+		t.Fatalf("resolveSocialLoginOptions() = %+v, %v; want invitation code from %s", opts, err, envInvitationCode)
+	}
+	if opts, _ = resolveSocialLoginOptions([]SocialLoginOption{WithInvitationCode("opt-code")}); opts.invitationCode != "opt-code" {
+		t.Fatalf("WithInvitationCode should override %s, got %q", envInvitationCode, opts.invitationCode)
+	}
+
+	var sent CreateTokenRequest
+	client := &SocialAuthClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+			t.Fatalf("decode token request: %v", err)
+		}
+		body := `{"message":"An invitation code is required to sign up"}`
+		return &http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}}
+	_, err = client.CreateToken(context.Background(), &CreateTokenRequest{Code: "code", CodeVerifier: "verifier", InvitationCode: "opt-code"})
+	if !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("CreateToken() error = %v, want ErrInvitationRequired", err)
+	}
+	if sent.InvitationCode != "opt-code" {
+		t.Fatalf("token request invitation_code = %q, want %q", sent.InvitationCode, "opt-code")
+	}
+}
+
+// browserAuthEvents stands in for the user's browser: it follows the login URL by calling the
+// local callback with code and the URL's state.
+type browserAuthEvents struct {
+	terminalAuthEvents
+	t        *testing.T
+	code     string
+	loginURL chan *url.URL
+}
+
+func (b *browserAuthEvents) OnAuthorizationURL(raw string) {
+	loginURL, err := url.Parse(raw)
+	if err != nil {
+		b.t.Errorf("parse login URL: %v", err)
+		return
+	}
+	b.loginURL <- loginURL
+	query := loginURL.Query()
+	callback := query.Get("redirect_uri") + "?" + url.Values{"code": {b.code}, "state": {query.Get("state")}}.Encode()
+	go func() {
+		resp, err := http.Get(callback)
+		if err != nil {
+			b.t.Errorf("callback request: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+	}()
+}
+
+func TestLoginWithCognitoAgainstFakeTokenEndpoint(t *testing.T) {
+	// An empty PATH keeps the login from launching a real browser.
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv(envInvitationCode, "")
+
+	var sent CreateTokenRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("decode token request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"accessToken":"cognito-access","refreshToken":"cognito-refresh","profileArn":"arn:aws:codewhisperer:us-east-1:123456789012:profile/COGNITO","expiresIn":3600}`))
+	}))
+	defer server.Close()
+	fake, _ := url.Parse(server.URL)
+	authService, _ := url.Parse(kiroAuthServiceEndpoint)
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == authService.Host {
+			req = req.Clone(req.Context())
+			req.URL.Scheme, req.URL.Host = fake.Scheme, fake.Host
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	cfg := &config.Config{KiroAuth: config.KiroAuthConfig{AccountLabel: "cognito-user"}}
+	client := NewSocialAuthClient(cfg, WithTransport(transport))
+	events := &browserAuthEvents{terminalAuthEvents: terminalAuthEvents{out: io.Discard}, t: t, code: "auth-code", loginURL: make(chan *url.URL, 1)}
+	client.Events = events
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, err := client.LoginWithCognito(ctx)
+	if err != nil {
+		t.Fatalf("LoginWithCognito() error = %v", err)
+	}
+
+	loginURL := <-events.loginURL
+	if got := loginURL.Query().Get("idp"); got != string(ProviderCognito) {
+		t.Fatalf("login URL idp = %q, want %q", got, ProviderCognito)
+	}
+	if sent.Code != "auth-code" || sent.CodeVerifier == "" || sent.RedirectURI != loginURL.Query().Get("redirect_uri") {
+		t.Fatalf("token request = %+v, want the callback code, a PKCE verifier and the login redirect URI", sent)
+	}
+	if token.AccessToken != "cognito-access" || token.RefreshToken != "cognito-refresh" || token.Provider != string(ProviderCognito) ||
+		token.AuthMethod != "social" || token.Email != "cognito-user" {
+		t.Fatalf("token = %+v, want the exchanged Cognito tokens labelled cognito-user", token)
+	}
+}
@@ -4,17 +4,15 @@ package kiro
 import (
 	"bufio"
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,9 +32,30 @@ const (
 	// Default region for IDC
 	defaultIDCRegion = "us-east-1"
 
+	// Environment variables for non-interactive IDC login
+	envIDCStartURL = "KIRO_IDC_START_URL"
+	envIDCRegion   = "KIRO_IDC_REGION"
+
+	// Environment variable choosing the CodeWhisperer profile at login
+	envProfileArn = "KIRO_PROFILE_ARN"
+
 	// Polling interval
 	pollInterval = 5 * time.Second
 
+	// Device-code polling backoff: each slow_down adds pollSlowDownStep up to
+	// maxPollInterval, and maxConsecutiveSlowDowns in a row aborts the login.
+	pollSlowDownStep        = 5 * time.Second
+	maxPollInterval         = 30 * time.Second
+	maxConsecutiveSlowDowns = 5
+
+	// Default time a device-code login may sit unauthorized before it is abandoned
+	defaultDeviceCodeInactivityTimeout = 3 * time.Minute
+
+	// Retry policy for a rate-limited userinfo endpoint
+	defaultUserInfoRetries = 3
+	userInfoBaseBackoff    = 1 * time.Second
+	userInfoMaxBackoff     = 30 * time.Second
+
 	// Authorization code flow callback
 	authCodeCallbackPath = "/oauth/callback"
 	authCodeCallbackPort = 19877
@@ -44,6 +63,9 @@ const (
 	// User-Agent to match official Kiro IDE
 	kiroUserAgent = "KiroIDE"
 
+	// Client name sent at OIDC client registration (matching Kiro IDE)
+	defaultOIDCClientName = "Kiro IDE"
+
 	// IDC token refresh headers (matching Kiro IDE behavior)
 	idcAmzUserAgent = "aws-sdk-js/3.738.0 ua/2.1 os/other lang/js md/browser#unknown_unknown api/sso-oidc#3.738.0 m/E KiroIDE"
 )
@@ -52,24 +74,257 @@ const (
 var (
 	ErrAuthorizationPending = errors.New("authorization_pending")
 	ErrSlowDown             = errors.New("slow_down")
+	ErrLoginAbandoned       = errors.New("login abandoned: no authorization received before the inactivity timeout")
+	ErrTooManySlowDowns     = errors.New("login aborted: too many consecutive slow_down responses")
 )
 
+// OIDCError is an error response from the OIDC token endpoint, e.g. expired_token when the
+// user let the device code lapse or access_denied when they rejected the request.
+type OIDCError struct {
+	Code        string
+	Description string
+	HTTPStatus  int
+}
+
+func (e *OIDCError) Error() string {
+	msg := fmt.Sprintf("create token failed (status %d)", e.HTTPStatus)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// parseOIDCError builds an OIDCError from a token endpoint error response. A body that is not
+// the OAuth error JSON leaves Code and Description empty.
+func parseOIDCError(status int, body []byte) *OIDCError {
+	var errResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &errResp)
+	return &OIDCError{Code: errResp.Error, Description: errResp.ErrorDescription, HTTPStatus: status}
+}
+
+// tokenErrorHint returns a user-facing explanation for a failed device-code token request.
+func tokenErrorHint(err error) string {
+	var oidcErr *OIDCError
+	if !errors.As(err, &oidcErr) {
+		return ""
+	}
+	switch oidcErr.Code {
+	case "access_denied":
+		return "You denied the request."
+	case "expired_token":
+		return "The code expired, please retry."
+	}
+	return ""
+}
+
+// devicePollInterval tracks the device-code polling interval across slow_down responses.
+type devicePollInterval struct {
+	base      time.Duration
+	current   time.Duration
+	slowDowns int
+	polled    bool
+}
+
+// newDevicePollInterval starts at the server-provided interval in seconds, or pollInterval.
+func newDevicePollInterval(serverSeconds int) *devicePollInterval {
+	base := pollInterval
+	if serverSeconds > 0 {
+		base = time.Duration(serverSeconds) * time.Second
+	}
+	return &devicePollInterval{base: base, current: base}
+}
+
+// next returns how long to wait before the next poll. The first poll is immediate so a fast
+// authorization does not wait out a full interval.
+func (p *devicePollInterval) next() time.Duration {
+	if !p.polled {
+		p.polled = true
+		return 0
+	}
+	return p.current
+}
+
+// slowDown backs off after a slow_down response, capped at maxPollInterval. It returns
+// ErrTooManySlowDowns once maxConsecutiveSlowDowns arrive without a pending poll in between.
+func (p *devicePollInterval) slowDown() error {
+	p.slowDowns++
+	if p.slowDowns >= maxConsecutiveSlowDowns {
+		return ErrTooManySlowDowns
+	}
+	p.current = min(p.current+pollSlowDownStep, max(maxPollInterval, p.base))
+	return nil
+}
+
+// pending steps the interval back toward the server-provided one after an
+// authorization_pending response and clears the slow_down streak.
+func (p *devicePollInterval) pending() {
+	p.slowDowns = 0
+	p.current = max(p.current-pollSlowDownStep, p.base)
+}
+
 // SSOOIDCClient handles AWS SSO OIDC authentication.
 type SSOOIDCClient struct {
 	httpClient *http.Client
 	cfg        *config.Config
+	// endpoint overrides the OIDC base URL for all regions when set.
+	endpoint string
+	// clients persists registrations for reuse; nil disables reuse.
+	clients *OIDCClientStore
+	// Events receives login progress; nil uses terminal output (see AuthEventHandler).
+	Events AuthEventHandler
+	// Scopes overrides the scopes requested at client registration and in the auth-code
+	// authorize URL. Empty uses DefaultOIDCScopes.
+	Scopes []string
 }
 
+// DefaultOIDCScopes are the CodeWhisperer scopes requested when no override is configured.
+var DefaultOIDCScopes = []string{"codewhisperer:completions", "codewhisperer:analysis", "codewhisperer:conversations", "codewhisperer:transformations", "codewhisperer:taskassist"}
+
+// defaultAuthorizeScopes is the narrower list sent in the auth-code authorize URL when no
+// override is configured.
+const defaultAuthorizeScopes = "codewhisperer:completions,codewhisperer:analysis,codewhisperer:conversations"
+
 // NewSSOOIDCClient creates a new SSO OIDC client.
-func NewSSOOIDCClient(cfg *config.Config) *SSOOIDCClient {
-	client := &http.Client{Timeout: 30 * time.Second}
-	if cfg != nil {
-		client = util.SetProxy(&cfg.SDKConfig, client)
-	}
-	return &SSOOIDCClient{
+func NewSSOOIDCClient(cfg *config.Config, opts ...ClientOption) *SSOOIDCClient {
+	client := resolveAuthHTTPClient(cfg, 30*time.Second, opts)
+	oidcClient := &SSOOIDCClient{
 		httpClient: client,
 		cfg:        cfg,
 	}
+	if cfg != nil {
+		oidcClient.endpoint = strings.TrimRight(strings.TrimSpace(cfg.KiroAuth.OIDCEndpoint), "/")
+		oidcClient.Scopes = cfg.KiroAuth.OIDCScopes
+		if cfg.AuthDir != "" {
+			if authDir, err := util.ResolveAuthDir(cfg.AuthDir); err == nil {
+				oidcClient.clients = NewOIDCClientStore(authDir)
+			}
+		}
+	}
+	return oidcClient
+}
+
+// oidcScopes returns the scopes to register clients with: the Scopes override with blank and
+// duplicate entries removed, or DefaultOIDCScopes when no override is set.
+func (c *SSOOIDCClient) oidcScopes() ([]string, error) {
+	if len(c.Scopes) == 0 {
+		return DefaultOIDCScopes, nil
+	}
+	scopes := make([]string, 0, len(c.Scopes))
+	seen := make(map[string]struct{}, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if _, dup := seen[scope]; dup {
+			continue
+		}
+		seen[scope] = struct{}{}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("kiro: at least one OIDC scope is required")
+	}
+	return scopes, nil
+}
+
+// reusableClient returns a stored registration that can serve a new login, or nil.
+func (c *SSOOIDCClient) reusableClient(endpoint, grantType, redirectURI, startURL string, scopes []string) *RegisterClientResponse {
+	if c.clients == nil {
+		return nil
+	}
+	record := c.clients.Find(endpoint, grantType, redirectURI, startURL, scopes, time.Now())
+	if record == nil {
+		return nil
+	}
+	log.Debugf("kiro: reusing OIDC client %s registered at %s", record.ClientID, record.IssuedAt.Format(time.RFC3339))
+	return &RegisterClientResponse{
+		ClientID:              record.ClientID,
+		ClientSecret:          record.ClientSecret,
+		ClientIDIssuedAt:      record.IssuedAt.Unix(),
+		ClientSecretExpiresAt: record.ExpiresAt.Unix(),
+	}
+}
+
+// rememberClient stores a new registration so later logins can reuse it.
+func (c *SSOOIDCClient) rememberClient(resp *RegisterClientResponse, endpoint, grantType, redirectURI, startURL string, scopes []string) {
+	if c.clients == nil || resp == nil || resp.ClientID == "" {
+		return
+	}
+	if err := c.clients.Save(recordFromRegistration(resp, endpoint, grantType, redirectURI, startURL, scopes, time.Now())); err != nil {
+		log.Warnf("kiro: failed to record OIDC client %s: %v", resp.ClientID, err)
+	}
+}
+
+// deviceCodeInactivityTimeout returns how long a device-code login may wait for the user
+// before it is abandoned. Zero means the inactivity timeout is disabled.
+func (c *SSOOIDCClient) deviceCodeInactivityTimeout() time.Duration {
+	if c.cfg == nil || c.cfg.KiroAuth.DeviceCodeInactivityTimeout == 0 {
+		return defaultDeviceCodeInactivityTimeout
+	}
+	if c.cfg.KiroAuth.DeviceCodeInactivityTimeout < 0 {
+		return 0
+	}
+	return time.Duration(c.cfg.KiroAuth.DeviceCodeInactivityTimeout) * time.Second
+}
+
+// events returns the login event handler.
+func (c *SSOOIDCClient) events() AuthEventHandler {
+	if c.Events != nil {
+		return c.Events
+	}
+	return defaultAuthEvents(c.cfg)
+}
+
+// copyUserCode copies the device-code user code to the clipboard when enabled in config.
+// Failures are only logged; the code is always printed as well.
+func (c *SSOOIDCClient) copyUserCode(userCode string) {
+	if c.cfg == nil || !c.cfg.KiroAuth.CopyUserCode || userCode == "" {
+		return
+	}
+	if err := browser.CopyToClipboard(userCode); err != nil {
+		log.Debugf("kiro: could not copy user code to clipboard: %v", err)
+		return
+	}
+	fmt.Fprintln(LoginOutput(c.cfg), "  (Code copied to clipboard)")
+}
+
+// clientName returns the client name sent at OIDC client registration.
+func (c *SSOOIDCClient) clientName() string {
+	if c.cfg == nil || strings.TrimSpace(c.cfg.KiroAuth.ClientName) == "" {
+		return defaultOIDCClientName
+	}
+	return strings.TrimSpace(c.cfg.KiroAuth.ClientName)
+}
+
+// userAgent returns the User-Agent for OIDC requests, "KiroIDE" or "KiroIDE-<version>".
+func (c *SSOOIDCClient) userAgent() string {
+	if version := configuredKiroVersion(c.cfg); version != "" {
+		return kiroUserAgent + "-" + version
+	}
+	return kiroUserAgent
+}
+
+// amzUserAgent returns the x-amz-user-agent for IDC token refresh; a configured Kiro
+// version is appended to its KiroIDE component.
+func (c *SSOOIDCClient) amzUserAgent() string {
+	if version := configuredKiroVersion(c.cfg); version != "" {
+		return idcAmzUserAgent + "-" + version
+	}
+	return idcAmzUserAgent
+}
+
+// warnMissingRefreshToken logs a successful token exchange that carries no refresh token.
+func warnMissingRefreshToken(flow string, result *CreateTokenResponse) {
+	if result.RefreshToken == "" {
+		log.Warnf("kiro: %s token response has no refresh token; the token cannot be refreshed and will need a new login once it expires", flow)
+	}
 }
 
 // RegisterClientResponse from AWS SSO OIDC.
@@ -103,16 +358,63 @@ func getOIDCEndpoint(region string) string {
 	if region == "" {
 		region = defaultIDCRegion
 	}
-	return fmt.Sprintf("https://oidc.%s.amazonaws.com", region)
+	return fmt.Sprintf("https://oidc.%s.%s", region, partitionForRegion(region).dnsSuffix)
+}
+
+// awsPartition describes the AWS partition a region belongs to.
+type awsPartition struct {
+	name      string
+	dnsSuffix string
+}
+
+// partitionForRegion detects the partition from the region prefix. GovCloud service
+// endpoints share the commercial amazonaws.com suffix (amazonaws-us-gov.com only serves the
+// console and sign-in pages), but credentials from one partition are not accepted in another.
+func partitionForRegion(region string) awsPartition {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return awsPartition{name: "aws-us-gov", dnsSuffix: "amazonaws.com"}
+	case strings.HasPrefix(region, "cn-"):
+		return awsPartition{name: "aws-cn", dnsSuffix: "amazonaws.com.cn"}
+	default:
+		return awsPartition{name: "aws", dnsSuffix: "amazonaws.com"}
+	}
 }
 
-// promptInput prompts the user for input with an optional default value.
-func promptInput(prompt, defaultValue string) string {
+// getCodeWhispererEndpoint returns the CodeWhisperer endpoint used for profile lookups.
+// The commercial partition serves every region from us-east-1; the GovCloud and China
+// partitions cannot reach it and use the login region instead.
+func getCodeWhispererEndpoint(region string) string {
+	partition := partitionForRegion(region)
+	if partition.name == "aws" {
+		return "https://codewhisperer.us-east-1.amazonaws.com"
+	}
+	return fmt.Sprintf("https://codewhisperer.%s.%s", region, partition.dnsSuffix)
+}
+
+// baseEndpoint returns the OIDC endpoint used by the Builder ID flows.
+func (c *SSOOIDCClient) baseEndpoint() string {
+	if c.endpoint != "" {
+		return c.endpoint
+	}
+	return ssoOIDCEndpoint
+}
+
+// regionEndpoint returns the OIDC endpoint for the given region, honoring the override.
+func (c *SSOOIDCClient) regionEndpoint(region string) string {
+	if c.endpoint != "" {
+		return c.endpoint
+	}
+	return getOIDCEndpoint(region)
+}
+
+// promptInput prompts the user on out for input with an optional default value.
+func promptInput(out io.Writer, prompt, defaultValue string) string {
 	reader := bufio.NewReader(os.Stdin)
 	if defaultValue != "" {
-		fmt.Printf("%s [%s]: ", prompt, defaultValue)
+		fmt.Fprintf(out, "%s [%s]: ", prompt, defaultValue)
 	} else {
-		fmt.Printf("%s: ", prompt)
+		fmt.Fprintf(out, "%s: ", prompt)
 	}
 	input, err := reader.ReadString('\n')
 	if err != nil {
@@ -126,16 +428,16 @@ func promptInput(prompt, defaultValue string) string {
 	return input
 }
 
-// promptSelect prompts the user to select from options using number input.
-func promptSelect(prompt string, options []string) int {
+// promptSelect prompts the user on out to select from options using number input.
+func promptSelect(out io.Writer, prompt string, options []string) int {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
-		fmt.Println(prompt)
+		fmt.Fprintln(out, prompt)
 		for i, opt := range options {
-			fmt.Printf("  %d) %s\n", i+1, opt)
+			fmt.Fprintf(out, "  %d) %s\n", i+1, opt)
 		}
-		fmt.Printf("Enter selection (1-%d): ", len(options))
+		fmt.Fprintf(out, "Enter selection (1-%d): ", len(options))
 
 		input, err := reader.ReadString('\n')
 		if err != nil {
@@ -147,7 +449,7 @@ func promptSelect(prompt string, options []string) int {
 		// Parse the selection
 		var selection int
 		if _, err := fmt.Sscanf(input, "%d", &selection); err != nil || selection < 1 || selection > len(options) {
-			fmt.Printf("Invalid selection '%s'. Please enter a number between 1 and %d.\n\n", input, len(options))
+			fmt.Fprintf(out, "Invalid selection '%s'. Please enter a number between 1 and %d.\n\n", input, len(options))
 			continue
 		}
 		return selection - 1
@@ -155,55 +457,60 @@ func promptSelect(prompt string, options []string) int {
 }
 
 // RegisterClientWithRegion registers a new OIDC client with AWS using a specific region.
+// It reuses a stored registration made without a start URL; see GetOrRegisterClient.
 func (c *SSOOIDCClient) RegisterClientWithRegion(ctx context.Context, region string) (*RegisterClientResponse, error) {
-	endpoint := getOIDCEndpoint(region)
+	return c.GetOrRegisterClient(ctx, region, "")
+}
 
-	payload := map[string]interface{}{
-		"clientName": "Kiro IDE",
-		"clientType": "public",
-		"scopes":     []string{"codewhisperer:completions", "codewhisperer:analysis", "codewhisperer:conversations", "codewhisperer:transformations", "codewhisperer:taskassist"},
-		"grantTypes": []string{"urn:ietf:params:oauth:grant-type:device_code", "refresh_token"},
-	}
+// GetOrRegisterClient returns a device-code client registration for region and startURL.
+// A registration stored in the auth directory is reused until its secret is within
+// oidcClientReuseMargin of expiry; otherwise a new client is registered and stored.
+func (c *SSOOIDCClient) GetOrRegisterClient(ctx context.Context, region, startURL string) (*RegisterClientResponse, error) {
+	return c.registerDeviceClient(ctx, c.regionEndpoint(region), startURL)
+}
 
-	body, err := json.Marshal(payload)
+// registerDeviceClient registers a device-code client at endpoint unless a stored
+// registration for the endpoint, start URL and scopes can be reused.
+func (c *SSOOIDCClient) registerDeviceClient(ctx context.Context, endpoint, startURL string) (*RegisterClientResponse, error) {
+	scopes, err := c.oidcScopes()
 	if err != nil {
 		return nil, err
 	}
+	if cached := c.reusableClient(endpoint, OIDCGrantDeviceCode, "", startURL, scopes); cached != nil {
+		return cached, nil
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/client/register", strings.NewReader(string(body)))
-	if err != nil {
-		return nil, err
+	payload := map[string]interface{}{
+		"clientName": c.clientName(),
+		"clientType": "public",
+		"scopes":     scopes,
+		"grantTypes": []string{"urn:ietf:params:oauth:grant-type:device_code", "refresh_token"},
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
 
-	resp, err := c.httpClient.Do(req)
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err := c.postOIDCWithRetry(ctx, "register client", endpoint+"/client/register", body)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("register client failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("register client failed (status %d)", resp.StatusCode)
-	}
-
 	var result RegisterClientResponse
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
 	}
 
+	c.rememberClient(&result, endpoint, OIDCGrantDeviceCode, "", startURL, scopes)
 	return &result, nil
 }
 
 // StartDeviceAuthorizationWithIDC starts the device authorization flow for IDC.
+// The request carries no scopes: AWS grants the scopes the client was registered with,
+// so a Scopes override applies through GetOrRegisterClient.
 func (c *SSOOIDCClient) StartDeviceAuthorizationWithIDC(ctx context.Context, clientID, clientSecret, startURL, region string) (*StartDeviceAuthResponse, error) {
-	endpoint := getOIDCEndpoint(region)
+	endpoint := c.regionEndpoint(region)
 
 	payload := map[string]string{
 		"clientId":     clientID,
@@ -216,28 +523,10 @@ func (c *SSOOIDCClient) StartDeviceAuthorizationWithIDC(ctx context.Context, cli
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/device_authorization", strings.NewReader(string(body)))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.postOIDCWithRetry(ctx, "start device auth", endpoint+"/device_authorization", body)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("start device auth failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("start device auth failed (status %d)", resp.StatusCode)
-	}
 
 	var result StartDeviceAuthResponse
 	if err := json.Unmarshal(respBody, &result); err != nil {
@@ -249,7 +538,10 @@ func (c *SSOOIDCClient) StartDeviceAuthorizationWithIDC(ctx context.Context, cli
 
 // CreateTokenWithRegion polls for the access token after user authorization using a specific region.
 func (c *SSOOIDCClient) CreateTokenWithRegion(ctx context.Context, clientID, clientSecret, deviceCode, region string) (*CreateTokenResponse, error) {
-	endpoint := getOIDCEndpoint(region)
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
+	endpoint := c.regionEndpoint(region)
 
 	payload := map[string]string{
 		"clientId":     clientID,
@@ -268,7 +560,7 @@ func (c *SSOOIDCClient) CreateTokenWithRegion(ctx context.Context, clientID, cli
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
+	req.Header.Set("User-Agent", c.userAgent())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -281,26 +573,17 @@ func (c *SSOOIDCClient) CreateTokenWithRegion(ctx context.Context, clientID, cli
 		return nil, err
 	}
 
-	// Check for pending authorization
-	if resp.StatusCode == http.StatusBadRequest {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if json.Unmarshal(respBody, &errResp) == nil {
-			if errResp.Error == "authorization_pending" {
-				return nil, ErrAuthorizationPending
-			}
-			if errResp.Error == "slow_down" {
-				return nil, ErrSlowDown
-			}
-		}
-		log.Debugf("create token failed: %s", string(respBody))
-		return nil, fmt.Errorf("create token failed")
-	}
-
 	if resp.StatusCode != http.StatusOK {
+		oidcErr := parseOIDCError(resp.StatusCode, respBody)
+		// Check for pending authorization
+		switch oidcErr.Code {
+		case "authorization_pending":
+			return nil, ErrAuthorizationPending
+		case "slow_down":
+			return nil, ErrSlowDown
+		}
 		log.Debugf("create token failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("create token failed (status %d)", resp.StatusCode)
+		return nil, oidcErr
 	}
 
 	var result CreateTokenResponse
@@ -308,12 +591,23 @@ func (c *SSOOIDCClient) CreateTokenWithRegion(ctx context.Context, clientID, cli
 		return nil, err
 	}
 
+	warnMissingRefreshToken("device code", &result)
 	return &result, nil
 }
 
 // RefreshTokenWithRegion refreshes an access token using the refresh token with a specific region.
+// Concurrent refreshes of the same refresh token share one request (see sharedRefresh).
 func (c *SSOOIDCClient) RefreshTokenWithRegion(ctx context.Context, clientID, clientSecret, refreshToken, region, startURL string) (*KiroTokenData, error) {
-	endpoint := getOIDCEndpoint(region)
+	return sharedRefresh(ctx, refreshToken, func(ctx context.Context) (*KiroTokenData, error) {
+		return c.refreshTokenWithRegion(ctx, clientID, clientSecret, refreshToken, region, startURL)
+	})
+}
+
+func (c *SSOOIDCClient) refreshTokenWithRegion(ctx context.Context, clientID, clientSecret, refreshToken, region, startURL string) (*KiroTokenData, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
+	endpoint := c.regionEndpoint(region)
 
 	payload := map[string]string{
 		"clientId":     clientID,
@@ -335,9 +629,9 @@ func (c *SSOOIDCClient) RefreshTokenWithRegion(ctx context.Context, clientID, cl
 	// Set headers matching kiro2api's IDC token refresh
 	// These headers are required for successful IDC token refresh
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Host", fmt.Sprintf("oidc.%s.amazonaws.com", region))
+	req.Header.Set("Host", strings.TrimPrefix(getOIDCEndpoint(region), "https://"))
 	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("x-amz-user-agent", idcAmzUserAgent)
+	req.Header.Set("x-amz-user-agent", c.amzUserAgent())
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Language", "*")
 	req.Header.Set("sec-fetch-mode", "cors")
@@ -367,7 +661,7 @@ func (c *SSOOIDCClient) RefreshTokenWithRegion(ctx context.Context, clientID, cl
 
 	expiresAt := time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
 
-	return &KiroTokenData{
+	return finishRefresh("IDC token refresh", &KiroTokenData{
 		AccessToken:  result.AccessToken,
 		RefreshToken: result.RefreshToken,
 		ExpiresAt:    expiresAt.Format(time.RFC3339),
@@ -377,37 +671,61 @@ func (c *SSOOIDCClient) RefreshTokenWithRegion(ctx context.Context, clientID, cl
 		ClientSecret: clientSecret,
 		StartURL:     startURL,
 		Region:       region,
-	}, nil
+	}, refreshToken), nil
 }
 
-// LoginWithIDC performs the full device code flow for AWS Identity Center (IDC).
+// LoginWithIDC performs the full device code flow for AWS Identity Center (IDC), waiting for
+// authorization at most kiro-auth.idc-login-timeout when configured.
 func (c *SSOOIDCClient) LoginWithIDC(ctx context.Context, startURL, region string) (*KiroTokenData, error) {
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║       Kiro Authentication (AWS Identity Center)          ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	return c.LoginWithIDCTimeout(ctx, startURL, region, c.idcLoginTimeout())
+}
+
+// idcLoginTimeout returns the configured cap on the IDC authorization wait; 0 means none.
+func (c *SSOOIDCClient) idcLoginTimeout() time.Duration {
+	if c.cfg == nil || c.cfg.KiroAuth.IDCLoginTimeout <= 0 {
+		return 0
+	}
+	return time.Duration(c.cfg.KiroAuth.IDCLoginTimeout) * time.Second
+}
+
+// LoginWithIDCTimeout is LoginWithIDC with the wait for authorization capped at maxWait, for
+// scripted flows that must bound their runtime. The effective limit is the smallest of maxWait,
+// the device code's expiry and the login session max age; maxWait <= 0 applies no extra cap.
+// Running out of time returns an "authorization timed out after ..." error, unlike ctx cancellation.
+func (c *SSOOIDCClient) LoginWithIDCTimeout(ctx context.Context, startURL, region string, maxWait time.Duration) (*KiroTokenData, error) {
+	token, err := c.loginWithIDC(ctx, startURL, region, maxWait)
+	finishLogin(c.events(), token, err)
+	return token, err
+}
+
+func (c *SSOOIDCClient) loginWithIDC(ctx context.Context, startURL, region string, maxWait time.Duration) (*KiroTokenData, error) {
+	if err := validateStartURL(startURL); err != nil {
+		return nil, err
+	}
+	out := LoginOutput(c.cfg)
+	fmt.Fprintln(out, "\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(out, "║       Kiro Authentication (AWS Identity Center)          ║")
+	fmt.Fprintln(out, "╚══════════════════════════════════════════════════════════╝")
 
 	// Step 1: Register client with the specified region
-	fmt.Println("\nRegistering client...")
-	regResp, err := c.RegisterClientWithRegion(ctx, region)
+	fmt.Fprintln(out, "\nRegistering client...")
+	regResp, err := c.GetOrRegisterClient(ctx, region, startURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register client: %w", err)
 	}
 	log.Debugf("Client registered: %s", regResp.ClientID)
+	c.events().OnClientRegistered(regResp.ClientID)
 
 	// Step 2: Start device authorization with IDC start URL
-	fmt.Println("Starting device authorization...")
+	fmt.Fprintln(out, "Starting device authorization...")
 	authResp, err := c.StartDeviceAuthorizationWithIDC(ctx, regResp.ClientID, regResp.ClientSecret, startURL, region)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start device auth: %w", err)
 	}
 
 	// Step 3: Show user the verification URL
-	fmt.Printf("\n")
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("  Confirm the following code in the browser:\n")
-	fmt.Printf("  Code: %s\n", authResp.UserCode)
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("\n  Open this URL: %s\n\n", authResp.VerificationURIComplete)
+	c.events().OnDeviceCodeIssued(authResp)
+	c.copyUserCode(authResp.UserCode)
 
 	// Set incognito mode based on config
 	if c.cfg != nil {
@@ -425,42 +743,63 @@ func (c *SSOOIDCClient) LoginWithIDC(ctx context.Context, startURL, region strin
 	// Open browser
 	if err := browser.OpenURL(authResp.VerificationURIComplete); err != nil {
 		log.Warnf("Could not open browser automatically: %v", err)
-		fmt.Println("  Please open the URL manually in your browser.")
+		fmt.Fprintln(out, "  Please open the URL manually in your browser.")
 	} else {
-		fmt.Println("  (Browser opened automatically)")
+		fmt.Fprintln(out, "  (Browser opened automatically)")
 	}
 
 	// Step 4: Poll for token
-	fmt.Println("Waiting for authorization...")
+	fmt.Fprintln(out, "Waiting for authorization...")
 
-	interval := pollInterval
-	if authResp.Interval > 0 {
-		interval = time.Duration(authResp.Interval) * time.Second
-	}
+	interval := newDevicePollInterval(authResp.Interval)
 
-	deadline := time.Now().Add(time.Duration(authResp.ExpiresIn) * time.Second)
+	wait := time.Duration(authResp.ExpiresIn) * time.Second
+	if maxAge := loginSessionMaxAge(c.cfg); wait > maxAge {
+		wait = maxAge
+	}
+	if maxWait > 0 && wait > maxWait {
+		wait = maxWait
+	}
+	deadline := time.Now().Add(wait)
+	expired := time.NewTimer(wait)
+	defer expired.Stop()
+	var abandonAt time.Time
+	if inactivity := c.deviceCodeInactivityTimeout(); inactivity > 0 {
+		abandonAt = time.Now().Add(inactivity)
+	}
 
+poll:
 	for time.Now().Before(deadline) {
+		if !abandonAt.IsZero() && time.Now().After(abandonAt) {
+			browser.CloseBrowser()
+			return nil, ErrLoginAbandoned
+		}
 		select {
 		case <-ctx.Done():
 			browser.CloseBrowser()
+			fmt.Fprintln(out, "\n\n✗ Login cancelled")
 			return nil, ctx.Err()
-		case <-time.After(interval):
+		case <-expired.C:
+			break poll
+		case <-time.After(interval.next()):
 			tokenResp, err := c.CreateTokenWithRegion(ctx, regResp.ClientID, regResp.ClientSecret, authResp.DeviceCode, region)
 			if err != nil {
 				if errors.Is(err, ErrAuthorizationPending) {
-					fmt.Print(".")
+					interval.pending()
+					c.events().OnPollTick(err)
 					continue
 				}
 				if errors.Is(err, ErrSlowDown) {
-					interval += 5 * time.Second
-					continue
+					c.events().OnPollTick(err)
+					if err = interval.slowDown(); err == nil {
+						continue
+					}
 				}
 				browser.CloseBrowser()
 				return nil, fmt.Errorf("token creation failed: %w", err)
 			}
 
-			fmt.Println("\n\n✓ Authorization successful!")
+			fmt.Fprintln(out, "\n\n✓ Authorization successful!")
 
 			// Close the browser window
 			if err := browser.CloseBrowser(); err != nil {
@@ -468,30 +807,31 @@ func (c *SSOOIDCClient) LoginWithIDC(ctx context.Context, startURL, region strin
 			}
 
 			// Step 5: Get profile ARN from CodeWhisperer API
-			fmt.Println("Fetching profile information...")
-			profileArn := c.fetchProfileArn(ctx, tokenResp.AccessToken)
+			fmt.Fprintln(out, "Fetching profile information...")
+			profileArn := c.selectProfileArn(ctx, tokenResp.AccessToken, region)
 
 			// Fetch user email
 			email := FetchUserEmailWithFallback(ctx, c.cfg, tokenResp.AccessToken)
 			if email != "" {
-				fmt.Printf("  Logged in as: %s\n", email)
+				fmt.Fprintf(out, "  Logged in as: %s\n", email)
 			}
 
 			expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
 
-			return &KiroTokenData{
-				AccessToken:  tokenResp.AccessToken,
-				RefreshToken: tokenResp.RefreshToken,
-				ProfileArn:   profileArn,
-				ExpiresAt:    expiresAt.Format(time.RFC3339),
-				AuthMethod:   "idc",
-				Provider:     "AWS",
-				ClientID:     regResp.ClientID,
-				ClientSecret: regResp.ClientSecret,
-				Email:        email,
-				StartURL:     startURL,
-				Region:       region,
-			}, nil
+			return StampLoginProvenance(&KiroTokenData{
+				AccessToken:    tokenResp.AccessToken,
+				RefreshToken:   tokenResp.RefreshToken,
+				ProfileArn:     profileArn,
+				ExpiresAt:      expiresAt.Format(time.RFC3339),
+				AuthMethod:     "idc",
+				Provider:       "AWS",
+				ClientID:       regResp.ClientID,
+				ClientSecret:   regResp.ClientSecret,
+				Email:          email,
+				StartURL:       startURL,
+				Region:         region,
+				NonRefreshable: tokenResp.RefreshToken == "",
+			}, LoginMethodDeviceCode), nil
 		}
 	}
 
@@ -499,21 +839,72 @@ func (c *SSOOIDCClient) LoginWithIDC(ctx context.Context, startURL, region strin
 	if err := browser.CloseBrowser(); err != nil {
 		log.Debugf("Failed to close browser on timeout: %v", err)
 	}
-	return nil, fmt.Errorf("authorization timed out")
+	fmt.Fprintf(out, "\n\n✗ Authorization timed out after %s\n", wait)
+	return nil, fmt.Errorf("authorization timed out after %s", wait)
+}
+
+// startURLExample is shown in start URL validation errors.
+const startURLExample = "https://d-1234567890.awsapps.com/start"
+
+// validateStartURL checks that startURL looks like an IAM Identity Center access portal URL
+// (https://<alias or d-directory>.awsapps.com/start, its .awsapps.cn and GovCloud /directory/
+// variants, or a *.app.aws portal) so typos fail before any request is sent.
+func validateStartURL(startURL string) error {
+	parsed, err := url.Parse(strings.TrimSpace(startURL))
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid start URL %q: expected a URL like %s", startURL, startURLExample)
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("invalid start URL %q: must use https, e.g. %s", startURL, startURLExample)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	switch {
+	case strings.HasSuffix(host, ".app.aws"):
+		return nil
+	case strings.HasSuffix(host, ".awsapps.com"), strings.HasSuffix(host, ".awsapps.cn"):
+		path := strings.TrimSuffix(parsed.Path, "/")
+		if path == "/start" || strings.HasPrefix(path, "/directory/") {
+			return nil
+		}
+		return fmt.Errorf("invalid start URL %q: path should be /start, e.g. %s", startURL, startURLExample)
+	}
+	return fmt.Errorf("invalid start URL %q: host should end in .awsapps.com, e.g. %s", startURL, startURLExample)
+}
+
+// idcLoginFromEnv returns the IDC start URL and region from KIRO_IDC_START_URL and
+// KIRO_IDC_REGION. ok is true only when both are set.
+func idcLoginFromEnv() (startURL, region string, ok bool) {
+	startURL = strings.TrimSpace(os.Getenv(envIDCStartURL))
+	region = strings.TrimSpace(os.Getenv(envIDCRegion))
+	return startURL, region, startURL != "" && region != ""
 }
 
 // LoginWithMethodSelection prompts the user to select between Builder ID and IDC, then performs the login.
+// When KIRO_IDC_START_URL and KIRO_IDC_REGION are both set, the prompts are skipped and the IDC
+// flow runs directly; without them a non-interactive stdin is an error rather than a hang.
 func (c *SSOOIDCClient) LoginWithMethodSelection(ctx context.Context) (*KiroTokenData, error) {
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║              Kiro Authentication (AWS)                    ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	out := LoginOutput(c.cfg)
+	if startURL, region, ok := idcLoginFromEnv(); ok {
+		if err := validateStartURL(startURL); err != nil {
+			return nil, fmt.Errorf("%s: %w", envIDCStartURL, err)
+		}
+		log.Infof("Using IDC login from %s and %s", envIDCStartURL, envIDCRegion)
+		return c.LoginWithIDC(ctx, startURL, region)
+	}
+	if !isInteractiveTerminal() {
+		return nil, fmt.Errorf("stdin is not a terminal: set %s and %s for non-interactive IDC login", envIDCStartURL, envIDCRegion)
+	}
+
+	fmt.Fprintln(out, "\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(out, "║              Kiro Authentication (AWS)                    ║")
+	fmt.Fprintln(out, "╚══════════════════════════════════════════════════════════╝")
 
 	// Prompt for login method
 	options := []string{
 		"Use with Builder ID (personal AWS account)",
 		"Use with IDC Account (organization SSO)",
 	}
-	selection := promptSelect("\n? Select login method:", options)
+	selection := promptSelect(out, "\n? Select login method:", options)
 
 	if selection == 0 {
 		// Builder ID flow - use existing implementation
@@ -521,60 +912,28 @@ func (c *SSOOIDCClient) LoginWithMethodSelection(ctx context.Context) (*KiroToke
 	}
 
 	// IDC flow - prompt for start URL and region
-	fmt.Println()
-	startURL := promptInput("? Enter Start URL", "")
+	// A partially set environment pre-fills the prompts.
+	envStartURL, envRegion, _ := idcLoginFromEnv()
+	if envRegion == "" {
+		envRegion = defaultIDCRegion
+	}
+	fmt.Fprintln(out)
+	startURL := promptInput(out, "? Enter Start URL", envStartURL)
 	if startURL == "" {
 		return nil, fmt.Errorf("start URL is required for IDC login")
 	}
+	if err := validateStartURL(startURL); err != nil {
+		return nil, err
+	}
 
-	region := promptInput("? Enter Region", defaultIDCRegion)
+	region := promptInput(out, "? Enter Region", envRegion)
 
 	return c.LoginWithIDC(ctx, startURL, region)
 }
 
 // RegisterClient registers a new OIDC client with AWS.
 func (c *SSOOIDCClient) RegisterClient(ctx context.Context) (*RegisterClientResponse, error) {
-	payload := map[string]interface{}{
-		"clientName": "Kiro IDE",
-		"clientType": "public",
-		"scopes":     []string{"codewhisperer:completions", "codewhisperer:analysis", "codewhisperer:conversations", "codewhisperer:transformations", "codewhisperer:taskassist"},
-		"grantTypes": []string{"urn:ietf:params:oauth:grant-type:device_code", "refresh_token"},
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/client/register", strings.NewReader(string(body)))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("register client failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("register client failed (status %d)", resp.StatusCode)
-	}
-
-	var result RegisterClientResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, err
-	}
-
-	return &result, nil
+	return c.registerDeviceClient(ctx, c.baseEndpoint(), "")
 }
 
 // StartDeviceAuthorization starts the device authorization flow.
@@ -590,28 +949,10 @@ func (c *SSOOIDCClient) StartDeviceAuthorization(ctx context.Context, clientID,
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/device_authorization", strings.NewReader(string(body)))
+	respBody, err := c.postOIDCWithRetry(ctx, "start device auth", c.baseEndpoint()+"/device_authorization", body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("start device auth failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("start device auth failed (status %d)", resp.StatusCode)
-	}
 
 	var result StartDeviceAuthResponse
 	if err := json.Unmarshal(respBody, &result); err != nil {
@@ -623,6 +964,9 @@ func (c *SSOOIDCClient) StartDeviceAuthorization(ctx context.Context, clientID,
 
 // CreateToken polls for the access token after user authorization.
 func (c *SSOOIDCClient) CreateToken(ctx context.Context, clientID, clientSecret, deviceCode string) (*CreateTokenResponse, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
 	payload := map[string]string{
 		"clientId":     clientID,
 		"clientSecret": clientSecret,
@@ -635,12 +979,12 @@ func (c *SSOOIDCClient) CreateToken(ctx context.Context, clientID, clientSecret,
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/token", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseEndpoint()+"/token", strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
+	req.Header.Set("User-Agent", c.userAgent())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -653,26 +997,17 @@ func (c *SSOOIDCClient) CreateToken(ctx context.Context, clientID, clientSecret,
 		return nil, err
 	}
 
-	// Check for pending authorization
-	if resp.StatusCode == http.StatusBadRequest {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if json.Unmarshal(respBody, &errResp) == nil {
-			if errResp.Error == "authorization_pending" {
-				return nil, ErrAuthorizationPending
-			}
-			if errResp.Error == "slow_down" {
-				return nil, ErrSlowDown
-			}
-		}
-		log.Debugf("create token failed: %s", string(respBody))
-		return nil, fmt.Errorf("create token failed")
-	}
-
 	if resp.StatusCode != http.StatusOK {
+		oidcErr := parseOIDCError(resp.StatusCode, respBody)
+		// Check for pending authorization
+		switch oidcErr.Code {
+		case "authorization_pending":
+			return nil, ErrAuthorizationPending
+		case "slow_down":
+			return nil, ErrSlowDown
+		}
 		log.Debugf("create token failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("create token failed (status %d)", resp.StatusCode)
+		return nil, oidcErr
 	}
 
 	var result CreateTokenResponse
@@ -680,12 +1015,23 @@ func (c *SSOOIDCClient) CreateToken(ctx context.Context, clientID, clientSecret,
 		return nil, err
 	}
 
+	warnMissingRefreshToken("device code", &result)
 	return &result, nil
 }
 
 // RefreshToken refreshes an access token using the refresh token.
 // Includes retry logic and improved error handling for better reliability.
+// Concurrent refreshes of the same refresh token share one request (see sharedRefresh).
 func (c *SSOOIDCClient) RefreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*KiroTokenData, error) {
+	return sharedRefresh(ctx, refreshToken, func(ctx context.Context) (*KiroTokenData, error) {
+		return c.refreshToken(ctx, clientID, clientSecret, refreshToken)
+	})
+}
+
+func (c *SSOOIDCClient) refreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*KiroTokenData, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
 	payload := map[string]string{
 		"clientId":     clientID,
 		"clientSecret": clientSecret,
@@ -698,7 +1044,7 @@ func (c *SSOOIDCClient) RefreshToken(ctx context.Context, clientID, clientSecret
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/token", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseEndpoint()+"/token", strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
@@ -706,7 +1052,7 @@ func (c *SSOOIDCClient) RefreshToken(ctx context.Context, clientID, clientSecret
 	// Set headers matching Kiro IDE behavior for better compatibility
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Host", "oidc.us-east-1.amazonaws.com")
-	req.Header.Set("x-amz-user-agent", idcAmzUserAgent)
+	req.Header.Set("x-amz-user-agent", c.amzUserAgent())
 	req.Header.Set("User-Agent", "node")
 	req.Header.Set("Accept", "*/*")
 
@@ -733,7 +1079,7 @@ func (c *SSOOIDCClient) RefreshToken(ctx context.Context, clientID, clientSecret
 
 	expiresAt := time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
 
-	return &KiroTokenData{
+	return finishRefresh("token refresh", &KiroTokenData{
 		AccessToken:  result.AccessToken,
 		RefreshToken: result.RefreshToken,
 		ExpiresAt:    expiresAt.Format(time.RFC3339),
@@ -742,38 +1088,41 @@ func (c *SSOOIDCClient) RefreshToken(ctx context.Context, clientID, clientSecret
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		Region:       defaultIDCRegion,
-	}, nil
+	}, refreshToken), nil
 }
 
 // LoginWithBuilderID performs the full device code flow for AWS Builder ID.
 func (c *SSOOIDCClient) LoginWithBuilderID(ctx context.Context) (*KiroTokenData, error) {
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║         Kiro Authentication (AWS Builder ID)              ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	token, err := c.loginWithBuilderID(ctx)
+	finishLogin(c.events(), token, err)
+	return token, err
+}
+
+func (c *SSOOIDCClient) loginWithBuilderID(ctx context.Context) (*KiroTokenData, error) {
+	out := LoginOutput(c.cfg)
+	fmt.Fprintln(out, "\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(out, "║         Kiro Authentication (AWS Builder ID)              ║")
+	fmt.Fprintln(out, "╚══════════════════════════════════════════════════════════╝")
 
 	// Step 1: Register client
-	fmt.Println("\nRegistering client...")
-	regResp, err := c.RegisterClient(ctx)
+	fmt.Fprintln(out, "\nRegistering client...")
+	regResp, err := c.GetOrRegisterClient(ctx, defaultIDCRegion, builderIDStartURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register client: %w", err)
 	}
 	log.Debugf("Client registered: %s", regResp.ClientID)
+	c.events().OnClientRegistered(regResp.ClientID)
 
 	// Step 2: Start device authorization
-	fmt.Println("Starting device authorization...")
+	fmt.Fprintln(out, "Starting device authorization...")
 	authResp, err := c.StartDeviceAuthorization(ctx, regResp.ClientID, regResp.ClientSecret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start device auth: %w", err)
 	}
 
 	// Step 3: Show user the verification URL
-	fmt.Printf("\n")
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("  Open this URL in your browser:\n")
-	fmt.Printf("  %s\n", authResp.VerificationURIComplete)
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("\n  Or go to: %s\n", authResp.VerificationURI)
-	fmt.Printf("  And enter code: %s\n\n", authResp.UserCode)
+	c.events().OnDeviceCodeIssued(authResp)
+	c.copyUserCode(authResp.UserCode)
 
 	// Set incognito mode based on config (defaults to true for Kiro, can be overridden with --no-incognito)
 	// Incognito mode enables multi-account support by bypassing cached sessions
@@ -792,43 +1141,55 @@ func (c *SSOOIDCClient) LoginWithBuilderID(ctx context.Context) (*KiroTokenData,
 	// Open browser using cross-platform browser package
 	if err := browser.OpenURL(authResp.VerificationURIComplete); err != nil {
 		log.Warnf("Could not open browser automatically: %v", err)
-		fmt.Println("  Please open the URL manually in your browser.")
+		fmt.Fprintln(out, "  Please open the URL manually in your browser.")
 	} else {
-		fmt.Println("  (Browser opened automatically)")
+		fmt.Fprintln(out, "  (Browser opened automatically)")
 	}
 
 	// Step 4: Poll for token
-	fmt.Println("Waiting for authorization...")
+	fmt.Fprintln(out, "Waiting for authorization...")
 
-	interval := pollInterval
-	if authResp.Interval > 0 {
-		interval = time.Duration(authResp.Interval) * time.Second
-	}
+	interval := newDevicePollInterval(authResp.Interval)
 
 	deadline := time.Now().Add(time.Duration(authResp.ExpiresIn) * time.Second)
+	if maxAge := loginSessionMaxAge(c.cfg); time.Until(deadline) > maxAge {
+		deadline = time.Now().Add(maxAge)
+	}
+	var abandonAt time.Time
+	if inactivity := c.deviceCodeInactivityTimeout(); inactivity > 0 {
+		abandonAt = time.Now().Add(inactivity)
+	}
 
 	for time.Now().Before(deadline) {
+		if !abandonAt.IsZero() && time.Now().After(abandonAt) {
+			browser.CloseBrowser() // Cleanup when the user walked away
+			return nil, ErrLoginAbandoned
+		}
 		select {
 		case <-ctx.Done():
 			browser.CloseBrowser() // Cleanup on cancel
+			fmt.Fprintln(out, "\n\n✗ Login cancelled")
 			return nil, ctx.Err()
-		case <-time.After(interval):
+		case <-time.After(interval.next()):
 			tokenResp, err := c.CreateToken(ctx, regResp.ClientID, regResp.ClientSecret, authResp.DeviceCode)
 			if err != nil {
 				if errors.Is(err, ErrAuthorizationPending) {
-					fmt.Print(".")
+					interval.pending()
+					c.events().OnPollTick(err)
 					continue
 				}
 				if errors.Is(err, ErrSlowDown) {
-					interval += 5 * time.Second
-					continue
+					c.events().OnPollTick(err)
+					if err = interval.slowDown(); err == nil {
+						continue
+					}
 				}
 				// Close browser on error before returning
 				browser.CloseBrowser()
 				return nil, fmt.Errorf("token creation failed: %w", err)
 			}
 
-			fmt.Println("\n\n✓ Authorization successful!")
+			fmt.Fprintln(out, "\n\n✓ Authorization successful!")
 
 			// Close the browser window
 			if err := browser.CloseBrowser(); err != nil {
@@ -836,29 +1197,30 @@ func (c *SSOOIDCClient) LoginWithBuilderID(ctx context.Context) (*KiroTokenData,
 			}
 
 			// Step 5: Get profile ARN from CodeWhisperer API
-			fmt.Println("Fetching profile information...")
-			profileArn := c.fetchProfileArn(ctx, tokenResp.AccessToken)
+			fmt.Fprintln(out, "Fetching profile information...")
+			profileArn := c.selectProfileArn(ctx, tokenResp.AccessToken, defaultIDCRegion)
 
 			// Fetch user email (tries CodeWhisperer API first, then userinfo endpoint, then JWT parsing)
 			email := FetchUserEmailWithFallback(ctx, c.cfg, tokenResp.AccessToken)
 			if email != "" {
-				fmt.Printf("  Logged in as: %s\n", email)
+				fmt.Fprintf(out, "  Logged in as: %s\n", email)
 			}
 
 			expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
 
-			return &KiroTokenData{
-				AccessToken:  tokenResp.AccessToken,
-				RefreshToken: tokenResp.RefreshToken,
-				ProfileArn:   profileArn,
-				ExpiresAt:    expiresAt.Format(time.RFC3339),
-				AuthMethod:   "builder-id",
-				Provider:     "AWS",
-				ClientID:     regResp.ClientID,
-				ClientSecret: regResp.ClientSecret,
-				Email:        email,
-				Region:       defaultIDCRegion,
-			}, nil
+			return StampLoginProvenance(&KiroTokenData{
+				AccessToken:    tokenResp.AccessToken,
+				RefreshToken:   tokenResp.RefreshToken,
+				ProfileArn:     profileArn,
+				ExpiresAt:      expiresAt.Format(time.RFC3339),
+				AuthMethod:     "builder-id",
+				Provider:       "AWS",
+				ClientID:       regResp.ClientID,
+				ClientSecret:   regResp.ClientSecret,
+				Email:          email,
+				Region:         defaultIDCRegion,
+				NonRefreshable: tokenResp.RefreshToken == "",
+			}, LoginMethodDeviceCode), nil
 			}
 			}
 
@@ -882,11 +1244,55 @@ func (c *SSOOIDCClient) FetchUserEmail(ctx context.Context, accessToken string)
 	return ExtractEmailFromJWT(accessToken)
 }
 
+// userInfoRetries returns how many times a rate-limited userinfo call is retried.
+func (c *SSOOIDCClient) userInfoRetries() int {
+	if c.cfg == nil || c.cfg.KiroAuth.UserInfoRetries == 0 {
+		return defaultUserInfoRetries
+	}
+	if c.cfg.KiroAuth.UserInfoRetries < 0 {
+		return 0
+	}
+	return c.cfg.KiroAuth.UserInfoRetries
+}
+
 // tryUserInfoEndpoint attempts to get user info from AWS SSO OIDC userinfo endpoint.
+// A 429 response is retried with backoff (honoring Retry-After) before giving up.
 func (c *SSOOIDCClient) tryUserInfoEndpoint(ctx context.Context, accessToken string) string {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ssoOIDCEndpoint+"/userinfo", nil)
+	retries := c.userInfoRetries()
+	backoff := userInfoBaseBackoff
+	for attempt := 0; ; attempt++ {
+		email, retryAfter, throttled := c.requestUserInfo(ctx, accessToken)
+		if !throttled {
+			return email
+		}
+		if attempt >= retries {
+			log.Debugf("userinfo endpoint still rate-limited after %d retries, falling back", retries)
+			return ""
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > userInfoMaxBackoff {
+			wait = userInfoMaxBackoff
+		}
+		log.Debugf("userinfo endpoint rate-limited, retrying in %v (attempt %d/%d)", wait, attempt+1, retries)
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// requestUserInfo performs a single userinfo call. throttled reports a 429 response,
+// with retryAfter set from the Retry-After header when present.
+func (c *SSOOIDCClient) requestUserInfo(ctx context.Context, accessToken string) (email string, retryAfter time.Duration, throttled bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseEndpoint()+"/userinfo", nil)
 	if err != nil {
-		return ""
+		return "", 0, false
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("Accept", "application/json")
@@ -894,19 +1300,23 @@ func (c *SSOOIDCClient) tryUserInfoEndpoint(ctx context.Context, accessToken str
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		log.Debugf("userinfo request failed: %v", err)
-		return ""
+		return "", 0, false
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", parseRetryAfter(resp.Header.Get("Retry-After")), true
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
 		log.Debugf("userinfo endpoint returned status %d: %s", resp.StatusCode, string(respBody))
-		return ""
+		return "", 0, false
 	}
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return ""
+		return "", 0, false
 	}
 
 	log.Debugf("userinfo response: %s", string(respBody))
@@ -919,151 +1329,241 @@ func (c *SSOOIDCClient) tryUserInfoEndpoint(ctx context.Context, accessToken str
 	}
 
 	if err := json.Unmarshal(respBody, &userInfo); err != nil {
-		return ""
+		return "", 0, false
 	}
 
 	if userInfo.Email != "" {
-		return userInfo.Email
+		return userInfo.Email, 0, false
 	}
 	if userInfo.PreferredUsername != "" && strings.Contains(userInfo.PreferredUsername, "@") {
-		return userInfo.PreferredUsername
+		return userInfo.PreferredUsername, 0, false
 	}
-	return ""
+	return "", 0, false
 }
 
-// fetchProfileArn retrieves the profile ARN from CodeWhisperer API.
-// This is needed for file naming since AWS SSO OIDC doesn't return profile info.
-func (c *SSOOIDCClient) fetchProfileArn(ctx context.Context, accessToken string) string {
-	// Try ListProfiles API first
-	profileArn := c.tryListProfiles(ctx, accessToken)
-	if profileArn != "" {
-		return profileArn
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
 	}
-
-	// Fallback: Try ListAvailableCustomizations
-	return c.tryListCustomizations(ctx, accessToken)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
 }
 
-func (c *SSOOIDCClient) tryListProfiles(ctx context.Context, accessToken string) string {
-	payload := map[string]interface{}{
-		"origin": "AI_EDITOR",
-	}
+// Profile lookup errors returned by FetchProfileArn and FetchProfileArns.
+var (
+	ErrNoProfiles          = errors.New("no CodeWhisperer profile available for this token")
+	ErrProfileAccessDenied = errors.New("CodeWhisperer rejected the access token")
+)
 
-	body, err := json.Marshal(payload)
+// FetchProfileArn looks up the profile ARN for accessToken and returns the first one found.
+// Unlike the login flows, it reports why the lookup failed: ErrNoProfiles, ErrProfileAccessDenied
+// or a status or network error.
+func (c *SSOOIDCClient) FetchProfileArn(ctx context.Context, accessToken string) (string, error) {
+	arns, err := c.FetchProfileArns(ctx, accessToken)
 	if err != nil {
-		return ""
+		return "", err
 	}
+	return arns[0], nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://codewhisperer.us-east-1.amazonaws.com", strings.NewReader(string(body)))
+// FetchProfileArns returns every profile ARN available to accessToken so a caller can choose.
+// The result is never empty when err is nil.
+func (c *SSOOIDCClient) FetchProfileArns(ctx context.Context, accessToken string) ([]string, error) {
+	profiles, err := c.fetchProfiles(ctx, accessToken, defaultIDCRegion)
 	if err != nil {
-		return ""
+		return nil, err
 	}
+	arns := make([]string, len(profiles))
+	for i, profile := range profiles {
+		arns[i] = profile.Arn
+	}
+	return arns, nil
+}
 
-	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
-	req.Header.Set("x-amz-target", "AmazonCodeWhispererService.ListProfiles")
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+// fetchProfileArn retrieves the profile ARN from CodeWhisperer API.
+// This is needed for file naming since AWS SSO OIDC doesn't return profile info.
+// region selects the partition's CodeWhisperer endpoint (see getCodeWhispererEndpoint).
+// Failures are logged and yield "".
+func (c *SSOOIDCClient) fetchProfileArn(ctx context.Context, accessToken, region string) string {
+	profiles, err := c.fetchProfiles(ctx, accessToken, region)
 	if err != nil {
+		log.Debugf("profile ARN lookup failed: %v", err)
 		return ""
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
+	return profiles[0].Arn
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("ListProfiles failed (status %d): %s", resp.StatusCode, string(respBody))
+// selectProfileArn is fetchProfileArn for interactive logins. KIRO_PROFILE_ARN, when set, picks
+// the profile; otherwise the user chooses when several are available and stdin is a terminal.
+// Non-interactive logins keep the first match.
+func (c *SSOOIDCClient) selectProfileArn(ctx context.Context, accessToken, region string) string {
+	out := LoginOutput(c.cfg)
+	profiles, err := c.fetchProfiles(ctx, accessToken, region)
+	if envArn := strings.TrimSpace(os.Getenv(envProfileArn)); envArn != "" {
+		if err == nil && !hasProfile(profiles, envArn) {
+			log.Warnf("%s is not among the profiles available to this account, using it anyway", envProfileArn)
+		}
+		return envArn
+	}
+	if err != nil {
+		log.Debugf("profile ARN lookup failed: %v", err)
 		return ""
 	}
-
-	log.Debugf("ListProfiles response: %s", string(respBody))
-
-	var result struct {
-		Profiles []struct {
-			Arn string `json:"arn"`
-		} `json:"profiles"`
-		ProfileArn string `json:"profileArn"`
+	if len(profiles) == 1 || !isInteractiveTerminal() {
+		return profiles[0].Arn
 	}
 
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return ""
+	options := make([]string, len(profiles))
+	for i, profile := range profiles {
+		options[i] = profile.Arn
+		if profile.Name != "" {
+			options[i] = fmt.Sprintf("%s (%s)", profile.Name, profile.Arn)
+		}
 	}
+	fmt.Fprintln(out)
+	return profiles[promptSelect(out, "? Select CodeWhisperer profile:", options)].Arn
+}
 
-	if result.ProfileArn != "" {
-		return result.ProfileArn
+func hasProfile(profiles []codeWhispererProfile, arn string) bool {
+	for _, profile := range profiles {
+		if profile.Arn == arn {
+			return true
+		}
 	}
+	return false
+}
+
+// codeWhispererProfile is a profile returned by ListProfiles or ListAvailableCustomizations.
+type codeWhispererProfile struct {
+	Arn  string
+	Name string
+}
 
-	if len(result.Profiles) > 0 {
-		return result.Profiles[0].Arn
+func (c *SSOOIDCClient) fetchProfiles(ctx context.Context, accessToken, region string) ([]codeWhispererProfile, error) {
+	// Try ListProfiles API first
+	profiles, errProfiles := c.listProfiles(ctx, accessToken, region, "ListProfiles", "profiles")
+	if len(profiles) > 0 {
+		return profiles, nil
 	}
 
-	return ""
+	// Fallback: Try ListAvailableCustomizations
+	profiles, errCustomizations := c.listProfiles(ctx, accessToken, region, "ListAvailableCustomizations", "customizations")
+	if len(profiles) > 0 {
+		return profiles, nil
+	}
+	if err := errors.Join(errProfiles, errCustomizations); err != nil {
+		return nil, err
+	}
+	return nil, ErrNoProfiles
 }
 
-func (c *SSOOIDCClient) tryListCustomizations(ctx context.Context, accessToken string) string {
+// listProfiles calls a CodeWhisperer list operation and collects the top-level profileArn
+// followed by each entry in listField.
+func (c *SSOOIDCClient) listProfiles(ctx context.Context, accessToken, region, operation, listField string) ([]codeWhispererProfile, error) {
 	payload := map[string]interface{}{
 		"origin": "AI_EDITOR",
 	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://codewhisperer.us-east-1.amazonaws.com", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getCodeWhispererEndpoint(region), strings.NewReader(string(body)))
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
-	req.Header.Set("x-amz-target", "AmazonCodeWhispererService.ListAvailableCustomizations")
+	req.Header.Set("x-amz-target", "AmazonCodeWhispererService."+operation)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return ""
+		return nil, fmt.Errorf("%s: %w", operation, err)
 	}
 	defer resp.Body.Close()
 
 	respBody, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		log.Debugf("ListAvailableCustomizations failed (status %d): %s", resp.StatusCode, string(respBody))
-		return ""
+		log.Debugf("%s failed (status %d): %s", operation, resp.StatusCode, string(respBody))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%s: %w (status %d)", operation, ErrProfileAccessDenied, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s failed (status %d)", operation, resp.StatusCode)
 	}
 
-	log.Debugf("ListAvailableCustomizations response: %s", string(respBody))
-
-	var result struct {
-		Customizations []struct {
-			Arn string `json:"arn"`
-		} `json:"customizations"`
-		ProfileArn string `json:"profileArn"`
-	}
+	log.Debugf("%s response: %s", operation, string(respBody))
 
+	var result map[string]json.RawMessage
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return ""
+		return nil, fmt.Errorf("%s: decode response: %w", operation, err)
 	}
 
-	if result.ProfileArn != "" {
-		return result.ProfileArn
+	var entries []struct {
+		Arn         string `json:"arn"`
+		ProfileName string `json:"profileName"`
+		Name        string `json:"name"`
 	}
+	_ = json.Unmarshal(result[listField], &entries)
+	var profileArn string
+	_ = json.Unmarshal(result["profileArn"], &profileArn)
 
-	if len(result.Customizations) > 0 {
-		return result.Customizations[0].Arn
+	var profiles []codeWhispererProfile
+	seen := make(map[string]int)
+	add := func(arn, name string) {
+		if arn == "" {
+			return
+		}
+		if i, ok := seen[arn]; ok {
+			if profiles[i].Name == "" {
+				profiles[i].Name = name
+			}
+			return
+		}
+		seen[arn] = len(profiles)
+		profiles = append(profiles, codeWhispererProfile{Arn: arn, Name: name})
+	}
+	add(profileArn, "")
+	for _, entry := range entries {
+		name := entry.ProfileName
+		if name == "" {
+			name = entry.Name
+		}
+		add(entry.Arn, name)
 	}
-
-	return ""
+	return profiles, nil
 }
 
 // RegisterClientForAuthCode registers a new OIDC client for authorization code flow.
 func (c *SSOOIDCClient) RegisterClientForAuthCode(ctx context.Context, redirectURI string) (*RegisterClientResponse, error) {
+	endpoint := c.baseEndpoint()
+	scopes, err := c.oidcScopes()
+	if err != nil {
+		return nil, err
+	}
+	if cached := c.reusableClient(endpoint, OIDCGrantAuthCode, redirectURI, "", scopes); cached != nil {
+		return cached, nil
+	}
+
 	payload := map[string]interface{}{
-		"clientName":   "Kiro IDE",
+		"clientName":   c.clientName(),
 		"clientType":   "public",
-		"scopes":       []string{"codewhisperer:completions", "codewhisperer:analysis", "codewhisperer:conversations", "codewhisperer:transformations", "codewhisperer:taskassist"},
+		"scopes":       scopes,
 		"grantTypes":   []string{"authorization_code", "refresh_token"},
 		"redirectUris": []string{redirectURI},
 		"issuerUrl":    builderIDStartURL,
@@ -1074,34 +1574,17 @@ func (c *SSOOIDCClient) RegisterClientForAuthCode(ctx context.Context, redirectU
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/client/register", strings.NewReader(string(body)))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.postOIDCWithRetry(ctx, "register client for auth code", endpoint+"/client/register", body)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Debugf("register client for auth code failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("register client failed (status %d)", resp.StatusCode)
-	}
 
 	var result RegisterClientResponse
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
 	}
 
+	c.rememberClient(&result, endpoint, OIDCGrantAuthCode, redirectURI, "", scopes)
 	return &result, nil
 }
 
@@ -1112,21 +1595,66 @@ type AuthCodeCallbackResult struct {
 	Error string
 }
 
-// startAuthCodeCallbackServer starts a local HTTP server to receive the authorization code callback.
-func (c *SSOOIDCClient) startAuthCodeCallbackServer(ctx context.Context, expectedState string) (string, <-chan AuthCodeCallbackResult, error) {
-	// Try to find an available port
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", authCodeCallbackPort))
+// authCodeCallbackPorts returns the configured callback ports to try in order, or nil when
+// no port is pinned.
+func (c *SSOOIDCClient) authCodeCallbackPorts() ([]int, error) {
+	if c.cfg == nil || (c.cfg.KiroAuth.AuthCodeCallbackPort == 0 && c.cfg.KiroAuth.AuthCodeCallbackPortMax == 0) {
+		return nil, nil
+	}
+	first, last := c.cfg.KiroAuth.AuthCodeCallbackPort, c.cfg.KiroAuth.AuthCodeCallbackPortMax
+	if last == 0 {
+		last = first
+	}
+	if first < 1 || last > 65535 || last < first {
+		return nil, fmt.Errorf("invalid auth-code callback port range %d-%d", first, last)
+	}
+	ports := make([]int, 0, last-first+1)
+	for port := first; port <= last; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// listenAuthCodeCallback opens the callback listener. Pinned ports never fall back to a
+// dynamic port, since the redirect URI registered with the identity provider would not match.
+func (c *SSOOIDCClient) listenAuthCodeCallback() (net.Listener, error) {
+	ports, err := c.authCodeCallbackPorts()
 	if err != nil {
+		return nil, err
+	}
+	host := callbackHost(c.cfg, "127.0.0.1")
+	if ports == nil {
+		listener, err := net.Listen("tcp", callbackAddr(host, authCodeCallbackPort))
+		if err == nil {
+			return listener, nil
+		}
 		// Try with dynamic port
 		log.Warnf("sso oidc: default port %d is busy, falling back to dynamic port", authCodeCallbackPort)
-		listener, err = net.Listen("tcp", "127.0.0.1:0")
-		if err != nil {
-			return "", nil, fmt.Errorf("failed to start callback server: %w", err)
+		return net.Listen("tcp", callbackAddr(host, 0))
+	}
+
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", callbackAddr(host, port))
+		if err == nil {
+			return listener, nil
 		}
+		log.Debugf("sso oidc: callback port %d unavailable: %v", port, err)
+	}
+	if len(ports) == 1 {
+		return nil, fmt.Errorf("configured callback port %d is busy", ports[0])
+	}
+	return nil, fmt.Errorf("all configured callback ports %d-%d are busy", ports[0], ports[len(ports)-1])
+}
+
+// startAuthCodeCallbackServer starts a local HTTP server to receive the authorization code callback.
+func (c *SSOOIDCClient) startAuthCodeCallbackServer(ctx context.Context, expectedState string) (string, <-chan AuthCodeCallbackResult, error) {
+	listener, err := c.listenAuthCodeCallback()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start callback server: %w", err)
 	}
 
 	port := listener.Addr().(*net.TCPAddr).Port
-	redirectURI := fmt.Sprintf("http://127.0.0.1:%d%s", port, authCodeCallbackPath)
+	redirectURI := fmt.Sprintf("http://%s%s", callbackAddr(callbackHost(c.cfg, "127.0.0.1"), port), authCodeCallbackPath)
 	resultChan := make(chan AuthCodeCallbackResult, 1)
 
 	server := &http.Server{
@@ -1135,34 +1663,29 @@ func (c *SSOOIDCClient) startAuthCodeCallbackServer(ctx context.Context, expecte
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(authCodeCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		// HEAD is the VerifyCallbackReachable self-check; it must not consume the login result.
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		code := r.URL.Query().Get("code")
 		state := r.URL.Query().Get("state")
 		errParam := r.URL.Query().Get("error")
 
 		// Send response to browser
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if errParam != "" {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `<!DOCTYPE html>
-<html><head><title>Login Failed</title></head>
-<body><h1>Login Failed</h1><p>Error: %s</p><p>You can close this window.</p></body></html>`, html.EscapeString(errParam))
+			writeCallbackFailure(w, c.cfg, "Error: "+errParam)
 			resultChan <- AuthCodeCallbackResult{Error: errParam}
 			return
 		}
 
 		if state != expectedState {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, `<!DOCTYPE html>
-<html><head><title>Login Failed</title></head>
-<body><h1>Login Failed</h1><p>Invalid state parameter</p><p>You can close this window.</p></body></html>`)
+			writeCallbackFailure(w, c.cfg, "Invalid state parameter")
 			resultChan <- AuthCodeCallbackResult{Error: "state mismatch"}
 			return
 		}
 
-		fmt.Fprint(w, `<!DOCTYPE html>
-<html><head><title>Login Successful</title></head>
-<body><h1>Login Successful!</h1><p>You can close this window and return to the terminal.</p>
-<script>window.close();</script></body></html>`)
+		writeCallbackSuccess(w, r, c.cfg)
 		resultChan <- AuthCodeCallbackResult{Code: code, State: state}
 	})
 
@@ -1177,7 +1700,7 @@ func (c *SSOOIDCClient) startAuthCodeCallbackServer(ctx context.Context, expecte
 	go func() {
 		select {
 		case <-ctx.Done():
-		case <-time.After(10 * time.Minute):
+		case <-time.After(loginSessionMaxAge(c.cfg)):
 		case <-resultChan:
 		}
 		_ = server.Shutdown(context.Background())
@@ -1186,29 +1709,11 @@ func (c *SSOOIDCClient) startAuthCodeCallbackServer(ctx context.Context, expecte
 	return redirectURI, resultChan, nil
 }
 
-// generatePKCEForAuthCode generates PKCE code verifier and challenge for authorization code flow.
-func generatePKCEForAuthCode() (verifier, challenge string, err error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", "", fmt.Errorf("failed to generate random bytes: %w", err)
-	}
-	verifier = base64.RawURLEncoding.EncodeToString(b)
-	h := sha256.Sum256([]byte(verifier))
-	challenge = base64.RawURLEncoding.EncodeToString(h[:])
-	return verifier, challenge, nil
-}
-
-// generateStateForAuthCode generates a random state parameter.
-func generateStateForAuthCode() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
-}
-
 // CreateTokenWithAuthCode exchanges authorization code for tokens.
 func (c *SSOOIDCClient) CreateTokenWithAuthCode(ctx context.Context, clientID, clientSecret, code, codeVerifier, redirectURI string) (*CreateTokenResponse, error) {
+	ctx, cancel := withTokenRequestTimeout(ctx, c.cfg)
+	defer cancel()
+
 	payload := map[string]string{
 		"clientId":     clientID,
 		"clientSecret": clientSecret,
@@ -1223,12 +1728,12 @@ func (c *SSOOIDCClient) CreateTokenWithAuthCode(ctx context.Context, clientID, c
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ssoOIDCEndpoint+"/token", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseEndpoint()+"/token", strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", kiroUserAgent)
+	req.Header.Set("User-Agent", c.userAgent())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -1243,7 +1748,7 @@ func (c *SSOOIDCClient) CreateTokenWithAuthCode(ctx context.Context, clientID, c
 
 	if resp.StatusCode != http.StatusOK {
 		log.Debugf("create token with auth code failed (status %d): %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("create token failed (status %d)", resp.StatusCode)
+		return nil, parseOIDCError(resp.StatusCode, respBody)
 	}
 
 	var result CreateTokenResponse
@@ -1251,47 +1756,65 @@ func (c *SSOOIDCClient) CreateTokenWithAuthCode(ctx context.Context, clientID, c
 		return nil, err
 	}
 
+	warnMissingRefreshToken("auth code", &result)
 	return &result, nil
 }
 
 // LoginWithBuilderIDAuthCode performs the authorization code flow for AWS Builder ID.
 // This provides a better UX than device code flow as it uses automatic browser callback.
 func (c *SSOOIDCClient) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTokenData, error) {
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║     Kiro Authentication (AWS Builder ID - Auth Code)      ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	token, err := c.loginWithBuilderIDAuthCode(ctx)
+	finishLogin(c.events(), token, err)
+	return token, err
+}
+
+func (c *SSOOIDCClient) loginWithBuilderIDAuthCode(ctx context.Context) (*KiroTokenData, error) {
+	out := LoginOutput(c.cfg)
+	fmt.Fprintln(out, "\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(out, "║     Kiro Authentication (AWS Builder ID - Auth Code)      ║")
+	fmt.Fprintln(out, "╚══════════════════════════════════════════════════════════╝")
 
 	// Step 1: Generate PKCE and state
-	codeVerifier, codeChallenge, err := generatePKCEForAuthCode()
+	pkce, err := NewPKCE()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate PKCE: %w", err)
 	}
+	codeVerifier, codeChallenge := pkce.CodeVerifier, pkce.CodeChallenge
 
-	state, err := generateStateForAuthCode()
+	state, err := NewState(stateBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate state: %w", err)
+		return nil, err
 	}
 
 	// Step 2: Start callback server
-	fmt.Println("\nStarting callback server...")
+	fmt.Fprintln(out, "\nStarting callback server...")
 	redirectURI, resultChan, err := c.startAuthCodeCallbackServer(ctx, state)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start callback server: %w", err)
 	}
 	log.Debugf("Callback server started, redirect URI: %s", redirectURI)
+	warnUnreachableCallback(ctx, out, redirectURI)
 
 	// Step 3: Register client with auth code grant type
-	fmt.Println("Registering client...")
+	fmt.Fprintln(out, "Registering client...")
 	regResp, err := c.RegisterClientForAuthCode(ctx, redirectURI)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register client: %w", err)
 	}
 	log.Debugf("Client registered: %s", regResp.ClientID)
+	c.events().OnClientRegistered(regResp.ClientID)
 
 	// Step 4: Build authorization URL
-	scopes := "codewhisperer:completions,codewhisperer:analysis,codewhisperer:conversations"
+	scopes := defaultAuthorizeScopes
+	if len(c.Scopes) > 0 {
+		registered, err := c.oidcScopes()
+		if err != nil {
+			return nil, err
+		}
+		scopes = strings.Join(registered, ",")
+	}
 	authURL := fmt.Sprintf("%s/authorize?response_type=code&client_id=%s&redirect_uri=%s&scopes=%s&state=%s&code_challenge=%s&code_challenge_method=S256",
-		ssoOIDCEndpoint,
+		c.baseEndpoint(),
 		regResp.ClientID,
 		redirectURI,
 		scopes,
@@ -1300,10 +1823,10 @@ func (c *SSOOIDCClient) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTo
 	)
 
 	// Step 5: Open browser
-	fmt.Println("\n════════════════════════════════════════════════════════════")
-	fmt.Println("  Opening browser for authentication...")
-	fmt.Println("════════════════════════════════════════════════════════════")
-	fmt.Printf("\n  URL: %s\n\n", authURL)
+	fmt.Fprintln(out, "\n════════════════════════════════════════════════════════════")
+	fmt.Fprintln(out, "  Opening browser for authentication...")
+	fmt.Fprintln(out, "════════════════════════════════════════════════════════════")
+	c.events().OnAuthorizationURL(authURL)
 
 	// Set incognito mode
 	if c.cfg != nil {
@@ -1314,20 +1837,20 @@ func (c *SSOOIDCClient) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTo
 
 	if err := browser.OpenURL(authURL); err != nil {
 		log.Warnf("Could not open browser automatically: %v", err)
-		fmt.Println("  ⚠ Could not open browser automatically.")
-		fmt.Println("  Please open the URL above in your browser manually.")
+		fmt.Fprintln(out, "  ⚠ Could not open browser automatically.")
+		fmt.Fprintln(out, "  Please open the URL above in your browser manually.")
 	} else {
-		fmt.Println("  (Browser opened automatically)")
+		fmt.Fprintln(out, "  (Browser opened automatically)")
 	}
 
-	fmt.Println("\n  Waiting for authorization callback...")
+	fmt.Fprintln(out, "\n  Waiting for authorization callback...")
 
 	// Step 6: Wait for callback
 	select {
 	case <-ctx.Done():
 		browser.CloseBrowser()
 		return nil, ctx.Err()
-	case <-time.After(10 * time.Minute):
+	case <-time.After(loginSessionMaxAge(c.cfg)):
 		browser.CloseBrowser()
 		return nil, fmt.Errorf("authorization timed out")
 	case result := <-resultChan:
@@ -1336,7 +1859,7 @@ func (c *SSOOIDCClient) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTo
 			return nil, fmt.Errorf("authorization failed: %s", result.Error)
 		}
 
-		fmt.Println("\n✓ Authorization received!")
+		fmt.Fprintln(out, "\n✓ Authorization received!")
 
 		// Close browser
 		if err := browser.CloseBrowser(); err != nil {
@@ -1344,37 +1867,38 @@ func (c *SSOOIDCClient) LoginWithBuilderIDAuthCode(ctx context.Context) (*KiroTo
 		}
 
 		// Step 7: Exchange code for tokens
-		fmt.Println("Exchanging code for tokens...")
+		fmt.Fprintln(out, "Exchanging code for tokens...")
 		tokenResp, err := c.CreateTokenWithAuthCode(ctx, regResp.ClientID, regResp.ClientSecret, result.Code, codeVerifier, redirectURI)
 		if err != nil {
 			return nil, fmt.Errorf("failed to exchange code for tokens: %w", err)
 		}
 
-		fmt.Println("\n✓ Authentication successful!")
+		fmt.Fprintln(out, "\n✓ Authentication successful!")
 
 		// Step 8: Get profile ARN
-		fmt.Println("Fetching profile information...")
-		profileArn := c.fetchProfileArn(ctx, tokenResp.AccessToken)
+		fmt.Fprintln(out, "Fetching profile information...")
+		profileArn := c.selectProfileArn(ctx, tokenResp.AccessToken, defaultIDCRegion)
 
 		// Fetch user email (tries CodeWhisperer API first, then userinfo endpoint, then JWT parsing)
 		email := FetchUserEmailWithFallback(ctx, c.cfg, tokenResp.AccessToken)
 		if email != "" {
-			fmt.Printf("  Logged in as: %s\n", email)
+			fmt.Fprintf(out, "  Logged in as: %s\n", email)
 		}
 
 		expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
 
-		return &KiroTokenData{
-			AccessToken:  tokenResp.AccessToken,
-			RefreshToken: tokenResp.RefreshToken,
-			ProfileArn:   profileArn,
-			ExpiresAt:    expiresAt.Format(time.RFC3339),
-			AuthMethod:   "builder-id",
-			Provider:     "AWS",
-			ClientID:     regResp.ClientID,
-			ClientSecret: regResp.ClientSecret,
-			Email:        email,
-			Region:       defaultIDCRegion,
-		}, nil
+		return StampLoginProvenance(&KiroTokenData{
+			AccessToken:    tokenResp.AccessToken,
+			RefreshToken:   tokenResp.RefreshToken,
+			ProfileArn:     profileArn,
+			ExpiresAt:      expiresAt.Format(time.RFC3339),
+			AuthMethod:     "builder-id",
+			Provider:       "AWS",
+			ClientID:       regResp.ClientID,
+			ClientSecret:   regResp.ClientSecret,
+			Email:          email,
+			Region:         defaultIDCRegion,
+			NonRefreshable: tokenResp.RefreshToken == "",
+		}, LoginMethodAuthCode), nil
 	}
 }
@@ -0,0 +1,809 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestDeviceCodeInactivityTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		want time.Duration
+	}{
+		{name: "nil config", cfg: nil, want: defaultDeviceCodeInactivityTimeout},
+		{name: "unset", cfg: &config.Config{}, want: defaultDeviceCodeInactivityTimeout},
+		{name: "custom", cfg: &config.Config{KiroAuth: config.KiroAuthConfig{DeviceCodeInactivityTimeout: 45}}, want: 45 * time.Second},
+		{name: "disabled", cfg: &config.Config{KiroAuth: config.KiroAuthConfig{DeviceCodeInactivityTimeout: -1}}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SSOOIDCClient{cfg: tt.cfg}
+			if got := c.deviceCodeInactivityTimeout(); got != tt.want {
+				t.Errorf("deviceCodeInactivityTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryUserInfoEndpointRetriesOnRateLimit(t *testing.T) {
+	calls := 0
+	client := &SSOOIDCClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": []string{"0"}},
+				Body:       io.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"email":"user@example.com"}`)),
+			Request:    req,
+		}, nil
+	})}}
+
+	if got := client.tryUserInfoEndpoint(context.Background(), "token"); got != "user@example.com" {
+		t.Fatalf("tryUserInfoEndpoint() = %q, want %q", got, "user@example.com")
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("5"); got != 5*time.Second {
+		t.Errorf("parseRetryAfter(5) = %v, want 5s", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("parseRetryAfter(\"\") = %v, want 0", got)
+	}
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, want within (0, 1m]", future, got)
+	}
+}
+
+func TestDeviceCodeFlowAgainstFakeOIDC(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      []fakeOIDCResponse
+		expired    bool
+		wantPolls  []error
+		wantToken  bool
+		wantFailed bool
+	}{
+		{
+			name:      "pending then success",
+			token:     []fakeOIDCResponse{oidcError("authorization_pending"), oidcError("authorization_pending"), oidcOK(CreateTokenResponse{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600})},
+			wantPolls: []error{ErrAuthorizationPending, ErrAuthorizationPending},
+			wantToken: true,
+		},
+		{
+			name:      "slow down then success",
+			token:     []fakeOIDCResponse{oidcError("slow_down"), oidcOK(CreateTokenResponse{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600})},
+			wantPolls: []error{ErrSlowDown},
+			wantToken: true,
+		},
+		{
+			name:       "denied",
+			token:      []fakeOIDCResponse{oidcError("invalid_grant")},
+			wantFailed: true,
+		},
+		{
+			name:       "client secret expired",
+			expired:    true,
+			wantFailed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOIDCServer(t)
+			if tt.token != nil {
+				fake.setToken(tt.token...)
+			}
+			if tt.expired {
+				fake.expiredSecret = "client-secret"
+			}
+			client := fake.client()
+			ctx := context.Background()
+
+			reg, err := client.RegisterClient(ctx)
+			if err != nil {
+				t.Fatalf("RegisterClient() error = %v", err)
+			}
+			auth, err := client.StartDeviceAuthorization(ctx, reg.ClientID, reg.ClientSecret)
+			if err != nil {
+				t.Fatalf("StartDeviceAuthorization() error = %v", err)
+			}
+			if auth.UserCode != "ABCD-EFGH" {
+				t.Fatalf("UserCode = %q, want %q", auth.UserCode, "ABCD-EFGH")
+			}
+
+			for i, want := range tt.wantPolls {
+				if _, err := client.CreateToken(ctx, reg.ClientID, reg.ClientSecret, auth.DeviceCode); !errors.Is(err, want) {
+					t.Fatalf("poll %d error = %v, want %v", i, err, want)
+				}
+			}
+			tokenResp, err := client.CreateToken(ctx, reg.ClientID, reg.ClientSecret, auth.DeviceCode)
+			if tt.wantFailed {
+				if err == nil || errors.Is(err, ErrAuthorizationPending) || errors.Is(err, ErrSlowDown) {
+					t.Fatalf("CreateToken() error = %v, want terminal failure", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateToken() error = %v", err)
+			}
+			if tt.wantToken && tokenResp.AccessToken != "access" {
+				t.Fatalf("AccessToken = %q, want %q", tokenResp.AccessToken, "access")
+			}
+			if got := fake.tokenRequests[0]["deviceCode"]; got != "device-code" {
+				t.Fatalf("token request deviceCode = %q, want %q", got, "device-code")
+			}
+		})
+	}
+}
+
+func TestLoginWithIDCTimeoutAgainstFakeOIDC(t *testing.T) {
+	// An empty PATH keeps the login from launching a real browser.
+	t.Setenv("PATH", t.TempDir())
+	fake := newFakeOIDCServer(t)
+	fake.setToken(oidcError("authorization_pending"))
+	const startURL = "https://d-1234567890.awsapps.com/start"
+
+	client := fake.client()
+	client.cfg = &config.Config{KiroAuth: config.KiroAuthConfig{IDCLoginTimeout: 1}}
+	started := time.Now()
+	_, err := client.LoginWithIDC(context.Background(), startURL, "us-east-1")
+	if err == nil || !strings.Contains(err.Error(), "authorization timed out after 1s") {
+		t.Fatalf("LoginWithIDC() error = %v, want the idc-login-timeout to expire", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Fatalf("LoginWithIDC() timeout = %v, should not look like context cancellation", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("LoginWithIDC() returned after %v, want about 1s", elapsed)
+	}
+
+	// Without the cap, the caller's deadline ends the wait and is reported as the context error.
+	client.cfg = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.LoginWithIDC(ctx, startURL, "us-east-1")
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "authorization timed out") {
+		t.Fatalf("LoginWithIDC() with an expired context = %v, want context.DeadlineExceeded", err)
+	}
+	if fake.calls["token"] == 0 {
+		t.Fatal("expected the login to poll the token endpoint")
+	}
+}
+
+func TestRefreshAgainstFakeOIDC(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       fakeOIDCResponse
+		expired     bool
+		region      string
+		wantRefresh string
+		wantError   bool
+	}{
+		{name: "builder id success", token: oidcOK(CreateTokenResponse{AccessToken: "new-access", RefreshToken: "new-refresh", ExpiresIn: 3600}), wantRefresh: "new-refresh"},
+		{name: "idc success", region: "eu-west-1", token: oidcOK(CreateTokenResponse{AccessToken: "new-access", RefreshToken: "new-refresh", ExpiresIn: 3600}), wantRefresh: "new-refresh"},
+		{name: "builder id not rotated", token: oidcOK(CreateTokenResponse{AccessToken: "new-access", ExpiresIn: 3600}), wantRefresh: "old-refresh"},
+		{name: "idc not rotated", region: "eu-west-1", token: oidcOK(CreateTokenResponse{AccessToken: "new-access", ExpiresIn: 3600}), wantRefresh: "old-refresh"},
+		{name: "invalid grant", token: oidcError("invalid_grant"), wantError: true},
+		{name: "idc invalid grant", region: "eu-west-1", token: oidcError("invalid_grant"), wantError: true},
+		{name: "client secret expired", expired: true, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOIDCServer(t)
+			if tt.token.status != 0 {
+				fake.setToken(tt.token)
+			}
+			if tt.expired {
+				fake.expiredSecret = "client-secret"
+			}
+			client := fake.client()
+
+			var (
+				data *KiroTokenData
+				err  error
+			)
+			if tt.region != "" {
+				data, err = client.RefreshTokenWithRegion(context.Background(), "client-id", "client-secret", "old-refresh", tt.region, "https://start.example.com")
+			} else {
+				data, err = client.RefreshToken(context.Background(), "client-id", "client-secret", "old-refresh")
+			}
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("refresh error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("refresh error = %v", err)
+			}
+			if data.AccessToken != "new-access" || data.RefreshToken != tt.wantRefresh {
+				t.Fatalf("refresh tokens = %q/%q, want new-access/%s", data.AccessToken, data.RefreshToken, tt.wantRefresh)
+			}
+			if _, errParse := time.Parse(time.RFC3339, data.LastRefreshedAt); errParse != nil {
+				t.Fatalf("LastRefreshedAt = %q, want RFC3339 timestamp", data.LastRefreshedAt)
+			}
+			if got := fake.tokenRequests[0]["refreshToken"]; got != "old-refresh" {
+				t.Fatalf("token request refreshToken = %q, want %q", got, "old-refresh")
+			}
+			if got := fake.callCount("token"); got != 1 {
+				t.Fatalf("token calls = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestFetchUserEmailFromFakeUserInfo(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	if got := fake.client().tryUserInfoEndpoint(context.Background(), "token"); got != "user@example.com" {
+		t.Fatalf("tryUserInfoEndpoint() = %q, want %q", got, "user@example.com")
+	}
+}
+
+func TestRegisterClientReusesStoredRegistration(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	client := fake.client()
+	client.clients = NewOIDCClientStore(t.TempDir())
+	ctx := context.Background()
+
+	first, err := client.RegisterClient(ctx)
+	if err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+	second, err := client.RegisterClientWithRegion(ctx, "us-east-1")
+	if err != nil {
+		t.Fatalf("RegisterClientWithRegion() error = %v", err)
+	}
+	if fake.callCount("register") != 1 {
+		t.Fatalf("register calls = %d, want 1", fake.callCount("register"))
+	}
+	if second.ClientID != first.ClientID || second.ClientSecret != first.ClientSecret {
+		t.Fatalf("reused client = %+v, want %+v", second, first)
+	}
+
+	// Auth-code clients are tracked separately per redirect URI.
+	if _, err := client.RegisterClientForAuthCode(ctx, "http://127.0.0.1:3128/callback"); err != nil {
+		t.Fatalf("RegisterClientForAuthCode() error = %v", err)
+	}
+	if fake.callCount("register") != 2 {
+		t.Fatalf("register calls = %d, want 2", fake.callCount("register"))
+	}
+
+	records, err := client.clients.List()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("List() = %d records, %v; want 1 (same client ID replaced)", len(records), err)
+	}
+	if removed, _ := client.clients.Remove("client-id"); !removed {
+		t.Fatal("Remove() = false, want true")
+	}
+
+	// A secret expiring soon is not reused.
+	client.clients.Save(OIDCClientRecord{ClientID: "old", ClientSecret: "s", Endpoint: fake.URL, GrantType: OIDCGrantDeviceCode, ExpiresAt: time.Now().Add(time.Hour)})
+	if got := client.reusableClient(fake.URL, OIDCGrantDeviceCode, "", "", DefaultOIDCScopes); got != nil {
+		t.Fatalf("reusableClient() = %+v, want nil for a nearly expired secret", got)
+	}
+	client.clients.Save(OIDCClientRecord{ClientID: "gone", ClientSecret: "s", Endpoint: fake.URL, GrantType: OIDCGrantDeviceCode, ExpiresAt: time.Now().Add(-time.Hour)})
+	if removed, err := client.clients.PruneExpired(time.Now()); err != nil || removed != 1 {
+		t.Fatalf("PruneExpired() = %d, %v; want 1", removed, err)
+	}
+}
+
+func TestRegisterClientScopesOverride(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	client := fake.client()
+	client.clients = NewOIDCClientStore(t.TempDir())
+	ctx := context.Background()
+
+	client.Scopes = []string{" codewhisperer:completions ", "codewhisperer:conversations", "codewhisperer:completions"}
+	if _, err := client.RegisterClient(ctx); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+	// A stored registration with other scopes is not reused.
+	client.Scopes = nil
+	if _, err := client.RegisterClientWithRegion(ctx, "us-east-1"); err != nil {
+		t.Fatalf("RegisterClientWithRegion() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	got := fake.registerScopes
+	fake.mu.Unlock()
+	want := [][]string{{"codewhisperer:completions", "codewhisperer:conversations"}, DefaultOIDCScopes}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("registered scopes = %v, want %v", got, want)
+	}
+
+	client.Scopes = []string{" ", ""}
+	if _, err := client.RegisterClientForAuthCode(ctx, "http://127.0.0.1:3128/callback"); err == nil {
+		t.Fatal("RegisterClientForAuthCode() error = nil, want an error for an empty scope list")
+	}
+	if fake.callCount("register") != 2 {
+		t.Fatalf("register calls = %d, want 2", fake.callCount("register"))
+	}
+}
+
+func TestPartitionEndpoints(t *testing.T) {
+	tests := []struct {
+		region          string
+		wantOIDC        string
+		wantCodeWhisper string
+	}{
+		{region: "", wantOIDC: "https://oidc.us-east-1.amazonaws.com", wantCodeWhisper: "https://codewhisperer.us-east-1.amazonaws.com"},
+		{region: "us-east-1", wantOIDC: "https://oidc.us-east-1.amazonaws.com", wantCodeWhisper: "https://codewhisperer.us-east-1.amazonaws.com"},
+		{region: "eu-west-1", wantOIDC: "https://oidc.eu-west-1.amazonaws.com", wantCodeWhisper: "https://codewhisperer.us-east-1.amazonaws.com"},
+		{region: "us-gov-west-1", wantOIDC: "https://oidc.us-gov-west-1.amazonaws.com", wantCodeWhisper: "https://codewhisperer.us-gov-west-1.amazonaws.com"},
+		{region: "us-gov-east-1", wantOIDC: "https://oidc.us-gov-east-1.amazonaws.com", wantCodeWhisper: "https://codewhisperer.us-gov-east-1.amazonaws.com"},
+		{region: "cn-north-1", wantOIDC: "https://oidc.cn-north-1.amazonaws.com.cn", wantCodeWhisper: "https://codewhisperer.cn-north-1.amazonaws.com.cn"},
+		{region: "cn-northwest-1", wantOIDC: "https://oidc.cn-northwest-1.amazonaws.com.cn", wantCodeWhisper: "https://codewhisperer.cn-northwest-1.amazonaws.com.cn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			if got := getOIDCEndpoint(tt.region); got != tt.wantOIDC {
+				t.Errorf("getOIDCEndpoint(%q) = %q, want %q", tt.region, got, tt.wantOIDC)
+			}
+			if got := getCodeWhispererEndpoint(tt.region); got != tt.wantCodeWhisper {
+				t.Errorf("getCodeWhispererEndpoint(%q) = %q, want %q", tt.region, got, tt.wantCodeWhisper)
+			}
+		})
+	}
+}
+
+func TestGetOrRegisterClientKeyedByStartURL(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	client := fake.client()
+	client.clients = NewOIDCClientStore(t.TempDir())
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetOrRegisterClient(ctx, "us-east-1", "https://a.awsapps.com/start"); err != nil {
+			t.Fatalf("GetOrRegisterClient() error = %v", err)
+		}
+	}
+	if fake.callCount("register") != 1 {
+		t.Fatalf("register calls = %d, want 1 for a repeated start URL", fake.callCount("register"))
+	}
+	if _, err := client.GetOrRegisterClient(ctx, "us-east-1", "https://b.awsapps.com/start"); err != nil {
+		t.Fatalf("GetOrRegisterClient() error = %v", err)
+	}
+	if fake.callCount("register") != 2 {
+		t.Fatalf("register calls = %d, want 2 for another start URL", fake.callCount("register"))
+	}
+
+	// Secrets are reused until they are within a day of expiry.
+	now := time.Now()
+	client.clients.Save(OIDCClientRecord{ClientID: "two-days", ClientSecret: "s", Endpoint: fake.URL, GrantType: OIDCGrantDeviceCode, StartURL: "https://c.awsapps.com/start", ExpiresAt: now.Add(48 * time.Hour)})
+	if got := client.reusableClient(fake.URL, OIDCGrantDeviceCode, "", "https://c.awsapps.com/start", nil); got == nil || got.ClientID != "two-days" {
+		t.Fatalf("reusableClient() = %+v, want the client expiring in two days", got)
+	}
+	client.clients.Save(OIDCClientRecord{ClientID: "two-days", ClientSecret: "s", Endpoint: fake.URL, GrantType: OIDCGrantDeviceCode, StartURL: "https://c.awsapps.com/start", ExpiresAt: now.Add(12 * time.Hour)})
+	if got := client.reusableClient(fake.URL, OIDCGrantDeviceCode, "", "https://c.awsapps.com/start", nil); got != nil {
+		t.Fatalf("reusableClient() = %+v, want nil within a day of expiry", got)
+	}
+}
+
+func TestRegisterClientRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		wantCalls int
+		wantErr   string
+	}{
+		{name: "recovers after 503", statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, wantCalls: 2},
+		{name: "400 fails fast", statuses: []int{http.StatusBadRequest, http.StatusOK}, wantCalls: 1, wantErr: "register client failed (status 400)"},
+		{name: "gives up with last status", statuses: []int{http.StatusTooManyRequests}, attempts: 2, wantCalls: 2, wantErr: "register client failed (status 429) after 2 attempts"},
+		{name: "retries disabled", statuses: []int{http.StatusBadGateway, http.StatusOK}, attempts: -1, wantCalls: 1, wantErr: "register client failed (status 502)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			client := &SSOOIDCClient{
+				cfg: &config.Config{KiroAuth: config.KiroAuthConfig{OIDCRequestAttempts: tt.attempts}},
+				httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					status := tt.statuses[min(calls, len(tt.statuses)-1)]
+					calls++
+					body := `{"error":"throttled"}`
+					if status == http.StatusOK {
+						body = `{"clientId":"client-id","clientSecret":"client-secret"}`
+					}
+					return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+				})},
+				endpoint: "https://oidc.example.com",
+			}
+
+			resp, err := client.RegisterClient(context.Background())
+			if calls != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("RegisterClient() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || resp.ClientID != "client-id" {
+				t.Fatalf("RegisterClient() = %+v, %v", resp, err)
+			}
+		})
+	}
+}
+
+func TestIDCLoginFromEnv(t *testing.T) {
+	t.Setenv(envIDCStartURL, " https://example.awsapps.com/start ")
+	t.Setenv(envIDCRegion, "")
+	if _, _, ok := idcLoginFromEnv(); ok {
+		t.Fatal("expected partial environment to be rejected")
+	}
+
+	t.Setenv(envIDCRegion, "eu-west-1")
+	startURL, region, ok := idcLoginFromEnv()
+	if !ok || startURL != "https://example.awsapps.com/start" || region != "eu-west-1" {
+		t.Fatalf("idcLoginFromEnv() = %q, %q, %v", startURL, region, ok)
+	}
+}
+
+func TestDevicePollIntervalSlowDown(t *testing.T) {
+	if p := newDevicePollInterval(0); p.current != pollInterval {
+		t.Fatalf("default interval = %v, want %v", p.current, pollInterval)
+	}
+	p := newDevicePollInterval(20)
+	if first, second := p.next(), p.next(); first != 0 || second != 20*time.Second {
+		t.Fatalf("next() = %v then %v, want immediate first poll then %v", first, second, 20*time.Second)
+	}
+	for i := 0; i < maxConsecutiveSlowDowns-1; i++ {
+		if err := p.slowDown(); err != nil {
+			t.Fatalf("slowDown() #%d error = %v", i+1, err)
+		}
+	}
+	if p.current != maxPollInterval {
+		t.Fatalf("interval after slow_downs = %v, want cap %v", p.current, maxPollInterval)
+	}
+
+	p.pending()
+	if p.current != maxPollInterval-pollSlowDownStep {
+		t.Fatalf("interval after pending = %v, want %v", p.current, maxPollInterval-pollSlowDownStep)
+	}
+	for i := 0; i < maxConsecutiveSlowDowns-1; i++ {
+		if err := p.slowDown(); err != nil {
+			t.Fatalf("pending should reset the slow_down streak, got %v", err)
+		}
+	}
+	if err := p.slowDown(); !errors.Is(err, ErrTooManySlowDowns) {
+		t.Fatalf("slowDown() error = %v, want ErrTooManySlowDowns", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		p.pending()
+	}
+	if p.current != 20*time.Second {
+		t.Fatalf("interval should settle at the server interval, got %v", p.current)
+	}
+}
+
+func TestCreateTokenReturnsOIDCError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  error
+		wantCode string
+		wantHint string
+	}{
+		{name: "pending", status: http.StatusBadRequest, body: `{"error":"authorization_pending"}`, wantErr: ErrAuthorizationPending},
+		{name: "slow down", status: http.StatusBadRequest, body: `{"error":"slow_down"}`, wantErr: ErrSlowDown},
+		{name: "expired", status: http.StatusBadRequest, body: `{"error":"expired_token","error_description":"Device code has expired"}`, wantCode: "expired_token", wantHint: "The code expired, please retry."},
+		{name: "denied", status: http.StatusBadRequest, body: `{"error":"access_denied"}`, wantCode: "access_denied", wantHint: "You denied the request."},
+		{name: "non-json", status: http.StatusInternalServerError, body: `oops`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &SSOOIDCClient{
+				httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body)), Request: req}, nil
+				})},
+				endpoint: "https://oidc.example.com",
+			}
+
+			_, err := client.CreateToken(context.Background(), "client-id", "client-secret", "device-code")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CreateToken() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			var oidcErr *OIDCError
+			if !errors.As(err, &oidcErr) {
+				t.Fatalf("CreateToken() error = %T %v, want *OIDCError", err, err)
+			}
+			if oidcErr.Code != tt.wantCode || oidcErr.HTTPStatus != tt.status {
+				t.Fatalf("OIDCError = %+v", oidcErr)
+			}
+			if hint := tokenErrorHint(fmt.Errorf("token creation failed: %w", err)); hint != tt.wantHint {
+				t.Fatalf("tokenErrorHint() = %q, want %q", hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestListenAuthCodeCallbackPinnedPort(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	client := &SSOOIDCClient{cfg: &config.Config{KiroAuth: config.KiroAuthConfig{AuthCodeCallbackPort: busyPort}}}
+	if _, err := client.listenAuthCodeCallback(); err == nil || !strings.Contains(err.Error(), "is busy") {
+		t.Fatalf("expected busy pinned port to fail, got %v", err)
+	}
+
+	if busyPort < 65535 {
+		client.cfg.KiroAuth.AuthCodeCallbackPortMax = busyPort + 1
+		listener, err := client.listenAuthCodeCallback()
+		if err != nil {
+			t.Skipf("next port also unavailable: %v", err)
+		}
+		defer listener.Close()
+		if got := listener.Addr().(*net.TCPAddr).Port; got != busyPort+1 {
+			t.Fatalf("listener port = %d, want %d", got, busyPort+1)
+		}
+	}
+
+	client.cfg.KiroAuth = config.KiroAuthConfig{AuthCodeCallbackPort: 20000, AuthCodeCallbackPortMax: 19000}
+	if _, err := client.listenAuthCodeCallback(); err == nil {
+		t.Fatal("expected inverted port range to be rejected")
+	}
+}
+
+func TestAuthCodeCallbackRedirectMatchesBindHost(t *testing.T) {
+	if got := callbackHost(&config.Config{KiroAuth: config.KiroAuthConfig{CallbackHost: " [::1] "}}, "127.0.0.1"); got != "::1" {
+		t.Fatalf("callbackHost() = %q, want ::1", got)
+	}
+	if got := callbackAddr("::1", 19877); got != "[::1]:19877" {
+		t.Fatalf("callbackAddr() = %q", got)
+	}
+
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		probe, err := net.Listen("tcp", callbackAddr(host, 0))
+		if err != nil {
+			t.Logf("skipping %s: %v", host, err)
+			continue
+		}
+		probe.Close()
+
+		client := &SSOOIDCClient{cfg: &config.Config{KiroAuth: config.KiroAuthConfig{CallbackHost: host}}}
+		ctx, cancel := context.WithCancel(context.Background())
+		redirectURI, _, err := client.startAuthCodeCallbackServer(ctx, "state")
+		if err != nil {
+			cancel()
+			t.Fatalf("startAuthCodeCallbackServer(%s) error = %v", host, err)
+		}
+		resp, err := http.Get(redirectURI + "?code=abc&state=state")
+		cancel()
+		if err != nil {
+			t.Fatalf("callback on %s unreachable: %v", redirectURI, err)
+		}
+		resp.Body.Close()
+		if !strings.HasPrefix(redirectURI, "http://"+strings.TrimSuffix(callbackAddr(host, 0), "0")) {
+			t.Fatalf("redirect URI %q does not use bind host %s", redirectURI, host)
+		}
+	}
+}
+
+func TestFetchProfileArns(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]*http.Response
+		want      []string
+		wantErr   error
+	}{
+		{
+			name: "multiple profiles",
+			responses: map[string]*http.Response{
+				"ListProfiles": {StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"profiles":[{"arn":"arn:a"},{"arn":"arn:b"}]}`))},
+			},
+			want: []string{"arn:a", "arn:b"},
+		},
+		{
+			name: "falls back to customizations",
+			responses: map[string]*http.Response{
+				"ListProfiles":                {StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"profiles":[]}`))},
+				"ListAvailableCustomizations": {StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"profileArn":"arn:c","customizations":[{"arn":"arn:c"}]}`))},
+			},
+			want: []string{"arn:c"},
+		},
+		{
+			name: "no profiles",
+			responses: map[string]*http.Response{
+				"ListProfiles":                {StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`))},
+				"ListAvailableCustomizations": {StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`))},
+			},
+			wantErr: ErrNoProfiles,
+		},
+		{
+			name: "access denied",
+			responses: map[string]*http.Response{
+				"ListProfiles":                {StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader(`{}`))},
+				"ListAvailableCustomizations": {StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader(`{}`))},
+			},
+			wantErr: ErrProfileAccessDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &SSOOIDCClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				operation := strings.TrimPrefix(req.Header.Get("x-amz-target"), "AmazonCodeWhispererService.")
+				resp, ok := tt.responses[operation]
+				if !ok {
+					t.Fatalf("unexpected operation %q", operation)
+				}
+				resp.Request = req
+				return resp, nil
+			})}}
+
+			got, err := client.FetchProfileArns(context.Background(), "access-token")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FetchProfileArns() error = %v, want %v", err, tt.wantErr)
+				}
+				if arn, err := client.FetchProfileArn(context.Background(), "access-token"); arn != "" || err == nil {
+					t.Fatalf("FetchProfileArn() = %q, %v", arn, err)
+				}
+				return
+			}
+			if err != nil || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("FetchProfileArns() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectProfileArn(t *testing.T) {
+	client := &SSOOIDCClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"profiles":[{"arn":"arn:a","profileName":"team-a"},{"arn":"arn:b","profileName":"team-b"}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}}
+
+	profiles, err := client.fetchProfiles(context.Background(), "access-token", defaultIDCRegion)
+	if err != nil || len(profiles) != 2 || profiles[1] != (codeWhispererProfile{Arn: "arn:b", Name: "team-b"}) {
+		t.Fatalf("fetchProfiles() = %+v, %v", profiles, err)
+	}
+
+	t.Setenv(envProfileArn, "arn:b")
+	if got := client.selectProfileArn(context.Background(), "access-token", defaultIDCRegion); got != "arn:b" {
+		t.Fatalf("selectProfileArn() with %s = %q, want arn:b", envProfileArn, got)
+	}
+
+	t.Setenv(envProfileArn, "")
+	if isInteractiveTerminal() {
+		t.Skip("stdin is a terminal; selection would prompt")
+	}
+	if got := client.selectProfileArn(context.Background(), "access-token", defaultIDCRegion); got != "arn:a" {
+		t.Fatalf("non-interactive selectProfileArn() = %q, want first profile", got)
+	}
+}
+
+func TestValidateStartURL(t *testing.T) {
+	valid := []string{
+		builderIDStartURL,
+		"https://d-1234567890.awsapps.com/start",
+		"https://my-company.awsapps.com/start/",
+		"https://d-1234567890.awsapps.cn/start",
+		"https://start.us-gov-home.awsapps.com/directory/d-1234567890",
+		"https://ssoins-1234567890abcdef.portal.us-east-1.app.aws",
+	}
+	for _, startURL := range valid {
+		if err := validateStartURL(startURL); err != nil {
+			t.Errorf("validateStartURL(%q) error = %v", startURL, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"":                                         "expected a URL",
+		"d-1234567890.awsapps.com/start":           "expected a URL",
+		"http://d-1234567890.awsapps.com/start":    "must use https",
+		"https://d-1234567890.awsapps.com":         "path should be /start",
+		"https://d-1234567890.awsapps.com/login":   "path should be /start",
+		"https://d-1234567890.example.com/start":   "host should end in .awsapps.com",
+		"https://awsapps.com.evil.example/start":   "host should end in .awsapps.com",
+		"https://d-1234567890.awsapps.com:%/start": "expected a URL",
+	}
+	for startURL, want := range invalid {
+		err := validateStartURL(startURL)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("validateStartURL(%q) error = %v, want %q", startURL, err, want)
+		}
+	}
+}
+
+func TestEnsureFresh(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	fake.setToken(oidcOK(CreateTokenResponse{AccessToken: "new-access", RefreshToken: "new-refresh", ExpiresIn: 3600}))
+	cfg := &config.Config{KiroAuth: config.KiroAuthConfig{OIDCEndpoint: fake.URL}}
+	oauth := NewKiroOAuth(cfg, WithHTTPClient(fake.Client()))
+
+	valid := &KiroTokenData{AccessToken: "access", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	if got, err := oauth.EnsureFresh(context.Background(), valid); err != nil || got != valid {
+		t.Fatalf("EnsureFresh(valid) = %v, %v; want the token unchanged", got, err)
+	}
+	if fake.callCount("token") != 0 {
+		t.Fatalf("valid token should not be refreshed")
+	}
+
+	expiring := &KiroTokenData{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresAt:    time.Now().Add(time.Minute).Format(time.RFC3339),
+		AuthMethod:   "builder-id",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Email:        "user@example.com",
+		ProfileArn:   "arn:aws:codewhisperer:us-east-1:123:profile/p",
+	}
+	got, err := oauth.EnsureFresh(context.Background(), expiring)
+	if err != nil {
+		t.Fatalf("EnsureFresh(expiring) error = %v", err)
+	}
+	if got.AccessToken != "new-access" || got.RefreshToken != "new-refresh" {
+		t.Fatalf("refreshed tokens = %q/%q, want new-access/new-refresh", got.AccessToken, got.RefreshToken)
+	}
+	if got.Email != expiring.Email || got.ProfileArn != expiring.ProfileArn {
+		t.Fatalf("refresh dropped stored fields: %+v", got)
+	}
+	if expiring.AccessToken != "access" {
+		t.Fatalf("EnsureFresh mutated its input")
+	}
+
+	expired := &KiroTokenData{AccessToken: "access", ExpiresAt: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	if _, err := oauth.EnsureFresh(context.Background(), expired); err == nil {
+		t.Fatalf("EnsureFresh(expired without refresh token) error = nil, want error")
+	}
+}
+
+func TestVerifyCallbackReachable(t *testing.T) {
+	client := &SSOOIDCClient{cfg: &config.Config{KiroAuth: config.KiroAuthConfig{CallbackHost: "127.0.0.1"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	redirectURI, resultChan, err := client.startAuthCodeCallbackServer(ctx, "state")
+	if err != nil {
+		t.Fatalf("startAuthCodeCallbackServer() error = %v", err)
+	}
+	if err := VerifyCallbackReachable(ctx, redirectURI); err != nil {
+		t.Fatalf("VerifyCallbackReachable() error = %v", err)
+	}
+	select {
+	case result := <-resultChan:
+		t.Fatalf("self-check consumed the login result: %+v", result)
+	default:
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closedURI := fmt.Sprintf("http://%s%s", closed.Addr(), authCodeCallbackPath)
+	closed.Close()
+	if err := VerifyCallbackReachable(context.Background(), closedURI); err == nil {
+		t.Fatalf("VerifyCallbackReachable(%s) error = nil, want unreachable", closedURI)
+	}
+}
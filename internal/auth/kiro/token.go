@@ -35,6 +35,14 @@ type KiroTokenStorage struct {
 	StartURL string `json:"start_url,omitempty"`
 	// Email is the user's email address
 	Email string `json:"email,omitempty"`
+	// IssuedAt is when the token was first obtained by a login
+	IssuedAt string `json:"issued_at,omitempty"`
+	// LoginHost is the hostname of the machine that performed the login
+	LoginHost string `json:"login_host,omitempty"`
+	// LoginMethod is the login flow that produced the token
+	LoginMethod string `json:"login_method,omitempty"`
+	// NonRefreshable marks a token issued without a refresh token
+	NonRefreshable bool `json:"non_refreshable,omitempty"`
 }
 
 // SaveTokenToFile persists the token storage to the specified file path.
@@ -85,5 +93,10 @@ func (s *KiroTokenStorage) ToTokenData() *KiroTokenData {
 		Region:       s.Region,
 		StartURL:     s.StartURL,
 		Email:        s.Email,
+		IssuedAt:     s.IssuedAt,
+		LoginHost:    s.LoginHost,
+		LoginMethod:  s.LoginMethod,
+		// Tokens saved without a refresh token cannot be refreshed either way.
+		NonRefreshable: s.NonRefreshable || s.RefreshToken == "",
 	}
 }
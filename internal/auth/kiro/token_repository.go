@@ -70,7 +70,7 @@ func (r *FileTokenRepository) FindOldestUnverified(limit int) []*Token {
 			return nil
 		}
 
-		if token != nil && token.RefreshToken != "" {
+		if token != nil && token.RefreshToken != "" && !token.NonRefreshable {
 			// 检查 token 是否需要刷新（过期前 5 分钟）
 			if token.ExpiresAt.IsZero() || time.Until(token.ExpiresAt) < 5*time.Minute {
 				tokens = append(tokens, token)
@@ -148,6 +148,16 @@ func (r *FileTokenRepository) UpdateToken(token *Token) error {
 	if token.StartURL != "" {
 		existingData["start_url"] = token.StartURL
 	}
+	// 登录来源信息只在首次登录时写入，刷新时保留原值
+	if _, exists := existingData["issued_at"]; !exists && !token.IssuedAt.IsZero() {
+		existingData["issued_at"] = token.IssuedAt.UTC().Format(time.RFC3339)
+	}
+	if _, exists := existingData["login_host"]; !exists && token.LoginHost != "" {
+		existingData["login_host"] = token.LoginHost
+	}
+	if _, exists := existingData["login_method"]; !exists && token.LoginMethod != "" {
+		existingData["login_method"] = token.LoginMethod
+	}
 
 	// 序列化并写入文件
 	raw, err := json.MarshalIndent(existingData, "", "  ")
@@ -221,6 +231,15 @@ func (r *FileTokenRepository) readTokenFile(path string) (*Token, error) {
 	if v, ok := metadata["provider"].(string); ok {
 		token.Provider = v
 	}
+	if v, ok := metadata["login_host"].(string); ok {
+		token.LoginHost = v
+	}
+	if v, ok := metadata["login_method"].(string); ok {
+		token.LoginMethod = v
+	}
+	if v, ok := metadata["non_refreshable"].(bool); ok {
+		token.NonRefreshable = v
+	}
 
 	// 解析时间字段
 	if v, ok := metadata["expires_at"].(string); ok {
@@ -233,6 +252,11 @@ func (r *FileTokenRepository) readTokenFile(path string) (*Token, error) {
 			token.LastVerified = t
 		}
 	}
+	if v, ok := metadata["issued_at"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			token.IssuedAt = t
+		}
+	}
 
 	return token, nil
 }
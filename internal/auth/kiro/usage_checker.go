@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
-	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 )
 
 // UsageQuotaResponse represents the API response structure for usage quota checking.
@@ -57,7 +56,7 @@ type UsageChecker struct {
 // NewUsageChecker creates a new UsageChecker instance.
 func NewUsageChecker(cfg *config.Config) *UsageChecker {
 	return &UsageChecker{
-		httpClient: util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: 30 * time.Second}),
+		httpClient: newAuthHTTPClient(cfg, 30*time.Second),
 		endpoint:   awsKiroEndpoint,
 	}
 }
@@ -0,0 +1,77 @@
+package browser
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clipboardTimeout bounds how long a clipboard helper may run.
+const clipboardTimeout = 3 * time.Second
+
+// CopyToClipboard places text on the system clipboard on a best-effort basis.
+// It shells out to the platform clipboard tool (pbcopy, clip, wl-copy, xclip or xsel)
+// and returns an error when none is available, e.g. on headless servers.
+//
+// Parameters:
+//   - text: The text to copy.
+//
+// Returns:
+//   - An error if the text could not be copied, otherwise nil.
+func CopyToClipboard(text string) error {
+	cmd, err := clipboardCommand()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(text)
+
+	log.Debugf("Running clipboard command: %s %v", cmd.Path, cmd.Args[1:])
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start clipboard command: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("clipboard command failed: %w", err)
+		}
+		return nil
+	case <-time.After(clipboardTimeout):
+		// xclip and xsel may keep serving the selection; the text is already copied.
+		return nil
+	}
+}
+
+// clipboardCommand returns the clipboard command for the current platform.
+func clipboardCommand() (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy"), nil
+	case "windows":
+		return exec.Command("clip"), nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		candidates := [][]string{
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append([][]string{{"wl-copy"}}, candidates...)
+		} else if os.Getenv("DISPLAY") == "" {
+			return nil, fmt.Errorf("no graphical session available for clipboard access")
+		}
+		for _, candidate := range candidates {
+			if _, err := exec.LookPath(candidate[0]); err == nil {
+				return exec.Command(candidate[0], candidate[1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("no clipboard tool found (install wl-copy, xclip or xsel)")
+	default:
+		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+}
@@ -8,15 +8,83 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/redis/go-redis/v9"
 )
 
 // redisClient wraps the Redis client with connection pool management.
 type redisClient struct {
-	client *redis.Client
-	config config.RedisCacheConfig
-	mu     sync.RWMutex
+	client   *redis.Client
+	config   config.RedisCacheConfig
+	mu       sync.RWMutex
+	inflight inflightHook
+}
+
+// closeDrainTimeout bounds how long Close waits for outstanding commands and pipelines.
+const closeDrainTimeout = 5 * time.Second
+
+// inflightHook counts commands and pipelines that have been issued but not yet returned,
+// so Close can let them finish before the connection pool is torn down.
+type inflightHook struct {
+	mu    sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+func (h *inflightHook) begin() {
+	h.mu.Lock()
+	if h.count == 0 {
+		h.idle = make(chan struct{})
+	}
+	h.count++
+	h.mu.Unlock()
+}
+
+func (h *inflightHook) end() {
+	h.mu.Lock()
+	h.count--
+	if h.count == 0 {
+		close(h.idle)
+	}
+	h.mu.Unlock()
+}
+
+// wait blocks until no operation is in flight or the timeout elapses.
+func (h *inflightHook) wait(timeout time.Duration) bool {
+	h.mu.Lock()
+	if h.count == 0 {
+		h.mu.Unlock()
+		return true
+	}
+	idle := h.idle
+	h.mu.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (h *inflightHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *inflightHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.begin()
+		defer h.end()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *inflightHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.begin()
+		defer h.end()
+		return next(ctx, cmds)
+	}
 }
 
 // globalRedisClient is the global Redis client instance.
@@ -68,6 +136,7 @@ func (r *redisClient) connect() error {
 	}
 
 	r.client = redis.NewClient(opts)
+	r.client.AddHook(&r.inflight)
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -108,14 +177,23 @@ func GetConfig() config.RedisCacheConfig {
 	return globalRedisClient.config
 }
 
-// Close closes the Redis connection.
+// Close closes the Redis connection. It first detaches the client so no new work starts,
+// then waits up to closeDrainTimeout for outstanding commands and pipelines to complete.
 func Close() error {
-	if globalRedisClient == nil || globalRedisClient.client == nil {
+	if globalRedisClient == nil {
 		return nil
 	}
 	globalRedisClient.mu.Lock()
-	defer globalRedisClient.mu.Unlock()
-	return globalRedisClient.client.Close()
+	client := globalRedisClient.client
+	globalRedisClient.client = nil
+	globalRedisClient.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	if !globalRedisClient.inflight.wait(closeDrainTimeout) {
+		log.Warnf("Redis close: outstanding operations did not finish within %v", closeDrainTimeout)
+	}
+	return client.Close()
 }
 
 // Ping checks if Redis is reachable.
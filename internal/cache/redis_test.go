@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestInflightHookWaitsForPipelines(t *testing.T) {
+	var h inflightHook
+	if !h.wait(time.Millisecond) {
+		t.Fatal("wait() on idle hook = false, want true")
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pipeline := h.ProcessPipelineHook(func(ctx context.Context, cmds []redis.Cmder) error {
+		close(started)
+		<-release
+		return nil
+	})
+	go func() { _ = pipeline(context.Background(), nil) }()
+	<-started
+
+	if h.wait(10 * time.Millisecond) {
+		t.Fatal("wait() returned true while a pipeline was in flight")
+	}
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	if !h.wait(time.Second) {
+		t.Fatal("wait() did not observe the pipeline completing")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	kiroauth "github.com/router-for-me/CLIProxyAPI/v6/internal/auth/kiro"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
 	log "github.com/sirupsen/logrus"
@@ -34,6 +35,7 @@ func DoKiroGoogleLogin(cfg *config.Config, options *LoginOptions) {
 	// Note: Kiro defaults to incognito mode for multi-account support.
 	// Users can override with --no-incognito if they want to use existing browser sessions.
 
+	out := kiroauth.LoginOutput(cfg)
 	manager := newAuthManager()
 
 	// Use KiroAuthenticator with Google login
@@ -45,10 +47,10 @@ func DoKiroGoogleLogin(cfg *config.Config, options *LoginOptions) {
 	})
 	if err != nil {
 		log.Errorf("Kiro Google authentication failed: %v", err)
-		fmt.Println("\nTroubleshooting:")
-		fmt.Println("1. Make sure the protocol handler is installed")
-		fmt.Println("2. Complete the Google login in the browser")
-		fmt.Println("3. If callback fails, try: --kiro-import (after logging in via Kiro IDE)")
+		fmt.Fprintln(out, "\nTroubleshooting:")
+		fmt.Fprintln(out, "1. Make sure the protocol handler is installed")
+		fmt.Fprintln(out, "2. Complete the Google login in the browser")
+		fmt.Fprintln(out, "3. If callback fails, try: --kiro-import (after logging in via Kiro IDE)")
 		return
 	}
 
@@ -60,12 +62,12 @@ func DoKiroGoogleLogin(cfg *config.Config, options *LoginOptions) {
 	}
 
 	if savedPath != "" {
-		fmt.Printf("Authentication saved to %s\n", savedPath)
+		fmt.Fprintf(out, "Authentication saved to %s\n", savedPath)
 	}
 	if record != nil && record.Label != "" {
-		fmt.Printf("Authenticated as %s\n", record.Label)
+		fmt.Fprintf(out, "Authenticated as %s\n", record.Label)
 	}
-	fmt.Println("Kiro Google authentication successful!")
+	fmt.Fprintln(out, "Kiro Google authentication successful!")
 }
 
 // DoKiroAWSLogin triggers Kiro authentication with AWS Builder ID.
@@ -82,6 +84,7 @@ func DoKiroAWSLogin(cfg *config.Config, options *LoginOptions) {
 	// Note: Kiro defaults to incognito mode for multi-account support.
 	// Users can override with --no-incognito if they want to use existing browser sessions.
 
+	out := kiroauth.LoginOutput(cfg)
 	manager := newAuthManager()
 
 	// Use KiroAuthenticator with AWS Builder ID login (device code flow)
@@ -93,10 +96,10 @@ func DoKiroAWSLogin(cfg *config.Config, options *LoginOptions) {
 	})
 	if err != nil {
 		log.Errorf("Kiro AWS authentication failed: %v", err)
-		fmt.Println("\nTroubleshooting:")
-		fmt.Println("1. Make sure you have an AWS Builder ID")
-		fmt.Println("2. Complete the authorization in the browser")
-		fmt.Println("3. If callback fails, try: --kiro-import (after logging in via Kiro IDE)")
+		fmt.Fprintln(out, "\nTroubleshooting:")
+		fmt.Fprintln(out, "1. Make sure you have an AWS Builder ID")
+		fmt.Fprintln(out, "2. Complete the authorization in the browser")
+		fmt.Fprintln(out, "3. If callback fails, try: --kiro-import (after logging in via Kiro IDE)")
 		return
 	}
 
@@ -108,12 +111,60 @@ func DoKiroAWSLogin(cfg *config.Config, options *LoginOptions) {
 	}
 
 	if savedPath != "" {
-		fmt.Printf("Authentication saved to %s\n", savedPath)
+		fmt.Fprintf(out, "Authentication saved to %s\n", savedPath)
 	}
 	if record != nil && record.Label != "" {
-		fmt.Printf("Authenticated as %s\n", record.Label)
+		fmt.Fprintf(out, "Authenticated as %s\n", record.Label)
 	}
-	fmt.Println("Kiro AWS authentication successful!")
+	fmt.Fprintln(out, "Kiro AWS authentication successful!")
+}
+
+// DoKiroCognitoLogin triggers Kiro authentication for an email/password account.
+// This opens Kiro's Cognito sign-in page and receives the callback on a local HTTP server.
+//
+// Parameters:
+//   - cfg: The application configuration
+//   - options: Login options including prompts
+func DoKiroCognitoLogin(cfg *config.Config, options *LoginOptions) {
+	if options == nil {
+		options = &LoginOptions{}
+	}
+
+	// Note: Kiro defaults to incognito mode for multi-account support.
+	// Users can override with --no-incognito if they want to use existing browser sessions.
+
+	out := kiroauth.LoginOutput(cfg)
+	manager := newAuthManager()
+
+	authenticator := sdkAuth.NewKiroAuthenticator()
+	record, err := authenticator.LoginWithCognito(context.Background(), cfg, &sdkAuth.LoginOptions{
+		NoBrowser: options.NoBrowser,
+		Metadata:  map[string]string{},
+		Prompt:    options.Prompt,
+	})
+	if err != nil {
+		log.Errorf("Kiro email/password authentication failed: %v", err)
+		fmt.Fprintln(out, "\nTroubleshooting:")
+		fmt.Fprintln(out, "1. Sign in with the email and password of your Kiro account in the browser")
+		fmt.Fprintln(out, "2. Make sure the local callback port is reachable from the browser")
+		fmt.Fprintln(out, "3. If callback fails, try: --kiro-import (after logging in via Kiro IDE)")
+		return
+	}
+
+	// Save the auth record
+	savedPath, err := manager.SaveAuth(record, cfg)
+	if err != nil {
+		log.Errorf("Failed to save auth: %v", err)
+		return
+	}
+
+	if savedPath != "" {
+		fmt.Fprintf(out, "Authentication saved to %s\n", savedPath)
+	}
+	if record != nil && record.Label != "" {
+		fmt.Fprintf(out, "Authenticated as %s\n", record.Label)
+	}
+	fmt.Fprintln(out, "Kiro email/password authentication successful!")
 }
 
 // DoKiroAWSAuthCodeLogin triggers Kiro authentication with AWS Builder ID using authorization code flow.
@@ -130,6 +181,7 @@ func DoKiroAWSAuthCodeLogin(cfg *config.Config, options *LoginOptions) {
 	// Note: Kiro defaults to incognito mode for multi-account support.
 	// Users can override with --no-incognito if they want to use existing browser sessions.
 
+	out := kiroauth.LoginOutput(cfg)
 	manager := newAuthManager()
 
 	// Use KiroAuthenticator with AWS Builder ID login (authorization code flow)
@@ -141,10 +193,10 @@ func DoKiroAWSAuthCodeLogin(cfg *config.Config, options *LoginOptions) {
 	})
 	if err != nil {
 		log.Errorf("Kiro AWS authentication (auth code) failed: %v", err)
-		fmt.Println("\nTroubleshooting:")
-		fmt.Println("1. Make sure you have an AWS Builder ID")
-		fmt.Println("2. Complete the authorization in the browser")
-		fmt.Println("3. If callback fails, try: --kiro-aws-login (device code flow)")
+		fmt.Fprintln(out, "\nTroubleshooting:")
+		fmt.Fprintln(out, "1. Make sure you have an AWS Builder ID")
+		fmt.Fprintln(out, "2. Complete the authorization in the browser")
+		fmt.Fprintln(out, "3. If callback fails, try: --kiro-aws-login (device code flow)")
 		return
 	}
 
@@ -156,12 +208,12 @@ func DoKiroAWSAuthCodeLogin(cfg *config.Config, options *LoginOptions) {
 	}
 
 	if savedPath != "" {
-		fmt.Printf("Authentication saved to %s\n", savedPath)
+		fmt.Fprintf(out, "Authentication saved to %s\n", savedPath)
 	}
 	if record != nil && record.Label != "" {
-		fmt.Printf("Authenticated as %s\n", record.Label)
+		fmt.Fprintf(out, "Authenticated as %s\n", record.Label)
 	}
-	fmt.Println("Kiro AWS authentication successful!")
+	fmt.Fprintln(out, "Kiro AWS authentication successful!")
 }
 
 // DoKiroImport imports Kiro token from Kiro IDE's token file.
@@ -176,6 +228,7 @@ func DoKiroImport(cfg *config.Config, options *LoginOptions) {
 		options = &LoginOptions{}
 	}
 
+	out := kiroauth.LoginOutput(cfg)
 	manager := newAuthManager()
 
 	// Use ImportFromKiroIDE instead of Login
@@ -183,11 +236,11 @@ func DoKiroImport(cfg *config.Config, options *LoginOptions) {
 	record, err := authenticator.ImportFromKiroIDE(context.Background(), cfg)
 	if err != nil {
 		log.Errorf("Kiro token import failed: %v", err)
-		fmt.Println("\nMake sure you have logged in to Kiro IDE first:")
-		fmt.Println("1. Open Kiro IDE")
-		fmt.Println("2. Click 'Sign in with Google' (or GitHub)")
-		fmt.Println("3. Complete the login process")
-		fmt.Println("4. Run this command again")
+		fmt.Fprintln(out, "\nMake sure you have logged in to Kiro IDE first:")
+		fmt.Fprintln(out, "1. Open Kiro IDE")
+		fmt.Fprintln(out, "2. Click 'Sign in with Google' (or GitHub)")
+		fmt.Fprintln(out, "3. Complete the login process")
+		fmt.Fprintln(out, "4. Run this command again")
 		return
 	}
 
@@ -199,10 +252,10 @@ func DoKiroImport(cfg *config.Config, options *LoginOptions) {
 	}
 
 	if savedPath != "" {
-		fmt.Printf("Authentication saved to %s\n", savedPath)
+		fmt.Fprintf(out, "Authentication saved to %s\n", savedPath)
 	}
 	if record != nil && record.Label != "" {
-		fmt.Printf("Imported as %s\n", record.Label)
+		fmt.Fprintf(out, "Imported as %s\n", record.Label)
 	}
-	fmt.Println("Kiro token import successful!")
+	fmt.Fprintln(out, "Kiro token import successful!")
 }
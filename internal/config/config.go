@@ -53,6 +53,34 @@ type Config struct {
 	// LoggingToFile controls whether application logs are written to rotating files or stdout.
 	LoggingToFile bool `yaml:"logging-to-file" json:"logging-to-file"`
 
+	// LogFormat selects how log entries are encoded: "text" (default) for the human-readable
+	// line format, or "json" for one JSON object per entry carrying only structured fields.
+	LogFormat string `yaml:"log-format,omitempty" json:"log-format,omitempty"`
+
+	// RequestIDPaths lists the request paths that get a request ID in the logs. An entry
+	// starting with "=" matches the rest of it exactly, any other entry matches as a path
+	// prefix. Empty uses the built-in list of AI API endpoints.
+	RequestIDPaths []string `yaml:"request-id-paths,omitempty" json:"request-id-paths,omitempty"`
+
+	// RequestIDHeader names the header carrying an inbound correlation ID, which is used as the
+	// request ID when valid and echoed back in the response. Empty uses "X-Request-Id".
+	RequestIDHeader string `yaml:"request-id-header,omitempty" json:"request-id-header,omitempty"`
+
+	// LogRedaction replaces bearer tokens and API keys in request log fields with a short hash.
+	LogRedaction LogRedactionConfig `yaml:"log-redaction,omitempty" json:"log-redaction,omitempty"`
+
+	// BodyLogging logs truncated request and response bodies of AI API requests at debug level
+	// for diagnosing provider integrations.
+	BodyLogging BodyLoggingConfig `yaml:"body-logging,omitempty" json:"body-logging,omitempty"`
+
+	// SlowRequestThreshold is the request duration in seconds above which successful requests
+	// are logged at warning level with a slow field. 0 disables slow request warnings.
+	SlowRequestThreshold int `yaml:"slow-request-threshold,omitempty" json:"slow-request-threshold,omitempty"`
+
+	// ModelPeekKB bounds how many kilobytes of a request body are read to find the model name
+	// for request logs. 0 uses the default of 64 and a negative value disables body peeking.
+	ModelPeekKB int `yaml:"model-peek-kb,omitempty" json:"model-peek-kb,omitempty"`
+
 	// LogsMaxTotalSizeMB limits the total size (in MB) of log files under the logs directory.
 	// When exceeded, the oldest log files are deleted until within the limit. Set to 0 to disable.
 	LogsMaxTotalSizeMB int `yaml:"logs-max-total-size-mb" json:"logs-max-total-size-mb"`
@@ -67,6 +95,27 @@ type Config struct {
 	// UsageStatisticsCache configures Redis caching for usage statistics.
 	UsageStatisticsCache RedisCacheConfig `yaml:"usage-statistics-cache" json:"usage-statistics-cache"`
 
+	// UsagePricing maps model names to per-1K-token prices used to estimate request cost.
+	UsagePricing map[string]ModelPricing `yaml:"usage-pricing,omitempty" json:"usage-pricing,omitempty"`
+
+	// UsagePlans names per-1K-token prices that UsageAPIKeys can assign to client API keys in
+	// place of UsagePricing, for customers with negotiated rates.
+	UsagePlans map[string]ModelPricing `yaml:"usage-plans,omitempty" json:"usage-plans,omitempty"`
+
+	// UsageAPIKeys attributes client API keys to a tenant and a plan for cost accounting.
+	UsageAPIKeys []UsageAPIKey `yaml:"usage-api-keys,omitempty" json:"usage-api-keys,omitempty"`
+
+	// UsageMetricsPush periodically pushes usage statistics to a Pushgateway or a
+	// Prometheus remote-write endpoint.
+	UsageMetricsPush MetricsPushConfig `yaml:"usage-metrics-push,omitempty" json:"usage-metrics-push,omitempty"`
+
+	// UsageMetrics serves usage statistics at GET /metrics for Prometheus to scrape.
+	UsageMetrics UsageMetricsConfig `yaml:"usage-metrics,omitempty" json:"usage-metrics,omitempty"`
+
+	// Notifications sends critical auth events (token suspensions, providers losing all
+	// models, failing background refresh) to a webhook.
+	Notifications NotificationConfig `yaml:"notifications,omitempty" json:"notifications,omitempty"`
+
 	// DisableCooling disables quota cooldown scheduling when true.
 	DisableCooling bool `yaml:"disable-cooling" json:"disable-cooling"`
 
@@ -94,6 +143,12 @@ type Config struct {
 	// Values: "ide" (default, CodeWhisperer) or "cli" (Amazon Q).
 	KiroPreferredEndpoint string `yaml:"kiro-preferred-endpoint" json:"kiro-preferred-endpoint"`
 
+	// KiroAuth tunes the interactive Kiro login flows (device code, social, auth code).
+	KiroAuth KiroAuthConfig `yaml:"kiro-auth" json:"kiro-auth"`
+
+	// KiroRateLimit tunes the per-token Kiro request rate limiter.
+	KiroRateLimit KiroRateLimitConfig `yaml:"kiro-rate-limit,omitempty" json:"kiro-rate-limit,omitempty"`
+
 	// Codex defines a list of Codex API key configurations as specified in the YAML configuration file.
 	CodexKey []CodexKey `yaml:"codex-api-key" json:"codex-api-key"`
 
@@ -114,6 +169,9 @@ type Config struct {
 	// Supported channels: gemini-cli, vertex, aistudio, antigravity, claude, codex, qwen, iflow, kiro, github-copilot.
 	OAuthExcludedModels map[string][]string `yaml:"oauth-excluded-models,omitempty" json:"oauth-excluded-models,omitempty"`
 
+	// ModelAvailability holds manual model availability overrides managed via the management API.
+	ModelAvailability ModelAvailabilityConfig `yaml:"model-availability,omitempty" json:"model-availability,omitempty"`
+
 	// OAuthModelAlias defines global model name aliases for OAuth/file-backed auth channels.
 	// These aliases affect both model listing and model routing for supported channels:
 	// gemini-cli, vertex, aistudio, antigravity, claude, codex, qwen, iflow, kiro, github-copilot.
@@ -191,18 +249,131 @@ type RedisCacheConfig struct {
 	Password string `yaml:"password" json:"-"`
 	// DB is the Redis database number.
 	DB int `yaml:"db" json:"db"`
-	// KeyPrefix is the prefix for Redis keys.
+	// KeyPrefix is the prefix for Redis keys. Usage statistics scripts build some keys from it,
+	// so on Redis Cluster it must contain a hash tag such as "{cliproxy}:usage:".
 	KeyPrefix string `yaml:"key-prefix" json:"key-prefix"`
 	// TTL is the expiration time in seconds (default: 86400 = 1 day).
 	TTL int `yaml:"ttl" json:"ttl"`
+	// MinTTL is the smallest TTL in seconds accepted for stats keys; lower TTLs are clamped up
+	// to it with a warning. 0 uses DefaultRedisMinTTL, a negative value disables the floor.
+	MinTTL int `yaml:"min-ttl,omitempty" json:"min-ttl,omitempty"`
+	// TTLJitterPercent adds up to this percentage of the TTL as random jitter to each stats key,
+	// so keys written together do not all expire at the same moment. 0 disables jitter.
+	TTLJitterPercent int `yaml:"ttl-jitter-percent,omitempty" json:"ttl-jitter-percent,omitempty"`
+	// MergeConcurrency bounds the workers used to merge imported snapshots, one API key per worker,
+	// in both the in-memory and the Redis store. 0 uses GOMAXPROCS.
+	MergeConcurrency int `yaml:"merge-concurrency,omitempty" json:"merge-concurrency,omitempty"`
+	// SnapshotCacheTTLMs serves repeated Snapshot() calls from an in-process copy for this many
+	// milliseconds; any write invalidates it. 0 disables the cache.
+	SnapshotCacheTTLMs int `yaml:"snapshot-cache-ttl-ms,omitempty" json:"snapshot-cache-ttl-ms,omitempty"`
+	// Granularities selects the time buckets to aggregate: any of "minute", "hour", "day", "month".
+	// Only the listed buckets are stored. Empty keeps the default of hour and day.
+	Granularities []string `yaml:"granularities,omitempty" json:"granularities,omitempty"`
+	// MaxDetailsPerModel caps the request details retained per API key and model; the oldest
+	// are dropped first while the aggregate counters keep counting every request.
+	// 0 uses DefaultUsageMaxDetailsPerModel, a negative value keeps every detail.
+	MaxDetailsPerModel int `yaml:"max-details-per-model,omitempty" json:"max-details-per-model,omitempty"`
+	// DayRetentionDays is how many days of day buckets, and of the per-day usage behind range
+	// queries, are kept; older days are pruned while the aggregate totals keep counting them.
+	// 0 uses DefaultUsageDayRetentionDays, a negative value keeps every day.
+	DayRetentionDays int `yaml:"day-retention-days,omitempty" json:"day-retention-days,omitempty"`
+	// MinuteRetentionHours is how many hours of minute buckets are kept. Hour buckets are
+	// keyed by hour of day and never hold more than 24 entries, so they need no retention.
+	// 0 uses DefaultUsageMinuteRetentionHours, a negative value keeps every minute.
+	MinuteRetentionHours int `yaml:"minute-retention-hours,omitempty" json:"minute-retention-hours,omitempty"`
+}
+
+// ModelPricing holds per-1K-token prices for a model, in the operator's billing currency.
+type ModelPricing struct {
+	Input  float64 `yaml:"input" json:"input"`
+	Output float64 `yaml:"output" json:"output"`
+	Cached float64 `yaml:"cached" json:"cached"`
+}
+
+// UsageAPIKey assigns a client API key (from top-level api-keys) a tenant and a plan.
+type UsageAPIKey struct {
+	APIKey string `yaml:"api-key" json:"api-key"`
+	// Tenant receives the key's request cost in the per-tenant totals. Empty uses the key.
+	Tenant string `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+	// Plan names the usage-plans entry that prices the key's requests. Empty uses usage-pricing.
+	Plan string `yaml:"plan,omitempty" json:"plan,omitempty"`
+}
+
+// MetricsPushConfig configures pushing usage statistics to Prometheus.
+type MetricsPushConfig struct {
+	// Mode selects the push protocol: "pushgateway" or "remote-write". Empty disables pushing.
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// URL is the Pushgateway base URL or the remote-write endpoint.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	// Interval is the push interval in seconds. 0 means 60 seconds.
+	Interval int `yaml:"interval,omitempty" json:"interval,omitempty"`
+	// Job is the job label attached to pushed series. Empty means "cli-proxy-api".
+	Job string `yaml:"job,omitempty" json:"job,omitempty"`
+	// Headers are extra HTTP headers sent with each push, e.g. Authorization.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// LogRedactionConfig configures the redaction of secrets in request logs.
+type LogRedactionConfig struct {
+	// Enable replaces bearer tokens, known API key formats and API key accounts in request log
+	// fields with a keyed hash.
+	Enable bool `yaml:"enable,omitempty" json:"enable,omitempty"`
+	// HashKey keys the hash, so a secret hashes to the same value across restarts and
+	// instances sharing the key. Empty uses a random key per process.
+	HashKey string `yaml:"hash-key,omitempty" json:"hash-key,omitempty"`
+}
+
+// BodyLoggingConfig configures the debug logging of request and response bodies.
+type BodyLoggingConfig struct {
+	// Enable logs request and response bodies as request_body and response_body fields of a
+	// debug entry. It only takes effect while debug logging is on.
+	Enable bool `yaml:"enable,omitempty" json:"enable,omitempty"`
+	// MaxKB truncates each logged body to this many kilobytes. 0 uses the default of 16.
+	MaxKB int `yaml:"max-kb,omitempty" json:"max-kb,omitempty"`
+}
+
+// UsageMetricsConfig configures the Prometheus scrape endpoint for usage statistics.
+type UsageMetricsConfig struct {
+	// Enable serves the metrics at GET /metrics. The endpoint is not authenticated.
+	Enable bool `yaml:"enable,omitempty" json:"enable,omitempty"`
+	// APIKeyLabel controls how API keys appear in the api_key label: "hash" (default) keeps
+	// a short SHA-256 digest, "truncate" keeps the first characters and "raw" the full key.
+	// It also applies to the series sent by usage-metrics-push.
+	APIKeyLabel string `yaml:"api-key-label,omitempty" json:"api-key-label,omitempty"`
+}
+
+// NotificationConfig configures the webhook notified on critical auth events.
+type NotificationConfig struct {
+	// WebhookURL receives a JSON POST per event. The payload carries a "text" field, so Slack
+	// incoming webhooks can be used directly. Empty disables notifications.
+	WebhookURL string `yaml:"webhook-url,omitempty" json:"webhook-url,omitempty"`
+	// Headers are extra HTTP headers sent with each notification, e.g. Authorization.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Events lists the event types to send: "token_suspended", "provider_unavailable" and
+	// "refresh_failing". Empty sends all of them.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+	// MinInterval is the minimum time in seconds between notifications for the same event type
+	// and subject. 0 means 300 seconds; a negative value disables the rate limit.
+	MinInterval int `yaml:"min-interval,omitempty" json:"min-interval,omitempty"`
+	// RefreshFailureCycles is how many consecutive background refresh cycles must fail every
+	// refresh before "refresh_failing" is sent. 0 means 3.
+	RefreshFailureCycles int `yaml:"refresh-failure-cycles,omitempty" json:"refresh-failure-cycles,omitempty"`
 }
 
 const (
 	// DefaultRedisCacheTTL is the default TTL for Redis cache entries.
 	// -1 means never expire (permanent)
 	DefaultRedisCacheTTL = -1
+	// DefaultRedisMinTTL is the default lower bound (in seconds) for Redis cache TTLs.
+	DefaultRedisMinTTL = 300
 	// DefaultRedisKeyPrefix is the default prefix for Redis keys.
 	DefaultRedisKeyPrefix = "cliproxy:usage:"
+	// DefaultUsageMaxDetailsPerModel is the default number of request details kept per model.
+	DefaultUsageMaxDetailsPerModel = 10000
+	// DefaultUsageDayRetentionDays is the default number of days of day buckets kept.
+	DefaultUsageDayRetentionDays = 90
+	// DefaultUsageMinuteRetentionHours is the default number of hours of minute buckets kept.
+	DefaultUsageMinuteRetentionHours = 48
 )
 
 // OAuthModelAlias defines a model ID alias for a specific channel.
@@ -496,6 +667,175 @@ type KiroKey struct {
 	PreferredEndpoint string `yaml:"preferred-endpoint,omitempty" json:"preferred-endpoint,omitempty"`
 }
 
+// KiroAuthConfig tunes the interactive Kiro login flows.
+type KiroAuthConfig struct {
+	// DeviceCodeInactivityTimeout aborts a device-code login (in seconds) when the user has not
+	// completed authorization within this window, independent of the server-side code expiry.
+	// 0 uses the default (180 seconds); a negative value disables the inactivity timeout.
+	DeviceCodeInactivityTimeout int `yaml:"device-code-inactivity-timeout,omitempty" json:"device-code-inactivity-timeout,omitempty"`
+
+	// UserInfoRetries is how many times a rate-limited (429) userinfo lookup is retried before
+	// falling back to JWT parsing. 0 uses the default (3); a negative value disables retries.
+	UserInfoRetries int `yaml:"userinfo-retries,omitempty" json:"userinfo-retries,omitempty"`
+
+	// IDCLoginTimeout caps (in seconds) how long an AWS Identity Center login waits for the
+	// user to authorize, for scripted logins that must bound their runtime. 0 leaves the wait
+	// to the device code expiry and login-session-max-age.
+	IDCLoginTimeout int `yaml:"idc-login-timeout,omitempty" json:"idc-login-timeout,omitempty"`
+
+	// LoginSessionMaxAge is how long (in seconds) a pending login stays alive across the
+	// device-code, social and auth-code flows before its callback server or session is
+	// torn down. 0 or a negative value uses the default (600 seconds).
+	LoginSessionMaxAge int `yaml:"login-session-max-age,omitempty" json:"login-session-max-age,omitempty"`
+
+	// TokenRequestTimeout bounds each token exchange and refresh request, in seconds, so one
+	// stalled request does not consume the whole login window. 0 uses the default (15 seconds);
+	// a negative value leaves only the auth HTTP client's 30-second timeout.
+	TokenRequestTimeout int `yaml:"token-request-timeout,omitempty" json:"token-request-timeout,omitempty"`
+
+	// JSONOutput makes the CLI login flows write only JSON events to stdout (the verification
+	// or authorize URL, then the token data or an error) and print human messages to stderr.
+	// The --json flag enables it for a single login.
+	JSONOutput bool `yaml:"json-output,omitempty" json:"json-output,omitempty"`
+
+	// CopyUserCode copies the device-code login's user code to the system clipboard so it can
+	// be pasted on the verification page. Best-effort; skipped where no clipboard is available.
+	CopyUserCode bool `yaml:"copy-user-code,omitempty" json:"copy-user-code,omitempty"`
+
+	// OIDCEndpoint overrides the AWS SSO OIDC base URL (e.g. "https://oidc.us-east-1.amazonaws.com")
+	// for every region. Intended for testing against a fake server or routing through a gateway.
+	OIDCEndpoint string `yaml:"oidc-endpoint,omitempty" json:"oidc-endpoint,omitempty"`
+
+	// OIDCScopes overrides the CodeWhisperer scopes requested when registering OIDC clients and
+	// in the auth-code authorize URL, e.g. to drop "codewhisperer:transformations" for IAM
+	// Identity Center permission sets that do not grant it. Empty uses the default list.
+	OIDCScopes []string `yaml:"oidc-scopes,omitempty" json:"oidc-scopes,omitempty"`
+
+	// OIDCRequestAttempts is how many times OIDC client registration and device authorization
+	// requests are attempted when AWS throttles (429), returns a 5xx or the connection fails.
+	// 0 uses the default (3); a negative value disables retries.
+	OIDCRequestAttempts int `yaml:"oidc-request-attempts,omitempty" json:"oidc-request-attempts,omitempty"`
+
+	// CallbackHost is the loopback address the local OAuth callback servers bind to and use in
+	// their redirect URIs, e.g. "::1" for IPv6-only setups. Empty keeps each flow's default:
+	// 127.0.0.1 for the AWS auth-code flow and localhost for Kiro social login.
+	CallbackHost string `yaml:"callback-host,omitempty" json:"callback-host,omitempty"`
+
+	// AuthCodeCallbackPort pins the localhost port of the auth-code login callback server, for
+	// identity providers that only allow pre-registered redirect URIs. When the port is busy
+	// the login fails instead of falling back to a random port. 0 uses 19877, then any free port.
+	AuthCodeCallbackPort int `yaml:"auth-code-callback-port,omitempty" json:"auth-code-callback-port,omitempty"`
+
+	// AuthCodeCallbackPortMax extends AuthCodeCallbackPort to the range AuthCodeCallbackPort..
+	// AuthCodeCallbackPortMax; the first free port is used. 0 pins the single port.
+	AuthCodeCallbackPortMax int `yaml:"auth-code-callback-port-max,omitempty" json:"auth-code-callback-port-max,omitempty"`
+
+	// FallbackLabelTemplate labels social (GitHub/Google) tokens whose JWT carries no email,
+	// e.g. "{provider}-user-{sub}@example.internal". Placeholders: {provider}, {sub} and
+	// {preferred_username}; templates without {sub} or {preferred_username} would give every
+	// account the same label and are ignored. Empty keeps the interactive prompt or generated
+	// file names.
+	FallbackLabelTemplate string `yaml:"fallback-label-template,omitempty" json:"fallback-label-template,omitempty"`
+
+	// AccountLabel names social (GitHub/Google) tokens whose JWT carries no email, skipping the
+	// interactive prompt. The --account-label flag sets it for a single login.
+	AccountLabel string `yaml:"account-label,omitempty" json:"account-label,omitempty"`
+
+	// ProfileArnBackfill controls what happens when a social token has no stored profile ARN.
+	// "first-use" (default) looks the ARN up with the token's access token on first use and
+	// saves it to the auth file; "off" sends requests without one, as before.
+	ProfileArnBackfill string `yaml:"profile-arn-backfill,omitempty" json:"profile-arn-backfill,omitempty"`
+
+	// ClientName is the client name sent when registering OIDC clients. Empty uses "Kiro IDE".
+	ClientName string `yaml:"client-name,omitempty" json:"client-name,omitempty"`
+
+	// UserAgentVersion is the Kiro version sent in the auth User-Agent headers, e.g. "0.7.45",
+	// so a version AWS stops accepting can be bumped without a rebuild. Empty keeps the
+	// built-in User-Agents.
+	UserAgentVersion string `yaml:"user-agent-version,omitempty" json:"user-agent-version,omitempty"`
+
+	// Transport tunes the HTTP transport shared by the Kiro/AWS auth clients. When any field is
+	// set, the clients share one pooled transport per proxy setting instead of one each.
+	Transport KiroTransportConfig `yaml:"transport,omitempty" json:"transport,omitempty"`
+
+	// CallbackPages customizes the pages the local login callback servers show in the browser.
+	CallbackPages KiroCallbackPagesConfig `yaml:"callback-pages,omitempty" json:"callback-pages,omitempty"`
+}
+
+// KiroTransportConfig tunes connection reuse for the Kiro/AWS auth clients.
+type KiroTransportConfig struct {
+	// MaxIdleConns caps idle connections across all hosts. 0 uses the default (100).
+	MaxIdleConns int `yaml:"max-idle-conns,omitempty" json:"max-idle-conns,omitempty"`
+	// MaxIdleConnsPerHost caps idle connections kept per host. 0 uses the default (16).
+	MaxIdleConnsPerHost int `yaml:"max-idle-conns-per-host,omitempty" json:"max-idle-conns-per-host,omitempty"`
+	// IdleConnTimeout closes idle connections after this many seconds. 0 uses the default (90).
+	IdleConnTimeout int `yaml:"idle-conn-timeout,omitempty" json:"idle-conn-timeout,omitempty"`
+	// KeepAlive is the TCP keep-alive period in seconds. 0 uses the default (30);
+	// a negative value disables TCP keep-alives.
+	KeepAlive int `yaml:"keep-alive,omitempty" json:"keep-alive,omitempty"`
+	// DisableHTTP2 forces HTTP/1.1.
+	DisableHTTP2 bool `yaml:"disable-http2,omitempty" json:"disable-http2,omitempty"`
+	// HTTP2ReadIdleTimeout sends an HTTP/2 health-check ping after this many idle seconds so
+	// dead connections are detected before reuse. 0 disables health checks.
+	HTTP2ReadIdleTimeout int `yaml:"http2-read-idle-timeout,omitempty" json:"http2-read-idle-timeout,omitempty"`
+}
+
+// IsZero reports whether no transport tuning is configured.
+func (c KiroTransportConfig) IsZero() bool {
+	return c == KiroTransportConfig{}
+}
+
+// KiroCallbackPagesConfig customizes the browser pages of the local login callback servers.
+// Unset fields keep the built-in pages.
+type KiroCallbackPagesConfig struct {
+	// SuccessTemplate is the path of an html/template file shown after a successful login.
+	SuccessTemplate string `yaml:"success-template,omitempty" json:"success-template,omitempty"`
+	// FailureTemplate is the path of an html/template file shown when a login fails; the
+	// failure reason is available as {{.Error}}.
+	FailureTemplate string `yaml:"failure-template,omitempty" json:"failure-template,omitempty"`
+	// SuccessRedirectURL redirects the browser here (e.g. an app deep link) after a successful
+	// login instead of showing a success page.
+	SuccessRedirectURL string `yaml:"success-redirect-url,omitempty" json:"success-redirect-url,omitempty"`
+	// KeepWindowOpen stops the built-in success page from trying to close its window.
+	KeepWindowOpen bool `yaml:"keep-window-open,omitempty" json:"keep-window-open,omitempty"`
+}
+
+// KiroRateLimitConfig tunes the per-token Kiro request rate limiter.
+type KiroRateLimitConfig struct {
+	// DailyIdleDecayPerMinute subtracts this many requests from a token's daily count for
+	// every idle minute, floored at zero, so a token that paused mid-day regains headroom
+	// before the daily reset. The daily cap still applies. 0 disables the decay.
+	DailyIdleDecayPerMinute int `yaml:"daily-idle-decay-per-minute,omitempty" json:"daily-idle-decay-per-minute,omitempty"`
+
+	// SuspendStatusCodes lists upstream HTTP statuses (e.g. 403, 451) that mark a token suspended
+	// immediately, regardless of the response body. Empty keeps body-based detection only.
+	SuspendStatusCodes []int `yaml:"suspend-status-codes,omitempty" json:"suspend-status-codes,omitempty"`
+
+	// DailyResetCheckInterval is how often (in seconds) daily counters of all tracked tokens are
+	// checked for the daily reset, so idle tokens do not carry yesterday's count into their next
+	// request. 0 uses the default (60 seconds); a negative value resets only when a token is used.
+	DailyResetCheckInterval int `yaml:"daily-reset-check-interval,omitempty" json:"daily-reset-check-interval,omitempty"`
+
+	// SharedState keeps daily counts, cooldowns and suspensions in the usage-statistics-cache
+	// Redis so every replica behind a load balancer sees the same token state. It requires
+	// usage-statistics-cache to be enabled; while Redis is unreachable each replica falls back
+	// to its local state. Each token's shared state is read at most once a second and in the
+	// background during scheduling, so changes from other replicas apply within about a second.
+	SharedState bool `yaml:"shared-state,omitempty" json:"shared-state,omitempty"`
+}
+
+// ModelAvailabilityConfig lists models whose availability is forced by the operator.
+type ModelAvailabilityConfig struct {
+	// Deny lists models that are always reported unavailable and never routed.
+	Deny []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+	// Allow lists models that stay available regardless of quota or suspension signals.
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	// MaxConcurrency caps concurrent in-flight requests per upstream model, after aliases are
+	// resolved; requests beyond the limit wait for a free slot instead of overshooting the
+	// provider's ceiling.
+	MaxConcurrency map[string]int `yaml:"max-concurrency,omitempty" json:"max-concurrency,omitempty"`
+}
+
 // OpenAICompatibility represents the configuration for OpenAI API compatibility
 // with external providers, allowing model aliases to be routed through OpenAI API format.
 type OpenAICompatibility struct {
@@ -687,6 +1027,8 @@ func LoadConfigOptional(configFile string, optional bool) (*Config, error) {
 
 	// Normalize OAuth provider model exclusion map.
 	cfg.OAuthExcludedModels = NormalizeOAuthExcludedModels(cfg.OAuthExcludedModels)
+	cfg.ModelAvailability.Deny = NormalizeExcludedModels(cfg.ModelAvailability.Deny)
+	cfg.ModelAvailability.Allow = NormalizeExcludedModels(cfg.ModelAvailability.Allow)
 
 	// Normalize global OAuth model name aliases.
 	cfg.SanitizeOAuthModelAlias()
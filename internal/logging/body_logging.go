@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultBodyLogLimit is the number of bytes of each body logged unless configured otherwise.
+const defaultBodyLogLimit = 16 << 10
+
+// ginBodiesMessage is the message of the debug entries carrying request and response bodies.
+const ginBodiesMessage = "request bodies"
+
+// bodyLogLimit is the number of bytes of each body logged; 0 disables body logging.
+var bodyLogLimit atomic.Int64
+
+// sensitiveKeyPattern matches the names of JSON and form fields whose values are secrets.
+const sensitiveKeyPattern = `[^"=&]*(?:token|secret|password|passwd|api[_-]?key|apikey|key)[^"=&]*`
+
+var (
+	// sensitiveJSONField matches a JSON string member with a sensitive name, capturing the
+	// part up to the opening quote of the value and the value itself.
+	sensitiveJSONField = regexp.MustCompile(`(?i)("` + sensitiveKeyPattern + `"\s*:\s*")((?:[^"\\]|\\.)*)"`)
+	// sensitiveFormField matches a form or query parameter with a sensitive name.
+	sensitiveFormField = regexp.MustCompile(`(?i)((?:^|[&?])` + sensitiveKeyPattern + `=)([^&\s]*)`)
+)
+
+var (
+	bodyRedactorOnce sync.Once
+	bodyRedactor     *logRedactor
+)
+
+// SetBodyLogging toggles the debug logging of request and response bodies, truncated to maxKB
+// kilobytes each (0 uses the default of 16KB). Bodies are only captured while debug logging
+// is enabled as well.
+func SetBodyLogging(enabled bool, maxKB int) {
+	if !enabled {
+		bodyLogLimit.Store(0)
+		return
+	}
+	limit := int64(defaultBodyLogLimit)
+	if maxKB > 0 {
+		limit = int64(maxKB) << 10
+	}
+	bodyLogLimit.Store(limit)
+}
+
+// bodyLoggingLimit returns the number of bytes of each body to capture for the current request,
+// or 0 when body logging is disabled or debug entries would be dropped anyway.
+func bodyLoggingLimit() int64 {
+	limit := bodyLogLimit.Load()
+	if limit <= 0 || !log.IsLevelEnabled(log.DebugLevel) {
+		return 0
+	}
+	return limit
+}
+
+// formatLoggedBody renders a captured body for logging. Secrets are always redacted, with the
+// configured log redaction key when redaction is enabled and a per-process key otherwise:
+// values of JSON members and form fields named like tokens, secrets, passwords or keys, and
+// any value matching secretPatterns.
+func formatLoggedBody(body []byte, truncated bool) string {
+	r := logRedaction.Load()
+	if r == nil {
+		bodyRedactorOnce.Do(func() {
+			bodyRedactor = newRandomLogRedactor()
+		})
+		r = bodyRedactor
+	}
+	value := redactSensitiveFields(r, string(body))
+	value = r.redact(value)
+	if truncated {
+		value += "...[truncated]"
+	}
+	return value
+}
+
+// redactSensitiveFields replaces the values of sensitive JSON members and form fields in body.
+func redactSensitiveFields(r *logRedactor, body string) string {
+	for _, pattern := range []*regexp.Regexp{sensitiveJSONField, sensitiveFormField} {
+		body = pattern.ReplaceAllStringFunc(body, func(field string) string {
+			match := pattern.FindStringSubmatch(field)
+			if match[2] == "" {
+				return field
+			}
+			return strings.Replace(field, match[1]+match[2], match[1]+r.hash(match[2]), 1)
+		})
+	}
+	return body
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCategory groups request errors for log aggregation.
+type ErrorCategory string
+
+const (
+	// ErrorCategoryValidation covers malformed or rejected client input.
+	ErrorCategoryValidation ErrorCategory = "validation"
+	// ErrorCategoryUpstream covers failures reported by or while reaching an upstream provider.
+	ErrorCategoryUpstream ErrorCategory = "upstream"
+	// ErrorCategoryAuth covers authentication and authorization failures.
+	ErrorCategoryAuth ErrorCategory = "auth"
+	// ErrorCategoryInternal covers everything else.
+	ErrorCategoryInternal ErrorCategory = "internal"
+)
+
+// LoggedError is a single request error as emitted in the "errors" log field.
+type LoggedError struct {
+	Category ErrorCategory `json:"category"`
+	Message  string        `json:"message"`
+}
+
+// collectGinErrors classifies the errors attached to the context. Handlers can force a
+// category with c.Error(err).SetMeta(logging.ErrorCategoryAuth); otherwise it is derived
+// from the gin error type, the error's own status code, or the response status.
+func collectGinErrors(c *gin.Context) []LoggedError {
+	ginErrors := c.Errors.ByType(gin.ErrorTypePrivate | gin.ErrorTypeBind)
+	if len(ginErrors) == 0 {
+		return nil
+	}
+	status := c.Writer.Status()
+	result := make([]LoggedError, 0, len(ginErrors))
+	for _, ginErr := range ginErrors {
+		if ginErr == nil || ginErr.Err == nil {
+			continue
+		}
+		result = append(result, LoggedError{
+			Category: classifyGinError(ginErr, status),
+			Message:  ginErr.Err.Error(),
+		})
+	}
+	return result
+}
+
+func classifyGinError(ginErr *gin.Error, responseStatus int) ErrorCategory {
+	if category, ok := ginErr.Meta.(ErrorCategory); ok && category != "" {
+		return category
+	}
+	if ginErr.IsType(gin.ErrorTypeBind) {
+		return ErrorCategoryValidation
+	}
+	var statusErr interface{ StatusCode() int }
+	if errors.As(ginErr.Err, &statusErr) && statusErr.StatusCode() > 0 {
+		return categoryForStatus(statusErr.StatusCode())
+	}
+	var httpStatusErr interface{ HTTPStatusCode() int }
+	if errors.As(ginErr.Err, &httpStatusErr) && httpStatusErr.HTTPStatusCode() > 0 {
+		return categoryForStatus(httpStatusErr.HTTPStatusCode())
+	}
+	return categoryForStatus(responseStatus)
+}
+
+func categoryForStatus(status int) ErrorCategory {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorCategoryAuth
+	case status == http.StatusTooManyRequests:
+		return ErrorCategoryUpstream
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout:
+		return ErrorCategoryUpstream
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError:
+		return ErrorCategoryValidation
+	default:
+		return ErrorCategoryInternal
+	}
+}
+
+// formatLoggedErrors renders errors for the human-readable log line.
+func formatLoggedErrors(loggedErrors []LoggedError) string {
+	parts := make([]string, 0, len(loggedErrors))
+	for _, loggedErr := range loggedErrors {
+		parts = append(parts, "["+string(loggedErr.Category)+"] "+loggedErr.Message)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,7 +20,8 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-// aiAPIPrefixes defines path prefixes for AI API requests that should have request ID tracking.
+// aiAPIPrefixes defines the default path prefixes for AI API requests that should have
+// request ID tracking, used unless SetAIAPIPaths configures others.
 var aiAPIPrefixes = []string{
 	"/v1/chat/completions",
 	"/v1/completions",
@@ -29,15 +31,111 @@ var aiAPIPrefixes = []string{
 	"/api/provider/",
 }
 
+// defaultModelPeekLimit is the number of request body bytes read to find the model name.
+const defaultModelPeekLimit = 64 << 10
+
+// modelPeekLimit bounds the request body prefix extractModelFromRequest reads; 0 disables
+// reading the body.
+var modelPeekLimit atomic.Int64
+
+func init() {
+	modelPeekLimit.Store(defaultModelPeekLimit)
+}
+
+// SetModelPeekKB sets how many kilobytes of a request body are read to find its model name.
+// 0 restores the default of 64KB and a negative value disables reading the body.
+func SetModelPeekKB(kb int) {
+	switch {
+	case kb == 0:
+		modelPeekLimit.Store(defaultModelPeekLimit)
+	case kb < 0:
+		modelPeekLimit.Store(0)
+	default:
+		modelPeekLimit.Store(int64(kb) << 10)
+	}
+}
+
+// slowRequestThreshold is the latency above which successful requests are logged as slow;
+// 0 disables the warning.
+var slowRequestThreshold atomic.Int64
+
+// SetSlowRequestThreshold sets the latency above which GinLogrusLogger logs successful
+// requests at warning level with slow=true. A threshold of 0 or less disables the warning.
+func SetSlowRequestThreshold(threshold time.Duration) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	slowRequestThreshold.Store(int64(threshold))
+}
+
+// aiAPIPathSet matches request paths against exact paths and path prefixes.
+type aiAPIPathSet struct {
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+// aiAPIPaths holds the configured AI API paths; nil uses aiAPIPrefixes.
+var aiAPIPaths atomic.Pointer[aiAPIPathSet]
+
+// SetAIAPIPaths replaces the paths that get request ID tracking. An entry starting with "="
+// matches the rest of it exactly, any other entry matches as a path prefix. An empty list
+// restores the default aiAPIPrefixes.
+func SetAIAPIPaths(paths []string) {
+	set := &aiAPIPathSet{exact: make(map[string]struct{})}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if exact, ok := strings.CutPrefix(path, "="); ok {
+			if exact = strings.TrimSpace(exact); exact != "" {
+				set.exact[exact] = struct{}{}
+			}
+			continue
+		}
+		if path != "" {
+			set.prefixes = append(set.prefixes, path)
+		}
+	}
+	if len(set.exact) == 0 && len(set.prefixes) == 0 {
+		aiAPIPaths.Store(nil)
+		return
+	}
+	aiAPIPaths.Store(set)
+}
+
 const skipGinLogKey = "__gin_skip_request_logging__"
 
+// upstreamDurationKey stores the time a request spent waiting on upstream providers.
+const upstreamDurationKey = "cliproxy.upstream_duration"
+
+// ginRequestMessage is the message of request log entries in JSON mode, where the fields
+// already carry everything the text line shows.
+const ginRequestMessage = "request"
+
 // GinLogrusLogger returns a Gin middleware handler that logs HTTP requests and responses
 // using logrus. It captures request details including method, path, status code, latency,
 // client IP, and any error messages. Request ID is only added for AI API requests.
 //
+// An AI API request whose RequestIDHeader carries a valid ID keeps it as its request ID; the
+// request ID is echoed back in that response header.
+//
 // Output format (AI API): [2025-12-23 20:14:10] [info ] | a1b2c3d4 | 200 |       23.559s | ...
 // Output format (others): [2025-12-23 20:14:10] [info ] | -------- | 200 |       23.559s | ...
 //
+// The line ends with the response body size and, when handlers reported one through
+// AddUpstreamDuration, the time spent waiting on upstream providers, which separates upstream
+// latency from proxy overhead.
+//
+// Successful requests slower than the threshold set by SetSlowRequestThreshold are logged
+// at warning level with slow=true, so slow upstreams stand out from the error statuses.
+//
+// With SetLogRedaction enabled, secrets in the path, account and error fields are replaced
+// with a short keyed hash.
+//
+// With SetBodyLogging enabled and debug logging on, AI API requests get a debug entry with the
+// truncated request_body and response_body, secrets redacted.
+//
+// With log-format "json" the message is ginRequestMessage and the details are only logged as
+// fields, with latency in milliseconds.
+//
 // Returns:
 //   - gin.HandlerFunc: A middleware handler for request logging
 func GinLogrusLogger() gin.HandlerFunc {
@@ -46,10 +144,15 @@ func GinLogrusLogger() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		raw := util.MaskSensitiveQuery(c.Request.URL.RawQuery)
 
-		// Only generate request ID for AI API paths
+		// Only assign a request ID for AI API paths, preferring a valid inbound one
 		var requestID string
 		if isAIAPIPath(path) {
-			requestID = GenerateRequestID()
+			header := RequestIDHeader()
+			requestID = sanitizeInboundRequestID(c.GetHeader(header))
+			if requestID == "" {
+				requestID = GenerateRequestID()
+			}
+			c.Header(header, requestID)
 			SetGinRequestID(c, requestID)
 			ctx := WithRequestID(c.Request.Context(), requestID)
 			c.Request = c.Request.WithContext(ctx)
@@ -68,15 +171,37 @@ func GinLogrusLogger() gin.HandlerFunc {
 			model = extractModelFromRequest(c)
 		}
 
+		// Bodies are only buffered while body logging is active, and only for AI API requests:
+		// management routes carry credential files and configuration
+		var requestBody []byte
+		requestBodyComplete := true
+		writer := &countingResponseWriter{ResponseWriter: c.Writer}
+		if bodyLimit := bodyLoggingLimit(); bodyLimit > 0 && isAIAPIPath(path) {
+			requestBody, requestBodyComplete = peekRequestBody(c, bodyLimit)
+			writer.body = &bytes.Buffer{}
+			writer.bodyLimit = int(bodyLimit)
+		}
+		c.Writer = writer
+
 		c.Next()
 
 		if shouldSkipGinRequestLogging(c) {
 			return
 		}
 
+		// The model may lie beyond the body prefix; the auth manager reports it while handling
+		if model == "" {
+			if modelVal, exists := c.Get("cliproxy.model"); exists {
+				if modelStr, ok := modelVal.(string); ok {
+					model = modelStr
+				}
+			}
+		}
+
 		if raw != "" {
 			path = path + "?" + raw
 		}
+		path = redactLogValue(path)
 
 		latency := time.Since(start)
 		if latency > time.Minute {
@@ -88,13 +213,16 @@ func GinLogrusLogger() gin.HandlerFunc {
 		statusCode := c.Writer.Status()
 		clientIP := c.ClientIP()
 		method := c.Request.Method
-		errorMessage := c.Errors.ByType(gin.ErrorTypePrivate).String()
+		loggedErrors := collectGinErrors(c)
+		for i := range loggedErrors {
+			loggedErrors[i].Message = redactLogValue(loggedErrors[i].Message)
+		}
 
 		// Get account info from gin.Context if available
 		var accountInfo string
 		if accountVal, exists := c.Get("cliproxy.account_info"); exists {
 			if accountStr, ok := accountVal.(string); ok {
-				accountInfo = accountStr
+				accountInfo = redactAccountInfo(accountStr)
 			}
 		}
 
@@ -106,6 +234,8 @@ func GinLogrusLogger() gin.HandlerFunc {
 			}
 		}
 
+		upstream, hasUpstream := upstreamDuration(c)
+
 		if requestID == "" {
 			requestID = "--------"
 		}
@@ -122,11 +252,17 @@ func GinLogrusLogger() gin.HandlerFunc {
 		logEntry := log.WithFields(log.Fields{
 			"request_id": requestID,
 			"status":     statusCode,
-			"latency":    latency,
+			"latency":    latency.Milliseconds(),
 			"client_ip":  clientIP,
 			"method":     method,
 			"path":       path,
+			"bytes":      writer.bytes,
 		})
+		logLine += fmt.Sprintf(" | bytes=%d", writer.bytes)
+		if hasUpstream {
+			logEntry = logEntry.WithField("upstream_ms", upstream.Milliseconds())
+			logLine += fmt.Sprintf(" | upstream=%v", upstream.Truncate(time.Millisecond))
+		}
 
 		// Only add provider and model fields if model is not empty
 		if model != "" {
@@ -145,24 +281,113 @@ func GinLogrusLogger() gin.HandlerFunc {
 			logLine += " | account=" + accountInfo
 		}
 
-		if errorMessage != "" {
-			logEntry = logEntry.WithField("error", errorMessage)
-			logLine += " | error=" + errorMessage
+		slow := false
+		if threshold := time.Duration(slowRequestThreshold.Load()); threshold > 0 && latency > threshold {
+			slow = true
+			logEntry = logEntry.WithField("slow", true)
+			logLine += " | slow"
 		}
 
+		if len(loggedErrors) > 0 {
+			categories := make([]string, 0, len(loggedErrors))
+			for _, loggedErr := range loggedErrors {
+				categories = append(categories, string(loggedErr.Category))
+			}
+			logEntry = logEntry.WithFields(log.Fields{
+				"errors":           loggedErrors,
+				"error_categories": categories,
+			})
+			logLine += " | error=" + formatLoggedErrors(loggedErrors)
+		}
+
+		if jsonLogging.Load() {
+			logLine = ginRequestMessage
+		}
 		if statusCode >= http.StatusInternalServerError {
 			logEntry.Error(logLine)
-		} else if statusCode >= http.StatusBadRequest {
+		} else if statusCode >= http.StatusBadRequest || slow {
 			logEntry.Warn(logLine)
 		} else {
 			logEntry.Info(logLine)
 		}
+
+		if writer.body != nil {
+			logEntry.WithFields(log.Fields{
+				"request_body":  formatLoggedBody(requestBody, !requestBodyComplete),
+				"response_body": formatLoggedBody(writer.body.Bytes(), writer.bytes > int64(writer.body.Len())),
+			}).Debug(ginBodiesMessage)
+		}
+	}
+}
+
+// countingResponseWriter counts the response body bytes written through it and, when body is
+// set, keeps the first bodyLimit of them.
+type countingResponseWriter struct {
+	gin.ResponseWriter
+	bytes     int64
+	body      *bytes.Buffer
+	bodyLimit int
+}
+
+func (w *countingResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.capture(data[:n])
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	if w.body != nil {
+		w.capture([]byte(s[:n]))
+	}
+	w.bytes += int64(n)
+	return n, err
+}
+
+// capture keeps written bytes up to bodyLimit when the body is captured.
+func (w *countingResponseWriter) capture(data []byte) {
+	if w.body == nil {
+		return
+	}
+	if remaining := w.bodyLimit - w.body.Len(); remaining > 0 {
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		w.body.Write(data)
 	}
 }
 
+// AddUpstreamDuration adds d to the time the request of c spent waiting on upstream providers,
+// so retries across several upstream calls add up. GinLogrusLogger logs the total.
+func AddUpstreamDuration(c *gin.Context, d time.Duration) {
+	if c == nil || d <= 0 {
+		return
+	}
+	total, _ := upstreamDuration(c)
+	c.Set(upstreamDurationKey, total+d)
+}
+
+// upstreamDuration returns the upstream duration stored in c and whether any was reported.
+func upstreamDuration(c *gin.Context) (time.Duration, bool) {
+	val, exists := c.Get(upstreamDurationKey)
+	if !exists {
+		return 0, false
+	}
+	d, ok := val.(time.Duration)
+	return d, ok
+}
+
 // isAIAPIPath checks if the given path is an AI API endpoint that should have request ID tracking.
 func isAIAPIPath(path string) bool {
-	for _, prefix := range aiAPIPrefixes {
+	prefixes := aiAPIPrefixes
+	if set := aiAPIPaths.Load(); set != nil {
+		if _, ok := set.exact[path]; ok {
+			return true
+		}
+		prefixes = set.prefixes
+	}
+	for _, prefix := range prefixes {
 		if strings.HasPrefix(path, prefix) {
 			return true
 		}
@@ -215,19 +440,40 @@ func shouldSkipGinRequestLogging(c *gin.Context) bool {
 	return ok && flag
 }
 
+// peekedBody replays the peeked prefix of a request body before the unread remainder, while
+// closing the original body.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// peekRequestBody reads at most limit bytes of the request body and puts them back in front
+// of the remainder, so only the prefix is buffered. It reports whether the prefix is the whole
+// body.
+func peekRequestBody(c *gin.Context, limit int64) ([]byte, bool) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody || limit <= 0 {
+		return nil, true
+	}
+	original := c.Request.Body
+	// Read one byte past the limit to tell a body of exactly limit bytes from a longer one
+	prefix, _ := io.ReadAll(io.LimitReader(original, limit+1))
+	c.Request.Body = peekedBody{Reader: io.MultiReader(bytes.NewReader(prefix), original), Closer: original}
+	if int64(len(prefix)) > limit {
+		return prefix[:limit], false
+	}
+	return prefix, true
+}
+
 // extractModelFromRequest attempts to extract the model name from various request formats
 func extractModelFromRequest(c *gin.Context) string {
-	// First try to parse from JSON body (OpenAI, Claude, etc.)
+	// First try to parse from the JSON body prefix (OpenAI, Claude, etc.)
 	// Check common model field names
-	var body []byte
-	if c.Request.Body != nil {
-		body, _ = io.ReadAll(c.Request.Body)
-		// Reset the body so it can be read again by subsequent handlers
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-	}
-
+	body, complete := peekRequestBody(c, modelPeekLimit.Load())
 	if result := gjson.GetBytes(body, "model"); result.Exists() && result.Type == gjson.String {
-		return result.String()
+		// A value cut off by the prefix end lacks its closing quote
+		if complete || (len(result.Raw) >= 2 && strings.HasSuffix(result.Raw, `"`)) {
+			return result.String()
+		}
 	}
 
 	// For Gemini requests, model is in the URL path
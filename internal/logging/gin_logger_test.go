@@ -1,12 +1,18 @@
 package logging
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestGinLogrusRecoveryRepanicsErrAbortHandler(t *testing.T) {
@@ -58,3 +64,420 @@ func TestGinLogrusRecoveryHandlesRegularPanic(t *testing.T) {
 		t.Fatalf("expected 500, got %d", recorder.Code)
 	}
 }
+
+type statusError struct{ code int }
+
+func (e statusError) Error() string   { return "status error" }
+func (e statusError) StatusCode() int { return e.code }
+
+func TestCollectGinErrorsCategories(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Status(http.StatusBadRequest)
+	_ = c.Error(errors.New("bad field"))
+	_ = c.Error(statusError{code: http.StatusBadGateway})
+	_ = c.Error(statusError{code: http.StatusUnauthorized})
+	_ = c.Error(errors.New("tagged")).SetMeta(ErrorCategoryInternal)
+	_ = c.Error(errors.New("public")).SetType(gin.ErrorTypePublic)
+
+	got := collectGinErrors(c)
+	want := []ErrorCategory{ErrorCategoryValidation, ErrorCategoryUpstream, ErrorCategoryAuth, ErrorCategoryInternal}
+	if len(got) != len(want) {
+		t.Fatalf("collectGinErrors() returned %d errors, want %d: %+v", len(got), len(want), got)
+	}
+	for i, category := range want {
+		if got[i].Category != category {
+			t.Errorf("error %d category = %q, want %q", i, got[i].Category, category)
+		}
+	}
+	if got[0].Message != "bad field" {
+		t.Errorf("error 0 message = %q, want %q", got[0].Message, "bad field")
+	}
+}
+
+func TestGinLogrusLoggerJSONFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter := logger.Out, logger.Formatter
+	logger.SetOutput(&buf)
+	setLogFormat(LogFormatJSON)
+	defer func() {
+		jsonLogging.Store(false)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output is not a JSON object: %v\n%s", err, buf.String())
+	}
+	if entry["msg"] != ginRequestMessage {
+		t.Fatalf("msg = %v, want %q", entry["msg"], ginRequestMessage)
+	}
+	if _, ok := entry["latency"].(float64); !ok {
+		t.Fatalf("latency = %#v, want a number of milliseconds", entry["latency"])
+	}
+	if entry["status"] != float64(http.StatusNoContent) || entry["path"] != "/health" {
+		t.Fatalf("entry = %v, want status 204 and path /health", entry)
+	}
+}
+
+func TestGinLogrusLoggerBytesAndUpstreamDuration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter := logger.Out, logger.Formatter
+	logger.SetOutput(&buf)
+	setLogFormat(LogFormatJSON)
+	defer func() {
+		jsonLogging.Store(false)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.GET("/v1/models", func(c *gin.Context) {
+		AddUpstreamDuration(c, 1500*time.Millisecond)
+		AddUpstreamDuration(c, 500*time.Millisecond)
+		c.String(http.StatusOK, "hello")
+	})
+	engine.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output is not a JSON object: %v\n%s", err, buf.String())
+	}
+	if entry["bytes"] != float64(len("hello")) {
+		t.Fatalf("bytes = %v, want %d", entry["bytes"], len("hello"))
+	}
+	if entry["upstream_ms"] != float64(2000) {
+		t.Fatalf("upstream_ms = %v, want 2000", entry["upstream_ms"])
+	}
+
+	buf.Reset()
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	entry = nil
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output is not a JSON object: %v\n%s", err, buf.String())
+	}
+	if entry["bytes"] != float64(0) {
+		t.Fatalf("bytes = %v, want 0", entry["bytes"])
+	}
+	if _, ok := entry["upstream_ms"]; ok {
+		t.Fatalf("upstream_ms = %v, want no field without an upstream call", entry["upstream_ms"])
+	}
+}
+
+func TestIsAIAPIPathConfiguredPaths(t *testing.T) {
+	defer SetAIAPIPaths(nil)
+
+	if !isAIAPIPath("/v1/chat/completions") || isAIAPIPath("/proxy/v1/chat/completions") {
+		t.Fatalf("default paths should match only unprefixed AI API endpoints")
+	}
+
+	SetAIAPIPaths([]string{"/proxy/v1/", "=/custom/generate"})
+	cases := map[string]bool{
+		"/proxy/v1/chat/completions": true,
+		"/proxy/v1/messages":         true,
+		"/custom/generate":           true,
+		"/custom/generate/stream":    false,
+		"/v1/chat/completions":       false,
+	}
+	for path, want := range cases {
+		if got := isAIAPIPath(path); got != want {
+			t.Errorf("isAIAPIPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	SetAIAPIPaths([]string{" ", "="})
+	if !isAIAPIPath("/v1/messages") {
+		t.Fatalf("blank entries should restore the default paths")
+	}
+}
+
+func TestGinLogrusLoggerSlowRequestWarning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter := logger.Out, logger.Formatter
+	logger.SetOutput(&buf)
+	setLogFormat(LogFormatJSON)
+	defer func() {
+		jsonLogging.Store(false)
+		SetSlowRequestThreshold(0)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.GET("/slow", func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	serve := func() map[string]any {
+		buf.Reset()
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("log output is not a JSON object: %v\n%s", err, buf.String())
+		}
+		return entry
+	}
+
+	if entry := serve(); entry["level"] != "info" || entry["slow"] != nil {
+		t.Fatalf("entry = %v, want info without slow field when no threshold is set", entry)
+	}
+
+	SetSlowRequestThreshold(10 * time.Millisecond)
+	if entry := serve(); entry["level"] != "warning" || entry["slow"] != true {
+		t.Fatalf("entry = %v, want warning with slow=true above the threshold", entry)
+	}
+
+	SetSlowRequestThreshold(time.Minute)
+	if entry := serve(); entry["level"] != "info" || entry["slow"] != nil {
+		t.Fatalf("entry = %v, want info without slow field below the threshold", entry)
+	}
+}
+
+func TestGinLogrusLoggerInboundRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer SetRequestIDHeader("")
+
+	var seen string
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		seen = GetRequestID(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("X-Request-Id", "gateway-123.abc")
+	if seen != "gateway-123.abc" || recorder.Header().Get("X-Request-Id") != "gateway-123.abc" {
+		t.Fatalf("request ID = %q, echoed %q, want the inbound ID", seen, recorder.Header().Get("X-Request-Id"))
+	}
+
+	recorder = serve("X-Request-Id", "../../etc/passwd")
+	if seen == "../../etc/passwd" || len(seen) != 8 || recorder.Header().Get("X-Request-Id") != seen {
+		t.Fatalf("request ID = %q, echoed %q, want a generated ID for an invalid inbound one", seen, recorder.Header().Get("X-Request-Id"))
+	}
+
+	SetRequestIDHeader("X-Correlation-Id")
+	recorder = serve("X-Correlation-Id", "corr-42")
+	if seen != "corr-42" || recorder.Header().Get("X-Correlation-Id") != "corr-42" {
+		t.Fatalf("request ID = %q, echoed %q, want the ID of the configured header", seen, recorder.Header().Get("X-Correlation-Id"))
+	}
+}
+
+func TestExtractModelFromRequestPeeksBodyPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer SetModelPeekKB(0)
+	SetModelPeekKB(1)
+
+	padding := strings.Repeat("a", 4<<10)
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "model in prefix", body: `{"model":"gpt-5","input":"` + padding + `"}`, want: "gpt-5"},
+		{name: "model beyond prefix", body: `{"input":"` + padding + `","model":"gpt-5"}`, want: ""},
+		{name: "model cut by prefix", body: `{"input":"` + padding[:1000] + `","model":"gpt-5-long-name"}`, want: ""},
+		{name: "small body", body: `{"model":"claude"}`, want: "claude"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(tc.body))
+			if got := extractModelFromRequest(c); got != tc.want {
+				t.Fatalf("model = %q, want %q", got, tc.want)
+			}
+			rest, err := io.ReadAll(c.Request.Body)
+			if err != nil || string(rest) != tc.body {
+				t.Fatalf("body was not replayed intact (err %v, %d of %d bytes)", err, len(rest), len(tc.body))
+			}
+		})
+	}
+}
+
+func TestGinLogrusLoggerFallsBackToReportedModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter := logger.Out, logger.Formatter
+	logger.SetOutput(&buf)
+	setLogFormat(LogFormatJSON)
+	SetModelPeekKB(1)
+	defer func() {
+		jsonLogging.Store(false)
+		SetModelPeekKB(0)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.POST("/v1/responses", func(c *gin.Context) {
+		_, _ = io.Copy(io.Discard, c.Request.Body)
+		c.Set("cliproxy.model", "gpt-5")
+		c.Status(http.StatusOK)
+	})
+	body := `{"input":"` + strings.Repeat("a", 4<<10) + `","model":"gpt-5"}`
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body)))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output is not a JSON object: %v\n%s", err, buf.String())
+	}
+	if entry["model"] != "gpt-5" {
+		t.Fatalf("model = %v, want the model reported while handling", entry["model"])
+	}
+}
+
+func TestGinLogrusLoggerBodyLogging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter, previousLevel := logger.Out, logger.Formatter, logger.GetLevel()
+	logger.SetOutput(&buf)
+	setLogFormat(LogFormatJSON)
+	defer func() {
+		jsonLogging.Store(false)
+		SetBodyLogging(false, 0)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+		logger.SetLevel(previousLevel)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "echo:"+string(body)+strings.Repeat("z", 2<<10))
+	})
+
+	const secret = "sk-abcdefghijklmnopqrstuvwxyz012345"
+	requestBody := `{"model":"claude","api_key":"` + secret + `"}`
+	serve := func() []map[string]any {
+		buf.Reset()
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+		var entries []map[string]any
+		decoder := json.NewDecoder(&buf)
+		for decoder.More() {
+			var entry map[string]any
+			if err := decoder.Decode(&entry); err != nil {
+				t.Fatalf("log output is not JSON: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	logger.SetLevel(log.DebugLevel)
+	if entries := serve(); len(entries) != 1 {
+		t.Fatalf("got %d entries, want only the request line while body logging is disabled", len(entries))
+	}
+
+	SetBodyLogging(true, 1)
+	logger.SetLevel(log.InfoLevel)
+	if entries := serve(); len(entries) != 1 {
+		t.Fatalf("got %d entries, want only the request line without debug logging", len(entries))
+	}
+
+	logger.SetLevel(log.DebugLevel)
+	entries := serve()
+	if len(entries) != 2 || entries[1]["msg"] != ginBodiesMessage {
+		t.Fatalf("entries = %v, want the request line followed by the bodies", entries)
+	}
+	requestLogged, _ := entries[1]["request_body"].(string)
+	responseLogged, _ := entries[1]["response_body"].(string)
+	if strings.Contains(requestLogged, secret) || strings.Contains(responseLogged, secret) {
+		t.Fatalf("secret leaked into logged bodies: %q / %q", requestLogged, responseLogged)
+	}
+	if !strings.Contains(requestLogged, `"model":"claude"`) || strings.HasSuffix(requestLogged, "[truncated]") {
+		t.Fatalf("request_body = %q, want the whole redacted body", requestLogged)
+	}
+	if !strings.HasPrefix(responseLogged, "echo:") || !strings.HasSuffix(responseLogged, "...[truncated]") {
+		t.Fatalf("response_body = %q, want the truncated response", responseLogged)
+	}
+}
+
+func TestFormatLoggedBodyRedactsSensitiveFields(t *testing.T) {
+	secrets := []string{"aoaAccessValue", "aorRefreshValue", "clientSecretValue", "hunter2", "plainApiKey"}
+	jsonBody := `{"access_token":"aoaAccessValue","refresh_token":"aorRefreshValue","client_secret":"clientSecretValue",` +
+		`"password":"hunter2","api_key":"plainApiKey","expires_in":3600,"token_type":"Bearer","model":"claude"}`
+	formBody := "grant_type=refresh_token&refresh_token=aorRefreshValue&client_secret=clientSecretValue&scope=openid"
+
+	for _, body := range []string{jsonBody, formBody} {
+		logged := formatLoggedBody([]byte(body), false)
+		for _, secret := range secrets {
+			if strings.Contains(logged, secret) {
+				t.Fatalf("secret %q leaked: %s", secret, logged)
+			}
+		}
+		if !strings.Contains(logged, "[redacted:") {
+			t.Fatalf("expected redacted values: %s", logged)
+		}
+	}
+	if logged := formatLoggedBody([]byte(jsonBody), false); !strings.Contains(logged, `"model":"claude"`) || !strings.Contains(logged, `"expires_in":3600`) {
+		t.Fatalf("non-sensitive fields should be kept: %s", logged)
+	}
+	if logged := formatLoggedBody([]byte(formBody), false); !strings.Contains(logged, "scope=openid") {
+		t.Fatalf("non-sensitive form fields should be kept: %s", logged)
+	}
+}
+
+func TestGinLogrusLoggerBodyLoggingSkipsManagementRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	previousOut, previousFormatter, previousLevel := logger.Out, logger.Formatter, logger.GetLevel()
+	logger.SetOutput(&buf)
+	logger.SetLevel(log.DebugLevel)
+	setLogFormat(LogFormatJSON)
+	SetBodyLogging(true, 0)
+	defer func() {
+		jsonLogging.Store(false)
+		SetBodyLogging(false, 0)
+		logger.SetOutput(previousOut)
+		logger.SetFormatter(previousFormatter)
+		logger.SetLevel(previousLevel)
+	}()
+
+	engine := gin.New()
+	engine.Use(GinLogrusLogger())
+	engine.PUT("/v0/management/config.yaml", func(c *gin.Context) {
+		_, _ = io.Copy(io.Discard, c.Request.Body)
+		c.String(http.StatusOK, `{"access_token":"aoaAccessValue"}`)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/v0/management/config.yaml", strings.NewReader("api-keys:\n  - plainApiKey\n")))
+
+	if strings.Contains(buf.String(), "request_body") || strings.Contains(buf.String(), "aoaAccessValue") {
+		t.Fatalf("management route bodies must not be logged: %s", buf.String())
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
@@ -22,15 +24,21 @@ var (
 	logWriter      *lumberjack.Logger
 	ginInfoWriter  *io.PipeWriter
 	ginErrorWriter *io.PipeWriter
+
+	// jsonLogging reports whether log entries are encoded as JSON, see ConfigureLogOutput.
+	jsonLogging atomic.Bool
 )
 
+// LogFormatJSON selects JSON log entries in the log-format setting.
+const LogFormatJSON = "json"
+
 // LogFormatter defines a custom log format for logrus.
 // This formatter adds timestamp, level, request ID, and source location to each log entry.
 // Format: [2025-12-23 20:14:04] [debug] [manager.go:524] | a1b2c3d4 | Use API key sk-9...0RHO for model gpt-5.2
 type LogFormatter struct{}
 
 // logFieldOrder defines the display order for common log fields.
-var logFieldOrder = []string{"provider", "model", "mode", "budget", "level", "original_mode", "original_value", "min", "max", "clamped_to", "error"}
+var logFieldOrder = []string{"provider", "model", "mode", "budget", "level", "original_mode", "original_value", "min", "max", "clamped_to", "error", "request_body", "response_body"}
 
 // Format renders a single log entry with custom formatting.
 func (m *LogFormatter) Format(entry *log.Entry) ([]byte, error) {
@@ -179,10 +187,22 @@ func ConfigureLogOutput(cfg *config.Config) error {
 		log.SetOutput(os.Stdout)
 	}
 
+	setLogFormat(cfg.LogFormat)
 	configureLogDirCleanerLocked(logDir, cfg.LogsMaxTotalSizeMB, protectedPath)
 	return nil
 }
 
+// setLogFormat switches the global formatter between LogFormatter and JSON entries.
+func setLogFormat(format string) {
+	if strings.EqualFold(strings.TrimSpace(format), LogFormatJSON) {
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+		jsonLogging.Store(true)
+		return
+	}
+	log.SetFormatter(&LogFormatter{})
+	jsonLogging.Store(false)
+}
+
 func closeLogOutputs() {
 	writerMu.Lock()
 	defer writerMu.Unlock()
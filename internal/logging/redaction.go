@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// redactedHashLength is the number of hex characters of the hash that replaces a secret.
+const redactedHashLength = 12
+
+// secretPatterns match credentials that may end up in logged request fields: bearer tokens and
+// the key formats of common providers.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+	regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+}
+
+// logRedactor replaces secrets with a keyed hash.
+type logRedactor struct {
+	key []byte
+}
+
+// logRedaction holds the active redactor; nil leaves logged fields unchanged.
+var logRedaction atomic.Pointer[logRedactor]
+
+// SetLogRedaction toggles the redaction of secrets in request log fields. Redacted secrets are
+// replaced with a short HMAC-SHA256 of hashKey, so the same secret stays correlatable across
+// log lines without being recoverable. An empty hashKey uses a random key, which keeps hashes
+// stable only for the lifetime of the process.
+func SetLogRedaction(enabled bool, hashKey string) {
+	if !enabled {
+		logRedaction.Store(nil)
+		return
+	}
+	if hashKey == "" {
+		logRedaction.Store(newRandomLogRedactor())
+		return
+	}
+	logRedaction.Store(&logRedactor{key: []byte(hashKey)})
+}
+
+// newRandomLogRedactor returns a redactor keyed with random bytes.
+func newRandomLogRedactor() *logRedactor {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		key = []byte(GenerateRequestID())
+	}
+	return &logRedactor{key: key}
+}
+
+// hash returns the redacted form of secret.
+func (r *logRedactor) hash(secret string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(secret))
+	return "[redacted:" + hex.EncodeToString(mac.Sum(nil))[:redactedHashLength] + "]"
+}
+
+// redact replaces the secrets matched by secretPatterns in value. Bearer tokens keep their
+// scheme so the redacted value still reads as an authorization.
+func (r *logRedactor) redact(value string) string {
+	for _, pattern := range secretPatterns {
+		value = pattern.ReplaceAllStringFunc(value, func(secret string) string {
+			if fields := strings.Fields(secret); len(fields) == 2 {
+				return fields[0] + " " + r.hash(fields[1])
+			}
+			return r.hash(secret)
+		})
+	}
+	return value
+}
+
+// redactLogValue redacts the secrets in a logged value when redaction is enabled.
+func redactLogValue(value string) string {
+	r := logRedaction.Load()
+	if r == nil || value == "" {
+		return value
+	}
+	return r.redact(value)
+}
+
+// redactAccountInfo redacts an account field of the form "<type>:<info>". API key accounts
+// carry the key itself, which is hashed whatever its format.
+func redactAccountInfo(accountInfo string) string {
+	r := logRedaction.Load()
+	if r == nil {
+		return accountInfo
+	}
+	if key, ok := strings.CutPrefix(accountInfo, "api_key:"); ok && key != "" {
+		return "api_key:" + r.hash(key)
+	}
+	return r.redact(accountInfo)
+}
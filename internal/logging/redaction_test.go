@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactLogValue(t *testing.T) {
+	defer SetLogRedaction(false, "")
+
+	const key = "sk-abcdefghijklmnopqrstuvwxyz012345"
+	message := "upstream rejected Authorization: Bearer abc.def-123 for " + key
+	if got := redactLogValue(message); got != message {
+		t.Fatalf("redaction disabled should leave values unchanged, got %q", got)
+	}
+
+	SetLogRedaction(true, "salt")
+	got := redactLogValue(message)
+	if strings.Contains(got, key) || strings.Contains(got, "abc.def-123") {
+		t.Fatalf("secrets leaked: %q", got)
+	}
+	if !strings.Contains(got, "Bearer [redacted:") {
+		t.Fatalf("bearer scheme should be kept: %q", got)
+	}
+	if again := redactLogValue(message); again != got {
+		t.Fatalf("same secret should hash to the same value: %q != %q", again, got)
+	}
+	if plain := "/v1/chat/completions?alt=sse"; redactLogValue(plain) != plain {
+		t.Fatalf("values without secrets should be unchanged, got %q", redactLogValue(plain))
+	}
+
+	account := redactAccountInfo("api_key:custom-key-without-pattern")
+	if !strings.HasPrefix(account, "api_key:[redacted:") {
+		t.Fatalf("api key account should be hashed, got %q", account)
+	}
+	if email := "oauth:user@example.com"; redactAccountInfo(email) != email {
+		t.Fatalf("oauth account should be unchanged, got %q", redactAccountInfo(email))
+	}
+
+	SetLogRedaction(true, "other-salt")
+	if other := redactLogValue(message); other == got {
+		t.Fatalf("a different hash key should produce different hashes")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,6 +16,55 @@ type requestIDKey struct{}
 // ginRequestIDKey is the Gin context key for request IDs.
 const ginRequestIDKey = "__request_id__"
 
+// DefaultRequestIDHeader is the header checked for an inbound request ID unless
+// SetRequestIDHeader configures another one.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// maxInboundRequestIDLength bounds the inbound request IDs that are accepted.
+const maxInboundRequestIDLength = 64
+
+// requestIDHeader holds the configured inbound request ID header; nil uses
+// DefaultRequestIDHeader.
+var requestIDHeader atomic.Pointer[string]
+
+// SetRequestIDHeader sets the header GinLogrusLogger reads inbound request IDs from and echoes
+// the request ID in. An empty name restores DefaultRequestIDHeader.
+func SetRequestIDHeader(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		requestIDHeader.Store(nil)
+		return
+	}
+	requestIDHeader.Store(&name)
+}
+
+// RequestIDHeader returns the header carrying inbound request IDs.
+func RequestIDHeader() string {
+	if name := requestIDHeader.Load(); name != nil {
+		return *name
+	}
+	return DefaultRequestIDHeader
+}
+
+// sanitizeInboundRequestID returns the inbound request ID if it is safe to log and to use in
+// request log file names, or "" when it is empty, too long or contains other characters than
+// letters, digits, '.', '_' and '-'.
+func sanitizeInboundRequestID(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > maxInboundRequestIDLength {
+		return ""
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return ""
+		}
+	}
+	return id
+}
+
 // GenerateRequestID creates a new 8-character hex request ID.
 func GenerateRequestID() string {
 	b := make([]byte, 4)
@@ -0,0 +1,171 @@
+// Package notify fans critical auth events out to an operator notification sink.
+// Events come from existing hooks (Kiro rate limiter suspensions, model registry
+// availability changes and background refresh cycles); this package only filters,
+// rate limits and delivers them.
+package notify
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Event types sent to the sink.
+const (
+	EventTokenSuspended      = "token_suspended"
+	EventProviderUnavailable = "provider_unavailable"
+	EventRefreshFailing      = "refresh_failing"
+)
+
+const (
+	defaultMinInterval          = 5 * time.Minute
+	defaultRefreshFailureCycles = 3
+	notifyTimeout               = 15 * time.Second
+)
+
+// Event is a critical auth event delivered to a Sink.
+type Event struct {
+	// Type is one of the Event* constants.
+	Type string `json:"type"`
+	// Subject identifies what the event is about, e.g. a token ID or provider name.
+	Subject string `json:"subject"`
+	// Message is a human-readable summary.
+	Message string         `json:"message"`
+	Time    time.Time      `json:"time"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Sink delivers events to an external system.
+type Sink interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Dispatcher filters events by type and rate limits them per type and subject before
+// handing them to a Sink.
+type Dispatcher struct {
+	sink        Sink
+	events      map[string]struct{}
+	minInterval time.Duration
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	now      func() time.Time
+}
+
+// NewDispatcher returns a dispatcher sending the given event types (all when empty) to sink,
+// at most once per minInterval for each type and subject. A non-positive minInterval
+// disables the rate limit.
+func NewDispatcher(sink Sink, events []string, minInterval time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		sink:        sink,
+		minInterval: minInterval,
+		lastSent:    make(map[string]time.Time),
+		now:         time.Now,
+	}
+	for _, eventType := range events {
+		eventType = strings.ToLower(strings.TrimSpace(eventType))
+		if eventType == "" {
+			continue
+		}
+		if d.events == nil {
+			d.events = make(map[string]struct{})
+		}
+		d.events[eventType] = struct{}{}
+	}
+	return d
+}
+
+// Publish sends event asynchronously and reports whether it passed the filters.
+func (d *Dispatcher) Publish(event Event) bool {
+	if d == nil || d.sink == nil || !d.allow(&event) {
+		return false
+	}
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Errorf("notify: sink panic: %v", recovered)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		if err := d.sink.Notify(ctx, event); err != nil {
+			log.Warnf("notify: failed to send %s for %s: %v", event.Type, event.Subject, err)
+		}
+	}()
+	return true
+}
+
+func (d *Dispatcher) allow(event *Event) bool {
+	if d.events != nil {
+		if _, ok := d.events[event.Type]; !ok {
+			return false
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if event.Time.IsZero() {
+		event.Time = now
+	}
+	if d.minInterval <= 0 {
+		return true
+	}
+	key := event.Type + "\x00" + event.Subject
+	if last, ok := d.lastSent[key]; ok && now.Sub(last) < d.minInterval {
+		return false
+	}
+	d.lastSent[key] = now
+	return true
+}
+
+var (
+	mu                   sync.Mutex
+	current              *Dispatcher
+	currentCfg           config.NotificationConfig
+	configured           bool
+	refreshFailureCycles = defaultRefreshFailureCycles
+)
+
+// Configure replaces the global dispatcher to match cfg. An empty webhook URL disables
+// notifications. Calling it with an unchanged configuration keeps the rate limit state.
+func Configure(cfg config.NotificationConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	if configured && reflect.DeepEqual(cfg, currentCfg) {
+		return
+	}
+	configured = true
+	currentCfg = cfg
+
+	refreshFailureCycles = defaultRefreshFailureCycles
+	if cfg.RefreshFailureCycles > 0 {
+		refreshFailureCycles = cfg.RefreshFailureCycles
+	}
+
+	url := strings.TrimSpace(cfg.WebhookURL)
+	if url == "" {
+		current = nil
+		return
+	}
+	minInterval := defaultMinInterval
+	if cfg.MinInterval > 0 {
+		minInterval = time.Duration(cfg.MinInterval) * time.Second
+	} else if cfg.MinInterval < 0 {
+		minInterval = 0
+	}
+	current = NewDispatcher(NewWebhookSink(url, cfg.Headers), cfg.Events, minInterval)
+	log.Infof("notify: sending critical auth events to webhook")
+}
+
+// Publish sends event through the global dispatcher, if notifications are configured.
+func Publish(event Event) bool {
+	mu.Lock()
+	d := current
+	mu.Unlock()
+	return d.Publish(event)
+}
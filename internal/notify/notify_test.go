@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+	done   chan struct{}
+}
+
+func (s *recordingSink) Notify(_ context.Context, event Event) error {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+	s.done <- struct{}{}
+	return nil
+}
+
+func TestDispatcherFiltersAndRateLimits(t *testing.T) {
+	sink := &recordingSink{done: make(chan struct{}, 8)}
+	d := NewDispatcher(sink, []string{EventTokenSuspended}, time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	if d.Publish(Event{Type: EventRefreshFailing, Subject: "kiro"}) {
+		t.Fatal("expected unlisted event type to be dropped")
+	}
+	if !d.Publish(Event{Type: EventTokenSuspended, Subject: "a.json"}) {
+		t.Fatal("expected first event to be sent")
+	}
+	if d.Publish(Event{Type: EventTokenSuspended, Subject: "a.json"}) {
+		t.Fatal("expected repeat within min interval to be dropped")
+	}
+	if !d.Publish(Event{Type: EventTokenSuspended, Subject: "b.json"}) {
+		t.Fatal("expected another subject to be sent")
+	}
+	now = now.Add(time.Minute)
+	if !d.Publish(Event{Type: EventTokenSuspended, Subject: "a.json"}) {
+		t.Fatal("expected event after min interval to be sent")
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-sink.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i+1)
+		}
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 3 || sink.events[0].Time.IsZero() {
+		t.Fatalf("unexpected delivered events: %+v", sink.events)
+	}
+}
+
+func TestWebhookSinkPayload(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing configured header, got %q", r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, map[string]string{"Authorization": "Bearer secret"})
+	err := sink.Notify(context.Background(), Event{Type: EventProviderUnavailable, Subject: "kiro", Message: "all kiro models are unavailable"})
+	if err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got["text"] != "[cli-proxy-api] provider_unavailable: all kiro models are unavailable" || got["subject"] != "kiro" {
+		t.Fatalf("unexpected payload: %v", got)
+	}
+}
+
+func TestProviderAvailabilityNotifiesOnTransition(t *testing.T) {
+	sink := &recordingSink{done: make(chan struct{}, 8)}
+	mu.Lock()
+	current = NewDispatcher(sink, nil, 0)
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+	}()
+
+	ProviderAvailability("test-provider", 0)
+	ProviderAvailability("test-provider", 2)
+	ProviderAvailability("test-provider", 0)
+	ProviderAvailability("test-provider", 0)
+
+	select {
+	case <-sink.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for provider_unavailable")
+	}
+	time.Sleep(50 * time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 1 || sink.events[0].Type != EventProviderUnavailable {
+		t.Fatalf("expected a single provider_unavailable event, got %+v", sink.events)
+	}
+}
+
+func TestProviderAvailabilityHookRequiresWebhook(t *testing.T) {
+	mu.Lock()
+	previous := current
+	current = nil
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		current = previous
+		mu.Unlock()
+	}()
+
+	if ProviderAvailabilityHook() != nil {
+		t.Fatal("expected no availability hook without a webhook")
+	}
+	mu.Lock()
+	current = NewDispatcher(&recordingSink{done: make(chan struct{}, 1)}, nil, 0)
+	mu.Unlock()
+	if ProviderAvailabilityHook() == nil {
+		t.Fatal("expected an availability hook with a webhook configured")
+	}
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/auth/kiro"
+)
+
+// TokenSuspended is the Kiro rate limiter's suspension callback.
+func TokenSuspended(tokenKey, reason string) {
+	Publish(Event{
+		Type:    EventTokenSuspended,
+		Subject: tokenKey,
+		Message: fmt.Sprintf("token %s suspended: %s", tokenKey, reason),
+		Details: map[string]any{"reason": reason},
+	})
+}
+
+var (
+	availabilityMu   sync.Mutex
+	lastAvailability = make(map[string]int)
+)
+
+// ProviderAvailabilityHook returns ProviderAvailability when a webhook is configured and nil
+// otherwise, so the model registry only computes availability when events can be sent.
+func ProviderAvailabilityHook() func(provider string, available int) {
+	mu.Lock()
+	enabled := current != nil
+	mu.Unlock()
+	if !enabled {
+		return nil
+	}
+	return ProviderAvailability
+}
+
+// ProviderAvailability is the model registry's availability callback. It sends an event when a
+// provider that had available models has none left.
+func ProviderAvailability(provider string, available int) {
+	availabilityMu.Lock()
+	previous, seen := lastAvailability[provider]
+	lastAvailability[provider] = available
+	availabilityMu.Unlock()
+	if available > 0 || !seen || previous == 0 {
+		return
+	}
+	Publish(Event{
+		Type:    EventProviderUnavailable,
+		Subject: provider,
+		Message: fmt.Sprintf("all %s models are unavailable", provider),
+		Details: map[string]any{"previously_available": previous},
+	})
+}
+
+var (
+	refreshMu         sync.Mutex
+	refreshFailStreak int
+)
+
+// RefreshCycle is the Kiro background refresher's per-cycle callback. It sends an event once
+// refresh-failure-cycles consecutive cycles have failed every refresh they attempted.
+func RefreshCycle(result kiro.RefreshBatchResult) {
+	if result.Attempted == 0 {
+		return
+	}
+	mu.Lock()
+	threshold := refreshFailureCycles
+	mu.Unlock()
+
+	refreshMu.Lock()
+	if result.Succeeded > 0 || result.Failed == 0 {
+		refreshFailStreak = 0
+		refreshMu.Unlock()
+		return
+	}
+	refreshFailStreak++
+	streak := refreshFailStreak
+	refreshMu.Unlock()
+	if streak != threshold {
+		return
+	}
+	Publish(Event{
+		Type:    EventRefreshFailing,
+		Subject: "kiro",
+		Message: fmt.Sprintf("Kiro background refresh failed every token for %d consecutive cycles", streak),
+		Details: map[string]any{
+			"cycles":    streak,
+			"attempted": result.Attempted,
+			"failed":    result.Failed,
+		},
+	})
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const webhookMaxErrorBodySize = 512
+
+// WebhookSink posts each event as JSON. Besides the event fields the payload carries a
+// "text" summary, which is what Slack-style incoming webhooks display.
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookSink returns a sink posting to url with the extra headers.
+func NewWebhookSink(url string, headers map[string]string) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+type webhookPayload struct {
+	Text string `json:"text"`
+	Event
+}
+
+// Notify implements Sink.
+func (s *WebhookSink) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(webhookPayload{
+		Text:  fmt.Sprintf("[cli-proxy-api] %s: %s", event.Type, event.Message),
+		Event: event,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Errorf("notify: close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxErrorBodySize))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
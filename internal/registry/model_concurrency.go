@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// modelLimiter caps concurrent in-flight requests for a single model.
+type modelLimiter struct {
+	sem      *semaphore.Weighted
+	limit    int64
+	inFlight atomic.Int64
+}
+
+// modelLimiters maps lowercase model IDs to their limiter.
+type modelLimiters map[string]*modelLimiter
+
+// ModelConcurrencyStatus reports the in-flight request count for a model with a limit.
+type ModelConcurrencyStatus struct {
+	ModelID        string `json:"model_id"`
+	InFlight       int    `json:"in_flight"`
+	MaxConcurrency int    `json:"max_concurrency"`
+}
+
+// SetModelConcurrencyLimits replaces the per-model concurrency limits. Models whose limit is
+// unchanged keep their limiter, so in-flight requests stay counted; non-positive limits are ignored.
+func (r *ModelRegistry) SetModelConcurrencyLimits(limits map[string]int) {
+	current := r.concurrency.Load()
+	next := make(modelLimiters, len(limits))
+	for modelID, limit := range limits {
+		key := overrideKey(modelID)
+		if key == "" || limit <= 0 {
+			continue
+		}
+		if current != nil {
+			if existing, ok := (*current)[key]; ok && existing.limit == int64(limit) {
+				next[key] = existing
+				continue
+			}
+		}
+		next[key] = &modelLimiter{sem: semaphore.NewWeighted(int64(limit)), limit: int64(limit)}
+	}
+	r.concurrency.Store(&next)
+}
+
+// AcquireModelSlot blocks until a concurrency slot for modelID is free or ctx is done.
+// The returned release func must be called once the request finishes; it is a no-op
+// for models without a limit.
+func (r *ModelRegistry) AcquireModelSlot(ctx context.Context, modelID string) (release func(), err error) {
+	limiter := r.modelLimiter(modelID)
+	if limiter == nil {
+		return func() {}, nil
+	}
+	if err := limiter.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	limiter.inFlight.Add(1)
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			limiter.inFlight.Add(-1)
+			limiter.sem.Release(1)
+		}
+	}, nil
+}
+
+// ModelConcurrency returns the current in-flight count and the limit for modelID.
+// A zero limit means the model is not limited.
+func (r *ModelRegistry) ModelConcurrency(modelID string) (inFlight, limit int) {
+	limiter := r.modelLimiter(modelID)
+	if limiter == nil {
+		return 0, 0
+	}
+	return int(limiter.inFlight.Load()), int(limiter.limit)
+}
+
+// ModelConcurrencyStatuses lists every limited model with its current in-flight count.
+func (r *ModelRegistry) ModelConcurrencyStatuses() []ModelConcurrencyStatus {
+	limiters := r.concurrency.Load()
+	if limiters == nil {
+		return nil
+	}
+	out := make([]ModelConcurrencyStatus, 0, len(*limiters))
+	for modelID, limiter := range *limiters {
+		out = append(out, ModelConcurrencyStatus{
+			ModelID:        modelID,
+			InFlight:       int(limiter.inFlight.Load()),
+			MaxConcurrency: int(limiter.limit),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ModelID < out[j].ModelID })
+	return out
+}
+
+func (r *ModelRegistry) modelLimiter(modelID string) *modelLimiter {
+	limiters := r.concurrency.Load()
+	if limiters == nil || len(*limiters) == 0 {
+		return nil
+	}
+	return (*limiters)[overrideKey(modelID)]
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestModelConcurrencyLimit(t *testing.T) {
+	r := newTestModelRegistry()
+	r.SetModelConcurrencyLimits(map[string]int{"Model-A": 1, "model-b": 0})
+
+	release, err := r.AcquireModelSlot(context.Background(), "model-a")
+	if err != nil {
+		t.Fatalf("AcquireModelSlot() error = %v", err)
+	}
+	if inFlight, limit := r.ModelConcurrency("model-a"); inFlight != 1 || limit != 1 {
+		t.Fatalf("ModelConcurrency() = %d/%d, want 1/1", inFlight, limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.AcquireModelSlot(ctx, "model-a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second AcquireModelSlot() error = %v, want deadline exceeded", err)
+	}
+
+	// Unchanged limits keep the in-flight count across reloads.
+	r.SetModelConcurrencyLimits(map[string]int{"model-a": 1})
+	if inFlight, _ := r.ModelConcurrency("model-a"); inFlight != 1 {
+		t.Fatalf("in-flight after reload = %d, want 1", inFlight)
+	}
+
+	release()
+	release()
+	if inFlight, _ := r.ModelConcurrency("model-a"); inFlight != 0 {
+		t.Fatalf("in-flight after release = %d, want 0", inFlight)
+	}
+
+	releaseB, err := r.AcquireModelSlot(context.Background(), "model-b")
+	if err != nil {
+		t.Fatalf("AcquireModelSlot(unlimited) error = %v", err)
+	}
+	releaseB()
+	if statuses := r.ModelConcurrencyStatuses(); len(statuses) != 1 || statuses[0].ModelID != "model-a" {
+		t.Fatalf("ModelConcurrencyStatuses() = %+v, want only model-a", statuses)
+	}
+}
@@ -0,0 +1,74 @@
+package registry
+
+import "strings"
+
+// modelOverrides holds operator-forced model availability. Keys are lowercase model IDs.
+type modelOverrides struct {
+	deny  map[string]struct{}
+	allow map[string]struct{}
+}
+
+// SetModelOverrides replaces the manual availability overrides.
+// Denied models are always reported unavailable and are not routed; allowed models
+// ignore quota and suspension signals. A model in both lists is treated as denied.
+func (r *ModelRegistry) SetModelOverrides(deny, allow []string) {
+	overrides := &modelOverrides{
+		deny:  overrideSet(deny),
+		allow: overrideSet(allow),
+	}
+	r.overrides.Store(overrides)
+}
+
+// IsModelDenied reports whether modelID is on the manual deny list.
+func (r *ModelRegistry) IsModelDenied(modelID string) bool {
+	overrides := r.overrides.Load()
+	if overrides == nil || len(overrides.deny) == 0 {
+		return false
+	}
+	_, ok := overrides.deny[overrideKey(modelID)]
+	return ok
+}
+
+// IsModelAllowed reports whether modelID is forced available, ignoring quota and suspension.
+func (r *ModelRegistry) IsModelAllowed(modelID string) bool {
+	overrides := r.overrides.Load()
+	if overrides == nil || len(overrides.allow) == 0 {
+		return false
+	}
+	key := overrideKey(modelID)
+	if _, denied := overrides.deny[key]; denied {
+		return false
+	}
+	_, ok := overrides.allow[key]
+	return ok
+}
+
+// DeniedModels returns the manual deny list.
+func (r *ModelRegistry) DeniedModels() []string {
+	overrides := r.overrides.Load()
+	if overrides == nil {
+		return nil
+	}
+	out := make([]string, 0, len(overrides.deny))
+	for modelID := range overrides.deny {
+		out = append(out, modelID)
+	}
+	return out
+}
+
+func overrideSet(models []string) map[string]struct{} {
+	if len(models) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(models))
+	for _, modelID := range models {
+		if key := overrideKey(modelID); key != "" {
+			set[key] = struct{}{}
+		}
+	}
+	return set
+}
+
+func overrideKey(modelID string) string {
+	return strings.ToLower(strings.TrimSpace(modelID))
+}
@@ -0,0 +1,38 @@
+package registry
+
+import "testing"
+
+func TestModelOverrides(t *testing.T) {
+	r := newTestModelRegistry()
+	r.RegisterClient("client-a", "kiro", []*ModelInfo{{ID: "model-deny"}, {ID: "model-allow"}})
+	r.SuspendClientModel("client-a", "model-allow", "payment required")
+
+	if got := r.GetModelCount("model-allow"); got != 0 {
+		t.Fatalf("GetModelCount(suspended) = %d, want 0", got)
+	}
+
+	r.SetModelOverrides([]string{" Model-Deny "}, []string{"model-allow"})
+
+	if !r.IsModelDenied("model-deny") || r.IsModelAllowed("model-deny") {
+		t.Fatal("model-deny should be denied and not allowed")
+	}
+	if r.ClientSupportsModel("client-a", "model-deny") {
+		t.Error("ClientSupportsModel(denied) = true, want false")
+	}
+	if got := r.GetModelCount("model-deny"); got != 0 {
+		t.Errorf("GetModelCount(denied) = %d, want 0", got)
+	}
+	if got := r.GetModelCount("model-allow"); got != 1 {
+		t.Errorf("GetModelCount(allowed) = %d, want 1", got)
+	}
+
+	available := r.GetAvailableModels("openai")
+	if len(available) != 1 || available[0]["id"] != "model-allow" {
+		t.Errorf("GetAvailableModels() = %v, want only model-allow", available)
+	}
+
+	r.SetModelOverrides(nil, nil)
+	if !r.ClientSupportsModel("client-a", "model-deny") {
+		t.Error("ClientSupportsModel() = false after clearing overrides, want true")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	misc "github.com/router-for-me/CLIProxyAPI/v6/internal/misc"
@@ -116,6 +117,20 @@ type ModelRegistry struct {
 	mutex *sync.RWMutex
 	// hook is an optional callback sink for model registration changes
 	hook ModelRegistryHook
+	// availabilityHook is an optional callback receiving a provider's available model count
+	// after changes that may affect it
+	availabilityHook func(provider string, available int)
+	// pendingAvailability holds the latest count per provider not yet delivered to the
+	// availability hook; availabilityDelivering is set while a goroutine delivers them.
+	// Both are guarded by availabilityMu.
+	availabilityMu         sync.Mutex
+	pendingAvailability    map[string]int
+	availabilityDelivering bool
+	// overrides holds manual model allow/deny lists set by the operator
+	overrides atomic.Pointer[modelOverrides]
+
+	// concurrency holds per-model in-flight request limiters
+	concurrency atomic.Pointer[modelLimiters]
 }
 
 // Global model registry instance
@@ -164,8 +179,69 @@ func (r *ModelRegistry) SetHook(hook ModelRegistryHook) {
 	r.hook = hook
 }
 
+// SetAvailabilityHook sets an optional callback invoked asynchronously with the number of
+// available models of a provider after a registration, quota or suspension change for it.
+// Calls are serialized, and each provider's count is delivered in the order of the changes;
+// counts superseded before delivery are skipped. A nil hook disables the computation.
+func (r *ModelRegistry) SetAvailabilityHook(hook func(provider string, available int)) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.availabilityHook = hook
+}
+
 const defaultModelRegistryHookTimeout = 5 * time.Second
 
+// triggerAvailabilityChanged computes the provider's availability while the caller still
+// holds the registry write lock, so the count matches the change that triggered it, and
+// queues it for the availability hook.
+func (r *ModelRegistry) triggerAvailabilityChanged(provider string) {
+	hook := r.availabilityHook
+	if hook == nil || provider == "" {
+		return
+	}
+	available := len(r.availableModelsByProviderLocked(provider))
+
+	r.availabilityMu.Lock()
+	defer r.availabilityMu.Unlock()
+	if r.pendingAvailability == nil {
+		r.pendingAvailability = make(map[string]int)
+	}
+	r.pendingAvailability[provider] = available
+	if !r.availabilityDelivering {
+		r.availabilityDelivering = true
+		go r.deliverAvailability(hook)
+	}
+}
+
+// deliverAvailability reports queued availability counts to hook one at a time until the
+// queue is empty.
+func (r *ModelRegistry) deliverAvailability(hook func(provider string, available int)) {
+	for {
+		r.availabilityMu.Lock()
+		pending := r.pendingAvailability
+		r.pendingAvailability = nil
+		if len(pending) == 0 {
+			r.availabilityDelivering = false
+			r.availabilityMu.Unlock()
+			return
+		}
+		r.availabilityMu.Unlock()
+		for provider, available := range pending {
+			func() {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						log.Errorf("model registry availability hook panic: %v", recovered)
+					}
+				}()
+				hook(provider, available)
+			}()
+		}
+	}
+}
+
 func (r *ModelRegistry) triggerModelsRegistered(provider, clientID string, models []*ModelInfo) {
 	hook := r.hook
 	if hook == nil {
@@ -262,6 +338,7 @@ func (r *ModelRegistry) RegisterClient(clientID, clientProvider string, models [
 			delete(r.clientProviders, clientID)
 		}
 		r.triggerModelsRegistered(provider, clientID, models)
+		r.triggerAvailabilityChanged(provider)
 		log.Debugf("Registered client %s from provider %s with %d models", clientID, clientProvider, len(rawModelIDs))
 		misc.LogCredentialSeparator()
 		return
@@ -405,6 +482,7 @@ func (r *ModelRegistry) RegisterClient(clientID, clientProvider string, models [
 	}
 
 	r.triggerModelsRegistered(provider, clientID, models)
+	r.triggerAvailabilityChanged(provider)
 	if len(added) == 0 && len(removed) == 0 && !providerChanged {
 		// Only metadata (e.g., display name) changed; skip separator when no log output.
 		return
@@ -590,6 +668,7 @@ func (r *ModelRegistry) unregisterClientInternal(clientID string) {
 	// Separator line after completing client unregistration (after the summary line)
 	misc.LogCredentialSeparator()
 	r.triggerModelsUnregistered(provider, clientID)
+	r.triggerAvailabilityChanged(provider)
 }
 
 // SetModelQuotaExceeded marks a model as quota exceeded for a specific client
@@ -604,6 +683,7 @@ func (r *ModelRegistry) SetModelQuotaExceeded(clientID, modelID string) {
 		now := time.Now()
 		registration.QuotaExceededClients[clientID] = &now
 		log.Debugf("Marked model %s as quota exceeded for client %s", modelID, clientID)
+		r.triggerAvailabilityChanged(r.clientProviders[clientID])
 	}
 }
 
@@ -616,8 +696,12 @@ func (r *ModelRegistry) ClearModelQuotaExceeded(clientID, modelID string) {
 	defer r.mutex.Unlock()
 
 	if registration, exists := r.models[modelID]; exists {
+		if _, exceeded := registration.QuotaExceededClients[clientID]; !exceeded {
+			return
+		}
 		delete(registration.QuotaExceededClients, clientID)
 		// log.Debugf("Cleared quota exceeded status for model %s and client %s", modelID, clientID)
+		r.triggerAvailabilityChanged(r.clientProviders[clientID])
 	}
 }
 
@@ -650,6 +734,7 @@ func (r *ModelRegistry) SuspendClientModel(clientID, modelID, reason string) {
 	} else {
 		log.Debugf("Suspended client %s for model %s", clientID, modelID)
 	}
+	r.triggerAvailabilityChanged(r.clientProviders[clientID])
 }
 
 // ResumeClientModel clears a previous suspension so the client counts toward availability again.
@@ -673,6 +758,7 @@ func (r *ModelRegistry) ResumeClientModel(clientID, modelID string) {
 	delete(registration.SuspendedClients, clientID)
 	registration.LastUpdated = time.Now()
 	log.Debugf("Resumed client %s for model %s", clientID, modelID)
+	r.triggerAvailabilityChanged(r.clientProviders[clientID])
 }
 
 // ClientSupportsModel reports whether the client registered support for modelID.
@@ -682,6 +768,9 @@ func (r *ModelRegistry) ClientSupportsModel(clientID, modelID string) bool {
 	if clientID == "" || modelID == "" {
 		return false
 	}
+	if r.IsModelDenied(modelID) {
+		return false
+	}
 
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -713,7 +802,19 @@ func (r *ModelRegistry) GetAvailableModels(handlerType string) []map[string]any
 	models := make([]map[string]any, 0)
 	quotaExpiredDuration := 5 * time.Minute
 
-	for _, registration := range r.models {
+	for modelID, registration := range r.models {
+		if r.IsModelDenied(modelID) {
+			continue
+		}
+		if r.IsModelAllowed(modelID) {
+			if registration.Count > 0 {
+				if model := r.convertModelToMap(registration.Info, handlerType); model != nil {
+					models = append(models, model)
+				}
+			}
+			continue
+		}
+
 		// Check if model has any non-quota-exceeded clients
 		availableClients := registration.Count
 		now := time.Now()
@@ -762,14 +863,19 @@ func (r *ModelRegistry) GetAvailableModels(handlerType string) []map[string]any
 // Returns:
 //   - []*ModelInfo: List of available models for the provider
 func (r *ModelRegistry) GetAvailableModelsByProvider(provider string) []*ModelInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.availableModelsByProviderLocked(provider)
+}
+
+// availableModelsByProviderLocked implements GetAvailableModelsByProvider; the caller must
+// hold r.mutex.
+func (r *ModelRegistry) availableModelsByProviderLocked(provider string) []*ModelInfo {
 	provider = strings.ToLower(strings.TrimSpace(provider))
 	if provider == "" {
 		return nil
 	}
 
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
 	type providerModel struct {
 		count int
 		info  *ModelInfo
@@ -891,6 +997,12 @@ func (r *ModelRegistry) GetModelCount(modelID string) int {
 	defer r.mutex.RUnlock()
 
 	if registration, exists := r.models[modelID]; exists {
+		if r.IsModelDenied(modelID) {
+			return 0
+		}
+		if r.IsModelAllowed(modelID) {
+			return registration.Count
+		}
 		now := time.Now()
 		quotaExpiredDuration := 5 * time.Minute
 
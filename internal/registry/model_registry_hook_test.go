@@ -202,3 +202,64 @@ func TestModelRegistryHook_PanicDoesNotAffectRegistry(t *testing.T) {
 		t.Fatal("timeout waiting for OnModelsUnregistered hook call")
 	}
 }
+
+func TestModelRegistryAvailabilityHook_SerializesLatestCount(t *testing.T) {
+	r := newTestModelRegistry()
+	entered := make(chan struct{}, 8)
+	release := make(chan struct{})
+	delivered := make(chan int, 8)
+	var active, overlapped sync.Map
+	r.SetAvailabilityHook(func(provider string, available int) {
+		if _, busy := active.LoadOrStore(provider, true); busy {
+			overlapped.Store(provider, true)
+		}
+		entered <- struct{}{}
+		<-release
+		active.Delete(provider)
+		delivered <- available
+	})
+
+	r.RegisterClient("client-1", "kiro", []*ModelInfo{{ID: "m1"}, {ID: "m2"}})
+	select {
+	case <-entered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the first availability delivery")
+	}
+	// The first delivery is blocked; the changes below are queued behind it and coalesced.
+	r.SuspendClientModel("client-1", "m1", "manual")
+	r.SuspendClientModel("client-1", "m2", "manual")
+	r.ResumeClientModel("client-1", "m1")
+	close(release)
+
+	var got []int
+	for len(got) < 2 {
+		select {
+		case available := <-delivered:
+			got = append(got, available)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for availability deliveries, got %v", got)
+		}
+	}
+	select {
+	case available := <-delivered:
+		t.Fatalf("unexpected extra delivery %d after %v", available, got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got[0] != 2 || got[1] != 1 {
+		t.Fatalf("deliveries = %v, want [2 1]: the registration count, then the latest count", got)
+	}
+	if _, ok := overlapped.Load("kiro"); ok {
+		t.Fatal("availability hook calls overlapped")
+	}
+}
+
+func TestModelRegistryAvailabilityHook_NilSkipsComputation(t *testing.T) {
+	r := newTestModelRegistry()
+	r.SetAvailabilityHook(nil)
+	r.RegisterClient("client-1", "kiro", []*ModelInfo{{ID: "m1"}})
+	r.availabilityMu.Lock()
+	defer r.availabilityMu.Unlock()
+	if r.availabilityDelivering || len(r.pendingAvailability) != 0 {
+		t.Fatal("availability was queued without a hook")
+	}
+}
@@ -614,6 +614,32 @@ func getTokenKey(auth *cliproxyauth.Auth) string {
 	return accessToken
 }
 
+// CredentialAvailability reports whether the token is usable now according to the Kiro
+// cooldown manager and rate limiter, and when it is expected to recover otherwise.
+func (e *KiroExecutor) CredentialAvailability(auth *cliproxyauth.Auth) (bool, time.Time) {
+	tokenKey := getTokenKey(auth)
+	if tokenKey == "" {
+		return true, time.Time{}
+	}
+	var retryAt time.Time
+	if cooldownMgr := kiroauth.GetGlobalCooldownManager(); cooldownMgr.IsInCooldown(tokenKey) {
+		retryAt = time.Now().Add(cooldownMgr.GetRemainingCooldown(tokenKey))
+	}
+	rateLimiter := kiroauth.GetGlobalRateLimiter()
+	if !rateLimiter.IsTokenAvailable(tokenKey) {
+		if next := rateLimiter.NextAvailableTime(tokenKey); next.After(retryAt) {
+			retryAt = next
+		}
+		if retryAt.IsZero() {
+			return false, time.Time{}
+		}
+	}
+	if retryAt.IsZero() {
+		return true, time.Time{}
+	}
+	return false, retryAt
+}
+
 // Execute sends the request to Kiro API and returns the response.
 // Supports automatic token refresh on 401/403 errors.
 func (e *KiroExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (resp cliproxyexecutor.Response, err error) {
@@ -637,7 +663,12 @@ func (e *KiroExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth, req
 
 	// Wait for rate limiter before proceeding
 	log.Debugf("kiro: waiting for rate limiter for token %s", tokenKey)
-	rateLimiter.WaitForToken(tokenKey)
+	releaseProbe, err := rateLimiter.AcquireToken(ctx, tokenKey)
+	if err != nil {
+		return resp, fmt.Errorf("kiro: waiting for rate limiter: %w", err)
+	}
+	// Frees a half-open probe slot if this request exits without recording an outcome
+	defer releaseProbe()
 	log.Debugf("kiro: rate limiter cleared for token %s", tokenKey)
 
 	// Check for pure web_search request
@@ -685,6 +716,11 @@ func (e *KiroExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth, req
 
 	kiroModelID := e.mapModelToKiro(req.Model)
 
+	// Social tokens stored without a profile ARN get it looked up once before the request
+	if profileArn == "" {
+		auth, profileArn = e.backfillProfileArn(ctx, auth, accessToken)
+	}
+
 	// Determine agentic mode and effective profile ARN using helper functions
 	isAgentic, isChatOnly := determineAgenticMode(req.Model)
 	effectiveProfileArn := getEffectiveProfileArnWithWarning(auth, profileArn)
@@ -809,6 +845,18 @@ func (e *KiroExecutor) executeWithRetry(ctx context.Context, auth *cliproxyauth.
 			}
 			recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
 
+			// Statuses configured as suspension (e.g. an opaque 403/451) suspend the token
+			// without inspecting the body.
+			if rateLimiter.MarkSuspendedByStatus(tokenKey, httpResp.StatusCode) {
+				respBody, _ := io.ReadAll(httpResp.Body)
+				_ = httpResp.Body.Close()
+				appendAPIResponseChunk(ctx, e.cfg, respBody)
+
+				cooldownMgr.SetCooldown(tokenKey, kiroauth.LongCooldown, kiroauth.CooldownReasonSuspended)
+				log.Errorf("kiro: account suspended by status %d, token %s set to cooldown for %v", httpResp.StatusCode, tokenKey, kiroauth.LongCooldown)
+				return resp, statusErr{code: httpResp.StatusCode, msg: "account suspended: " + string(respBody)}
+			}
+
 			// Handle 429 errors (quota exhausted) - try next endpoint
 			// Each endpoint has its own quota pool, so we can try different endpoints
 			if httpResp.StatusCode == 429 {
@@ -1065,7 +1113,12 @@ func (e *KiroExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth.Aut
 
 	// Wait for rate limiter before proceeding
 	log.Debugf("kiro: stream waiting for rate limiter for token %s", tokenKey)
-	rateLimiter.WaitForToken(tokenKey)
+	releaseProbe, err := rateLimiter.AcquireToken(ctx, tokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("kiro: waiting for rate limiter: %w", err)
+	}
+	// Frees a half-open probe slot if this request exits without recording an outcome
+	defer releaseProbe()
 	log.Debugf("kiro: stream rate limiter cleared for token %s", tokenKey)
 
 	// Check for pure web_search request
@@ -1113,6 +1166,11 @@ func (e *KiroExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth.Aut
 
 	kiroModelID := e.mapModelToKiro(req.Model)
 
+	// Social tokens stored without a profile ARN get it looked up once before the request
+	if profileArn == "" {
+		auth, profileArn = e.backfillProfileArn(ctx, auth, accessToken)
+	}
+
 	// Determine agentic mode and effective profile ARN using helper functions
 	isAgentic, isChatOnly := determineAgenticMode(req.Model)
 	effectiveProfileArn := getEffectiveProfileArnWithWarning(auth, profileArn)
@@ -1222,6 +1280,18 @@ func (e *KiroExecutor) executeStreamWithRetry(ctx context.Context, auth *cliprox
 			}
 			recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
 
+			// Statuses configured as suspension (e.g. an opaque 403/451) suspend the token
+			// without inspecting the body.
+			if rateLimiter.MarkSuspendedByStatus(tokenKey, httpResp.StatusCode) {
+				respBody, _ := io.ReadAll(httpResp.Body)
+				_ = httpResp.Body.Close()
+				appendAPIResponseChunk(ctx, e.cfg, respBody)
+
+				cooldownMgr.SetCooldown(tokenKey, kiroauth.LongCooldown, kiroauth.CooldownReasonSuspended)
+				log.Errorf("kiro: stream account suspended by status %d, token %s set to cooldown for %v", httpResp.StatusCode, tokenKey, kiroauth.LongCooldown)
+				return nil, statusErr{code: httpResp.StatusCode, msg: "account suspended: " + string(respBody)}
+			}
+
 			// Handle 429 errors (quota exhausted) - try next endpoint
 			// Each endpoint has its own quota pool, so we can try different endpoints
 			if httpResp.StatusCode == 429 {
@@ -1669,21 +1739,8 @@ func getEffectiveProfileArn(auth *cliproxyauth.Auth, profileArn string) string {
 // 2. Check auth_type field: "aws_sso_oidc" (from kiro-cli tokens)
 // 3. Check for client_id + client_secret presence (AWS SSO OIDC signature)
 func getEffectiveProfileArnWithWarning(auth *cliproxyauth.Auth, profileArn string) string {
-	if auth != nil && auth.Metadata != nil {
-		// Check 1: auth_method field (from CLIProxyAPI tokens)
-		if authMethod, ok := auth.Metadata["auth_method"].(string); ok && (authMethod == "builder-id" || authMethod == "idc") {
-			return "" // AWS SSO OIDC - don't include profileArn
-		}
-		// Check 2: auth_type field (from kiro-cli tokens)
-		if authType, ok := auth.Metadata["auth_type"].(string); ok && authType == "aws_sso_oidc" {
-			return "" // AWS SSO OIDC - don't include profileArn
-		}
-		// Check 3: client_id + client_secret presence (AWS SSO OIDC signature, like kiro-openai-gateway)
-		_, hasClientID := auth.Metadata["client_id"].(string)
-		_, hasClientSecret := auth.Metadata["client_secret"].(string)
-		if hasClientID && hasClientSecret {
-			return "" // AWS SSO OIDC - don't include profileArn
-		}
+	if isSSOOIDCAuth(auth) {
+		return "" // AWS SSO OIDC - don't include profileArn
 	}
 	// For social auth (Kiro Desktop), profileArn is required
 	if profileArn == "" {
@@ -1692,6 +1749,93 @@ func getEffectiveProfileArnWithWarning(auth *cliproxyauth.Auth, profileArn strin
 	return profileArn
 }
 
+// isSSOOIDCAuth reports whether auth came from AWS SSO OIDC (Builder ID or IDC), which
+// authenticates without a profile ARN.
+func isSSOOIDCAuth(auth *cliproxyauth.Auth) bool {
+	if auth == nil || auth.Metadata == nil {
+		return false
+	}
+	// Check 1: auth_method field (from CLIProxyAPI tokens)
+	if authMethod, ok := auth.Metadata["auth_method"].(string); ok && (authMethod == "builder-id" || authMethod == "idc") {
+		return true
+	}
+	// Check 2: auth_type field (from kiro-cli tokens)
+	if authType, ok := auth.Metadata["auth_type"].(string); ok && authType == "aws_sso_oidc" {
+		return true
+	}
+	// Check 3: client_id + client_secret presence (AWS SSO OIDC signature, like kiro-openai-gateway)
+	_, hasClientID := auth.Metadata["client_id"].(string)
+	_, hasClientSecret := auth.Metadata["client_secret"].(string)
+	return hasClientID && hasClientSecret
+}
+
+// Profile ARN backfill settings (kiro-auth.profile-arn-backfill).
+const (
+	profileArnBackfillFirstUse      = "first-use"
+	profileArnBackfillOff           = "off"
+	profileArnBackfillRetryInterval = 10 * time.Minute
+	profileArnBackfillTimeout       = 15 * time.Second
+)
+
+// profileArnBackfillAttempts holds the last backfill attempt time per auth ID so a token whose
+// lookup fails is not retried on every request.
+var profileArnBackfillAttempts sync.Map
+
+// backfillProfileArn looks up the profile ARN of a social token stored without one, using its
+// current access token, and saves it to the auth file. The outcome is recorded in the metadata
+// ("profile_arn_backfill": "succeeded" or "failed") for the management token status.
+// It returns the auth to use and its profile ARN, which stays empty when nothing was found.
+// The lookup goes through the context RoundTripper when one is set.
+func (e *KiroExecutor) backfillProfileArn(ctx context.Context, auth *cliproxyauth.Auth, accessToken string) (*cliproxyauth.Auth, string) {
+	if auth == nil || accessToken == "" || isSSOOIDCAuth(auth) {
+		return auth, ""
+	}
+	mode := profileArnBackfillFirstUse
+	if e.cfg != nil {
+		if configured := strings.ToLower(strings.TrimSpace(e.cfg.KiroAuth.ProfileArnBackfill)); configured != "" {
+			mode = configured
+		}
+	}
+	if mode == profileArnBackfillOff {
+		return auth, ""
+	}
+	if mode != profileArnBackfillFirstUse {
+		log.Warnf("kiro: unknown profile-arn-backfill mode %q, using %q", mode, profileArnBackfillFirstUse)
+	}
+
+	now := time.Now()
+	if last, ok := profileArnBackfillAttempts.Load(auth.ID); ok && now.Sub(last.(time.Time)) < profileArnBackfillRetryInterval {
+		return auth, ""
+	}
+	profileArnBackfillAttempts.Store(auth.ID, now)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, profileArnBackfillTimeout)
+	defer cancel()
+	var opts []kiroauth.ClientOption
+	if rt, ok := ctx.Value("cliproxy.roundtripper").(http.RoundTripper); ok && rt != nil {
+		opts = append(opts, kiroauth.WithTransport(rt))
+	}
+	profileArn, errLookup := kiroauth.NewSSOOIDCClient(e.cfg, opts...).FetchProfileArn(lookupCtx, accessToken)
+
+	updated := auth.Clone()
+	if updated.Metadata == nil {
+		updated.Metadata = make(map[string]any)
+	}
+	updated.Metadata["profile_arn_backfill_at"] = now.UTC().Format(time.RFC3339)
+	if profileArn == "" {
+		updated.Metadata["profile_arn_backfill"] = "failed"
+		log.Warnf("kiro: profile ARN backfill for %s failed: %v, retrying in %v", auth.ID, errLookup, profileArnBackfillRetryInterval)
+	} else {
+		updated.Metadata["profile_arn_backfill"] = "succeeded"
+		updated.Metadata["profile_arn"] = profileArn
+		log.Infof("kiro: backfilled profile ARN for %s", auth.ID)
+	}
+	if err := e.persistRefreshedAuth(updated); err != nil {
+		log.Warnf("kiro: failed to persist profile ARN backfill for %s: %v", auth.ID, err)
+	}
+	return updated, profileArn
+}
+
 // mapModelToKiro maps external model names to Kiro model IDs.
 // Supports both Kiro and Amazon Q prefixes since they use the same API.
 // Agentic variants (-agentic suffix) map to the same backend model IDs.
@@ -2947,6 +3091,7 @@ func (e *KiroExecutor) streamToChannel(ctx context.Context, body io.Reader, out
 						}
 
 						lastReportedOutputTokens = currentOutputTokens
+						reporter.publishProgress(ctx, totalUsage.InputTokens+currentOutputTokens)
 						log.Debugf("kiro: sent real-time usage update - input: %d, output: %d (accumulated: %d chars)",
 							totalUsage.InputTokens, currentOutputTokens, accumulatedContent.Len())
 					}
@@ -3812,6 +3957,19 @@ func (e *KiroExecutor) Refresh(ctx context.Context, auth *cliproxyauth.Auth) (*c
 	}
 
 	if refreshToken == "" {
+		if nonRefreshable, _ := auth.Metadata["non_refreshable"].(bool); nonRefreshable {
+			// Issued without a refresh token: stop scheduling doomed refreshes until it expires.
+			updated := auth.Clone()
+			nextRefresh := time.Now().Add(time.Hour)
+			if expiresAt, ok := auth.Metadata["expires_at"].(string); ok {
+				if expTime, err := time.Parse(time.RFC3339, expiresAt); err == nil && expTime.After(nextRefresh) {
+					nextRefresh = expTime
+				}
+			}
+			updated.NextRefreshAfter = nextRefresh
+			log.Debugf("kiro executor: auth %s is non-refreshable, skipping refresh until %s", authID, nextRefresh.Format(time.RFC3339))
+			return updated, nil
+		}
 		return nil, fmt.Errorf("kiro executor: refresh token not found")
 	}
 
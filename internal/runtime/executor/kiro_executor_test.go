@@ -0,0 +1,157 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+func TestBackfillProfileArnSkipsWithoutLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		auth *cliproxyauth.Auth
+	}{
+		{
+			name: "disabled",
+			cfg:  &config.Config{KiroAuth: config.KiroAuthConfig{ProfileArnBackfill: "off"}},
+			auth: &cliproxyauth.Auth{ID: "kiro-social.json", Metadata: map[string]any{"auth_method": "social"}},
+		},
+		{
+			name: "builder id",
+			cfg:  &config.Config{},
+			auth: &cliproxyauth.Auth{ID: "kiro-builder-id.json", Metadata: map[string]any{"auth_method": "builder-id"}},
+		},
+		{
+			name: "oidc client credentials",
+			cfg:  &config.Config{},
+			auth: &cliproxyauth.Auth{ID: "kiro-cli.json", Metadata: map[string]any{"client_id": "id", "client_secret": "secret"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewKiroExecutor(tt.cfg)
+			got, arn := e.backfillProfileArn(context.Background(), tt.auth, "access-token")
+			if got != tt.auth || arn != "" {
+				t.Fatalf("backfillProfileArn() = (%p, %q), want original auth and empty ARN", got, arn)
+			}
+			if _, ok := tt.auth.Metadata["profile_arn_backfill"]; ok {
+				t.Fatalf("backfill status recorded for skipped auth")
+			}
+		})
+	}
+}
+
+func TestBackfillProfileArnThrottlesRetries(t *testing.T) {
+	auth := &cliproxyauth.Auth{ID: "kiro-throttled.json", Metadata: map[string]any{"auth_method": "social"}}
+	profileArnBackfillAttempts.Store(auth.ID, time.Now())
+	defer profileArnBackfillAttempts.Delete(auth.ID)
+
+	got, arn := NewKiroExecutor(&config.Config{}).backfillProfileArn(context.Background(), auth, "access-token")
+	if got != auth || arn != "" {
+		t.Fatalf("backfillProfileArn() = (%p, %q), want original auth while throttled", got, arn)
+	}
+}
+
+// codeWhispererRedirect sends every request to the fake CodeWhisperer server at target.
+type codeWhispererRedirect struct{ target *url.URL }
+
+func (r codeWhispererRedirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newFakeCodeWhisperer serves the profile listing operations with status and body, and returns a
+// context that routes the backfill lookup to it.
+func newFakeCodeWhisperer(t *testing.T, status int, body string) (context.Context, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if got := r.Header.Get("Authorization"); got != "Bearer access-token" {
+			t.Errorf("Authorization = %q, want the auth's access token", got)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	return context.WithValue(context.Background(), "cliproxy.roundtripper", http.RoundTripper(codeWhispererRedirect{target: target})), &calls
+}
+
+func TestBackfillProfileArnAgainstFakeCodeWhisperer(t *testing.T) {
+	const arn = "arn:aws:codewhisperer:us-east-1:123456789012:profile/BACKFILL"
+	ctx, calls := newFakeCodeWhisperer(t, http.StatusOK, `{"profiles":[{"arn":"`+arn+`","profileName":"default"}]}`)
+	authPath := filepath.Join(t.TempDir(), "kiro-backfill-ok.json")
+	auth := &cliproxyauth.Auth{ID: "kiro-backfill-ok.json", Attributes: map[string]string{"path": authPath},
+		Metadata: map[string]any{"auth_method": "social", "access_token": "access-token"}}
+	defer profileArnBackfillAttempts.Delete(auth.ID)
+
+	got, gotArn := NewKiroExecutor(&config.Config{}).backfillProfileArn(ctx, auth, "access-token")
+	if gotArn != arn || got == auth {
+		t.Fatalf("backfillProfileArn() = (%p, %q), want an updated auth with %q", got, gotArn, arn)
+	}
+	if got.Metadata["profile_arn"] != arn || got.Metadata["profile_arn_backfill"] != "succeeded" {
+		t.Fatalf("metadata = %v, want the backfilled ARN and a succeeded status", got.Metadata)
+	}
+	if _, ok := auth.Metadata["profile_arn"]; ok {
+		t.Fatal("backfill modified the original auth")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("CodeWhisperer calls = %d, want 1", calls.Load())
+	}
+
+	raw, err := os.ReadFile(authPath)
+	if err != nil {
+		t.Fatalf("read persisted auth: %v", err)
+	}
+	var persisted map[string]any
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		t.Fatalf("decode persisted auth: %v", err)
+	}
+	if persisted["profile_arn"] != arn || persisted["profile_arn_backfill"] != "succeeded" || persisted["access_token"] != "access-token" {
+		t.Fatalf("persisted auth = %v, want the backfilled ARN alongside the token", persisted)
+	}
+}
+
+func TestBackfillProfileArnFailedLookup(t *testing.T) {
+	ctx, calls := newFakeCodeWhisperer(t, http.StatusForbidden, `{"message":"denied"}`)
+	authPath := filepath.Join(t.TempDir(), "kiro-backfill-failed.json")
+	auth := &cliproxyauth.Auth{ID: "kiro-backfill-failed.json", Attributes: map[string]string{"path": authPath},
+		Metadata: map[string]any{"auth_method": "social"}}
+	defer profileArnBackfillAttempts.Delete(auth.ID)
+	e := NewKiroExecutor(&config.Config{})
+
+	got, arn := e.backfillProfileArn(ctx, auth, "access-token")
+	if arn != "" || got.Metadata["profile_arn_backfill"] != "failed" {
+		t.Fatalf("backfillProfileArn() = (%q, %v), want no ARN and a failed status", arn, got.Metadata)
+	}
+	if _, ok := got.Metadata["profile_arn"]; ok {
+		t.Fatalf("metadata = %v, want no profile_arn after a failed lookup", got.Metadata)
+	}
+	raw, err := os.ReadFile(authPath)
+	if err != nil {
+		t.Fatalf("read persisted auth: %v", err)
+	}
+	var persisted map[string]any
+	if err := json.Unmarshal(raw, &persisted); err != nil || persisted["profile_arn_backfill"] != "failed" {
+		t.Fatalf("persisted auth = %s (%v), want a failed backfill status", raw, err)
+	}
+
+	// The failure is throttled, so the next request does not look the ARN up again.
+	before := calls.Load()
+	if _, arn := e.backfillProfileArn(ctx, got, "access-token"); arn != "" || calls.Load() != before {
+		t.Fatalf("retry looked up %d more times (ARN %q), want none within the retry interval", calls.Load()-before, arn)
+	}
+}
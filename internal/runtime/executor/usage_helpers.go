@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	"github.com/tidwall/gjson"
@@ -24,6 +25,13 @@ type usageReporter struct {
 	source      string
 	requestedAt time.Time
 	once        sync.Once
+
+	// progressMu orders partial updates before the final record. requestID is assigned on the
+	// first partial update and progressTokens is the cumulative count published so far.
+	progressMu     sync.Mutex
+	requestID      string
+	progressTokens int64
+	finished       bool
 }
 
 func newUsageReporter(ctx context.Context, provider, model string, auth *cliproxyauth.Auth) *usageReporter {
@@ -64,15 +72,19 @@ func (r *usageReporter) publishWithOutcome(ctx context.Context, detail usage.Det
 		return
 	}
 	if detail.TotalTokens == 0 {
-		total := detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens
+		total := detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens + detail.ToolTokens
 		if total > 0 {
 			detail.TotalTokens = total
 		}
 	}
-	if detail.InputTokens == 0 && detail.OutputTokens == 0 && detail.ReasoningTokens == 0 && detail.CachedTokens == 0 && detail.TotalTokens == 0 && !failed {
+	if detail.InputTokens == 0 && detail.OutputTokens == 0 && detail.ReasoningTokens == 0 && detail.CachedTokens == 0 && detail.ToolTokens == 0 && detail.TotalTokens == 0 && !failed {
 		return
 	}
+	tenant, pricing := pricingFromContext(ctx)
 	r.once.Do(func() {
+		r.progressMu.Lock()
+		defer r.progressMu.Unlock()
+		r.finished = true
 		usage.PublishRecord(ctx, usage.Record{
 			Provider:    r.provider,
 			Model:       r.model,
@@ -83,10 +95,35 @@ func (r *usageReporter) publishWithOutcome(ctx context.Context, detail usage.Det
 			RequestedAt: r.requestedAt,
 			Failed:      failed,
 			Detail:      detail,
+			Tenant:      tenant,
+			Pricing:     pricing,
+			Latency:     time.Since(r.requestedAt),
+			RequestID:   r.requestID,
 		})
 	})
 }
 
+// publishProgress reports the cumulative tokens of a response that is still streaming, so
+// usage statistics reflect a long generation before it completes. Only the increment since
+// the previous call is published; the final record replaces the partial count.
+// Only the Kiro executor calls it, since its stream carries running token counts; the other
+// providers report usage once, at the end of the stream.
+func (r *usageReporter) publishProgress(ctx context.Context, totalTokens int64) {
+	if r == nil {
+		return
+	}
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	if r.finished || totalTokens <= r.progressTokens {
+		return
+	}
+	if r.requestID == "" {
+		r.requestID = uuid.NewString()
+	}
+	usage.PublishPartial(ctx, r.requestID, totalTokens-r.progressTokens)
+	r.progressTokens = totalTokens
+}
+
 // ensurePublished guarantees that a usage record is emitted exactly once.
 // It is safe to call multiple times; only the first call wins due to once.Do.
 // This is used to ensure request counting even when upstream responses do not
@@ -95,7 +132,11 @@ func (r *usageReporter) ensurePublished(ctx context.Context) {
 	if r == nil {
 		return
 	}
+	tenant, pricing := pricingFromContext(ctx)
 	r.once.Do(func() {
+		r.progressMu.Lock()
+		defer r.progressMu.Unlock()
+		r.finished = true
 		usage.PublishRecord(ctx, usage.Record{
 			Provider:    r.provider,
 			Model:       r.model,
@@ -106,10 +147,36 @@ func (r *usageReporter) ensurePublished(ctx context.Context) {
 			RequestedAt: r.requestedAt,
 			Failed:      false,
 			Detail:      usage.Detail{},
+			Tenant:      tenant,
+			Pricing:     pricing,
+			Latency:     time.Since(r.requestedAt),
+			RequestID:   r.requestID,
 		})
 	})
 }
 
+// pricingFromContext returns the tenant and price override a router attached to the gin context.
+func pricingFromContext(ctx context.Context) (string, *usage.Pricing) {
+	if ctx == nil {
+		return "", nil
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return "", nil
+	}
+	tenant := ginCtx.GetString(usage.GinTenantKey)
+	var pricing *usage.Pricing
+	if v, exists := ginCtx.Get(usage.GinPricingKey); exists {
+		switch value := v.(type) {
+		case *usage.Pricing:
+			pricing = value
+		case usage.Pricing:
+			pricing = &value
+		}
+	}
+	return tenant, pricing
+}
+
 func apiKeyFromContext(ctx context.Context) string {
 	if ctx == nil {
 		return ""
@@ -336,9 +403,10 @@ func parseGeminiFamilyUsageDetail(node gjson.Result) usage.Detail {
 		ReasoningTokens: node.Get("thoughtsTokenCount").Int(),
 		TotalTokens:     node.Get("totalTokenCount").Int(),
 		CachedTokens:    node.Get("cachedContentTokenCount").Int(),
+		ToolTokens:      node.Get("toolUsePromptTokenCount").Int(),
 	}
 	if detail.TotalTokens == 0 {
-		detail.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens
+		detail.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens + detail.ToolTokens
 	}
 	return detail
 }
@@ -1,6 +1,18 @@
 package executor
 
-import "testing"
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	internalusage "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
 
 func TestParseOpenAIUsageChatCompletions(t *testing.T) {
 	data := []byte(`{"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3,"prompt_tokens_details":{"cached_tokens":4},"completion_tokens_details":{"reasoning_tokens":5}}}`)
@@ -41,3 +53,106 @@ func TestParseOpenAIUsageResponses(t *testing.T) {
 		t.Fatalf("reasoning tokens = %d, want %d", detail.ReasoningTokens, 9)
 	}
 }
+
+func TestParseGeminiUsageToolTokens(t *testing.T) {
+	data := []byte(`{"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"toolUsePromptTokenCount":3,"thoughtsTokenCount":2}}`)
+	detail := parseGeminiUsage(data)
+	if detail.ToolTokens != 3 {
+		t.Fatalf("tool tokens = %d, want %d", detail.ToolTokens, 3)
+	}
+	if detail.TotalTokens != 20 {
+		t.Fatalf("total tokens = %d, want %d", detail.TotalTokens, 20)
+	}
+}
+
+func TestUsagePlanFromGinRequestReachesTenantCosts(t *testing.T) {
+	internalusage.SetStatisticsEnabled(true)
+	internalusage.InitStatsStorage(config.RedisCacheConfig{})
+	internalusage.SetPriceTable(map[string]config.ModelPricing{"plan-test-model": {Input: 1, Output: 1}})
+	internalusage.SetAPIKeyPlans([]config.UsageAPIKey{
+		{APIKey: "sk-enterprise", Tenant: "acme", Plan: "enterprise"},
+		{APIKey: "sk-tenant-only", Tenant: "globex"},
+	}, map[string]config.ModelPricing{"enterprise": {Input: 0.002, Output: 0.004}})
+	defer func() {
+		internalusage.SetAPIKeyPlans(nil, nil)
+		internalusage.SetPriceTable(nil)
+		internalusage.InitStatsStorage(config.RedisCacheConfig{})
+	}()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		// What AuthMiddleware does once the key is accepted.
+		apiKey := c.GetHeader("Authorization")
+		c.Set("apiKey", apiKey)
+		internalusage.AttributeRequest(c, apiKey)
+	})
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), "gin", c)
+		reporter := newUsageReporter(ctx, "openai", "plan-test-model", nil)
+		reporter.publish(ctx, usage.Detail{InputTokens: 1000, OutputTokens: 500})
+		c.Status(http.StatusNoContent)
+	})
+	for _, apiKey := range []string{"sk-enterprise", "sk-tenant-only", "sk-unassigned"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", apiKey)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	want := map[string]float64{
+		"acme":          0.002 + 0.5*0.004, // enterprise plan
+		"globex":        1 + 0.5,           // global price table
+		"sk-unassigned": 1 + 0.5,           // no tenant configured
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		costs := internalusage.GetStatsStorage().Snapshot().TenantCosts
+		matched := len(costs) == len(want)
+		for tenant, cost := range want {
+			if math.Abs(costs[tenant]-cost) > 1e-9 {
+				matched = false
+			}
+		}
+		if matched {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TenantCosts = %v, want %v", costs, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPartialUsageReconciledByFinalRecord(t *testing.T) {
+	internalusage.SetStatisticsEnabled(true)
+	internalusage.InitStatsStorage(config.RedisCacheConfig{})
+	defer internalusage.InitStatsStorage(config.RedisCacheConfig{})
+
+	waitFor := func(pending, total int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			snapshot := internalusage.GetStatsStorage().Snapshot()
+			if snapshot.PendingTokens == pending && snapshot.TotalTokens == total {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("pending / total tokens = %d / %d, want %d / %d", snapshot.PendingTokens, snapshot.TotalTokens, pending, total)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx := context.Background()
+	reporter := newUsageReporter(ctx, "kiro", "partial-test-model", nil)
+	reporter.publishProgress(ctx, 120)
+	reporter.publishProgress(ctx, 300)
+	reporter.publishProgress(ctx, 250) // stale count, ignored
+	waitFor(300, 0)
+
+	reporter.publish(ctx, usage.Detail{InputTokens: 100, OutputTokens: 220})
+	reporter.publishProgress(ctx, 400) // after the final record, ignored
+	// A record of another request flushes the queue behind the late progress update.
+	newUsageReporter(ctx, "kiro", "partial-test-model", nil).publish(ctx, usage.Detail{TotalTokens: 5})
+	waitFor(0, 325)
+}
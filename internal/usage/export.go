@@ -0,0 +1,194 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// WriteSnapshotJSON streams the snapshot to w as JSON, producing the same document as
+// json.Marshal(snapshot) without holding the whole serialized payload in memory.
+// APIs, models and request details are encoded one at a time.
+func WriteSnapshotJSON(w io.Writer, snapshot StatisticsSnapshot) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+
+	sw.raw(`{"total_requests":`)
+	sw.value(snapshot.TotalRequests)
+	sw.raw(`,"success_count":`)
+	sw.value(snapshot.SuccessCount)
+	sw.raw(`,"failure_count":`)
+	sw.value(snapshot.FailureCount)
+	sw.raw(`,"total_tokens":`)
+	sw.value(snapshot.TotalTokens)
+	sw.raw(`,"success_tokens":`)
+	sw.value(snapshot.SuccessTokens)
+	sw.raw(`,"failure_tokens":`)
+	sw.value(snapshot.FailureTokens)
+	if snapshot.PendingTokens != 0 {
+		sw.raw(`,"pending_tokens":`)
+		sw.value(snapshot.PendingTokens)
+	}
+	sw.raw(`,"total_cost":`)
+	sw.value(snapshot.TotalCost)
+	sw.raw(`,"apis":`)
+	sw.apis(snapshot.APIs)
+	sw.raw(`,"requests_by_day":`)
+	sw.value(snapshot.RequestsByDay)
+	sw.raw(`,"requests_by_hour":`)
+	sw.value(snapshot.RequestsByHour)
+	sw.raw(`,"tokens_by_day":`)
+	sw.value(snapshot.TokensByDay)
+	sw.raw(`,"tokens_by_hour":`)
+	sw.value(snapshot.TokensByHour)
+	sw.optionalBuckets("requests_by_minute", snapshot.RequestsByMinute)
+	sw.optionalBuckets("tokens_by_minute", snapshot.TokensByMinute)
+	sw.optionalBuckets("requests_by_month", snapshot.RequestsByMonth)
+	sw.optionalBuckets("tokens_by_month", snapshot.TokensByMonth)
+	if len(snapshot.Granularities) > 0 {
+		sw.raw(`,"granularities":`)
+		sw.value(snapshot.Granularities)
+	}
+	if len(snapshot.TenantCosts) > 0 {
+		sw.raw(`,"tenant_costs":`)
+		sw.value(snapshot.TenantCosts)
+	}
+	sw.optionalBuckets("unpriced_requests", snapshot.UnpricedRequests)
+	if len(snapshot.Providers) > 0 {
+		sw.raw(`,"providers":`)
+		sw.value(snapshot.Providers)
+	}
+	if len(snapshot.Refreshes) > 0 {
+		sw.raw(`,"refreshes":`)
+		sw.value(snapshot.Refreshes)
+	}
+	sw.raw(`}`)
+
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// snapshotWriter writes JSON fragments and remembers the first error encountered.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *snapshotWriter) raw(s string) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = sw.w.WriteString(s)
+}
+
+func (sw *snapshotWriter) value(v any) {
+	if sw.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		sw.err = err
+		return
+	}
+	_, sw.err = sw.w.Write(data)
+}
+
+// optionalBuckets writes an omitempty bucket map field.
+func (sw *snapshotWriter) optionalBuckets(name string, buckets map[string]int64) {
+	if len(buckets) == 0 {
+		return
+	}
+	sw.raw(`,"` + name + `":`)
+	sw.value(buckets)
+}
+
+func (sw *snapshotWriter) apis(apis map[string]APISnapshot) {
+	if apis == nil {
+		sw.raw("null")
+		return
+	}
+	sw.raw("{")
+	for i, name := range sortedKeys(apis) {
+		if i > 0 {
+			sw.raw(",")
+		}
+		api := apis[name]
+		sw.value(name)
+		sw.raw(`:{"total_requests":`)
+		sw.value(api.TotalRequests)
+		sw.raw(`,"total_tokens":`)
+		sw.value(api.TotalTokens)
+		sw.raw(`,"success_tokens":`)
+		sw.value(api.SuccessTokens)
+		sw.raw(`,"failure_tokens":`)
+		sw.value(api.FailureTokens)
+		sw.raw(`,"total_cost":`)
+		sw.value(api.TotalCost)
+		sw.raw(`,"models":`)
+		sw.models(api.Models)
+		sw.raw("}")
+	}
+	sw.raw("}")
+}
+
+func (sw *snapshotWriter) models(models map[string]ModelSnapshot) {
+	if models == nil {
+		sw.raw("null")
+		return
+	}
+	sw.raw("{")
+	for i, name := range sortedKeys(models) {
+		if i > 0 {
+			sw.raw(",")
+		}
+		model := models[name]
+		sw.value(name)
+		sw.raw(`:{"total_requests":`)
+		sw.value(model.TotalRequests)
+		sw.raw(`,"failure_count":`)
+		sw.value(model.FailureCount)
+		sw.raw(`,"total_tokens":`)
+		sw.value(model.TotalTokens)
+		sw.raw(`,"success_tokens":`)
+		sw.value(model.SuccessTokens)
+		sw.raw(`,"failure_tokens":`)
+		sw.value(model.FailureTokens)
+		sw.raw(`,"total_cost":`)
+		sw.value(model.TotalCost)
+		if model.Latency != nil {
+			sw.raw(`,"latency":`)
+			sw.value(model.Latency)
+		}
+		sw.raw(`,"details":`)
+		sw.details(model.Details)
+		sw.raw("}")
+	}
+	sw.raw("}")
+}
+
+func (sw *snapshotWriter) details(details []RequestDetail) {
+	if details == nil {
+		sw.raw("null")
+		return
+	}
+	sw.raw("[")
+	for i := range details {
+		if i > 0 {
+			sw.raw(",")
+		}
+		sw.value(details[i])
+	}
+	sw.raw("]")
+}
+
+// sortedKeys returns the map keys in the order encoding/json emits them.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package usage
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported time-bucket granularities for aggregated statistics.
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+	GranularityMonth  = "month"
+)
+
+const (
+	minuteBucketLayout = "2006-01-02 15:04"
+	dayBucketLayout    = "2006-01-02"
+	monthBucketLayout  = "2006-01"
+)
+
+// granularitySet records which time buckets are maintained.
+type granularitySet struct {
+	minute bool
+	hour   bool
+	day    bool
+	month  bool
+}
+
+// defaultGranularities keeps the historical hour and day buckets.
+var defaultGranularities = granularitySet{hour: true, day: true}
+
+// parseGranularities builds the enabled set from configuration. Unknown names are
+// ignored with a warning; an empty or entirely invalid list yields the defaults.
+func parseGranularities(names []string) granularitySet {
+	set := granularitySet{}
+	valid := false
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case GranularityMinute:
+			set.minute = true
+		case GranularityHour:
+			set.hour = true
+		case GranularityDay:
+			set.day = true
+		case GranularityMonth:
+			set.month = true
+		case "":
+			continue
+		default:
+			log.Warnf("usage statistics: ignoring unknown granularity %q", name)
+			continue
+		}
+		valid = true
+	}
+	if !valid {
+		return defaultGranularities
+	}
+	return set
+}
+
+// orDefault treats an empty set, as in a zero-value store, as the defaults.
+func (g granularitySet) orDefault() granularitySet {
+	if g == (granularitySet{}) {
+		return defaultGranularities
+	}
+	return g
+}
+
+// names lists the enabled granularities from finest to coarsest.
+func (g granularitySet) names() []string {
+	g = g.orDefault()
+	names := make([]string, 0, 4)
+	if g.minute {
+		names = append(names, GranularityMinute)
+	}
+	if g.hour {
+		names = append(names, GranularityHour)
+	}
+	if g.day {
+		names = append(names, GranularityDay)
+	}
+	if g.month {
+		names = append(names, GranularityMonth)
+	}
+	return names
+}
+
+// addTimeBuckets counts one request and its tokens in every enabled bucket of snapshot.
+func (g granularitySet) addTimeBuckets(snapshot *StatisticsSnapshot, timestamp time.Time, totalTokens int64) {
+	g = g.orDefault()
+	if g.minute {
+		key := timestamp.Format(minuteBucketLayout)
+		snapshot.RequestsByMinute = incrementBucket(snapshot.RequestsByMinute, key, 1)
+		snapshot.TokensByMinute = incrementBucket(snapshot.TokensByMinute, key, totalTokens)
+	}
+	if g.hour {
+		key := formatHour(timestamp.Hour())
+		snapshot.RequestsByHour = incrementBucket(snapshot.RequestsByHour, key, 1)
+		snapshot.TokensByHour = incrementBucket(snapshot.TokensByHour, key, totalTokens)
+	}
+	if g.day {
+		key := timestamp.Format(dayBucketLayout)
+		snapshot.RequestsByDay = incrementBucket(snapshot.RequestsByDay, key, 1)
+		snapshot.TokensByDay = incrementBucket(snapshot.TokensByDay, key, totalTokens)
+	}
+	if g.month {
+		key := timestamp.Format(monthBucketLayout)
+		snapshot.RequestsByMonth = incrementBucket(snapshot.RequestsByMonth, key, 1)
+		snapshot.TokensByMonth = incrementBucket(snapshot.TokensByMonth, key, totalTokens)
+	}
+}
+
+func incrementBucket(buckets map[string]int64, key string, delta int64) map[string]int64 {
+	if buckets == nil {
+		buckets = make(map[string]int64)
+	}
+	buckets[key] += delta
+	return buckets
+}
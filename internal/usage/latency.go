@@ -0,0 +1,109 @@
+package usage
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// latencyGamma is the ratio between consecutive latency bucket bounds. Reporting the
+// relative midpoint of a bucket keeps every percentile within 1% of a real sample.
+const latencyGamma = 1.02
+
+var logLatencyGamma = math.Log(latencyGamma)
+
+// LatencySummary reports request latency percentiles in milliseconds.
+type LatencySummary struct {
+	Count int64   `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P90Ms float64 `json:"p90_ms"`
+	P99Ms float64 `json:"p99_ms"`
+}
+
+// latencyHistogram counts latencies in logarithmic buckets: bucket i holds samples in
+// (gamma^(i-1), gamma^i] milliseconds. Its size depends on the spread of latencies rather
+// than the number of samples (about 700 buckets cover 1ms to 10 minutes), and histograms
+// are merged by adding bucket counts.
+type latencyHistogram struct {
+	counts map[int]int64
+	total  int64
+}
+
+// latencyBucket returns the bucket of a latency given in milliseconds.
+func latencyBucket(ms int64) int {
+	if ms <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(ms)) / logLatencyGamma))
+}
+
+// latencyBucketValue returns the value reported for a bucket, the point with the same
+// relative distance to both of its bounds.
+func latencyBucketValue(bucket int) float64 {
+	return 2 * math.Pow(latencyGamma, float64(bucket)) / (latencyGamma + 1)
+}
+
+// add counts n samples in bucket.
+func (h *latencyHistogram) add(bucket int, n int64) {
+	if n <= 0 {
+		return
+	}
+	if h.counts == nil {
+		h.counts = make(map[int]int64)
+	}
+	h.counts[bucket] += n
+	h.total += n
+}
+
+// record counts one request of the given latency.
+func (h *latencyHistogram) record(latency time.Duration) {
+	h.add(latencyBucket(latency.Milliseconds()), 1)
+}
+
+// quantile estimates the latency in milliseconds below which a fraction q of samples fall.
+func (h *latencyHistogram) quantile(buckets []int, q float64) float64 {
+	rank := int64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for _, bucket := range buckets {
+		seen += h.counts[bucket]
+		if seen >= rank {
+			return math.Round(latencyBucketValue(bucket)*100) / 100
+		}
+	}
+	return math.Round(latencyBucketValue(buckets[len(buckets)-1])*100) / 100
+}
+
+// summary returns the percentiles of the histogram, or nil when it holds no samples.
+func (h *latencyHistogram) summary() *LatencySummary {
+	if h == nil || h.total == 0 {
+		return nil
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for bucket := range h.counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	return &LatencySummary{
+		Count: h.total,
+		P50Ms: h.quantile(buckets, 0.50),
+		P90Ms: h.quantile(buckets, 0.90),
+		P99Ms: h.quantile(buckets, 0.99),
+	}
+}
+
+// decodeLatencyHistogram rebuilds a histogram from a bucket/count hash as stored in Redis.
+func decodeLatencyHistogram(values map[string]string) latencyHistogram {
+	var h latencyHistogram
+	for field, value := range values {
+		bucket, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		h.add(bucket, parseCounter(value))
+	}
+	return h
+}
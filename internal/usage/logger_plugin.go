@@ -6,12 +6,12 @@ package usage
 import (
 	"context"
 	"fmt"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 )
 
@@ -56,6 +56,22 @@ func (p *LoggerPlugin) HandleUsage(ctx context.Context, record coreusage.Record)
 	storage.Record(ctx, record)
 }
 
+// HandlePartialUsage implements coreusage.PartialPlugin.
+// It adds the tokens of a response still streaming to the pending tokens of the store.
+func (p *LoggerPlugin) HandlePartialUsage(ctx context.Context, requestID string, deltaTokens int64) {
+	if !statisticsEnabled.Load() {
+		return
+	}
+	if p == nil {
+		return
+	}
+	storage := GetStatsStorage()
+	if storage == nil {
+		return
+	}
+	storage.UpdatePartial(ctx, requestID, deltaTokens)
+}
+
 // SetStatisticsEnabled toggles whether in-memory statistics are recorded.
 func SetStatisticsEnabled(enabled bool) { statisticsEnabled.Store(enabled) }
 
@@ -69,28 +85,92 @@ type RequestStatistics struct {
 	totalRequests int64
 	successCount  int64
 	failureCount  int64
-	totalTokens   int64
-
-	apis map[string]*apiStats
-
-	requestsByDay  map[string]int64
-	requestsByHour map[int]int64
-	tokensByDay    map[string]int64
-	tokensByHour   map[int]int64
+	successTokens int64
+	failureTokens int64
+	totalCost     float64
+
+	apis        map[string]*apiStats
+	tenantCosts map[string]float64
+	// unpricedRequests counts requests per model that had no price.
+	unpricedRequests map[string]int64
+	// partials accumulates the tokens of requests still streaming, keyed by request ID;
+	// lastSweep is when timed out accumulators were last committed.
+	partials  map[string]*partialUsage
+	lastSweep time.Time
+	// providers aggregates requests per upstream provider.
+	providers map[string]ProviderSnapshot
+	// refreshes counts token refresh attempts per provider.
+	refreshes map[string]RefreshSnapshot
+	// dayModels counts requests and tokens per day and model for QueryRange.
+	dayModels map[string]map[string]*RangeCounts
+
+	// maxDetails caps the details kept per model; 0 keeps all of them.
+	maxDetails int
+	// mergeConcurrency is the configured merge-concurrency; see newSnapshotMerger.
+	mergeConcurrency int
+	// retention bounds the day and minute buckets; lastPrune is the minute they were last
+	// pruned at.
+	retention bucketRetention
+	lastPrune time.Time
+
+	granularities granularitySet
+
+	requestsByMinute map[string]int64
+	requestsByHour   map[int]int64
+	requestsByDay    map[string]int64
+	requestsByMonth  map[string]int64
+	tokensByMinute   map[string]int64
+	tokensByHour     map[int]int64
+	tokensByDay      map[string]int64
+	tokensByMonth    map[string]int64
 }
 
 // apiStats holds aggregated metrics for a single API key.
 type apiStats struct {
 	TotalRequests int64
-	TotalTokens   int64
+	SuccessTokens int64
+	FailureTokens int64
+	TotalCost     float64
 	Models        map[string]*modelStats
 }
 
 // modelStats holds aggregated metrics for a specific model within an API.
 type modelStats struct {
 	TotalRequests int64
-	TotalTokens   int64
-	Details       []RequestDetail
+	FailureCount  int64
+	SuccessTokens int64
+	FailureTokens int64
+	TotalCost     float64
+	Latency       latencyHistogram
+	// Details is a ring buffer once it reaches the detail limit: detailsNext is the slot
+	// holding the oldest entry, which the next detail overwrites.
+	Details     []RequestDetail
+	detailsNext int
+}
+
+// addDetail appends detail, dropping the oldest one when limit (if positive) is reached.
+func (m *modelStats) addDetail(detail RequestDetail, limit int) {
+	if m.detailsNext != 0 && len(m.Details) != limit {
+		// The limit changed since the ring wrapped; restore chronological order first.
+		m.Details = m.orderedDetails()
+		m.detailsNext = 0
+	}
+	if limit > 0 && len(m.Details) > limit {
+		m.Details = append([]RequestDetail(nil), m.Details[len(m.Details)-limit:]...)
+	}
+	if limit <= 0 || len(m.Details) < limit {
+		m.Details = append(m.Details, detail)
+		return
+	}
+	m.Details[m.detailsNext] = detail
+	m.detailsNext = (m.detailsNext + 1) % limit
+}
+
+// orderedDetails returns a copy of the details, oldest first.
+func (m *modelStats) orderedDetails() []RequestDetail {
+	details := make([]RequestDetail, 0, len(m.Details))
+	details = append(details, m.Details[m.detailsNext:]...)
+	return append(details, m.Details[:m.detailsNext]...)
 }
 
 // RequestDetail stores the timestamp and token usage for a single request.
@@ -100,6 +180,14 @@ type RequestDetail struct {
 	AuthIndex string     `json:"auth_index"`
 	Tokens    TokenStats `json:"tokens"`
 	Failed    bool       `json:"failed"`
+	Cost      float64    `json:"cost,omitempty"`
+	Tenant    string     `json:"tenant,omitempty"`
+	// Unpriced marks a request whose model has no price, so Cost is zero rather than estimated.
+	Unpriced bool `json:"unpriced,omitempty"`
+	// LatencyMs is the upstream response time in milliseconds; 0 when unknown.
+	LatencyMs int64 `json:"latency_ms,omitempty"`
+	// Provider is the upstream provider that served the request, see resolveProvider.
+	Provider string `json:"provider,omitempty"`
 }
 
 // TokenStats captures the token usage breakdown for a request.
@@ -108,6 +196,7 @@ type TokenStats struct {
 	OutputTokens    int64 `json:"output_tokens"`
 	ReasoningTokens int64 `json:"reasoning_tokens"`
 	CachedTokens    int64 `json:"cached_tokens"`
+	ToolTokens      int64 `json:"tool_tokens"`
 	TotalTokens     int64 `json:"total_tokens"`
 }
 
@@ -116,7 +205,17 @@ type StatisticsSnapshot struct {
 	TotalRequests int64 `json:"total_requests"`
 	SuccessCount  int64 `json:"success_count"`
 	FailureCount  int64 `json:"failure_count"`
+	// TotalTokens is the sum of SuccessTokens and FailureTokens, the tokens of successful and
+	// failed requests.
 	TotalTokens   int64 `json:"total_tokens"`
+	SuccessTokens int64 `json:"success_tokens"`
+	FailureTokens int64 `json:"failure_tokens"`
+	// PendingTokens are the tokens reported so far by responses still streaming. They are not
+	// part of TotalTokens until the request's final record, which replaces them.
+	PendingTokens int64 `json:"pending_tokens,omitempty"`
+
+	// TotalCost is the estimated cost of all requests, see usage-pricing.
+	TotalCost float64 `json:"total_cost"`
 
 	APIs map[string]APISnapshot `json:"apis"`
 
@@ -124,20 +223,52 @@ type StatisticsSnapshot struct {
 	RequestsByHour map[string]int64 `json:"requests_by_hour"`
 	TokensByDay    map[string]int64 `json:"tokens_by_day"`
 	TokensByHour   map[string]int64 `json:"tokens_by_hour"`
+
+	// Minute ("2006-01-02 15:04") and month ("2006-01") buckets are only present when enabled.
+	RequestsByMinute map[string]int64 `json:"requests_by_minute,omitempty"`
+	TokensByMinute   map[string]int64 `json:"tokens_by_minute,omitempty"`
+	RequestsByMonth  map[string]int64 `json:"requests_by_month,omitempty"`
+	TokensByMonth    map[string]int64 `json:"tokens_by_month,omitempty"`
+
+	// Granularities lists the time buckets maintained by the store, finest first.
+	Granularities []string `json:"granularities,omitempty"`
+
+	// TenantCosts aggregates the estimated cost per tenant for billing reconciliation.
+	TenantCosts map[string]float64 `json:"tenant_costs,omitempty"`
+
+	// UnpricedRequests counts, per model, the requests that had no usage-pricing entry and
+	// were recorded at zero cost, so operators can tell how much spend TotalCost is missing.
+	UnpricedRequests map[string]int64 `json:"unpriced_requests,omitempty"`
+
+	// Providers aggregates requests, failures and tokens per upstream provider.
+	Providers map[string]ProviderSnapshot `json:"providers,omitempty"`
+
+	// Refreshes counts token refresh attempts per provider. MergeSnapshot does not import
+	// them: without details they cannot be deduplicated.
+	Refreshes map[string]RefreshSnapshot `json:"refreshes,omitempty"`
 }
 
 // APISnapshot summarises metrics for a single API key.
 type APISnapshot struct {
 	TotalRequests int64                    `json:"total_requests"`
 	TotalTokens   int64                    `json:"total_tokens"`
+	SuccessTokens int64                    `json:"success_tokens"`
+	FailureTokens int64                    `json:"failure_tokens"`
+	TotalCost     float64                  `json:"total_cost"`
 	Models        map[string]ModelSnapshot `json:"models"`
 }
 
 // ModelSnapshot summarises metrics for a specific model.
 type ModelSnapshot struct {
-	TotalRequests int64           `json:"total_requests"`
-	TotalTokens   int64           `json:"total_tokens"`
-	Details       []RequestDetail `json:"details"`
+	TotalRequests int64   `json:"total_requests"`
+	FailureCount  int64   `json:"failure_count"`
+	TotalTokens   int64   `json:"total_tokens"`
+	SuccessTokens int64   `json:"success_tokens"`
+	FailureTokens int64   `json:"failure_tokens"`
+	TotalCost     float64 `json:"total_cost"`
+	// Latency summarises the response times of the model's requests; nil when none were measured.
+	Latency *LatencySummary `json:"latency,omitempty"`
+	Details []RequestDetail `json:"details"`
 }
 
 var defaultRequestStatistics = NewRequestStatistics()
@@ -147,15 +278,114 @@ func GetRequestStatistics() *RequestStatistics { return defaultRequestStatistics
 
 // NewRequestStatistics constructs an empty statistics store.
 func NewRequestStatistics() *RequestStatistics {
-	return &RequestStatistics{
-		apis:           make(map[string]*apiStats),
-		requestsByDay:  make(map[string]int64),
-		requestsByHour: make(map[int]int64),
-		tokensByDay:    make(map[string]int64),
-		tokensByHour:   make(map[int]int64),
+	s := &RequestStatistics{
+		apis:             make(map[string]*apiStats),
+		tenantCosts:      make(map[string]float64),
+		unpricedRequests: make(map[string]int64),
+		providers:        make(map[string]ProviderSnapshot),
+		refreshes:        make(map[string]RefreshSnapshot),
+		partials:         make(map[string]*partialUsage),
+		dayModels:        make(map[string]map[string]*RangeCounts),
+		granularities:    defaultGranularities,
+		maxDetails:       config.DefaultUsageMaxDetailsPerModel,
+		retention:        resolveRetention(0, 0),
+	}
+	s.resetTimeBuckets()
+	return s
+}
+
+// SetGranularities selects the time buckets maintained from now on (minute, hour, day, month).
+// An empty list keeps the default hour and day buckets.
+func (s *RequestStatistics) SetGranularities(names []string) {
+	if s == nil {
+		return
+	}
+	granularities := parseGranularities(names)
+	s.mu.Lock()
+	s.granularities = granularities
+	s.mu.Unlock()
+}
+
+// SetMaxDetailsPerModel caps the request details kept per API key and model from now on.
+// 0 uses the default limit and a negative value keeps every detail; the aggregate counters
+// are not affected by the limit.
+func (s *RequestStatistics) SetMaxDetailsPerModel(limit int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.maxDetails = resolveMaxDetails(limit)
+	s.mu.Unlock()
+}
+
+// SetMergeConcurrency bounds the API keys merged concurrently by MergeSnapshot; 0 uses
+// GOMAXPROCS.
+func (s *RequestStatistics) SetMergeConcurrency(workers int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.mergeConcurrency = workers
+	s.mu.Unlock()
+}
+
+// resolveMaxDetails maps the configured detail limit to the one applied, where 0 means unlimited.
+func resolveMaxDetails(limit int) int {
+	switch {
+	case limit == 0:
+		return config.DefaultUsageMaxDetailsPerModel
+	case limit < 0:
+		return 0
+	default:
+		return limit
 	}
 }
 
+// trimDetails drops the oldest details beyond limit; a limit of 0 keeps all of them.
+func trimDetails(details []RequestDetail, limit int) []RequestDetail {
+	if limit > 0 && len(details) > limit {
+		return details[len(details)-limit:]
+	}
+	return details
+}
+
+func (s *RequestStatistics) resetTimeBuckets() {
+	s.requestsByMinute = make(map[string]int64)
+	s.requestsByHour = make(map[int]int64)
+	s.requestsByDay = make(map[string]int64)
+	s.requestsByMonth = make(map[string]int64)
+	s.tokensByMinute = make(map[string]int64)
+	s.tokensByHour = make(map[int]int64)
+	s.tokensByDay = make(map[string]int64)
+	s.tokensByMonth = make(map[string]int64)
+}
+
+// addTimeBuckets counts a request in every enabled bucket and prunes the buckets past the
+// retention window; the caller must hold s.mu.
+func (s *RequestStatistics) addTimeBuckets(timestamp time.Time, totalTokens int64) {
+	if s.granularities.minute {
+		minuteKey := timestamp.Format(minuteBucketLayout)
+		s.requestsByMinute[minuteKey]++
+		s.tokensByMinute[minuteKey] += totalTokens
+	}
+	if s.granularities.hour {
+		hourKey := timestamp.Hour()
+		s.requestsByHour[hourKey]++
+		s.tokensByHour[hourKey] += totalTokens
+	}
+	if s.granularities.day {
+		dayKey := timestamp.Format(dayBucketLayout)
+		s.requestsByDay[dayKey]++
+		s.tokensByDay[dayKey] += totalTokens
+	}
+	if s.granularities.month {
+		monthKey := timestamp.Format(monthBucketLayout)
+		s.requestsByMonth[monthKey]++
+		s.tokensByMonth[monthKey] += totalTokens
+	}
+	s.pruneIfDueLocked(timestamp)
+}
+
 // Record ingests a new usage record and updates the aggregates.
 func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record) {
 	if s == nil {
@@ -164,6 +394,12 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 	if !statisticsEnabled.Load() {
 		return
 	}
+	if isRefreshRecord(record.Source) {
+		s.mu.Lock()
+		s.refreshes = addRefresh(s.refreshes, resolveProvider(record.Provider, ""), record.Failed)
+		s.mu.Unlock()
+		return
+	}
 	timestamp := record.RequestedAt
 	if timestamp.IsZero() {
 		timestamp = time.Now()
@@ -183,19 +419,28 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 	if modelName == "" {
 		modelName = "unknown"
 	}
-	dayKey := timestamp.Format("2006-01-02")
-	hourKey := timestamp.Hour()
+	cost, priced := estimateCost(record, detail)
+	tenant := resolveTenant(record, statsKey)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if record.RequestID != "" {
+		delete(s.partials, record.RequestID)
+	}
+	s.sweepPartialsLocked(time.Now())
 	s.totalRequests++
 	if success {
 		s.successCount++
+		s.successTokens += totalTokens
 	} else {
 		s.failureCount++
+		s.failureTokens += totalTokens
+	}
+	s.totalCost += cost
+	if cost > 0 {
+		s.tenantCosts[tenant] += cost
 	}
-	s.totalTokens += totalTokens
 
 	stats, ok := s.apis[statsKey]
 	if !ok {
@@ -208,25 +453,80 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 		AuthIndex: record.AuthIndex,
 		Tokens:    detail,
 		Failed:    failed,
+		Cost:      cost,
+		Tenant:    tenant,
+		Unpriced:  !priced,
+		LatencyMs: record.Latency.Milliseconds(),
+		Provider:  resolveProvider(record.Provider, modelName),
 	})
 
-	s.requestsByDay[dayKey]++
-	s.requestsByHour[hourKey]++
-	s.tokensByDay[dayKey] += totalTokens
-	s.tokensByHour[hourKey] += totalTokens
+	s.addTimeBuckets(timestamp, totalTokens)
 }
 
 func (s *RequestStatistics) updateAPIStats(stats *apiStats, model string, detail RequestDetail) {
 	stats.TotalRequests++
-	stats.TotalTokens += detail.Tokens.TotalTokens
+	addTokens(&stats.SuccessTokens, &stats.FailureTokens, detail)
+	stats.TotalCost += detail.Cost
 	modelStatsValue, ok := stats.Models[model]
 	if !ok {
 		modelStatsValue = &modelStats{}
 		stats.Models[model] = modelStatsValue
 	}
 	modelStatsValue.TotalRequests++
-	modelStatsValue.TotalTokens += detail.Tokens.TotalTokens
-	modelStatsValue.Details = append(modelStatsValue.Details, detail)
+	if detail.Failed {
+		modelStatsValue.FailureCount++
+	}
+	addTokens(&modelStatsValue.SuccessTokens, &modelStatsValue.FailureTokens, detail)
+	modelStatsValue.TotalCost += detail.Cost
+	if detail.LatencyMs > 0 {
+		modelStatsValue.Latency.record(time.Duration(detail.LatencyMs) * time.Millisecond)
+	}
+	modelStatsValue.addDetail(detail, s.maxDetails)
+	if detail.Unpriced {
+		s.unpricedRequests[model]++
+	}
+	s.providers = addProviderStats(s.providers, detail)
+
+	day := detail.Timestamp.In(time.Local).Format(dayBucketLayout)
+	models, ok := s.dayModels[day]
+	if !ok {
+		models = make(map[string]*RangeCounts)
+		s.dayModels[day] = models
+	}
+	counts, ok := models[model]
+	if !ok {
+		counts = &RangeCounts{}
+		models[model] = counts
+	}
+	counts.Requests++
+	counts.Tokens += detail.Tokens.TotalTokens
+}
+
+// addTokens adds the tokens of detail to the success or failure counter, by its outcome.
+func addTokens(success, failure *int64, detail RequestDetail) {
+	if detail.Failed {
+		*failure += detail.Tokens.TotalTokens
+	} else {
+		*success += detail.Tokens.TotalTokens
+	}
+}
+
+// QueryRange returns the requests and tokens of the days from from to to inclusive, per model.
+func (s *RequestStatistics) QueryRange(from, to time.Time) RangeStats {
+	result := newRangeStats(from, to)
+	if s == nil {
+		return result
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, day := range rangeDays(from, to) {
+		for model, counts := range s.dayModels[day] {
+			result.add(day, model, counts.Requests, counts.Tokens)
+		}
+	}
+	return result
 }
 
 // Snapshot returns a copy of the aggregated metrics for external consumption.
@@ -239,25 +539,67 @@ func (s *RequestStatistics) Snapshot() StatisticsSnapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.snapshotLocked()
+}
+
+// ExportAndReset returns the current snapshot and clears all aggregates under a single lock.
+func (s *RequestStatistics) ExportAndReset() StatisticsSnapshot {
+	if s == nil {
+		return StatisticsSnapshot{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := s.snapshotLocked()
+	s.totalRequests = 0
+	s.successCount = 0
+	s.failureCount = 0
+	s.successTokens = 0
+	s.failureTokens = 0
+	s.totalCost = 0
+	s.apis = make(map[string]*apiStats)
+	s.tenantCosts = make(map[string]float64)
+	s.unpricedRequests = make(map[string]int64)
+	s.providers = make(map[string]ProviderSnapshot)
+	s.refreshes = make(map[string]RefreshSnapshot)
+	s.dayModels = make(map[string]map[string]*RangeCounts)
+	s.resetTimeBuckets()
+	return result
+}
+
+// snapshotLocked copies the aggregates; the caller must hold s.mu.
+func (s *RequestStatistics) snapshotLocked() StatisticsSnapshot {
+	result := StatisticsSnapshot{}
 	result.TotalRequests = s.totalRequests
 	result.SuccessCount = s.successCount
 	result.FailureCount = s.failureCount
-	result.TotalTokens = s.totalTokens
+	result.SuccessTokens = s.successTokens
+	result.FailureTokens = s.failureTokens
+	result.TotalTokens = s.successTokens + s.failureTokens
+	result.PendingTokens = s.pendingTokensLocked()
+	result.TotalCost = s.totalCost
 
 	result.APIs = make(map[string]APISnapshot, len(s.apis))
 	for apiName, stats := range s.apis {
 		apiSnapshot := APISnapshot{
 			TotalRequests: stats.TotalRequests,
-			TotalTokens:   stats.TotalTokens,
+			TotalTokens:   stats.SuccessTokens + stats.FailureTokens,
+			SuccessTokens: stats.SuccessTokens,
+			FailureTokens: stats.FailureTokens,
+			TotalCost:     stats.TotalCost,
 			Models:        make(map[string]ModelSnapshot, len(stats.Models)),
 		}
 		for modelName, modelStatsValue := range stats.Models {
-			requestDetails := make([]RequestDetail, len(modelStatsValue.Details))
-			copy(requestDetails, modelStatsValue.Details)
 			apiSnapshot.Models[modelName] = ModelSnapshot{
 				TotalRequests: modelStatsValue.TotalRequests,
-				TotalTokens:   modelStatsValue.TotalTokens,
-				Details:       requestDetails,
+				FailureCount:  modelStatsValue.FailureCount,
+				TotalTokens:   modelStatsValue.SuccessTokens + modelStatsValue.FailureTokens,
+				SuccessTokens: modelStatsValue.SuccessTokens,
+				FailureTokens: modelStatsValue.FailureTokens,
+				TotalCost:     modelStatsValue.TotalCost,
+				Latency:       modelStatsValue.Latency.summary(),
+				Details:       modelStatsValue.orderedDetails(),
 			}
 		}
 		result.APIs[apiName] = apiSnapshot
@@ -285,6 +627,33 @@ func (s *RequestStatistics) Snapshot() StatisticsSnapshot {
 		result.TokensByHour[key] = v
 	}
 
+	if s.granularities.minute {
+		result.RequestsByMinute = copyBuckets(s.requestsByMinute)
+		result.TokensByMinute = copyBuckets(s.tokensByMinute)
+	}
+	if s.granularities.month {
+		result.RequestsByMonth = copyBuckets(s.requestsByMonth)
+		result.TokensByMonth = copyBuckets(s.tokensByMonth)
+	}
+	result.Granularities = s.granularities.names()
+
+	if len(s.tenantCosts) > 0 {
+		result.TenantCosts = make(map[string]float64, len(s.tenantCosts))
+		for tenant, cost := range s.tenantCosts {
+			result.TenantCosts[tenant] = cost
+		}
+	}
+	if len(s.unpricedRequests) > 0 {
+		result.UnpricedRequests = copyBuckets(s.unpricedRequests)
+	}
+	result.Providers = addProviderSnapshots(nil, s.providers)
+	if len(s.refreshes) > 0 {
+		result.Refreshes = make(map[string]RefreshSnapshot, len(s.refreshes))
+		for provider, stats := range s.refreshes {
+			result.Refreshes[provider] = stats
+		}
+	}
+
 	return result
 }
 
@@ -294,69 +663,44 @@ type MergeResult struct {
 }
 
 // MergeSnapshot merges an exported statistics snapshot into the current store.
-// Existing data is preserved and duplicate request details are skipped.
+// Existing data is preserved and duplicate request details are skipped. Deduplication runs
+// on a snapshot of the store, the same way as for Redis, and the added details are then
+// replayed into the aggregates.
 func (s *RequestStatistics) MergeSnapshot(snapshot StatisticsSnapshot) MergeResult {
-	result := MergeResult{}
 	if s == nil {
-		return result
+		return MergeResult{}
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	seen := make(map[string]struct{})
-	for apiName, stats := range s.apis {
-		if stats == nil {
-			continue
-		}
-		for modelName, modelStatsValue := range stats.Models {
-			if modelStatsValue == nil {
-				continue
-			}
-			for _, detail := range modelStatsValue.Details {
-				seen[dedupKey(apiName, modelName, detail)] = struct{}{}
-			}
-		}
-	}
-
-	for apiName, apiSnapshot := range snapshot.APIs {
-		apiName = strings.TrimSpace(apiName)
-		if apiName == "" {
+	current := s.snapshotLocked()
+	jobs, result := s.merger().merge(&current, snapshot)
+	for _, job := range jobs {
+		if len(job.added) == 0 {
 			continue
 		}
-		stats, ok := s.apis[apiName]
+		stats, ok := s.apis[job.apiName]
 		if !ok || stats == nil {
 			stats = &apiStats{Models: make(map[string]*modelStats)}
-			s.apis[apiName] = stats
+			s.apis[job.apiName] = stats
 		} else if stats.Models == nil {
 			stats.Models = make(map[string]*modelStats)
 		}
-		for modelName, modelSnapshot := range apiSnapshot.Models {
-			modelName = strings.TrimSpace(modelName)
-			if modelName == "" {
-				modelName = "unknown"
-			}
-			for _, detail := range modelSnapshot.Details {
-				detail.Tokens = normaliseTokenStats(detail.Tokens)
-				if detail.Timestamp.IsZero() {
-					detail.Timestamp = time.Now()
-				}
-				key := dedupKey(apiName, modelName, detail)
-				if _, exists := seen[key]; exists {
-					result.Skipped++
-					continue
-				}
-				seen[key] = struct{}{}
-				s.recordImported(apiName, modelName, stats, detail)
-				result.Added++
-			}
+		for _, added := range job.added {
+			s.recordImported(job.apiName, added.modelName, stats, added.detail)
 		}
 	}
-
 	return result
 }
 
+// merger returns the snapshotMerger matching the store settings. The caller must hold s.mu.
+func (s *RequestStatistics) merger() snapshotMerger {
+	return newSnapshotMerger(s.granularities, s.maxDetails, s.mergeConcurrency)
+}
+
 func (s *RequestStatistics) recordImported(apiName, modelName string, stats *apiStats, detail RequestDetail) {
+	detail.Provider = resolveProvider(detail.Provider, modelName)
 	totalTokens := detail.Tokens.TotalTokens
 	if totalTokens < 0 {
 		totalTokens = 0
@@ -365,39 +709,30 @@ func (s *RequestStatistics) recordImported(apiName, modelName string, stats *api
 	s.totalRequests++
 	if detail.Failed {
 		s.failureCount++
+		s.failureTokens += totalTokens
 	} else {
 		s.successCount++
+		s.successTokens += totalTokens
+	}
+	s.totalCost += detail.Cost
+	if detail.Cost > 0 {
+		tenant := detail.Tenant
+		if tenant == "" {
+			tenant = apiName
+		}
+		s.tenantCosts[tenant] += detail.Cost
 	}
-	s.totalTokens += totalTokens
 
 	s.updateAPIStats(stats, modelName, detail)
+	s.addTimeBuckets(detail.Timestamp, totalTokens)
+}
 
-	dayKey := detail.Timestamp.Format("2006-01-02")
-	hourKey := detail.Timestamp.Hour()
-
-	s.requestsByDay[dayKey]++
-	s.requestsByHour[hourKey]++
-	s.tokensByDay[dayKey] += totalTokens
-	s.tokensByHour[hourKey] += totalTokens
-}
-
-func dedupKey(apiName, modelName string, detail RequestDetail) string {
-	timestamp := detail.Timestamp.UTC().Format(time.RFC3339Nano)
-	tokens := normaliseTokenStats(detail.Tokens)
-	return fmt.Sprintf(
-		"%s|%s|%s|%s|%s|%t|%d|%d|%d|%d|%d",
-		apiName,
-		modelName,
-		timestamp,
-		detail.Source,
-		detail.AuthIndex,
-		detail.Failed,
-		tokens.InputTokens,
-		tokens.OutputTokens,
-		tokens.ReasoningTokens,
-		tokens.CachedTokens,
-		tokens.TotalTokens,
-	)
+func copyBuckets(buckets map[string]int64) map[string]int64 {
+	result := make(map[string]int64, len(buckets))
+	for k, v := range buckets {
+		result[k] = v
+	}
+	return result
 }
 
 func resolveAPIIdentifier(ctx context.Context, record coreusage.Record) string {
@@ -448,23 +783,24 @@ func normaliseDetail(detail coreusage.Detail) TokenStats {
 		OutputTokens:    detail.OutputTokens,
 		ReasoningTokens: detail.ReasoningTokens,
 		CachedTokens:    detail.CachedTokens,
+		ToolTokens:      detail.ToolTokens,
 		TotalTokens:     detail.TotalTokens,
 	}
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens
+		tokens.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens + detail.ToolTokens
 	}
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens + detail.CachedTokens
+		tokens.TotalTokens = detail.InputTokens + detail.OutputTokens + detail.ReasoningTokens + detail.ToolTokens + detail.CachedTokens
 	}
 	return tokens
 }
 
 func normaliseTokenStats(tokens TokenStats) TokenStats {
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens
+		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.ToolTokens
 	}
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.CachedTokens
+		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.ToolTokens + tokens.CachedTokens
 	}
 	return tokens
 }
@@ -0,0 +1,294 @@
+package usage
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotMerger merges imported snapshots into a StatisticsSnapshot. Both storage backends
+// merge through it, so they skip the same duplicates and count an imported detail the same
+// way: Redis applies the details it adds with statsRecordScript and the memory store replays
+// them into its aggregates. Both build it with newSnapshotMerger.
+type snapshotMerger struct {
+	granularities granularitySet
+	// maxDetails caps the details kept per model; 0 keeps all of them.
+	maxDetails int
+	// workers bounds the API keys merged concurrently; below 1 merges them one at a time.
+	workers int
+}
+
+// newSnapshotMerger returns the merger used by both storage backends. mergeConcurrency is the
+// configured merge-concurrency, where 0 uses GOMAXPROCS.
+func newSnapshotMerger(granularities granularitySet, maxDetails, mergeConcurrency int) snapshotMerger {
+	workers := mergeConcurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return snapshotMerger{granularities: granularities, maxDetails: maxDetails, workers: workers}
+}
+
+// apiMerge holds the outcome of merging one API key of an imported snapshot.
+type apiMerge struct {
+	apiName string
+	stats   APISnapshot
+	delta   StatisticsSnapshot
+	result  MergeResult
+	// added lists the imported details that were not duplicates, in merge order.
+	added []importedDetail
+}
+
+// importedDetail is a detail added by a merge together with the model it belongs to.
+type importedDetail struct {
+	modelName string
+	detail    RequestDetail
+}
+
+// merge merges source into target and returns the per-API-key jobs with the details they
+// added. Dedup keys are scoped to an API key, so each key is merged independently by a
+// bounded pool of workers and the results are combined afterwards.
+func (m snapshotMerger) merge(target *StatisticsSnapshot, source StatisticsSnapshot) ([]apiMerge, MergeResult) {
+	result := MergeResult{}
+
+	if target.APIs == nil {
+		target.APIs = make(map[string]APISnapshot)
+	}
+
+	// Keys that only differ in surrounding whitespace normalize to the same API key; they
+	// share one job so their details are deduplicated against each other.
+	jobs := make([]apiMerge, 0, len(source.APIs))
+	sourceAPIs := make([][]APISnapshot, 0, len(source.APIs))
+	jobIndex := make(map[string]int, len(source.APIs))
+	for apiName, apiSnapshot := range source.APIs {
+		if apiName = normalizeAPIKey(apiName); apiName == "" {
+			continue
+		}
+		i, ok := jobIndex[apiName]
+		if !ok {
+			i = len(jobs)
+			jobIndex[apiName] = i
+			jobs = append(jobs, apiMerge{apiName: apiName, stats: target.APIs[apiName]})
+			sourceAPIs = append(sourceAPIs, nil)
+		}
+		sourceAPIs[i] = append(sourceAPIs[i], apiSnapshot)
+	}
+
+	workers := m.workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				m.mergeAPI(&jobs[i], sourceAPIs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i := range jobs {
+		job := &jobs[i]
+		target.APIs[job.apiName] = job.stats
+		addSnapshotTotals(target, job.delta)
+		result.Added += job.result.Added
+		result.Skipped += job.result.Skipped
+	}
+
+	return jobs, result
+}
+
+// mergeAPI merges the models of the imported snapshots of one API key into job.stats,
+// recording the aggregate counters it adds in job.delta.
+func (m snapshotMerger) mergeAPI(job *apiMerge, apiSnapshots []APISnapshot) {
+	apiName := job.apiName
+	stats := job.stats
+	seen := make(map[string]struct{})
+	for modelName, modelStatsValue := range stats.Models {
+		for _, detail := range modelStatsValue.Details {
+			seen[dedupKey(apiName, modelName, detail)] = struct{}{}
+		}
+	}
+
+	// Copy the models map so the target snapshot is only modified when results are combined.
+	models := make(map[string]ModelSnapshot, len(stats.Models))
+	for modelName, modelStatsValue := range stats.Models {
+		models[modelName] = modelStatsValue
+	}
+	stats.Models = models
+
+	for _, apiSnapshot := range apiSnapshots {
+		for modelName, modelSnapshot := range apiSnapshot.Models {
+			if modelName = normalizeModelName(modelName); modelName == "" {
+				modelName = "unknown"
+			}
+			for _, detail := range modelSnapshot.Details {
+				// Refresh attempts used to be recorded as requests; they are not imported as such.
+				if isRefreshRecord(detail.Source) {
+					continue
+				}
+				detail.Tokens = normaliseTokenStats(detail.Tokens)
+				if detail.Timestamp.IsZero() {
+					detail.Timestamp = time.Now()
+				}
+				key := dedupKey(apiName, modelName, detail)
+				if _, exists := seen[key]; exists {
+					job.result.Skipped++
+					continue
+				}
+				seen[key] = struct{}{}
+				m.recordImported(&job.delta, apiName, modelName, &stats, detail)
+				job.added = append(job.added, importedDetail{modelName: modelName, detail: detail})
+				job.result.Added++
+			}
+		}
+	}
+	job.stats = stats
+}
+
+// addSnapshotTotals adds the counters and time buckets of delta to target.
+func addSnapshotTotals(target *StatisticsSnapshot, delta StatisticsSnapshot) {
+	target.TotalRequests += delta.TotalRequests
+	target.SuccessCount += delta.SuccessCount
+	target.FailureCount += delta.FailureCount
+	target.TotalTokens += delta.TotalTokens
+	target.SuccessTokens += delta.SuccessTokens
+	target.FailureTokens += delta.FailureTokens
+	target.TotalCost += delta.TotalCost
+	for tenant, cost := range delta.TenantCosts {
+		if target.TenantCosts == nil {
+			target.TenantCosts = make(map[string]float64)
+		}
+		target.TenantCosts[tenant] += cost
+	}
+	target.UnpricedRequests = addBuckets(target.UnpricedRequests, delta.UnpricedRequests)
+	target.Providers = addProviderSnapshots(target.Providers, delta.Providers)
+	target.RequestsByDay = addBuckets(target.RequestsByDay, delta.RequestsByDay)
+	target.RequestsByHour = addBuckets(target.RequestsByHour, delta.RequestsByHour)
+	target.TokensByDay = addBuckets(target.TokensByDay, delta.TokensByDay)
+	target.TokensByHour = addBuckets(target.TokensByHour, delta.TokensByHour)
+	target.RequestsByMinute = addBuckets(target.RequestsByMinute, delta.RequestsByMinute)
+	target.TokensByMinute = addBuckets(target.TokensByMinute, delta.TokensByMinute)
+	target.RequestsByMonth = addBuckets(target.RequestsByMonth, delta.RequestsByMonth)
+	target.TokensByMonth = addBuckets(target.TokensByMonth, delta.TokensByMonth)
+}
+
+func addBuckets(target, delta map[string]int64) map[string]int64 {
+	if len(delta) == 0 {
+		return target
+	}
+	if target == nil {
+		target = make(map[string]int64, len(delta))
+	}
+	for key, value := range delta {
+		target[key] += value
+	}
+	return target
+}
+
+// recordImported counts detail in snapshot and in stats, the API key it belongs to.
+func (m snapshotMerger) recordImported(snapshot *StatisticsSnapshot, apiName, modelName string, stats *APISnapshot, detail RequestDetail) {
+	totalTokens := detail.Tokens.TotalTokens
+	if totalTokens < 0 {
+		totalTokens = 0
+	}
+
+	snapshot.TotalRequests++
+	if detail.Failed {
+		snapshot.FailureCount++
+		snapshot.FailureTokens += totalTokens
+	} else {
+		snapshot.SuccessCount++
+		snapshot.SuccessTokens += totalTokens
+	}
+	snapshot.TotalTokens += totalTokens
+	snapshot.TotalCost += detail.Cost
+	if detail.Cost > 0 {
+		tenant := detail.Tenant
+		if tenant == "" {
+			tenant = apiName
+		}
+		if snapshot.TenantCosts == nil {
+			snapshot.TenantCosts = make(map[string]float64)
+		}
+		snapshot.TenantCosts[tenant] += detail.Cost
+	}
+
+	if detail.Unpriced {
+		snapshot.UnpricedRequests = incrementBucket(snapshot.UnpricedRequests, modelName, 1)
+	}
+	detail.Provider = resolveProvider(detail.Provider, modelName)
+	snapshot.Providers = addProviderStats(snapshot.Providers, detail)
+
+	stats.TotalRequests++
+	stats.TotalTokens += totalTokens
+	if detail.Failed {
+		stats.FailureTokens += totalTokens
+	} else {
+		stats.SuccessTokens += totalTokens
+	}
+	stats.TotalCost += detail.Cost
+
+	if stats.Models == nil {
+		stats.Models = make(map[string]ModelSnapshot)
+	}
+	modelStatsValue, ok := stats.Models[modelName]
+	if !ok {
+		modelStatsValue = ModelSnapshot{}
+	}
+	modelStatsValue.TotalRequests++
+	modelStatsValue.TotalTokens += totalTokens
+	if detail.Failed {
+		modelStatsValue.FailureCount++
+		modelStatsValue.FailureTokens += totalTokens
+	} else {
+		modelStatsValue.SuccessTokens += totalTokens
+	}
+	modelStatsValue.TotalCost += detail.Cost
+	modelStatsValue.Details = trimDetails(append(modelStatsValue.Details, detail), m.maxDetails)
+	stats.Models[modelName] = modelStatsValue
+
+	m.granularities.addTimeBuckets(snapshot, detail.Timestamp, totalTokens)
+}
+
+// dedupKey identifies a request detail across exports, so importing a snapshot twice
+// does not count its requests twice.
+func dedupKey(apiName, modelName string, detail RequestDetail) string {
+	timestamp := detail.Timestamp.UTC().Format(time.RFC3339Nano)
+	tokens := normaliseTokenStats(detail.Tokens)
+	return fmt.Sprintf(
+		"%s|%s|%s|%s|%s|%t|%d|%d|%d|%d|%d|%d",
+		apiName,
+		modelName,
+		timestamp,
+		detail.Source,
+		detail.AuthIndex,
+		detail.Failed,
+		tokens.InputTokens,
+		tokens.OutputTokens,
+		tokens.ReasoningTokens,
+		tokens.CachedTokens,
+		tokens.ToolTokens,
+		tokens.TotalTokens,
+	)
+}
+
+func normalizeAPIKey(apiKey string) string {
+	return strings.TrimSpace(apiKey)
+}
+
+func normalizeModelName(modelName string) string {
+	return strings.TrimSpace(modelName)
+}
@@ -0,0 +1,183 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported metrics push modes.
+const (
+	MetricsPushModePushgateway  = "pushgateway"
+	MetricsPushModeRemoteWrite  = "remote-write"
+	defaultMetricsPushInterval  = 60 * time.Second
+	defaultMetricsPushJob       = "cli-proxy-api"
+	metricsPushRequestTimeout   = 30 * time.Second
+	metricsPushMaxErrorBodySize = 512
+)
+
+var (
+	metricsPushMu     sync.Mutex
+	metricsPushCfg    config.MetricsPushConfig
+	metricsPushCancel context.CancelFunc
+)
+
+// ConfigureMetricsPush starts, restarts or stops the background metrics pusher to match cfg.
+// Calling it with an unchanged configuration leaves a running pusher untouched.
+func ConfigureMetricsPush(cfg config.MetricsPushConfig) {
+	metricsPushMu.Lock()
+	defer metricsPushMu.Unlock()
+
+	if metricsPushCancel != nil && reflect.DeepEqual(cfg, metricsPushCfg) {
+		return
+	}
+	if metricsPushCancel != nil {
+		metricsPushCancel()
+		metricsPushCancel = nil
+	}
+	metricsPushCfg = cfg
+
+	mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
+	if mode == "" {
+		return
+	}
+	if mode != MetricsPushModePushgateway && mode != MetricsPushModeRemoteWrite {
+		log.Warnf("usage metrics push: unknown mode %q, pushing disabled", cfg.Mode)
+		return
+	}
+	if strings.TrimSpace(cfg.URL) == "" {
+		log.Warn("usage metrics push: url is empty, pushing disabled")
+		return
+	}
+
+	pusher := newMetricsPusher(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	metricsPushCancel = cancel
+	go pusher.run(ctx)
+	log.Infof("usage metrics push enabled: %s to %s every %s", pusher.mode, pusher.url, pusher.interval)
+}
+
+// StopMetricsPush stops the background metrics pusher, if running.
+func StopMetricsPush() {
+	metricsPushMu.Lock()
+	defer metricsPushMu.Unlock()
+	if metricsPushCancel != nil {
+		metricsPushCancel()
+		metricsPushCancel = nil
+	}
+	metricsPushCfg = config.MetricsPushConfig{}
+}
+
+// metricsPusher periodically sends the usage statistics snapshot to a Prometheus endpoint.
+// It pushes the series served at /metrics, with API keys rendered as set by
+// SetMetricsAPIKeyLabel; remote-write adds only the job label.
+type metricsPusher struct {
+	mode     string
+	url      string
+	job      string
+	interval time.Duration
+	headers  map[string]string
+	client   *http.Client
+	snapshot func() StatisticsSnapshot
+	now      func() time.Time
+}
+
+func newMetricsPusher(cfg config.MetricsPushConfig) *metricsPusher {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultMetricsPushInterval
+	}
+	job := strings.TrimSpace(cfg.Job)
+	if job == "" {
+		job = defaultMetricsPushJob
+	}
+	return &metricsPusher{
+		mode:     strings.ToLower(strings.TrimSpace(cfg.Mode)),
+		url:      strings.TrimRight(strings.TrimSpace(cfg.URL), "/"),
+		job:      job,
+		interval: interval,
+		headers:  cfg.Headers,
+		client:   &http.Client{Timeout: metricsPushRequestTimeout},
+		snapshot: func() StatisticsSnapshot { return GetStatsStorage().Snapshot() },
+		now:      time.Now,
+	}
+}
+
+func (p *metricsPusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.push(ctx); err != nil {
+				log.Warnf("usage metrics push failed: %v", err)
+			}
+		}
+	}
+}
+
+// push sends one snapshot using the configured protocol.
+func (p *metricsPusher) push(ctx context.Context) error {
+	snapshot := p.snapshot()
+
+	var (
+		method string
+		target string
+		body   []byte
+		header = make(http.Header)
+	)
+	switch p.mode {
+	case MetricsPushModeRemoteWrite:
+		method = http.MethodPost
+		target = p.url
+		body = encodeRemoteWrite(snapshot, []metricLabel{{name: "job", value: p.job}}, p.now().UnixMilli())
+		header.Set("Content-Type", "application/x-protobuf")
+		header.Set("Content-Encoding", "snappy")
+		header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	default:
+		var buf bytes.Buffer
+		if err := WritePrometheusText(&buf, snapshot); err != nil {
+			return err
+		}
+		method = http.MethodPut
+		target = p.url + "/metrics/job/" + url.PathEscape(p.job)
+		body = buf.Bytes()
+		header.Set("Content-Type", "text/plain; version=0.0.4")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Errorf("usage metrics push: close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, metricsPushMaxErrorBodySize))
+		return fmt.Errorf("%s %s returned %d: %s", method, target, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package usage
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+// partialUsageTimeout is how long a request may go without a partial update before its
+// accumulated tokens are committed as failure tokens: a stream that never reaches its final
+// record, for example because the process handling it crashed, still has its usage counted.
+const partialUsageTimeout = 10 * time.Minute
+
+// partialUsage accumulates the tokens published for a request that is still streaming.
+type partialUsage struct {
+	tokens  int64
+	updated time.Time
+}
+
+// UpdatePartial adds deltaTokens to the pending tokens of a request that is still streaming.
+// The request's final Record, carrying the same RequestID, replaces the accumulated count.
+func (s *RequestStatistics) UpdatePartial(ctx context.Context, requestID string, deltaTokens int64) {
+	if s == nil || requestID == "" || deltaTokens <= 0 {
+		return
+	}
+	if !statisticsEnabled.Load() {
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepPartialsLocked(now)
+	partial, ok := s.partials[requestID]
+	if !ok {
+		partial = &partialUsage{}
+		s.partials[requestID] = partial
+	}
+	partial.tokens += deltaTokens
+	partial.updated = now
+}
+
+// sweepPartialsLocked commits the accumulators that timed out as failure tokens. It runs at
+// most once a minute; the caller must hold s.mu.
+func (s *RequestStatistics) sweepPartialsLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for requestID, partial := range s.partials {
+		if now.Sub(partial.updated) >= partialUsageTimeout {
+			s.failureTokens += partial.tokens
+			delete(s.partials, requestID)
+		}
+	}
+}
+
+// pendingTokensLocked sums the tokens of requests still streaming; the caller must hold s.mu.
+func (s *RequestStatistics) pendingTokensLocked() int64 {
+	var pending int64
+	for _, partial := range s.partials {
+		pending += partial.tokens
+	}
+	return pending
+}
+
+// statsPartialSweepScript commits partial usage that timed out. KEYS[1] holds the pending
+// tokens and KEYS[2] the last update (Unix milliseconds) per request ID; entries updated at or
+// before ARGV[1] are removed and their tokens added to the total and failure tokens of the
+// totals hash KEYS[3].
+var statsPartialSweepScript = redis.NewScript(`
+local entries = redis.call('HGETALL', KEYS[2])
+local cutoff = tonumber(ARGV[1])
+for i = 1, #entries, 2 do
+	if tonumber(entries[i + 1]) <= cutoff then
+		local tokens = tonumber(redis.call('HGET', KEYS[1], entries[i]) or '0')
+		redis.call('HDEL', KEYS[1], entries[i])
+		redis.call('HDEL', KEYS[2], entries[i])
+		if tokens > 0 then
+			redis.call('HINCRBY', KEYS[3], 'total_tokens', tokens)
+			redis.call('HINCRBY', KEYS[3], 'failure_tokens', tokens)
+		end
+	end
+end
+return 1
+`)
+
+// UpdatePartial adds deltaTokens to the pending tokens of a request in Redis, so partial
+// usage survives the process that streams the response.
+func (s *redisStatsStorage) UpdatePartial(ctx context.Context, requestID string, deltaTokens int64) {
+	if requestID == "" || deltaTokens <= 0 {
+		return
+	}
+	client := cache.GetClient()
+	if client == nil {
+		return
+	}
+
+	bgCtx := context.Background()
+	_, err := client.TxPipelined(bgCtx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(bgCtx, s.key(statsPartialTokens), requestID, deltaTokens)
+		pipe.HSet(bgCtx, s.key(statsPartialUpdated), requestID, time.Now().UnixMilli())
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Redis partial usage update failed: %v", err)
+	}
+}
+
+// releasePartialArgs extends statsRecordScript arguments to drop the partial usage of
+// requestID, so the final record replaces it atomically.
+func (s *redisStatsStorage) releasePartialArgs(keys []string, args []any, requestID string) ([]string, []any) {
+	for _, name := range []string{statsPartialTokens, statsPartialUpdated} {
+		keys = append(keys, s.key(name))
+		args = append(args, int64(0), "d", requestID, int64(0))
+	}
+	return keys, args
+}
+
+// sweepPartialsIfDue runs statsPartialSweepScript at most once a minute per process.
+func (s *redisStatsStorage) sweepPartialsIfDue(ctx context.Context, client *redis.Client, now time.Time) {
+	minute := now.Truncate(time.Minute).Unix()
+	last := s.lastSweep.Load()
+	if minute <= last || !s.lastSweep.CompareAndSwap(last, minute) {
+		return
+	}
+	keys := []string{s.key(statsPartialTokens), s.key(statsPartialUpdated), s.key(statsTotalKey)}
+	cutoff := now.Add(-partialUsageTimeout).UnixMilli()
+	if err := statsPartialSweepScript.Run(ctx, client, keys, cutoff).Err(); err != nil {
+		log.Warnf("Redis partial usage sweep failed: %v", err)
+	}
+}
+
+// pendingTokens sums the partial usage of requests still streaming.
+func (s *redisStatsStorage) pendingTokens(ctx context.Context, client *redis.Client) int64 {
+	values, err := client.HVals(ctx, s.key(statsPartialTokens)).Result()
+	if err != nil {
+		return 0
+	}
+	var pending int64
+	for _, value := range values {
+		pending += parseCounter(value)
+	}
+	return pending
+}
@@ -0,0 +1,149 @@
+package usage
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+var priceTable atomic.Pointer[map[string]coreusage.Pricing]
+
+// SetPriceTable replaces the global model price table used to estimate request cost.
+// Model names are matched case-insensitively.
+func SetPriceTable(table map[string]config.ModelPricing) {
+	prices := make(map[string]coreusage.Pricing, len(table))
+	for model, price := range table {
+		model = strings.ToLower(strings.TrimSpace(model))
+		if model == "" {
+			continue
+		}
+		prices[model] = coreusage.Pricing{
+			InputPer1K:  price.Input,
+			OutputPer1K: price.Output,
+			CachedPer1K: price.Cached,
+		}
+	}
+	priceTable.Store(&prices)
+}
+
+// apiKeyPlan is the tenant and plan price configured for a client API key.
+type apiKeyPlan struct {
+	tenant  string
+	pricing *coreusage.Pricing
+}
+
+var apiKeyPlans atomic.Pointer[map[string]apiKeyPlan]
+
+// SetAPIKeyPlans replaces the tenant and plan assigned to each client API key. A key whose
+// plan is not among plans keeps the global price table.
+func SetAPIKeyPlans(keys []config.UsageAPIKey, plans map[string]config.ModelPricing) {
+	assigned := make(map[string]apiKeyPlan, len(keys))
+	for _, entry := range keys {
+		apiKey := strings.TrimSpace(entry.APIKey)
+		if apiKey == "" {
+			continue
+		}
+		plan := apiKeyPlan{tenant: strings.TrimSpace(entry.Tenant)}
+		if name := strings.TrimSpace(entry.Plan); name != "" {
+			if price, ok := plans[name]; ok {
+				plan.pricing = &coreusage.Pricing{InputPer1K: price.Input, OutputPer1K: price.Output, CachedPer1K: price.Cached}
+			} else {
+				log.Warnf("usage statistics: plan %q of a usage-api-keys entry is not in usage-plans, using usage-pricing", name)
+			}
+		}
+		assigned[apiKey] = plan
+	}
+	apiKeyPlans.Store(&assigned)
+}
+
+// AttributeRequest sets the tenant and price override configured for apiKey on the gin
+// context, under coreusage.GinTenantKey and coreusage.GinPricingKey, for the executors to
+// copy into the request's usage record.
+func AttributeRequest(c *gin.Context, apiKey string) {
+	plans := apiKeyPlans.Load()
+	if c == nil || plans == nil {
+		return
+	}
+	plan, ok := (*plans)[apiKey]
+	if !ok {
+		return
+	}
+	if plan.tenant != "" {
+		c.Set(coreusage.GinTenantKey, plan.tenant)
+	}
+	if plan.pricing != nil {
+		c.Set(coreusage.GinPricingKey, plan.pricing)
+	}
+}
+
+// lookupPricing returns the price for a record: the per-request override when present,
+// otherwise the global table entry for the model.
+func lookupPricing(record coreusage.Record) (coreusage.Pricing, bool) {
+	if record.Pricing != nil {
+		return *record.Pricing, true
+	}
+	table := priceTable.Load()
+	if table == nil {
+		return coreusage.Pricing{}, false
+	}
+	price, ok := (*table)[strings.ToLower(strings.TrimSpace(record.Model))]
+	return price, ok
+}
+
+// cachedExcludedProviders report cache reads separately from the input tokens (Claude's
+// input_tokens and cache_read_input_tokens); other providers count cached tokens as part of
+// the prompt (OpenAI prompt_tokens, Gemini promptTokenCount).
+var cachedExcludedProviders = map[string]bool{
+	"claude": true,
+}
+
+// separateReasoningProviders report reasoning tokens next to the output tokens (Gemini
+// thoughtsTokenCount); other providers include them in the output count (OpenAI
+// completion_tokens).
+var separateReasoningProviders = map[string]bool{
+	"gemini":      true,
+	"gemini-cli":  true,
+	"vertex":      true,
+	"aistudio":    true,
+	"antigravity": true,
+}
+
+// estimateCost computes the estimated cost of a request from its token breakdown.
+// Uncached input tokens are billed at the input rate and cached tokens at the cached rate;
+// reasoning tokens are billed at the output rate when the provider reports them apart from
+// the output tokens. priced is false when no price is known for the model, in which case the
+// cost is zero.
+func estimateCost(record coreusage.Record, tokens TokenStats) (cost float64, priced bool) {
+	price, ok := lookupPricing(record)
+	if !ok {
+		return 0, false
+	}
+	provider := resolveProvider(record.Provider, record.Model)
+	input := tokens.InputTokens
+	if !cachedExcludedProviders[provider] {
+		input -= tokens.CachedTokens
+		if input < 0 {
+			input = 0
+		}
+	}
+	output := tokens.OutputTokens
+	if separateReasoningProviders[provider] {
+		output += tokens.ReasoningTokens
+	}
+	cost = float64(input)*price.InputPer1K +
+		float64(output)*price.OutputPer1K +
+		float64(tokens.CachedTokens)*price.CachedPer1K
+	return cost / 1000, true
+}
+
+// resolveTenant returns the tenant a record's cost is attributed to.
+func resolveTenant(record coreusage.Record, statsKey string) string {
+	if tenant := strings.TrimSpace(record.Tenant); tenant != "" {
+		return tenant
+	}
+	return statsKey
+}
@@ -0,0 +1,306 @@
+package usage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/snappy"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// metricLabel is a single Prometheus label pair.
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// metricSample is one series value derived from a statistics snapshot.
+type metricSample struct {
+	labels []metricLabel
+	value  float64
+}
+
+// metricFamily groups the samples of one metric name.
+type metricFamily struct {
+	name    string
+	help    string
+	samples []metricSample
+}
+
+// scrapeMetrics converts a snapshot into the counter families exported to Prometheus. The
+// /metrics endpoint, the Pushgateway text and remote-write all use it, so every output has
+// the same series. Per-key series come from collectKeyMetrics, so no raw API key reaches a
+// label unless api-key-label is "raw".
+func scrapeMetrics(snapshot StatisticsSnapshot) []metricFamily {
+	families := collectTotalMetrics(snapshot)
+	families = append(families, collectKeyMetrics(snapshot)...)
+	families = append(families, collectTenantMetrics(snapshot)...)
+	return append(families, collectRegisteredCounters()...)
+}
+
+// registeredCounter is a counter kept outside the usage statistics, see RegisterCounter.
+type registeredCounter struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+var (
+	registeredCountersMu sync.RWMutex
+	registeredCounters   []registeredCounter
+)
+
+// RegisterCounter exports a process-wide counter kept by another package, e.g. the Kiro auth
+// client, alongside the usage statistics on /metrics and in pushed metrics. Registering a name
+// again replaces the earlier counter.
+func RegisterCounter(name, help string, value func() float64) {
+	if name == "" || value == nil {
+		return
+	}
+	registeredCountersMu.Lock()
+	defer registeredCountersMu.Unlock()
+	counter := registeredCounter{name: name, help: help, value: value}
+	for i := range registeredCounters {
+		if registeredCounters[i].name == name {
+			registeredCounters[i] = counter
+			return
+		}
+	}
+	registeredCounters = append(registeredCounters, counter)
+}
+
+// collectRegisteredCounters returns the counters added by RegisterCounter, in registration order.
+func collectRegisteredCounters() []metricFamily {
+	registeredCountersMu.RLock()
+	defer registeredCountersMu.RUnlock()
+	families := make([]metricFamily, 0, len(registeredCounters))
+	for _, counter := range registeredCounters {
+		families = append(families, metricFamily{
+			name:    counter.name,
+			help:    counter.help,
+			samples: []metricSample{{value: counter.value()}},
+		})
+	}
+	return families
+}
+
+// collectTotalMetrics returns the store-wide counters.
+func collectTotalMetrics(snapshot StatisticsSnapshot) []metricFamily {
+	return []metricFamily{
+		{name: "cliproxy_usage_requests_total", help: "Total requests recorded in usage statistics.", samples: []metricSample{{value: float64(snapshot.TotalRequests)}}},
+		{name: "cliproxy_usage_requests_success_total", help: "Successful requests recorded in usage statistics.", samples: []metricSample{{value: float64(snapshot.SuccessCount)}}},
+		{name: "cliproxy_usage_requests_failure_total", help: "Failed requests recorded in usage statistics.", samples: []metricSample{{value: float64(snapshot.FailureCount)}}},
+		{name: "cliproxy_usage_tokens_total", help: "Total tokens recorded in usage statistics.", samples: []metricSample{{value: float64(snapshot.TotalTokens)}}},
+		{name: "cliproxy_usage_cost_total", help: "Estimated total request cost.", samples: []metricSample{{value: snapshot.TotalCost}}},
+	}
+}
+
+// collectTenantMetrics returns the per-tenant cost counter, if any cost was recorded.
+func collectTenantMetrics(snapshot StatisticsSnapshot) []metricFamily {
+	if len(snapshot.TenantCosts) == 0 {
+		return nil
+	}
+	tenantCost := metricFamily{name: "cliproxy_usage_tenant_cost_total", help: "Estimated request cost per tenant."}
+	for _, tenant := range sortedKeys(snapshot.TenantCosts) {
+		tenantCost.samples = append(tenantCost.samples, metricSample{
+			labels: []metricLabel{{name: "tenant", value: tenant}},
+			value:  snapshot.TenantCosts[tenant],
+		})
+	}
+	return []metricFamily{tenantCost}
+}
+
+// collectKeyMetrics builds the per API key, model and status counters. API keys are
+// rendered by apiKeyLabelValue to bound label cardinality and keep keys out of the time
+// series database; keys that render to the same label value are summed.
+func collectKeyMetrics(snapshot StatisticsSnapshot) []metricFamily {
+	type seriesKey struct {
+		apiKey, model, status string
+	}
+	requests := make(map[seriesKey]float64)
+	tokens := make(map[seriesKey]float64)
+	for apiName, api := range snapshot.APIs {
+		apiKey := apiKeyLabelValue(apiName)
+		for modelName, model := range api.Models {
+			requests[seriesKey{apiKey, modelName, "success"}] += float64(model.TotalRequests - model.FailureCount)
+			requests[seriesKey{apiKey, modelName, "failure"}] += float64(model.FailureCount)
+			tokens[seriesKey{apiKey: apiKey, model: modelName}] += float64(model.TotalTokens)
+		}
+	}
+
+	samples := func(values map[seriesKey]float64) []metricSample {
+		keys := make([]seriesKey, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].apiKey != keys[j].apiKey {
+				return keys[i].apiKey < keys[j].apiKey
+			}
+			if keys[i].model != keys[j].model {
+				return keys[i].model < keys[j].model
+			}
+			return keys[i].status < keys[j].status
+		})
+		result := make([]metricSample, 0, len(keys))
+		for _, key := range keys {
+			labels := []metricLabel{{name: "api_key", value: key.apiKey}, {name: "model", value: key.model}}
+			if key.status != "" {
+				labels = append(labels, metricLabel{name: "status", value: key.status})
+			}
+			result = append(result, metricSample{labels: labels, value: values[key]})
+		}
+		return result
+	}
+	return []metricFamily{
+		{name: "cliproxy_requests_total", help: "Requests per API key, model and status.", samples: samples(requests)},
+		{name: "cliproxy_tokens_total", help: "Tokens per API key and model.", samples: samples(tokens)},
+	}
+}
+
+// Supported renderings of API keys in the api_key metric label.
+const (
+	MetricsAPIKeyLabelHash     = "hash"
+	MetricsAPIKeyLabelTruncate = "truncate"
+	MetricsAPIKeyLabelRaw      = "raw"
+
+	// metricsAPIKeyHashLength is the number of hex digits of the SHA-256 digest kept by "hash".
+	metricsAPIKeyHashLength = 12
+	// metricsAPIKeyTruncateLength is the number of leading characters kept by "truncate".
+	metricsAPIKeyTruncateLength = 8
+)
+
+var metricsAPIKeyLabel atomic.Pointer[string]
+
+// SetMetricsAPIKeyLabel selects how API keys are rendered in the api_key metric label:
+// "hash", "truncate" or "raw". Empty or unknown values use "hash".
+func SetMetricsAPIKeyLabel(mode string) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case MetricsAPIKeyLabelHash, MetricsAPIKeyLabelTruncate, MetricsAPIKeyLabelRaw:
+	case "":
+		mode = MetricsAPIKeyLabelHash
+	default:
+		log.Warnf("usage metrics: unknown api-key-label %q, using %q", mode, MetricsAPIKeyLabelHash)
+		mode = MetricsAPIKeyLabelHash
+	}
+	metricsAPIKeyLabel.Store(&mode)
+}
+
+// apiKeyLabelValue renders an API key for the api_key label according to SetMetricsAPIKeyLabel.
+func apiKeyLabelValue(apiKey string) string {
+	mode := MetricsAPIKeyLabelHash
+	if current := metricsAPIKeyLabel.Load(); current != nil {
+		mode = *current
+	}
+	switch mode {
+	case MetricsAPIKeyLabelRaw:
+		return apiKey
+	case MetricsAPIKeyLabelTruncate:
+		runes := []rune(apiKey)
+		if len(runes) <= metricsAPIKeyTruncateLength {
+			return apiKey
+		}
+		return string(runes[:metricsAPIKeyTruncateLength]) + "..."
+	default:
+		sum := sha256.Sum256([]byte(apiKey))
+		return hex.EncodeToString(sum[:])[:metricsAPIKeyHashLength]
+	}
+}
+
+// WritePrometheusText writes the snapshot in the Prometheus text exposition format (0.0.4),
+// suitable for a Pushgateway. It writes the same series as WritePrometheusScrape.
+func WritePrometheusText(w io.Writer, snapshot StatisticsSnapshot) error {
+	return writeMetricFamilies(w, scrapeMetrics(snapshot))
+}
+
+// WritePrometheusScrape writes the snapshot in the Prometheus text exposition format (0.0.4)
+// for the /metrics scrape endpoint, with API keys rendered as set by SetMetricsAPIKeyLabel.
+func WritePrometheusScrape(w io.Writer, snapshot StatisticsSnapshot) error {
+	return writeMetricFamilies(w, scrapeMetrics(snapshot))
+}
+
+func writeMetricFamilies(w io.Writer, families []metricFamily) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		bw.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		bw.WriteString("# TYPE " + family.name + " counter\n")
+		for _, sample := range family.samples {
+			bw.WriteString(family.name)
+			if len(sample.labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range sample.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.name + `="` + escapeLabelValue(label.value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatSampleValue(sample.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// encodeRemoteWrite builds a snappy-compressed Prometheus remote-write WriteRequest.
+// extraLabels (e.g. job) are added to every series; timestampMs is applied to all samples.
+func encodeRemoteWrite(snapshot StatisticsSnapshot, extraLabels []metricLabel, timestampMs int64) []byte {
+	var request []byte
+	for _, family := range scrapeMetrics(snapshot) {
+		for _, sample := range family.samples {
+			labels := make([]metricLabel, 0, len(sample.labels)+len(extraLabels)+1)
+			labels = append(labels, metricLabel{name: "__name__", value: family.name})
+			labels = append(labels, extraLabels...)
+			labels = append(labels, sample.labels...)
+			// Remote-write receivers expect labels sorted by name.
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+			var series []byte
+			for _, label := range labels {
+				var encoded []byte
+				encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+				encoded = protowire.AppendString(encoded, label.name)
+				encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+				encoded = protowire.AppendString(encoded, label.value)
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, encoded)
+			}
+			var encodedSample []byte
+			encodedSample = protowire.AppendTag(encodedSample, 1, protowire.Fixed64Type)
+			encodedSample = protowire.AppendFixed64(encodedSample, math.Float64bits(sample.value))
+			encodedSample = protowire.AppendTag(encodedSample, 2, protowire.VarintType)
+			encodedSample = protowire.AppendVarint(encodedSample, uint64(timestampMs))
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, encodedSample)
+
+			request = protowire.AppendTag(request, 1, protowire.BytesType)
+			request = protowire.AppendBytes(request, series)
+		}
+	}
+	return snappy.Encode(nil, request)
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func formatSampleValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
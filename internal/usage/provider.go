@@ -0,0 +1,90 @@
+package usage
+
+import (
+	"strings"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+// ProviderSnapshot summarises the requests served by one upstream provider.
+type ProviderSnapshot struct {
+	TotalRequests int64 `json:"total_requests"`
+	FailureCount  int64 `json:"failure_count"`
+	TotalTokens   int64 `json:"total_tokens"`
+}
+
+// resolveProvider returns the provider a request is attributed to: the one reported with the
+// record, else the first provider registered for the model, else "unknown".
+func resolveProvider(provider, modelName string) string {
+	if provider = strings.TrimSpace(provider); provider != "" {
+		return provider
+	}
+	if providers := util.GetProviderName(modelName); len(providers) > 0 {
+		return providers[0]
+	}
+	return "unknown"
+}
+
+// addProviderStats counts detail for its provider, creating providers when nil.
+func addProviderStats(providers map[string]ProviderSnapshot, detail RequestDetail) map[string]ProviderSnapshot {
+	if providers == nil {
+		providers = make(map[string]ProviderSnapshot)
+	}
+	stats := providers[detail.Provider]
+	stats.TotalRequests++
+	if detail.Failed {
+		stats.FailureCount++
+	}
+	if detail.Tokens.TotalTokens > 0 {
+		stats.TotalTokens += detail.Tokens.TotalTokens
+	}
+	providers[detail.Provider] = stats
+	return providers
+}
+
+// addProviderSnapshots adds the provider counters of delta to target.
+func addProviderSnapshots(target, delta map[string]ProviderSnapshot) map[string]ProviderSnapshot {
+	if len(delta) == 0 {
+		return target
+	}
+	if target == nil {
+		target = make(map[string]ProviderSnapshot, len(delta))
+	}
+	for provider, stats := range delta {
+		sum := target[provider]
+		sum.TotalRequests += stats.TotalRequests
+		sum.FailureCount += stats.FailureCount
+		sum.TotalTokens += stats.TotalTokens
+		target[provider] = sum
+	}
+	return target
+}
+
+// RefreshSnapshot counts the token refresh attempts of one provider, published with
+// coreusage.PublishRefresh. Refreshes are not requests: they are kept out of the request,
+// token, cost, unpriced and provider counters.
+type RefreshSnapshot struct {
+	Attempts     int64 `json:"attempts"`
+	FailureCount int64 `json:"failure_count"`
+}
+
+// isRefreshRecord reports whether a record or detail with source is a refresh attempt
+// rather than a request.
+func isRefreshRecord(source string) bool {
+	return source == coreusage.RefreshSource
+}
+
+// addRefresh counts one refresh attempt of provider, creating refreshes when nil.
+func addRefresh(refreshes map[string]RefreshSnapshot, provider string, failed bool) map[string]RefreshSnapshot {
+	if refreshes == nil {
+		refreshes = make(map[string]RefreshSnapshot)
+	}
+	stats := refreshes[provider]
+	stats.Attempts++
+	if failed {
+		stats.FailureCount++
+	}
+	refreshes[provider] = stats
+	return refreshes
+}
@@ -0,0 +1,115 @@
+package usage
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RangeStats aggregates usage over an inclusive range of days. Days are formatted like the
+// day buckets ("2006-01-02", local time).
+type RangeStats struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	TotalRequests int64  `json:"total_requests"`
+	TotalTokens   int64  `json:"total_tokens"`
+
+	// Models breaks the range totals down by model name, across API keys.
+	Models map[string]RangeCounts `json:"models"`
+	// Days holds the days of the range that saw traffic.
+	Days map[string]DayStats `json:"days"`
+}
+
+// RangeCounts holds request and token counts.
+type RangeCounts struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+}
+
+// DayStats is the usage of a single day, broken down by model name.
+type DayStats struct {
+	Requests int64                  `json:"requests"`
+	Tokens   int64                  `json:"tokens"`
+	Models   map[string]RangeCounts `json:"models"`
+}
+
+// maxRangeDays bounds the days a single QueryRange call walks.
+const maxRangeDays = 3660
+
+// rangeDays lists the days from from to to inclusive, oldest first. An inverted range is
+// empty and a range longer than maxRangeDays keeps its most recent days.
+func rangeDays(from, to time.Time) []string {
+	from, to = from.In(time.Local), to.In(time.Local)
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local)
+	if end.Before(start) {
+		return nil
+	}
+	if limit := end.AddDate(0, 0, -(maxRangeDays - 1)); start.Before(limit) {
+		start = limit
+	}
+	var days []string
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(dayBucketLayout))
+	}
+	return days
+}
+
+// newRangeStats returns an empty result for the range.
+func newRangeStats(from, to time.Time) RangeStats {
+	return RangeStats{
+		From:   from.In(time.Local).Format(dayBucketLayout),
+		To:     to.In(time.Local).Format(dayBucketLayout),
+		Models: make(map[string]RangeCounts),
+		Days:   make(map[string]DayStats),
+	}
+}
+
+// add counts requests and tokens of a model on day.
+func (r *RangeStats) add(day, model string, requests, tokens int64) {
+	if requests == 0 && tokens == 0 {
+		return
+	}
+	r.TotalRequests += requests
+	r.TotalTokens += tokens
+
+	counts := r.Models[model]
+	counts.Requests += requests
+	counts.Tokens += tokens
+	r.Models[model] = counts
+
+	dayStats := r.Days[day]
+	if dayStats.Models == nil {
+		dayStats.Models = make(map[string]RangeCounts)
+	}
+	dayStats.Requests += requests
+	dayStats.Tokens += tokens
+	dayCounts := dayStats.Models[model]
+	dayCounts.Requests += requests
+	dayCounts.Tokens += tokens
+	dayStats.Models[model] = dayCounts
+	r.Days[day] = dayStats
+}
+
+// addDayHash adds the "requests:<model>" and "tokens:<model>" fields of a Redis day hash.
+func (r *RangeStats) addDayHash(day string, fields map[string]string) {
+	counts := make(map[string]RangeCounts)
+	for field, value := range fields {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if model, ok := strings.CutPrefix(field, "requests:"); ok {
+			c := counts[model]
+			c.Requests += n
+			counts[model] = c
+		} else if model, ok := strings.CutPrefix(field, "tokens:"); ok {
+			c := counts[model]
+			c.Tokens += n
+			counts[model] = c
+		}
+	}
+	for model, c := range counts {
+		r.add(day, model, c.Requests, c.Tokens)
+	}
+}
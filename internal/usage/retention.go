@@ -0,0 +1,160 @@
+package usage
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketRetention bounds how far back the dated time buckets reach. Day buckets (and the
+// per-day data behind QueryRange) and minute buckets grow with every new day or minute,
+// while hour buckets are keyed by hour of day and month buckets add twelve keys a year, so
+// those two are kept as they are. Pruning only drops buckets: the aggregate totals, model
+// counters and details are never touched.
+type bucketRetention struct {
+	// days is the number of days of day buckets kept; 0 keeps every day.
+	days int
+	// minuteHours is the number of hours of minute buckets kept; 0 keeps every minute.
+	minuteHours int
+}
+
+// resolveRetention maps the configured retention to the one applied, where 0 keeps
+// everything: a configured 0 uses the default and a negative value disables pruning.
+func resolveRetention(days, minuteHours int) bucketRetention {
+	resolve := func(value, fallback int) int {
+		switch {
+		case value == 0:
+			return fallback
+		case value < 0:
+			return 0
+		default:
+			return value
+		}
+	}
+	return bucketRetention{
+		days:        resolve(days, config.DefaultUsageDayRetentionDays),
+		minuteHours: resolve(minuteHours, config.DefaultUsageMinuteRetentionHours),
+	}
+}
+
+// dayCutoff returns the newest day key to prune relative to now, or "" to keep every day.
+func (r bucketRetention) dayCutoff(now time.Time) string {
+	if r.days <= 0 {
+		return ""
+	}
+	return now.AddDate(0, 0, -r.days).Format(dayBucketLayout)
+}
+
+// minuteCutoff returns the newest minute key to prune relative to now, or "" to keep every
+// minute.
+func (r bucketRetention) minuteCutoff(now time.Time) string {
+	if r.minuteHours <= 0 {
+		return ""
+	}
+	return now.Add(-time.Duration(r.minuteHours) * time.Hour).Format(minuteBucketLayout)
+}
+
+// pruneBuckets deletes the buckets whose key sorts at or before cutoff. The day and minute
+// layouts sort chronologically, so this drops every bucket up to the cutoff.
+func pruneBuckets[V any](buckets map[string]V, cutoff string) {
+	if cutoff == "" {
+		return
+	}
+	for key := range buckets {
+		if key <= cutoff {
+			delete(buckets, key)
+		}
+	}
+}
+
+// SetRetention bounds the day buckets to the given number of days and the minute buckets to
+// the given number of hours from now on. 0 uses the default and a negative value keeps every
+// bucket; the aggregate counters are not affected.
+func (s *RequestStatistics) SetRetention(days, minuteHours int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.retention = resolveRetention(days, minuteHours)
+	s.mu.Unlock()
+}
+
+// pruneIfDueLocked prunes the buckets that fell out of the retention window when timestamp
+// starts a minute newer than any recorded before, so pruning runs at most once per minute of
+// traffic. The window ends at the newest record rather than the wall clock, which keeps
+// imported history until newer traffic pushes it out. The caller must hold s.mu.
+func (s *RequestStatistics) pruneIfDueLocked(timestamp time.Time) {
+	minute := timestamp.Truncate(time.Minute)
+	if !minute.After(s.lastPrune) {
+		return
+	}
+	s.lastPrune = minute
+
+	dayCutoff := s.retention.dayCutoff(timestamp)
+	pruneBuckets(s.requestsByDay, dayCutoff)
+	pruneBuckets(s.tokensByDay, dayCutoff)
+	pruneBuckets(s.dayModels, s.retention.dayCutoff(timestamp.In(time.Local)))
+
+	minuteCutoff := s.retention.minuteCutoff(timestamp)
+	pruneBuckets(s.requestsByMinute, minuteCutoff)
+	pruneBuckets(s.tokensByMinute, minuteCutoff)
+}
+
+// statsPruneScript deletes the bucket fields at or before a cutoff. KEYS[1] and KEYS[2] are
+// the day bucket hashes and KEYS[3] and KEYS[4] the minute bucket hashes, pruned up to ARGV[1]
+// and ARGV[2] respectively; an empty cutoff keeps every field. KEYS[5] is the day index: the
+// days it drops have their day hash, named ARGV[3] followed by the day, deleted as well.
+var statsPruneScript = redis.NewScript(`
+local function prune(key, cutoff)
+	local pruned = {}
+	if cutoff == '' then
+		return pruned
+	end
+	for _, field in ipairs(redis.call('HKEYS', key)) do
+		if field <= cutoff then
+			pruned[#pruned + 1] = field
+		end
+	end
+	for i = 1, #pruned, 1000 do
+		redis.call('HDEL', key, unpack(pruned, i, math.min(i + 999, #pruned)))
+	end
+	return pruned
+end
+prune(KEYS[1], ARGV[1])
+prune(KEYS[2], ARGV[1])
+prune(KEYS[3], ARGV[2])
+prune(KEYS[4], ARGV[2])
+for _, day in ipairs(prune(KEYS[5], ARGV[1])) do
+	redis.call('DEL', ARGV[3] .. day)
+end
+return 1
+`)
+
+// pruneIfDue runs statsPruneScript when timestamp starts a minute newer than the last prune
+// of this process. Every instance sharing the key prefix prunes on its own schedule; pruning
+// is idempotent, so overlapping runs only repeat work.
+func (s *redisStatsStorage) pruneIfDue(ctx context.Context, client *redis.Client, timestamp time.Time) {
+	minute := timestamp.Truncate(time.Minute).Unix()
+	last := s.lastPrune.Load()
+	if minute <= last || !s.lastPrune.CompareAndSwap(last, minute) {
+		return
+	}
+	dayCutoff := s.retention.dayCutoff(timestamp.In(time.Local))
+	minuteCutoff := s.retention.minuteCutoff(timestamp)
+	if dayCutoff == "" && minuteCutoff == "" {
+		return
+	}
+	keys := []string{
+		s.key(statsRequestsByDay),
+		s.key(statsTokensByDay),
+		s.key(statsRequestsByMinute),
+		s.key(statsTokensByMinute),
+		s.key(statsDays),
+	}
+	if err := statsPruneScript.Run(ctx, client, keys, dayCutoff, minuteCutoff, s.key(statsDayPrefix)).Err(); err != nil {
+		log.Warnf("Redis usage bucket pruning failed: %v", err)
+	}
+}
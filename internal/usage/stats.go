@@ -4,12 +4,18 @@ package usage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 
+	"github.com/redis/go-redis/v9"
 	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,18 +30,54 @@ type StatsStorage interface {
 
 	// MergeSnapshot merges an exported statistics snapshot into the current store.
 	MergeSnapshot(snapshot StatisticsSnapshot) MergeResult
+
+	// ExportAndReset returns the current snapshot and clears the store in one operation,
+	// so no record is lost or counted twice across the boundary.
+	ExportAndReset(ctx context.Context) (StatisticsSnapshot, error)
+
+	// QueryRange returns the requests and tokens of the days from from to to inclusive,
+	// with a per-model breakdown, without building a full snapshot.
+	QueryRange(from, to time.Time) RangeStats
+
+	// UpdatePartial adds deltaTokens to the pending tokens of a request that is still
+	// streaming; the final record with the same RequestID replaces them.
+	UpdatePartial(ctx context.Context, requestID string, deltaTokens int64)
 }
 
 // NewStatsStorage creates a new stats storage based on configuration.
 func NewStatsStorage(cfg config.RedisCacheConfig) StatsStorage {
+	var storage StatsStorage
 	if cfg.Enable {
-		return &redisStatsStorage{
-			config: cfg,
+		redisStorage := &redisStatsStorage{
+			config:        cfg,
+			ttl:           resolveRedisTTL(cfg),
+			granularities: parseGranularities(cfg.Granularities),
+			maxDetails:    resolveMaxDetails(cfg.MaxDetailsPerModel),
+			retention:     resolveRetention(cfg.DayRetentionDays, cfg.MinuteRetentionHours),
+		}
+		storage = redisStorage
+		if cache.GetClient() != nil {
+			result, err := redisStorage.migrateLegacyStats(context.Background())
+			if err != nil {
+				log.Errorf("usage statistics: migrating legacy Redis keys failed: %v", err)
+			} else if result.Added > 0 {
+				log.Infof("usage statistics: migrated %d requests from legacy Redis keys", result.Added)
+			}
+		}
+	} else {
+		stats := NewRequestStatistics()
+		stats.SetGranularities(cfg.Granularities)
+		stats.SetMaxDetailsPerModel(cfg.MaxDetailsPerModel)
+		stats.SetMergeConcurrency(cfg.MergeConcurrency)
+		stats.SetRetention(cfg.DayRetentionDays, cfg.MinuteRetentionHours)
+		storage = &memoryStatsStorage{
+			stats: stats,
 		}
 	}
-	return &memoryStatsStorage{
-		stats: NewRequestStatistics(),
+	if cfg.SnapshotCacheTTLMs > 0 {
+		storage = newCachedStatsStorage(storage, time.Duration(cfg.SnapshotCacheTTLMs)*time.Millisecond)
 	}
+	return storage
 }
 
 var defaultStatsStorage StatsStorage
@@ -45,6 +87,31 @@ func InitStatsStorage(cfg config.RedisCacheConfig) {
 	defaultStatsStorage = NewStatsStorage(cfg)
 }
 
+// flusher is implemented by storages that may hold writes not yet persisted.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Shutdown stops the metrics pusher, flushes pending writes of the global stats storage
+// and then closes the Redis client, so records accepted before shutdown are not lost.
+// Call it after the usage record queue has been drained.
+func Shutdown(ctx context.Context) error {
+	StopMetricsPush()
+	var flushErr error
+	if f, ok := defaultStatsStorage.(flusher); ok {
+		if flushErr = f.Flush(ctx); flushErr != nil {
+			log.Errorf("usage statistics: flush on shutdown failed: %v", flushErr)
+		}
+	}
+	if err := cache.Close(); err != nil {
+		log.Errorf("usage statistics: close Redis client: %v", err)
+		if flushErr == nil {
+			flushErr = err
+		}
+	}
+	return flushErr
+}
+
 // GetStatsStorage returns the global stats storage instance.
 func GetStatsStorage() StatsStorage {
 	if defaultStatsStorage == nil {
@@ -56,6 +123,82 @@ func GetStatsStorage() StatsStorage {
 	return defaultStatsStorage
 }
 
+// cachedStatsStorage serves repeated Snapshot calls from the last computed snapshot
+// for a short TTL. Writes through the wrapper invalidate the cached copy.
+// The returned snapshot shares its maps between callers and must be treated as read-only.
+type cachedStatsStorage struct {
+	StatsStorage
+	ttl time.Duration
+
+	mu         sync.Mutex
+	generation uint64
+	cached     *StatisticsSnapshot
+	cachedAt   time.Time
+}
+
+func newCachedStatsStorage(inner StatsStorage, ttl time.Duration) *cachedStatsStorage {
+	return &cachedStatsStorage{StatsStorage: inner, ttl: ttl}
+}
+
+func (s *cachedStatsStorage) Record(ctx context.Context, record coreusage.Record) {
+	s.StatsStorage.Record(ctx, record)
+	s.invalidate()
+}
+
+func (s *cachedStatsStorage) Snapshot() StatisticsSnapshot {
+	s.mu.Lock()
+	if s.cached != nil && time.Since(s.cachedAt) < s.ttl {
+		snapshot := *s.cached
+		s.mu.Unlock()
+		return snapshot
+	}
+	generation := s.generation
+	s.mu.Unlock()
+
+	snapshot := s.StatsStorage.Snapshot()
+
+	s.mu.Lock()
+	// Only cache if no write happened while the snapshot was being built.
+	if s.generation == generation {
+		s.cached = &snapshot
+		s.cachedAt = time.Now()
+	}
+	s.mu.Unlock()
+	return snapshot
+}
+
+func (s *cachedStatsStorage) UpdatePartial(ctx context.Context, requestID string, deltaTokens int64) {
+	s.StatsStorage.UpdatePartial(ctx, requestID, deltaTokens)
+	s.invalidate()
+}
+
+func (s *cachedStatsStorage) MergeSnapshot(snapshot StatisticsSnapshot) MergeResult {
+	result := s.StatsStorage.MergeSnapshot(snapshot)
+	s.invalidate()
+	return result
+}
+
+func (s *cachedStatsStorage) ExportAndReset(ctx context.Context) (StatisticsSnapshot, error) {
+	snapshot, err := s.StatsStorage.ExportAndReset(ctx)
+	s.invalidate()
+	return snapshot, err
+}
+
+func (s *cachedStatsStorage) Flush(ctx context.Context) error {
+	defer s.invalidate()
+	if f, ok := s.StatsStorage.(flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+func (s *cachedStatsStorage) invalidate() {
+	s.mu.Lock()
+	s.generation++
+	s.cached = nil
+	s.mu.Unlock()
+}
+
 // memoryStatsStorage implements StatsStorage using in-memory storage.
 type memoryStatsStorage struct {
 	stats *RequestStatistics
@@ -67,6 +210,12 @@ func (s *memoryStatsStorage) Record(ctx context.Context, record coreusage.Record
 	}
 }
 
+func (s *memoryStatsStorage) UpdatePartial(ctx context.Context, requestID string, deltaTokens int64) {
+	if s.stats != nil {
+		s.stats.UpdatePartial(ctx, requestID, deltaTokens)
+	}
+}
+
 func (s *memoryStatsStorage) Snapshot() StatisticsSnapshot {
 	if s.stats == nil {
 		return StatisticsSnapshot{}
@@ -81,23 +230,273 @@ func (s *memoryStatsStorage) MergeSnapshot(snapshot StatisticsSnapshot) MergeRes
 	return s.stats.MergeSnapshot(snapshot)
 }
 
+func (s *memoryStatsStorage) ExportAndReset(ctx context.Context) (StatisticsSnapshot, error) {
+	if s.stats == nil {
+		return StatisticsSnapshot{}, nil
+	}
+	return s.stats.ExportAndReset(), nil
+}
+
+func (s *memoryStatsStorage) QueryRange(from, to time.Time) RangeStats {
+	if s.stats == nil {
+		return newRangeStats(from, to)
+	}
+	return s.stats.QueryRange(from, to)
+}
+
 // redisStatsStorage implements StatsStorage using Redis.
 type redisStatsStorage struct {
-	config config.RedisCacheConfig
-	mu     sync.RWMutex
+	config        config.RedisCacheConfig
+	ttl           time.Duration
+	granularities granularitySet
+	// maxDetails caps the details kept per model; 0 keeps all of them.
+	maxDetails int
+	// retention bounds the day and minute buckets; lastPrune holds the Unix time of the
+	// minute this process last pruned them at.
+	retention bucketRetention
+	lastPrune atomic.Int64
+	// lastSweep holds the Unix time of the minute this process last swept partial usage at.
+	lastSweep atomic.Int64
+	// mu serializes MergeSnapshot and ExportAndReset within this process, so an import is not
+	// deduplicated against a snapshot that a concurrent merge is about to change. Record holds
+	// it for reading: records still run in parallel, since each is applied by the atomic
+	// statsRecordScript, but Flush and ExportAndReset wait for the ones in flight. Instances
+	// sharing the key prefix are not coordinated beyond what the scripts guarantee.
+	mu sync.RWMutex
 }
 
+// resolveRedisTTL converts the configured TTL into the expiration applied to stats keys.
+// A TTL below the configured floor is clamped up so stats don't vanish almost immediately.
+func resolveRedisTTL(cfg config.RedisCacheConfig) time.Duration {
+	if cfg.TTL == -1 {
+		// -1 means never expire
+		return 0
+	}
+	if cfg.TTL <= 0 {
+		// Default to 1 day if TTL is not set or invalid
+		return 24 * time.Hour
+	}
+	ttl := time.Duration(cfg.TTL) * time.Second
+	minTTL := cfg.MinTTL
+	if minTTL == 0 {
+		minTTL = config.DefaultRedisMinTTL
+	}
+	if minTTL > 0 && cfg.TTL < minTTL {
+		log.Warnf("usage statistics cache ttl %ds is below the minimum of %ds, using %ds", cfg.TTL, minTTL, minTTL)
+		ttl = time.Duration(minTTL) * time.Second
+	}
+	return ttl
+}
+
+// keyTTL returns the TTL for a single stats key, spread by the configured jitter.
+func (s *redisStatsStorage) keyTTL() time.Duration {
+	if s.ttl <= 0 || s.config.TTLJitterPercent <= 0 {
+		return s.ttl
+	}
+	percent := s.config.TTLJitterPercent
+	if percent > 100 {
+		percent = 100
+	}
+	maxJitter := int64(s.ttl) * int64(percent) / 100
+	if maxJitter <= 0 {
+		return s.ttl
+	}
+	return s.ttl + time.Duration(rand.Int63n(maxJitter+1))
+}
+
+// statsKeyVersion follows the key prefix of every stats key. Counters used to be JSON strings
+// rewritten on each record, stored directly under the prefix; migrateLegacyStats imports and
+// deletes keys in that layout.
+const statsKeyVersion = "v2:"
+
+// legacyStatsKeys lists the keys (without prefix) of the JSON layout used before
+// statsKeyVersion, with legacyAPIsKey holding the per-API snapshot and its details.
+var legacyStatsKeys = []string{
+	statsTotalKey,
+	legacyAPIsKey,
+	statsRequestsByDay,
+	statsRequestsByHour,
+	statsTokensByDay,
+	statsTokensByHour,
+	statsRequestsByMinute,
+	statsTokensByMinute,
+	statsRequestsByMonth,
+	statsTokensByMonth,
+	statsTenantCosts,
+}
+
+const legacyAPIsKey = "apis"
+
 const (
-	statsTotalKey       = "total"
-	statsAPIsKey        = "apis"
-	statsRequestsByDay  = "requests_by_day"
-	statsRequestsByHour = "requests_by_hour"
-	statsTokensByDay    = "tokens_by_day"
-	statsTokensByHour   = "tokens_by_hour"
+	statsTotalKey         = "total"
+	statsModelRequests    = "model_requests"
+	statsModelTokens      = "model_tokens"
+	statsModelFailures    = "model_failures"
+	statsModelFailTokens  = "model_failure_tokens"
+	statsModelCosts       = "model_costs"
+	statsRequestsByDay    = "requests_by_day"
+	statsRequestsByHour   = "requests_by_hour"
+	statsTokensByDay      = "tokens_by_day"
+	statsTokensByHour     = "tokens_by_hour"
+	statsRequestsByMinute = "requests_by_minute"
+	statsTokensByMinute   = "tokens_by_minute"
+	statsRequestsByMonth  = "requests_by_month"
+	statsTokensByMonth    = "tokens_by_month"
+	statsTenantCosts      = "tenant_costs"
+	statsUnpriced         = "unpriced_requests"
+	statsProviderRequests = "provider_requests"
+	statsProviderTokens   = "provider_tokens"
+	statsProviderFailures = "provider_failures"
+	statsRefreshAttempts  = "refresh_attempts"
+	statsRefreshFailures  = "refresh_failures"
+	// statsDetailsPrefix is followed by a model field (see modelField) to name the list
+	// holding that model's request details.
+	statsDetailsPrefix = "details:"
+	// statsLatencyPrefix is followed by a model field to name the hash holding that model's
+	// latency histogram as bucket/count fields.
+	statsLatencyPrefix = "latency:"
+	// statsDayPrefix is followed by a day ("2006-01-02") to name the hash holding that day's
+	// "requests:<model>" and "tokens:<model>" counters, read by QueryRange.
+	statsDayPrefix = "day:"
+	// statsDays indexes the day hashes: one field per day holding its request count.
+	statsDays = "days"
+	// statsPartialTokens and statsPartialUpdated hold the pending tokens and last update of
+	// requests still streaming, keyed by request ID. They are not part of a snapshot's
+	// counters and survive ExportAndReset.
+	statsPartialTokens  = "partial_tokens"
+	statsPartialUpdated = "partial_updated"
 )
 
+// statsHashKeys lists every hash (without prefix) that makes up a snapshot, in the order
+// statsReadScript returns them. statsModelRequests must come first: its fields name the
+// detail lists the script reads.
+var statsHashKeys = []string{
+	statsModelRequests,
+	statsModelTokens,
+	statsModelFailures,
+	statsModelFailTokens,
+	statsModelCosts,
+	statsTotalKey,
+	statsRequestsByDay,
+	statsRequestsByHour,
+	statsTokensByDay,
+	statsTokensByHour,
+	statsRequestsByMinute,
+	statsTokensByMinute,
+	statsRequestsByMonth,
+	statsTokensByMonth,
+	statsTenantCosts,
+	statsUnpriced,
+	statsProviderRequests,
+	statsProviderTokens,
+	statsProviderFailures,
+	statsRefreshAttempts,
+	statsRefreshFailures,
+}
+
+// statsRecordScript applies one request atomically. It appends ARGV[1] to the detail list
+// KEYS[1], keeps only the newest ARGV[2] entries when positive and sets a TTL of ARGV[3]
+// milliseconds when positive. Each further key KEYS[i] takes four arguments: a TTL in
+// milliseconds, the increment kind ("i" for HINCRBY, "f" for HINCRBYFLOAT, "d" to HDEL the
+// field, ignoring the amount), the hash field and the amount. A hash may appear several times
+// to update several of its fields.
+var statsRecordScript = redis.NewScript(`
+redis.call('RPUSH', KEYS[1], ARGV[1])
+local limit = tonumber(ARGV[2])
+if limit > 0 then
+	redis.call('LTRIM', KEYS[1], -limit, -1)
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+for i = 2, #KEYS do
+	local arg = 4 + (i - 2) * 4
+	if ARGV[arg + 1] == 'f' then
+		redis.call('HINCRBYFLOAT', KEYS[i], ARGV[arg + 2], ARGV[arg + 3])
+	elseif ARGV[arg + 1] == 'd' then
+		redis.call('HDEL', KEYS[i], ARGV[arg + 2])
+	else
+		redis.call('HINCRBY', KEYS[i], ARGV[arg + 2], ARGV[arg + 3])
+	end
+	if tonumber(ARGV[arg]) > 0 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[arg])
+	end
+end
+return 1
+`)
+
+// statsReadScript reads a whole snapshot in one atomic step. It returns every hash in KEYS
+// as a flat field/value list, followed by one flat list of (model field, detail list,
+// latency histogram) triples for the fields of KEYS[1]. ARGV[1] and ARGV[3] are the detail
+// list and latency histogram key prefixes. With ARGV[2] set to "1" it also deletes every
+// key it read, the day index ARGV[5] and the day hashes it lists under the prefix ARGV[4].
+//
+// The detail, latency and day keys are only known once the script has read KEYS[1] and the
+// day index, so they are built from the prefixes instead of being declared in KEYS. That
+// needs every stats key on one node: Redis Cluster is not supported unless the key prefix
+// holds a hash tag (e.g. "{cliproxy}:usage:") that maps all of them to a single slot.
+var statsReadScript = redis.NewScript(`
+local reset = ARGV[2] == '1'
+local result = {}
+for i = 1, #KEYS do
+	result[i] = redis.call('HGETALL', KEYS[i])
+end
+local details = {}
+for _, field in ipairs(redis.call('HKEYS', KEYS[1])) do
+	local key = ARGV[1] .. field
+	local latencyKey = ARGV[3] .. field
+	details[#details + 1] = field
+	details[#details + 1] = redis.call('LRANGE', key, 0, -1)
+	details[#details + 1] = redis.call('HGETALL', latencyKey)
+	if reset then
+		redis.call('DEL', key, latencyKey)
+	end
+end
+result[#KEYS + 1] = details
+if reset then
+	redis.call('DEL', unpack(KEYS))
+	for _, day in ipairs(redis.call('HKEYS', ARGV[5])) do
+		redis.call('DEL', ARGV[4] .. day)
+	end
+	redis.call('DEL', ARGV[5])
+end
+return result
+`)
+
+var errRedisUnavailable = errors.New("redis client unavailable")
+
 func (s *redisStatsStorage) key(prefix string) string {
-	return s.config.KeyPrefix + prefix
+	return s.config.KeyPrefix + statsKeyVersion + prefix
+}
+
+// modelField encodes an API key and model name as one hash field. JSON keeps the pair
+// unambiguous whatever characters either part contains.
+func modelField(apiName, modelName string) string {
+	data, _ := json.Marshal([2]string{apiName, modelName})
+	return string(data)
+}
+
+// parseModelField reverses modelField.
+func parseModelField(field string) (apiName, modelName string, ok bool) {
+	var pair [2]string
+	if err := json.Unmarshal([]byte(field), &pair); err != nil {
+		return "", "", false
+	}
+	return pair[0], pair[1], true
+}
+
+// bucketFields maps the key name of every time bucket to its field in snapshot.
+func bucketFields(snapshot *StatisticsSnapshot) map[string]*map[string]int64 {
+	return map[string]*map[string]int64{
+		statsRequestsByDay:    &snapshot.RequestsByDay,
+		statsRequestsByHour:   &snapshot.RequestsByHour,
+		statsTokensByDay:      &snapshot.TokensByDay,
+		statsTokensByHour:     &snapshot.TokensByHour,
+		statsRequestsByMinute: &snapshot.RequestsByMinute,
+		statsTokensByMinute:   &snapshot.TokensByMinute,
+		statsRequestsByMonth:  &snapshot.RequestsByMonth,
+		statsTokensByMonth:    &snapshot.TokensByMonth,
+	}
 }
 
 func (s *redisStatsStorage) Record(ctx context.Context, record coreusage.Record) {
@@ -110,9 +509,13 @@ func (s *redisStatsStorage) Record(ctx context.Context, record coreusage.Record)
 	// The request context may be canceled before Redis operations complete
 	bgCtx := context.Background()
 
-	// Get current snapshot, update it, and write back
-	// This is a simplified approach - in production, consider using Lua scripts for atomicity
-	snapshot := s.Snapshot()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if isRefreshRecord(record.Source) {
+		s.recordRefresh(bgCtx, client, resolveProvider(record.Provider, ""), record.Failed)
+		return
+	}
 
 	// Convert record to detail
 	timestamp := record.RequestedAt
@@ -120,345 +523,504 @@ func (s *redisStatsStorage) Record(ctx context.Context, record coreusage.Record)
 		timestamp = time.Now()
 	}
 	detail := normalizeRecordDetail(record)
-	totalTokens := detail.TotalTokens
 
 	statsKey := record.APIKey
 	if statsKey == "" {
 		statsKey = "unknown"
 	}
-	failed := record.Failed
-	success := !failed
 	modelName := record.Model
 	if modelName == "" {
 		modelName = "unknown"
 	}
 
-	dayKey := timestamp.Format("2006-01-02")
-	hourKey := timestamp.Hour()
-
-	// Update snapshot
-	snapshot.TotalRequests++
-	if success {
-		snapshot.SuccessCount++
-	} else {
-		snapshot.FailureCount++
-	}
-	snapshot.TotalTokens += totalTokens
-
-	// Update API stats
-	if snapshot.APIs == nil {
-		snapshot.APIs = make(map[string]APISnapshot)
-	}
-	apiSnapshot, ok := snapshot.APIs[statsKey]
-	if !ok {
-		apiSnapshot = APISnapshot{Models: make(map[string]ModelSnapshot)}
-	}
-	apiSnapshot.TotalRequests++
-	apiSnapshot.TotalTokens += totalTokens
-
-	if apiSnapshot.Models == nil {
-		apiSnapshot.Models = make(map[string]ModelSnapshot)
-	}
-	modelSnapshot, ok := apiSnapshot.Models[modelName]
-	if !ok {
-		modelSnapshot = ModelSnapshot{}
-	}
-	modelSnapshot.TotalRequests++
-	modelSnapshot.TotalTokens += totalTokens
-	modelSnapshot.Details = append(modelSnapshot.Details, RequestDetail{
+	cost, priced := estimateCost(record, detail)
+	keys, args := s.recordScriptArgs(statsKey, modelName, RequestDetail{
 		Timestamp: timestamp,
 		Source:    record.Source,
 		AuthIndex: record.AuthIndex,
 		Tokens:    detail,
-		Failed:    failed,
+		Failed:    record.Failed,
+		Cost:      cost,
+		Tenant:    resolveTenant(record, statsKey),
+		Unpriced:  !priced,
+		LatencyMs: record.Latency.Milliseconds(),
+		Provider:  resolveProvider(record.Provider, modelName),
 	})
-	apiSnapshot.Models[modelName] = modelSnapshot
-	snapshot.APIs[statsKey] = apiSnapshot
-
-	// Update time-based stats
-	if snapshot.RequestsByDay == nil {
-		snapshot.RequestsByDay = make(map[string]int64)
+	if record.RequestID != "" {
+		keys, args = s.releasePartialArgs(keys, args, record.RequestID)
+	}
+	if err := statsRecordScript.Run(bgCtx, client, keys, args...).Err(); err != nil {
+		log.Errorf("Redis record failed: %v", err)
+		return
 	}
-	snapshot.RequestsByDay[dayKey]++
+	s.pruneIfDue(bgCtx, client, timestamp)
+	s.sweepPartialsIfDue(bgCtx, client, time.Now())
+}
 
-	if snapshot.RequestsByHour == nil {
-		snapshot.RequestsByHour = make(map[string]int64)
+// recordRefresh counts one token refresh attempt of provider in the refresh hashes.
+func (s *redisStatsStorage) recordRefresh(ctx context.Context, client *redis.Client, provider string, failed bool) {
+	names := []string{statsRefreshAttempts}
+	if failed {
+		names = append(names, statsRefreshFailures)
 	}
-	snapshot.RequestsByHour[formatHour(hourKey)]++
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, name := range names {
+			pipe.HIncrBy(ctx, s.key(name), provider, 1)
+			if ttl := s.keyTTL(); ttl > 0 {
+				pipe.PExpire(ctx, s.key(name), ttl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Redis refresh record failed: %v", err)
+	}
+}
 
-	if snapshot.TokensByDay == nil {
-		snapshot.TokensByDay = make(map[string]int64)
+// recordScriptArgs builds the statsRecordScript keys and arguments that add detail to the
+// stats of apiName and modelName. The increments are derived with recordImported, so a
+// record and an imported detail are counted the same way.
+func (s *redisStatsStorage) recordScriptArgs(apiName, modelName string, detail RequestDetail) ([]string, []any) {
+	detail.Provider = resolveProvider(detail.Provider, modelName)
+	var delta StatisticsSnapshot
+	var stats APISnapshot
+	s.merger().recordImported(&delta, apiName, modelName, &stats, detail)
+	model := stats.Models[modelName]
+	field := modelField(apiName, modelName)
+	detailData, _ := json.Marshal(detail)
+
+	keys := []string{s.key(statsDetailsPrefix + field)}
+	args := []any{detailData, s.maxDetails, s.keyTTL().Milliseconds()}
+	increment := func(name, kind, field string, amount any) {
+		keys = append(keys, s.key(name))
+		args = append(args, s.keyTTL().Milliseconds(), kind, field, amount)
 	}
-	snapshot.TokensByDay[dayKey] += totalTokens
 
-	if snapshot.TokensByHour == nil {
-		snapshot.TokensByHour = make(map[string]int64)
+	increment(statsModelRequests, "i", field, model.TotalRequests)
+	increment(statsModelTokens, "i", field, model.TotalTokens)
+	if model.FailureCount != 0 {
+		increment(statsModelFailures, "i", field, model.FailureCount)
 	}
-	snapshot.TokensByHour[formatHour(hourKey)] += totalTokens
+	if model.FailureTokens != 0 {
+		increment(statsModelFailTokens, "i", field, model.FailureTokens)
+	}
+	if model.TotalCost != 0 {
+		increment(statsModelCosts, "f", field, model.TotalCost)
+	}
+	day := detail.Timestamp.In(time.Local).Format(dayBucketLayout)
+	increment(statsDays, "i", day, int64(1))
+	increment(statsDayPrefix+day, "i", "requests:"+modelName, int64(1))
+	increment(statsDayPrefix+day, "i", "tokens:"+modelName, model.TotalTokens)
+	if detail.LatencyMs > 0 {
+		increment(statsLatencyPrefix+field, "i", strconv.Itoa(latencyBucket(detail.LatencyMs)), int64(1))
+	}
+	increment(statsTotalKey, "i", "total_requests", delta.TotalRequests)
+	increment(statsTotalKey, "i", "success_count", delta.SuccessCount)
+	increment(statsTotalKey, "i", "failure_count", delta.FailureCount)
+	increment(statsTotalKey, "i", "total_tokens", delta.TotalTokens)
+	if delta.FailureTokens != 0 {
+		increment(statsTotalKey, "i", "failure_tokens", delta.FailureTokens)
+	}
+	if delta.TotalCost != 0 {
+		increment(statsTotalKey, "f", "total_cost", delta.TotalCost)
+	}
+	for tenant, cost := range delta.TenantCosts {
+		increment(statsTenantCosts, "f", tenant, cost)
+	}
+	for model, count := range delta.UnpricedRequests {
+		increment(statsUnpriced, "i", model, count)
+	}
+	for provider, counts := range delta.Providers {
+		increment(statsProviderRequests, "i", provider, counts.TotalRequests)
+		increment(statsProviderTokens, "i", provider, counts.TotalTokens)
+		if counts.FailureCount != 0 {
+			increment(statsProviderFailures, "i", provider, counts.FailureCount)
+		}
+	}
+	for name, buckets := range bucketFields(&delta) {
+		for bucket, amount := range *buckets {
+			increment(name, "i", bucket, amount)
+		}
+	}
+	return keys, args
+}
 
-	// Write back to Redis
-	s.saveSnapshot(bgCtx, snapshot)
+// Flush waits for in-flight records and an in-progress merge or export to finish. Records
+// are applied synchronously, so nothing else is buffered.
+func (s *redisStatsStorage) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+// Snapshot reads every stats key with statsReadScript, so the result is a point-in-time
+// view: each record is applied by a single script and is either fully visible or not at
+// all. A snapshot may still be stale by the time it is returned.
 func (s *redisStatsStorage) Snapshot() StatisticsSnapshot {
 	client := cache.GetClient()
 	if client == nil {
 		return StatisticsSnapshot{}
 	}
 
-	ctx := context.Background()
-	snapshot := StatisticsSnapshot{}
-
-	// Load total stats
-	totalData, err := client.Get(ctx, s.key(statsTotalKey)).Result()
-	if err == nil {
-		var total struct {
-			TotalRequests int64 `json:"total_requests"`
-			SuccessCount  int64 `json:"success_count"`
-			FailureCount  int64 `json:"failure_count"`
-			TotalTokens   int64 `json:"total_tokens"`
-		}
-		if json.Unmarshal([]byte(totalData), &total) == nil {
-			snapshot.TotalRequests = total.TotalRequests
-			snapshot.SuccessCount = total.SuccessCount
-			snapshot.FailureCount = total.FailureCount
-			snapshot.TotalTokens = total.TotalTokens
-		}
+	raw, err := s.readStats(context.Background(), client, false)
+	if err != nil {
+		log.Errorf("Redis snapshot failed: %v", err)
+		return StatisticsSnapshot{}
 	}
+	snapshot := decodeSnapshot(raw)
+	snapshot.Granularities = s.granularities.names()
+	snapshot.PendingTokens = s.pendingTokens(context.Background(), client)
+	return snapshot
+}
 
-	// Load APIs stats
-	apisData, err := client.Get(ctx, s.key(statsAPIsKey)).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(apisData), &snapshot.APIs); err == nil {
-			// Ensure maps are initialized
-			if snapshot.APIs == nil {
-				snapshot.APIs = make(map[string]APISnapshot)
-			}
-		}
+// ExportAndReset reads and deletes every stats key in a single statsReadScript call.
+// Merges from this process are held off until the reset completes.
+func (s *redisStatsStorage) ExportAndReset(ctx context.Context) (StatisticsSnapshot, error) {
+	client := cache.GetClient()
+	if client == nil {
+		return StatisticsSnapshot{}, errRedisUnavailable
 	}
 
-	// Load requests by day
-	requestsByDayData, err := client.Get(ctx, s.key(statsRequestsByDay)).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(requestsByDayData), &snapshot.RequestsByDay); err == nil {
-			if snapshot.RequestsByDay == nil {
-				snapshot.RequestsByDay = make(map[string]int64)
-			}
-		}
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Load requests by hour
-	requestsByHourData, err := client.Get(ctx, s.key(statsRequestsByHour)).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(requestsByHourData), &snapshot.RequestsByHour); err == nil {
-			if snapshot.RequestsByHour == nil {
-				snapshot.RequestsByHour = make(map[string]int64)
-			}
-		}
+	raw, err := s.readStats(ctx, client, true)
+	if err != nil {
+		return StatisticsSnapshot{}, fmt.Errorf("redis export and reset failed: %w", err)
 	}
+	snapshot := decodeSnapshot(raw)
+	snapshot.Granularities = s.granularities.names()
+	snapshot.PendingTokens = s.pendingTokens(ctx, client)
+	return snapshot, nil
+}
 
-	// Load tokens by day
-	tokensByDayData, err := client.Get(ctx, s.key(statsTokensByDay)).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(tokensByDayData), &snapshot.TokensByDay); err == nil {
-			if snapshot.TokensByDay == nil {
-				snapshot.TokensByDay = make(map[string]int64)
-			}
-		}
+// QueryRange reads only the day hashes of the range, in one pipeline.
+func (s *redisStatsStorage) QueryRange(from, to time.Time) RangeStats {
+	result := newRangeStats(from, to)
+	client := cache.GetClient()
+	if client == nil {
+		return result
+	}
+	days := rangeDays(from, to)
+	if len(days) == 0 {
+		return result
 	}
 
-	// Load tokens by hour
-	tokensByHourData, err := client.Get(ctx, s.key(statsTokensByHour)).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(tokensByHourData), &snapshot.TokensByHour); err == nil {
-			if snapshot.TokensByHour == nil {
-				snapshot.TokensByHour = make(map[string]int64)
-			}
+	ctx := context.Background()
+	cmds := make([]*redis.MapStringStringCmd, len(days))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, day := range days {
+			cmds[i] = pipe.HGetAll(ctx, s.key(statsDayPrefix+day))
 		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Redis range query failed: %v", err)
+		return result
+	}
+	for i, day := range days {
+		result.addDayHash(day, cmds[i].Val())
 	}
-
-	return snapshot
-}
-
-func (s *redisStatsStorage) MergeSnapshot(snapshot StatisticsSnapshot) MergeResult {
-	bgCtx := context.Background()
-	// For Redis storage, we merge by loading current snapshot, merging, and saving
-	current := s.Snapshot()
-	result := s.mergeSnapshots(&current, snapshot)
-	s.saveSnapshot(bgCtx, current)
 	return result
 }
 
-func (s *redisStatsStorage) mergeSnapshots(target *StatisticsSnapshot, source StatisticsSnapshot) MergeResult {
-	result := MergeResult{}
+// readStats runs statsReadScript over every stats hash, deleting them afterwards if reset is set.
+func (s *redisStatsStorage) readStats(ctx context.Context, client *redis.Client, reset bool) ([]any, error) {
+	keys := make([]string, len(statsHashKeys))
+	for i, name := range statsHashKeys {
+		keys[i] = s.key(name)
+	}
+	flag := "0"
+	if reset {
+		flag = "1"
+	}
+	return statsReadScript.Run(ctx, client, keys,
+		s.key(statsDetailsPrefix), flag, s.key(statsLatencyPrefix), s.key(statsDayPrefix), s.key(statsDays)).Slice()
+}
 
-	seen := make(map[string]struct{})
-	if target.APIs != nil {
-		for apiName, stats := range target.APIs {
-			if stats.Models == nil {
-				continue
-			}
-			for modelName, modelStatsValue := range stats.Models {
-				for _, detail := range modelStatsValue.Details {
-					seen[dedupKey(apiName, modelName, detail)] = struct{}{}
-				}
-			}
+// decodeSnapshot rebuilds a snapshot from a statsReadScript reply. Missing or malformed
+// values are left empty.
+func decodeSnapshot(raw []any) StatisticsSnapshot {
+	snapshot := StatisticsSnapshot{}
+	hashes := make(map[string]map[string]string, len(statsHashKeys))
+	for i, name := range statsHashKeys {
+		if i < len(raw) {
+			hashes[name] = decodeHash(raw[i])
 		}
 	}
 
-	if target.APIs == nil {
-		target.APIs = make(map[string]APISnapshot)
-	}
+	// Load total stats
+	total := hashes[statsTotalKey]
+	snapshot.TotalRequests = parseCounter(total["total_requests"])
+	snapshot.SuccessCount = parseCounter(total["success_count"])
+	snapshot.FailureCount = parseCounter(total["failure_count"])
+	// Only failure tokens are counted separately: success tokens are the rest of total_tokens,
+	// so counters written before the split read as successful tokens.
+	snapshot.TotalTokens = parseCounter(total["total_tokens"])
+	snapshot.FailureTokens = parseCounter(total["failure_tokens"])
+	snapshot.SuccessTokens = snapshot.TotalTokens - snapshot.FailureTokens
+	snapshot.TotalCost, _ = strconv.ParseFloat(total["total_cost"], 64)
 
-	for apiName, apiSnapshot := range source.APIs {
-		if apiName = normalizeAPIKey(apiName); apiName == "" {
-			continue
-		}
-		stats, ok := target.APIs[apiName]
+	// Load APIs stats
+	var details map[string][]any
+	var latencies map[string]map[string]string
+	if len(raw) > len(statsHashKeys) {
+		details, latencies = decodeModelData(raw[len(statsHashKeys)])
+	}
+	tokens, failures, costs := hashes[statsModelTokens], hashes[statsModelFailures], hashes[statsModelCosts]
+	failureTokens := hashes[statsModelFailTokens]
+	for field, requests := range hashes[statsModelRequests] {
+		apiName, modelName, ok := parseModelField(field)
 		if !ok {
-			stats = APISnapshot{Models: make(map[string]ModelSnapshot)}
+			continue
 		}
-		if stats.Models == nil {
-			stats.Models = make(map[string]ModelSnapshot)
+		model := ModelSnapshot{
+			TotalRequests: parseCounter(requests),
+			FailureCount:  parseCounter(failures[field]),
+			TotalTokens:   parseCounter(tokens[field]),
+			FailureTokens: parseCounter(failureTokens[field]),
 		}
-		for modelName, modelSnapshot := range apiSnapshot.Models {
-			if modelName = normalizeModelName(modelName); modelName == "" {
-				modelName = "unknown"
-			}
-			for _, detail := range modelSnapshot.Details {
-				detail.Tokens = normaliseTokenStats(detail.Tokens)
-				if detail.Timestamp.IsZero() {
-					detail.Timestamp = time.Now()
-				}
-				key := dedupKey(apiName, modelName, detail)
-				if _, exists := seen[key]; exists {
-					result.Skipped++
-					continue
-				}
-				seen[key] = struct{}{}
-				s.recordImported(target, apiName, modelName, &stats, detail)
-				result.Added++
+		model.SuccessTokens = model.TotalTokens - model.FailureTokens
+		model.TotalCost, _ = strconv.ParseFloat(costs[field], 64)
+		latency := decodeLatencyHistogram(latencies[field])
+		model.Latency = latency.summary()
+		for _, item := range details[field] {
+			data, _ := item.(string)
+			var detail RequestDetail
+			if json.Unmarshal([]byte(data), &detail) == nil {
+				model.Details = append(model.Details, detail)
 			}
 		}
-		target.APIs[apiName] = stats
+		if snapshot.APIs == nil {
+			snapshot.APIs = make(map[string]APISnapshot)
+		}
+		apiSnapshot := snapshot.APIs[apiName]
+		if apiSnapshot.Models == nil {
+			apiSnapshot.Models = make(map[string]ModelSnapshot)
+		}
+		apiSnapshot.TotalRequests += model.TotalRequests
+		apiSnapshot.TotalTokens += model.TotalTokens
+		apiSnapshot.SuccessTokens += model.SuccessTokens
+		apiSnapshot.FailureTokens += model.FailureTokens
+		apiSnapshot.TotalCost += model.TotalCost
+		apiSnapshot.Models[modelName] = model
+		snapshot.APIs[apiName] = apiSnapshot
 	}
 
-	return result
-}
+	// Load provider stats
+	providerTokens, providerFailures := hashes[statsProviderTokens], hashes[statsProviderFailures]
+	for provider, requests := range hashes[statsProviderRequests] {
+		if snapshot.Providers == nil {
+			snapshot.Providers = make(map[string]ProviderSnapshot)
+		}
+		snapshot.Providers[provider] = ProviderSnapshot{
+			TotalRequests: parseCounter(requests),
+			FailureCount:  parseCounter(providerFailures[provider]),
+			TotalTokens:   parseCounter(providerTokens[provider]),
+		}
+	}
 
-func (s *redisStatsStorage) recordImported(snapshot *StatisticsSnapshot, apiName, modelName string, stats *APISnapshot, detail RequestDetail) {
-	totalTokens := detail.Tokens.TotalTokens
-	if totalTokens < 0 {
-		totalTokens = 0
+	// Load refresh attempts
+	refreshFailures := hashes[statsRefreshFailures]
+	for provider, attempts := range hashes[statsRefreshAttempts] {
+		if snapshot.Refreshes == nil {
+			snapshot.Refreshes = make(map[string]RefreshSnapshot)
+		}
+		snapshot.Refreshes[provider] = RefreshSnapshot{
+			Attempts:     parseCounter(attempts),
+			FailureCount: parseCounter(refreshFailures[provider]),
+		}
 	}
 
-	snapshot.TotalRequests++
-	if detail.Failed {
-		snapshot.FailureCount++
-	} else {
-		snapshot.SuccessCount++
+	// Load time-based stats
+	for name, buckets := range bucketFields(&snapshot) {
+		*buckets = decodeBuckets(hashes[name])
 	}
-	snapshot.TotalTokens += totalTokens
 
-	stats.TotalRequests++
-	stats.TotalTokens += totalTokens
+	// Load tenant costs
+	for tenant, value := range hashes[statsTenantCosts] {
+		cost, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if snapshot.TenantCosts == nil {
+			snapshot.TenantCosts = make(map[string]float64)
+		}
+		snapshot.TenantCosts[tenant] = cost
+	}
+	snapshot.UnpricedRequests = decodeBuckets(hashes[statsUnpriced])
 
-	if stats.Models == nil {
-		stats.Models = make(map[string]ModelSnapshot)
+	return snapshot
+}
+
+// decodeHash converts a flat HGETALL field/value reply into a map.
+func decodeHash(raw any) map[string]string {
+	items, _ := raw.([]any)
+	if len(items) == 0 {
+		return nil
 	}
-	modelStatsValue, ok := stats.Models[modelName]
-	if !ok {
-		modelStatsValue = ModelSnapshot{}
+	values := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		values[field] = value
 	}
-	modelStatsValue.TotalRequests++
-	modelStatsValue.TotalTokens += totalTokens
-	modelStatsValue.Details = append(modelStatsValue.Details, detail)
-	stats.Models[modelName] = modelStatsValue
-
-	dayKey := detail.Timestamp.Format("2006-01-02")
-	hourKey := detail.Timestamp.Hour()
+	return values
+}
 
-	if snapshot.RequestsByDay == nil {
-		snapshot.RequestsByDay = make(map[string]int64)
+// decodeModelData splits the flat (model field, detail list, latency histogram) triples of
+// a statsReadScript reply into the detail lists and latency hashes keyed by model field.
+func decodeModelData(raw any) (map[string][]any, map[string]map[string]string) {
+	items, _ := raw.([]any)
+	lists := make(map[string][]any, len(items)/3)
+	latencies := make(map[string]map[string]string, len(items)/3)
+	for i := 0; i+2 < len(items); i += 3 {
+		field, _ := items[i].(string)
+		list, _ := items[i+1].([]any)
+		lists[field] = list
+		latencies[field] = decodeHash(items[i+2])
 	}
-	snapshot.RequestsByDay[dayKey]++
+	return lists, latencies
+}
 
-	if snapshot.RequestsByHour == nil {
-		snapshot.RequestsByHour = make(map[string]int64)
+func decodeBuckets(values map[string]string) map[string]int64 {
+	if len(values) == 0 {
+		return nil
+	}
+	buckets := make(map[string]int64, len(values))
+	for bucket, value := range values {
+		buckets[bucket] = parseCounter(value)
 	}
-	snapshot.RequestsByHour[formatHour(hourKey)]++
+	return buckets
+}
+
+func parseCounter(value string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
 
-	if snapshot.TokensByDay == nil {
-		snapshot.TokensByDay = make(map[string]int64)
+// MergeSnapshot deduplicates the imported details against the current snapshot and then
+// applies each new one with statsRecordScript, so records written concurrently by other
+// instances are kept rather than overwritten.
+func (s *redisStatsStorage) MergeSnapshot(snapshot StatisticsSnapshot) MergeResult {
+	result, err := s.mergeSnapshot(context.Background(), snapshot)
+	if err != nil {
+		log.Errorf("Redis merge failed: %v", err)
 	}
-	snapshot.TokensByDay[dayKey] += totalTokens
+	return result
+}
 
-	if snapshot.TokensByHour == nil {
-		snapshot.TokensByHour = make(map[string]int64)
+// mergeSnapshot implements MergeSnapshot and reports whether the new details were applied.
+func (s *redisStatsStorage) mergeSnapshot(ctx context.Context, snapshot StatisticsSnapshot) (MergeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.Snapshot()
+	jobs, result := s.merger().merge(&current, snapshot)
+	if result.Added == 0 {
+		return result, nil
 	}
-	snapshot.TokensByHour[formatHour(hourKey)] += totalTokens
+	return result, s.applyImported(ctx, jobs)
 }
 
-func (s *redisStatsStorage) saveSnapshot(ctx context.Context, snapshot StatisticsSnapshot) {
+// migrateLegacyStats imports the stats kept in the layout used before statsKeyVersion. The
+// legacy keys are read and deleted in one MULTI/EXEC, so only one instance sharing the prefix
+// imports them. Their request details are applied through mergeSnapshot, which rebuilds every
+// counter and bucket from them; requests whose details were already trimmed by
+// max-details-per-model cannot be rebuilt and are reported in the log. If applying fails the
+// legacy keys are written back so a later start can retry.
+func (s *redisStatsStorage) migrateLegacyStats(ctx context.Context) (MergeResult, error) {
 	client := cache.GetClient()
 	if client == nil {
-		return
+		return MergeResult{}, errRedisUnavailable
 	}
 
-	var ttl time.Duration
-	if s.config.TTL == -1 {
-		// -1 means never expire
-		ttl = 0
-	} else if s.config.TTL > 0 {
-		ttl = time.Duration(s.config.TTL) * time.Second
-	} else {
-		// Default to 1 day if TTL is not set or invalid
-		ttl = 24 * time.Hour
-	}
-
-	// Save total stats
-	totalData, _ := json.Marshal(map[string]int64{
-		"total_requests": snapshot.TotalRequests,
-		"success_count":  snapshot.SuccessCount,
-		"failure_count":  snapshot.FailureCount,
-		"total_tokens":   snapshot.TotalTokens,
+	gets := make(map[string]*redis.StringCmd, len(legacyStatsKeys))
+	keys := make([]string, 0, len(legacyStatsKeys))
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, name := range legacyStatsKeys {
+			gets[name] = pipe.Get(ctx, s.config.KeyPrefix+name)
+			keys = append(keys, s.config.KeyPrefix+name)
+		}
+		pipe.Del(ctx, keys...)
+		return nil
 	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return MergeResult{}, fmt.Errorf("read legacy usage statistics: %w", err)
+	}
+	values := make(map[string]string, len(gets))
+	for name, cmd := range gets {
+		if data, errGet := cmd.Result(); errGet == nil {
+			values[name] = data
+		}
+	}
+	if len(values) == 0 {
+		return MergeResult{}, nil
+	}
 
-	err := client.Set(ctx, s.key(statsTotalKey), totalData, ttl).Err()
+	var legacy StatisticsSnapshot
+	if data, ok := values[legacyAPIsKey]; ok {
+		if errJSON := json.Unmarshal([]byte(data), &legacy.APIs); errJSON != nil {
+			log.Warnf("usage statistics: legacy %q key is malformed and was dropped: %v", legacyAPIsKey, errJSON)
+		}
+	}
+	result, err := s.mergeSnapshot(ctx, legacy)
 	if err != nil {
-		log.Errorf("Redis saveSnapshot failed: %v", err)
-		return
+		if _, errRestore := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for name, data := range values {
+				pipe.Set(ctx, s.config.KeyPrefix+name, data, s.keyTTL())
+			}
+			return nil
+		}); errRestore != nil {
+			log.Errorf("usage statistics: restore legacy keys after failed migration: %v", errRestore)
+		}
+		return result, fmt.Errorf("apply legacy usage statistics: %w", err)
 	}
 
-	// Save APIs stats
-	if snapshot.APIs != nil {
-		apisData, _ := json.Marshal(snapshot.APIs)
-		client.Set(ctx, s.key(statsAPIsKey), apisData, ttl)
+	var total struct {
+		TotalRequests int64 `json:"total_requests"`
 	}
-
-	// Save requests by day
-	if snapshot.RequestsByDay != nil {
-		requestsByDayData, _ := json.Marshal(snapshot.RequestsByDay)
-		client.Set(ctx, s.key(statsRequestsByDay), requestsByDayData, ttl)
+	_ = json.Unmarshal([]byte(values[statsTotalKey]), &total)
+	if migrated := int64(result.Added + result.Skipped); total.TotalRequests > migrated {
+		log.Warnf("usage statistics: migrated %d of %d legacy requests; the rest had no stored details", migrated, total.TotalRequests)
 	}
+	return result, nil
+}
 
-	// Save requests by hour
-	if snapshot.RequestsByHour != nil {
-		requestsByHourData, _ := json.Marshal(snapshot.RequestsByHour)
-		client.Set(ctx, s.key(statsRequestsByHour), requestsByHourData, ttl)
+// applyImported runs statsRecordScript for every detail added by jobs in one pipeline.
+func (s *redisStatsStorage) applyImported(ctx context.Context, jobs []apiMerge) error {
+	client := cache.GetClient()
+	if client == nil {
+		return errRedisUnavailable
 	}
-
-	// Save tokens by day
-	if snapshot.TokensByDay != nil {
-		tokensByDayData, _ := json.Marshal(snapshot.TokensByDay)
-		client.Set(ctx, s.key(statsTokensByDay), tokensByDayData, ttl)
+	// EVALSHA inside a pipeline cannot fall back to EVAL, so make sure the script is cached.
+	if err := statsRecordScript.Load(ctx, client).Err(); err != nil {
+		return err
 	}
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range jobs {
+			for _, added := range jobs[i].added {
+				keys, args := s.recordScriptArgs(jobs[i].apiName, added.modelName, added.detail)
+				statsRecordScript.EvalSha(ctx, pipe, keys, args...)
+			}
+		}
+		return nil
+	})
+	return err
+}
 
-	// Save tokens by hour
-	if snapshot.TokensByHour != nil {
-		tokensByHourData, _ := json.Marshal(snapshot.TokensByHour)
-		client.Set(ctx, s.key(statsTokensByHour), tokensByHourData, ttl)
-	}
+// merger returns the snapshotMerger matching the storage configuration.
+func (s *redisStatsStorage) merger() snapshotMerger {
+	return newSnapshotMerger(s.granularities, s.maxDetails, s.config.MergeConcurrency)
 }
 
 func normalizeRecordDetail(record coreusage.Record) TokenStats {
@@ -467,27 +1029,14 @@ func normalizeRecordDetail(record coreusage.Record) TokenStats {
 		OutputTokens:    record.Detail.OutputTokens,
 		ReasoningTokens: record.Detail.ReasoningTokens,
 		CachedTokens:    record.Detail.CachedTokens,
+		ToolTokens:      record.Detail.ToolTokens,
 		TotalTokens:     record.Detail.TotalTokens,
 	}
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens
+		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.ToolTokens
 	}
 	if tokens.TotalTokens == 0 {
-		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.CachedTokens
+		tokens.TotalTokens = tokens.InputTokens + tokens.OutputTokens + tokens.ReasoningTokens + tokens.ToolTokens + tokens.CachedTokens
 	}
 	return tokens
 }
-
-func normalizeAPIKey(apiKey string) string {
-	if apiKey == "" {
-		return ""
-	}
-	return apiKey
-}
-
-func normalizeModelName(modelName string) string {
-	if modelName == "" {
-		return ""
-	}
-	return modelName
-}
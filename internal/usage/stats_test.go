@@ -0,0 +1,1228 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestResolveRedisTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.RedisCacheConfig
+		want time.Duration
+	}{
+		{name: "never expire", cfg: config.RedisCacheConfig{TTL: -1}, want: 0},
+		{name: "unset", cfg: config.RedisCacheConfig{}, want: 24 * time.Hour},
+		{name: "above floor", cfg: config.RedisCacheConfig{TTL: 3600}, want: time.Hour},
+		{name: "clamped to default floor", cfg: config.RedisCacheConfig{TTL: 10}, want: config.DefaultRedisMinTTL * time.Second},
+		{name: "clamped to custom floor", cfg: config.RedisCacheConfig{TTL: 10, MinTTL: 60}, want: time.Minute},
+		{name: "floor disabled", cfg: config.RedisCacheConfig{TTL: 10, MinTTL: -1}, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveRedisTTL(tt.cfg); got != tt.want {
+				t.Errorf("resolveRedisTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisKeyTTLJitter(t *testing.T) {
+	s := &redisStatsStorage{config: config.RedisCacheConfig{TTLJitterPercent: 10}, ttl: time.Hour}
+	for i := 0; i < 100; i++ {
+		got := s.keyTTL()
+		if got < time.Hour || got > time.Hour+6*time.Minute {
+			t.Fatalf("keyTTL() = %v, want within [1h, 1h6m]", got)
+		}
+	}
+
+	s.ttl = 0
+	if got := s.keyTTL(); got != 0 {
+		t.Fatalf("keyTTL() with no expiry = %v, want 0", got)
+	}
+}
+
+func TestWriteSnapshotJSONMatchesMarshal(t *testing.T) {
+	snapshot := StatisticsSnapshot{
+		TotalRequests: 3,
+		SuccessCount:  2,
+		FailureCount:  1,
+		TotalTokens:   42,
+		SuccessTokens: 40,
+		FailureTokens: 2,
+		APIs: map[string]APISnapshot{
+			"key-b": {TotalRequests: 1, TotalTokens: 2, FailureTokens: 2, TotalCost: 0.25, Models: map[string]ModelSnapshot{
+				"model": {TotalRequests: 1, TotalTokens: 2, FailureTokens: 2, TotalCost: 0.25, Latency: &LatencySummary{Count: 1, P50Ms: 99.5, P90Ms: 99.5, P99Ms: 99.5}, Details: []RequestDetail{{
+					Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+					Source:    "test",
+					Tokens:    TokenStats{InputTokens: 1, OutputTokens: 1, TotalTokens: 2},
+				}}},
+			}},
+			"key-a": {TotalRequests: 2, TotalTokens: 40, Models: map[string]ModelSnapshot{"empty": {}}},
+		},
+		RequestsByDay:    map[string]int64{"2025-01-02": 3},
+		TokensByHour:     map[string]int64{"03": 42},
+		TokensByMonth:    map[string]int64{"2025-01": 42},
+		UnpricedRequests: map[string]int64{"empty": 2},
+		Providers:        map[string]ProviderSnapshot{"kiro": {TotalRequests: 3, FailureCount: 1, TotalTokens: 42}},
+		Refreshes:        map[string]RefreshSnapshot{"kiro": {Attempts: 2, FailureCount: 1}},
+		Granularities:    []string{GranularityHour, GranularityDay, GranularityMonth},
+	}
+
+	want, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteSnapshotJSON(&buf, snapshot); err != nil {
+		t.Fatalf("WriteSnapshotJSON() error = %v", err)
+	}
+	if buf.String() != string(want) {
+		t.Fatalf("WriteSnapshotJSON() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRedisMergeSnapshotsConcurrent(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	detail := func(offset int) RequestDetail {
+		return RequestDetail{Timestamp: ts.Add(time.Duration(offset) * time.Second), Tokens: TokenStats{TotalTokens: 10}}
+	}
+	target := StatisticsSnapshot{}
+	s := &redisStatsStorage{config: config.RedisCacheConfig{MergeConcurrency: 2}}
+	s.merger().merge(&target, StatisticsSnapshot{APIs: map[string]APISnapshot{
+		"key-a": {Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{detail(0)}}}},
+	}})
+
+	source := StatisticsSnapshot{APIs: map[string]APISnapshot{}}
+	for _, key := range []string{"key-a", "key-b", "key-c", "key-d"} {
+		source.APIs[key] = APISnapshot{Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{detail(0), detail(1)}}}}
+	}
+	_, result := s.merger().merge(&target, source)
+
+	if result.Added != 7 || result.Skipped != 1 {
+		t.Fatalf("MergeResult = %+v, want Added=7 Skipped=1", result)
+	}
+	if target.TotalRequests != 8 || target.TotalTokens != 80 {
+		t.Fatalf("totals = %d requests / %d tokens, want 8 / 80", target.TotalRequests, target.TotalTokens)
+	}
+	if got := target.RequestsByDay["2025-01-02"]; got != 8 {
+		t.Fatalf("RequestsByDay = %d, want 8", got)
+	}
+	if got := len(target.APIs["key-a"].Models["m"].Details); got != 2 {
+		t.Fatalf("key-a details = %d, want 2", got)
+	}
+}
+
+func TestMergeGroupsKeysByNormalizedName(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	shared := RequestDetail{Timestamp: ts, Tokens: TokenStats{TotalTokens: 10}}
+	source := StatisticsSnapshot{APIs: map[string]APISnapshot{
+		"k":   {Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{shared}}}},
+		" k ": {Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{shared, {Timestamp: ts.Add(time.Second), Tokens: TokenStats{TotalTokens: 5}}}}}},
+	}}
+
+	for _, workers := range []int{1, 4} {
+		target := StatisticsSnapshot{}
+		merger := snapshotMerger{workers: workers}
+		jobs, result := merger.merge(&target, source)
+		if len(jobs) != 1 || jobs[0].apiName != "k" {
+			t.Fatalf("workers=%d: jobs = %+v, want a single job for k", workers, jobs)
+		}
+		if result.Added != 2 || result.Skipped != 1 {
+			t.Fatalf("workers=%d: MergeResult = %+v, want Added=2 Skipped=1", workers, result)
+		}
+		if target.TotalRequests != 2 || target.TotalTokens != 15 {
+			t.Fatalf("workers=%d: totals = %d requests / %d tokens, want 2 / 15", workers, target.TotalRequests, target.TotalTokens)
+		}
+		if got := len(target.APIs["k"].Models["m"].Details); got != 2 {
+			t.Fatalf("workers=%d: k details = %d, want 2", workers, got)
+		}
+	}
+}
+
+func TestMemoryMergeSnapshotUsesMergeConcurrency(t *testing.T) {
+	SetStatisticsEnabled(true)
+	if got := NewRequestStatistics().merger().workers; got != runtime.GOMAXPROCS(0) {
+		t.Fatalf("default merge workers = %d, want GOMAXPROCS", got)
+	}
+	storage := NewStatsStorage(config.RedisCacheConfig{MergeConcurrency: 4}).(*memoryStatsStorage)
+	if got := storage.stats.merger().workers; got != 4 {
+		t.Fatalf("merge workers = %d, want merge-concurrency 4", got)
+	}
+
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := StatisticsSnapshot{APIs: map[string]APISnapshot{}}
+	for i := 0; i < 32; i++ {
+		source.APIs["key-"+strconv.Itoa(i)] = APISnapshot{Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{
+			{Timestamp: ts, Tokens: TokenStats{TotalTokens: 3}},
+			{Timestamp: ts.Add(time.Second), Tokens: TokenStats{TotalTokens: 4}},
+		}}}}
+	}
+	if result := storage.MergeSnapshot(source); result.Added != 64 || result.Skipped != 0 {
+		t.Fatalf("MergeSnapshot() = %+v, want Added=64", result)
+	}
+	snapshot := storage.Snapshot()
+	if snapshot.TotalRequests != 64 || snapshot.TotalTokens != 224 || len(snapshot.APIs) != 32 {
+		t.Fatalf("totals = %d requests / %d tokens over %d keys, want 64 / 224 over 32", snapshot.TotalRequests, snapshot.TotalTokens, len(snapshot.APIs))
+	}
+}
+
+func TestBackendsBuildTheSameMerger(t *testing.T) {
+	for _, cfg := range []config.RedisCacheConfig{
+		{},
+		{MergeConcurrency: 3, MaxDetailsPerModel: 5, Granularities: []string{"minute", "day"}},
+	} {
+		memory := NewStatsStorage(cfg).(*memoryStatsStorage).stats.merger()
+		cfg.Enable = true
+		redisStorage := &redisStatsStorage{config: cfg, granularities: parseGranularities(cfg.Granularities), maxDetails: resolveMaxDetails(cfg.MaxDetailsPerModel)}
+		if redis := redisStorage.merger(); !reflect.DeepEqual(memory, redis) {
+			t.Fatalf("merge-concurrency %d: memory merger = %+v, redis merger = %+v", cfg.MergeConcurrency, memory, redis)
+		}
+	}
+}
+
+func TestMergeSnapshotTwiceSkipsEveryDetail(t *testing.T) {
+	SetStatisticsEnabled(true)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := StatisticsSnapshot{APIs: map[string]APISnapshot{
+		"key-a": {Models: map[string]ModelSnapshot{
+			"m": {Details: []RequestDetail{
+				{Timestamp: ts, Tokens: TokenStats{TotalTokens: 10}},
+				{Timestamp: ts.Add(time.Second), Tokens: TokenStats{TotalTokens: 20}, Failed: true},
+			}},
+			" spaced ": {Details: []RequestDetail{{Timestamp: ts, Tokens: TokenStats{InputTokens: 3}}}},
+		}},
+		"key-b ": {Models: map[string]ModelSnapshot{"": {Details: []RequestDetail{{Timestamp: ts}}}}},
+		// Differs from key-a only in whitespace: one detail repeats a key-a detail, one is new.
+		" key-a": {Models: map[string]ModelSnapshot{"m": {Details: []RequestDetail{
+			{Timestamp: ts, Tokens: TokenStats{TotalTokens: 10}},
+			{Timestamp: ts.Add(2 * time.Second), Tokens: TokenStats{TotalTokens: 30}},
+		}}}},
+	}}
+	const records = 5
+	const details = 6
+
+	memory := NewRequestStatistics()
+	redisTarget := StatisticsSnapshot{}
+	redisMerger := (&redisStatsStorage{config: config.RedisCacheConfig{MergeConcurrency: 4}}).merger()
+	for i, want := range []MergeResult{{Added: records, Skipped: details - records}, {Skipped: details}} {
+		if got := memory.MergeSnapshot(source); got != want {
+			t.Fatalf("memory import %d = %+v, want %+v", i+1, got, want)
+		}
+		if _, got := redisMerger.merge(&redisTarget, source); got != want {
+			t.Fatalf("redis import %d = %+v, want %+v", i+1, got, want)
+		}
+	}
+
+	memorySnapshot := memory.Snapshot()
+	if memorySnapshot.TotalRequests != records || redisTarget.TotalRequests != records {
+		t.Fatalf("TotalRequests = %d (memory) / %d (redis), want %d", memorySnapshot.TotalRequests, redisTarget.TotalRequests, records)
+	}
+	for _, snapshot := range []StatisticsSnapshot{memorySnapshot, redisTarget} {
+		if got := len(snapshot.APIs["key-a"].Models["m"].Details); got != 3 || len(snapshot.APIs) != 2 {
+			t.Fatalf("APIs = %+v, want key-a/m with 3 details and no whitespace variants", snapshot.APIs)
+		}
+		if _, ok := snapshot.APIs["key-b"].Models["unknown"]; !ok {
+			t.Fatalf("APIs = %+v, want normalized key-b/unknown", snapshot.APIs)
+		}
+		if _, ok := snapshot.APIs["key-a"].Models["spaced"]; !ok {
+			t.Fatalf("key-a models = %+v, want normalized model name", snapshot.APIs["key-a"].Models)
+		}
+	}
+}
+
+// applyRecordScriptArgs mirrors statsRecordScript on in-memory hashes and lists.
+func applyRecordScriptArgs(t *testing.T, hashes map[string]map[string]float64, lists map[string][]any, keys []string, args []any) {
+	t.Helper()
+	detail, _ := args[0].([]byte)
+	lists[keys[0]] = append(lists[keys[0]], string(detail))
+	if limit := args[1].(int); limit > 0 && len(lists[keys[0]]) > limit {
+		lists[keys[0]] = lists[keys[0]][len(lists[keys[0]])-limit:]
+	}
+	for i := 1; i < len(keys); i++ {
+		arg := 3 + (i-1)*4
+		field := args[arg+2].(string)
+		if hashes[keys[i]] == nil {
+			hashes[keys[i]] = make(map[string]float64)
+		}
+		if args[arg+1] == "d" {
+			delete(hashes[keys[i]], field)
+			continue
+		}
+		switch amount := args[arg+3].(type) {
+		case int64:
+			if args[arg+1] != "i" {
+				t.Fatalf("increment of %s/%s has kind %v for an integer", keys[i], field, args[arg+1])
+			}
+			hashes[keys[i]][field] += float64(amount)
+		case float64:
+			if args[arg+1] != "f" {
+				t.Fatalf("increment of %s/%s has kind %v for a float", keys[i], field, args[arg+1])
+			}
+			hashes[keys[i]][field] += amount
+		default:
+			t.Fatalf("unexpected amount %T", amount)
+		}
+	}
+}
+
+func TestRedisRecordScriptRoundTrip(t *testing.T) {
+	s := &redisStatsStorage{config: config.RedisCacheConfig{KeyPrefix: "p:"}, granularities: defaultGranularities, maxDetails: 2}
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	details := []struct {
+		api, model string
+		detail     RequestDetail
+	}{
+		{"key-a", "m", RequestDetail{Timestamp: ts, Tokens: TokenStats{TotalTokens: 10}, Cost: 0.5, LatencyMs: 100}},
+		{"key-a", "m", RequestDetail{Timestamp: ts.Add(time.Hour), Tokens: TokenStats{TotalTokens: 20}, Failed: true, LatencyMs: 100}},
+		{"key-a", "m", RequestDetail{Timestamp: ts.Add(2 * time.Hour), Tokens: TokenStats{TotalTokens: 30}, LatencyMs: 2000}},
+		{"key:b", "m/x", RequestDetail{Timestamp: ts, Tokens: TokenStats{TotalTokens: 5}, Cost: 0.25, Tenant: "t", Provider: "kiro"}},
+		{"key:b", "free", RequestDetail{Timestamp: ts, Tokens: TokenStats{TotalTokens: 5}, Unpriced: true}},
+	}
+	hashes := make(map[string]map[string]float64)
+	lists := make(map[string][]any)
+	for _, d := range details {
+		keys, args := s.recordScriptArgs(d.api, d.model, d.detail)
+		applyRecordScriptArgs(t, hashes, lists, keys, args)
+	}
+
+	// Build the reply statsReadScript would return.
+	flatten := func(key string) []any {
+		var flat []any
+		for field, value := range hashes[key] {
+			flat = append(flat, field, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		return flat
+	}
+	var raw []any
+	for _, name := range statsHashKeys {
+		raw = append(raw, flatten(s.key(name)))
+	}
+	var modelData []any
+	for field := range hashes[s.key(statsModelRequests)] {
+		modelData = append(modelData, field, lists[s.key(statsDetailsPrefix)+field], flatten(s.key(statsLatencyPrefix+field)))
+	}
+	snapshot := decodeSnapshot(append(raw, modelData))
+
+	if snapshot.TotalRequests != 5 || snapshot.SuccessCount != 4 || snapshot.FailureCount != 1 || snapshot.TotalTokens != 70 {
+		t.Fatalf("totals = %+v", snapshot)
+	}
+	if snapshot.SuccessTokens != 50 || snapshot.FailureTokens != 20 {
+		t.Fatalf("success / failure tokens = %d / %d, want 50 / 20", snapshot.SuccessTokens, snapshot.FailureTokens)
+	}
+	wantProviders := map[string]ProviderSnapshot{
+		"kiro":    {TotalRequests: 1, TotalTokens: 5},
+		"unknown": {TotalRequests: 4, FailureCount: 1, TotalTokens: 65},
+	}
+	if !reflect.DeepEqual(snapshot.Providers, wantProviders) {
+		t.Fatalf("Providers = %+v, want %+v", snapshot.Providers, wantProviders)
+	}
+	if snapshot.TotalCost != 0.75 || snapshot.TenantCosts["key-a"] != 0.5 || snapshot.TenantCosts["t"] != 0.25 {
+		t.Fatalf("costs = %v / %v", snapshot.TotalCost, snapshot.TenantCosts)
+	}
+	model := snapshot.APIs["key-a"].Models["m"]
+	if model.TotalRequests != 3 || model.TotalTokens != 60 || model.TotalCost != 0.5 || snapshot.APIs["key-a"].TotalRequests != 3 {
+		t.Fatalf("key-a/m = %+v", model)
+	}
+	if model.SuccessTokens != 40 || model.FailureTokens != 20 || snapshot.APIs["key-a"].FailureTokens != 20 {
+		t.Fatalf("key-a/m success / failure tokens = %d / %d, want 40 / 20", model.SuccessTokens, model.FailureTokens)
+	}
+	if len(model.Details) != 2 || model.Details[0].Tokens.TotalTokens != 20 || !model.Details[0].Failed || model.Details[1].Tokens.TotalTokens != 30 {
+		t.Fatalf("key-a/m details = %+v, want the newest two", model.Details)
+	}
+	if model.Latency == nil || model.Latency.Count != 3 || math.Abs(model.Latency.P50Ms-100) > 1 || math.Abs(model.Latency.P99Ms-2000) > 20 {
+		t.Fatalf("key-a/m latency = %+v, want 3 samples with p50 ~100ms and p99 ~2000ms", model.Latency)
+	}
+	if got := snapshot.APIs["key:b"].Models["free"].Latency; got != nil {
+		t.Fatalf("key:b/free latency = %+v, want nil without samples", got)
+	}
+	if got := snapshot.APIs["key:b"].Models["m/x"]; got.TotalRequests != 1 || got.TotalTokens != 5 || got.TotalCost != 0.25 || len(got.Details) != 1 {
+		t.Fatalf("key:b/m/x = %+v", got)
+	}
+	if got := snapshot.UnpricedRequests; len(got) != 1 || got["free"] != 1 {
+		t.Fatalf("UnpricedRequests = %v, want map[free:1]", got)
+	}
+	rangeStats := newRangeStats(ts, ts)
+	for _, day := range rangeDays(ts, ts) {
+		fields := make(map[string]string)
+		for field, value := range hashes[s.key(statsDayPrefix+day)] {
+			fields[field] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		rangeStats.addDayHash(day, fields)
+	}
+	if rangeStats.TotalRequests != 5 || rangeStats.Models["m/x"] != (RangeCounts{Requests: 1, Tokens: 5}) || rangeStats.Models["m"] != (RangeCounts{Requests: 3, Tokens: 60}) {
+		t.Fatalf("range stats for %s = %+v", ts.Format(time.DateOnly), rangeStats)
+	}
+	if snapshot.RequestsByDay["2025-01-02"] != 5 || snapshot.TokensByHour["04"] != 20 || snapshot.RequestsByMinute != nil {
+		t.Fatalf("buckets = %v / %v / %v", snapshot.RequestsByDay, snapshot.TokensByHour, snapshot.RequestsByMinute)
+	}
+}
+
+type countingStatsStorage struct {
+	StatsStorage
+	snapshots int
+}
+
+func (s *countingStatsStorage) Snapshot() StatisticsSnapshot {
+	s.snapshots++
+	return s.StatsStorage.Snapshot()
+}
+
+func TestCachedStatsStorage(t *testing.T) {
+	inner := &countingStatsStorage{StatsStorage: &memoryStatsStorage{stats: NewRequestStatistics()}}
+	s := newCachedStatsStorage(inner, time.Minute)
+
+	s.Snapshot()
+	s.Snapshot()
+	if inner.snapshots != 1 {
+		t.Fatalf("inner snapshots = %d, want 1", inner.snapshots)
+	}
+
+	s.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: 5}})
+	if got := s.Snapshot().TotalRequests; got != 1 {
+		t.Fatalf("TotalRequests after Record = %d, want 1", got)
+	}
+	if inner.snapshots != 2 {
+		t.Fatalf("inner snapshots = %d, want 2", inner.snapshots)
+	}
+}
+
+func TestEstimateCostByProviderUsageShape(t *testing.T) {
+	price := &coreusage.Pricing{InputPer1K: 1, OutputPer1K: 2, CachedPer1K: 0.1}
+	// The same request of 600 uncached input, 400 cached input, 300 output and 200 reasoning
+	// tokens, as reported by each provider family: (600*1 + 500*2 + 400*0.1) / 1000.
+	const want = 1.64
+	cases := []struct {
+		name   string
+		record coreusage.Record
+		tokens TokenStats
+	}{
+		{
+			// prompt_tokens includes cached tokens, completion_tokens includes reasoning tokens
+			name:   "openai",
+			record: coreusage.Record{Provider: "codex", Model: "gpt-5", Pricing: price},
+			tokens: TokenStats{InputTokens: 1000, CachedTokens: 400, OutputTokens: 500, ReasoningTokens: 200},
+		},
+		{
+			// input_tokens excludes cache reads, output_tokens includes thinking
+			name:   "claude",
+			record: coreusage.Record{Provider: "claude", Model: "claude-sonnet-4", Pricing: price},
+			tokens: TokenStats{InputTokens: 600, CachedTokens: 400, OutputTokens: 500},
+		},
+		{
+			// promptTokenCount includes cached tokens, thoughtsTokenCount is separate
+			name:   "gemini",
+			record: coreusage.Record{Provider: "gemini", Model: "gemini-2.5-pro", Pricing: price},
+			tokens: TokenStats{InputTokens: 1000, CachedTokens: 400, OutputTokens: 300, ReasoningTokens: 200},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cost, priced := estimateCost(tc.record, tc.tokens)
+			if !priced || math.Abs(cost-want) > 1e-9 {
+				t.Fatalf("estimateCost = %v (priced %v), want %v", cost, priced, want)
+			}
+		})
+	}
+}
+
+func TestRecordCostWithPricingOverride(t *testing.T) {
+	SetStatisticsEnabled(true)
+	SetPriceTable(map[string]config.ModelPricing{"priced-model": {Input: 1, Output: 2}})
+	defer SetPriceTable(nil)
+
+	stats := NewRequestStatistics()
+	ctx := context.Background()
+	stats.Record(ctx, coreusage.Record{APIKey: "key-a", Model: "Priced-Model", Detail: coreusage.Detail{InputTokens: 1000, OutputTokens: 500}})
+	stats.Record(ctx, coreusage.Record{
+		APIKey:  "key-b",
+		Model:   "priced-model",
+		Tenant:  "enterprise",
+		Pricing: &coreusage.Pricing{InputPer1K: 0.5, OutputPer1K: 1},
+		Detail:  coreusage.Detail{InputTokens: 1000, OutputTokens: 500},
+	})
+	stats.Record(ctx, coreusage.Record{APIKey: "key-a", Model: "unpriced", Detail: coreusage.Detail{InputTokens: 1000}})
+
+	snapshot := stats.Snapshot()
+	if snapshot.TotalCost != 3 {
+		t.Fatalf("TotalCost = %v, want 3", snapshot.TotalCost)
+	}
+	if got := snapshot.TenantCosts["key-a"]; got != 2 {
+		t.Fatalf("TenantCosts[key-a] = %v, want 2", got)
+	}
+	if got := snapshot.TenantCosts["enterprise"]; got != 1 {
+		t.Fatalf("TenantCosts[enterprise] = %v, want 1", got)
+	}
+	keyA := snapshot.APIs["key-a"]
+	if keyA.TotalCost != 2 || keyA.Models["Priced-Model"].TotalCost != 2 || keyA.Models["unpriced"].TotalCost != 0 {
+		t.Fatalf("key-a costs = %v / %+v", keyA.TotalCost, keyA.Models)
+	}
+	if got := snapshot.APIs["key-b"].Models["priced-model"].TotalCost; got != 1 {
+		t.Fatalf("key-b/priced-model TotalCost = %v, want 1", got)
+	}
+	if got := snapshot.UnpricedRequests; len(got) != 1 || got["unpriced"] != 1 {
+		t.Fatalf("UnpricedRequests = %v, want map[unpriced:1]", got)
+	}
+	if details := keyA.Models["unpriced"].Details; len(details) != 1 || !details[0].Unpriced {
+		t.Fatalf("unpriced details = %+v, want one detail marked unpriced", details)
+	}
+}
+
+func TestMemoryExportAndReset(t *testing.T) {
+	SetStatisticsEnabled(true)
+	s := &memoryStatsStorage{stats: NewRequestStatistics()}
+	ctx := context.Background()
+	s.Record(ctx, coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: 7}})
+
+	exported, err := s.ExportAndReset(ctx)
+	if err != nil {
+		t.Fatalf("ExportAndReset() error = %v", err)
+	}
+	if exported.TotalRequests != 1 || exported.TotalTokens != 7 {
+		t.Fatalf("exported = %d requests / %d tokens, want 1 / 7", exported.TotalRequests, exported.TotalTokens)
+	}
+	if after := s.Snapshot(); after.TotalRequests != 0 || len(after.APIs) != 0 || len(after.RequestsByDay) != 0 {
+		t.Fatalf("snapshot after reset = %+v, want empty", after)
+	}
+
+	s.Record(ctx, coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: 3}})
+	if after := s.Snapshot(); after.TotalRequests != 1 || after.TotalTokens != 3 {
+		t.Fatalf("snapshot after new record = %d requests / %d tokens, want 1 / 3", after.TotalRequests, after.TotalTokens)
+	}
+}
+
+func TestRecordConfiguredGranularities(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	stats.SetGranularities([]string{"minute", "Month", "bogus"})
+	requestedAt := time.Date(2024, 3, 5, 14, 7, 30, 0, time.Local)
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", RequestedAt: requestedAt, Detail: coreusage.Detail{TotalTokens: 9}})
+
+	snapshot := stats.Snapshot()
+	if got := snapshot.Granularities; len(got) != 2 || got[0] != GranularityMinute || got[1] != GranularityMonth {
+		t.Fatalf("Granularities = %v, want [minute month]", got)
+	}
+	if snapshot.RequestsByMinute["2024-03-05 14:07"] != 1 || snapshot.TokensByMinute["2024-03-05 14:07"] != 9 {
+		t.Fatalf("minute buckets = %v / %v", snapshot.RequestsByMinute, snapshot.TokensByMinute)
+	}
+	if snapshot.RequestsByMonth["2024-03"] != 1 || snapshot.TokensByMonth["2024-03"] != 9 {
+		t.Fatalf("month buckets = %v / %v", snapshot.RequestsByMonth, snapshot.TokensByMonth)
+	}
+	if len(snapshot.RequestsByDay) != 0 || len(snapshot.RequestsByHour) != 0 {
+		t.Fatalf("disabled buckets populated: day=%v hour=%v", snapshot.RequestsByDay, snapshot.RequestsByHour)
+	}
+
+	if got := NewRequestStatistics().Snapshot().Granularities; len(got) != 2 || got[0] != GranularityHour || got[1] != GranularityDay {
+		t.Fatalf("default Granularities = %v, want [hour day]", got)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	for i := 1; i <= 1000; i++ {
+		stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Latency: time.Duration(i) * time.Millisecond})
+	}
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "unmeasured"})
+
+	models := stats.Snapshot().APIs["key"].Models
+	latency := models["m"].Latency
+	if latency == nil || latency.Count != 1000 {
+		t.Fatalf("Latency = %+v, want 1000 samples", latency)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"p50", latency.P50Ms, 500},
+		{"p90", latency.P90Ms, 900},
+		{"p99", latency.P99Ms, 990},
+	} {
+		if math.Abs(tc.got-tc.want) > tc.want*0.01 {
+			t.Errorf("%s = %vms, want %vms within 1%%", tc.name, tc.got, tc.want)
+		}
+	}
+	if got := models["unmeasured"].Latency; got != nil {
+		t.Fatalf("unmeasured Latency = %+v, want nil", got)
+	}
+	if got := len(stats.apis["key"].Models["m"].Latency.counts); got > 400 {
+		t.Fatalf("histogram uses %d buckets for 1000 samples, want it bounded by the latency range", got)
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 12, 0, 0, 0, time.Local) }
+	record := func(d int, model string, tokens int64) {
+		stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: model, RequestedAt: day(d), Detail: coreusage.Detail{TotalTokens: tokens}})
+	}
+	record(1, "a", 1)
+	record(2, "a", 10)
+	record(2, "b", 20)
+	record(3, "a", 30)
+	record(5, "b", 100)
+
+	got := stats.QueryRange(day(2), day(4))
+	if got.From != "2025-03-02" || got.To != "2025-03-04" {
+		t.Fatalf("range = %s..%s, want 2025-03-02..2025-03-04", got.From, got.To)
+	}
+	if got.TotalRequests != 3 || got.TotalTokens != 60 {
+		t.Fatalf("totals = %d requests / %d tokens, want 3 / 60", got.TotalRequests, got.TotalTokens)
+	}
+	if got.Models["a"] != (RangeCounts{Requests: 2, Tokens: 40}) || got.Models["b"] != (RangeCounts{Requests: 1, Tokens: 20}) {
+		t.Fatalf("Models = %+v", got.Models)
+	}
+	if len(got.Days) != 2 || got.Days["2025-03-02"].Requests != 2 || got.Days["2025-03-02"].Models["b"].Tokens != 20 || got.Days["2025-03-03"].Tokens != 30 {
+		t.Fatalf("Days = %+v", got.Days)
+	}
+
+	if got := stats.QueryRange(day(4), day(2)); got.TotalRequests != 0 || len(got.Days) != 0 {
+		t.Fatalf("inverted range = %+v, want empty", got)
+	}
+	if days := rangeDays(day(1), day(1).AddDate(20, 0, 0)); len(days) != maxRangeDays || days[len(days)-1] != "2045-03-01" {
+		t.Fatalf("long range kept %d days ending %s, want %d ending 2045-03-01", len(days), days[len(days)-1], maxRangeDays)
+	}
+}
+
+func TestRecordCapsDetailsPerModel(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	stats.SetMaxDetailsPerModel(3)
+	for i := 1; i <= 5; i++ {
+		stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: int64(i)}})
+	}
+
+	model := stats.Snapshot().APIs["key"].Models["m"]
+	if model.TotalRequests != 5 || model.TotalTokens != 15 {
+		t.Fatalf("model totals = %d requests / %d tokens, want 5 / 15", model.TotalRequests, model.TotalTokens)
+	}
+	if len(model.Details) != 3 {
+		t.Fatalf("len(Details) = %d, want 3", len(model.Details))
+	}
+	for i, detail := range model.Details {
+		if want := int64(i + 3); detail.Tokens.TotalTokens != want {
+			t.Fatalf("Details[%d].TotalTokens = %d, want %d", i, detail.Tokens.TotalTokens, want)
+		}
+	}
+
+	stats.SetMaxDetailsPerModel(-1)
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: 6}})
+	details := stats.Snapshot().APIs["key"].Models["m"].Details
+	if len(details) != 4 || details[0].Tokens.TotalTokens != 3 || details[3].Tokens.TotalTokens != 6 {
+		t.Fatalf("Details after lifting the cap = %+v, want tokens 3..6", details)
+	}
+}
+
+func TestRecordPrunesExpiredBuckets(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	stats.SetGranularities([]string{"minute", "day"})
+	stats.SetRetention(2, 1)
+	base := time.Date(2025, 3, 5, 14, 7, 0, 0, time.Local)
+	for _, at := range []time.Time{base, base.Add(30 * time.Minute), base.AddDate(0, 0, 3)} {
+		stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", RequestedAt: at, Detail: coreusage.Detail{TotalTokens: 10}})
+	}
+
+	snapshot := stats.Snapshot()
+	if snapshot.TotalRequests != 3 || snapshot.TotalTokens != 30 {
+		t.Fatalf("totals = %d requests / %d tokens, want 3 / 30", snapshot.TotalRequests, snapshot.TotalTokens)
+	}
+	latestDay := base.AddDate(0, 0, 3).Format(dayBucketLayout)
+	if len(snapshot.RequestsByDay) != 1 || snapshot.RequestsByDay[latestDay] != 1 || len(snapshot.TokensByDay) != 1 {
+		t.Fatalf("day buckets = %v / %v, want only %s", snapshot.RequestsByDay, snapshot.TokensByDay, latestDay)
+	}
+	latestMinute := base.AddDate(0, 0, 3).Format(minuteBucketLayout)
+	if len(snapshot.RequestsByMinute) != 1 || snapshot.RequestsByMinute[latestMinute] != 1 {
+		t.Fatalf("minute buckets = %v, want only %s", snapshot.RequestsByMinute, latestMinute)
+	}
+	if got := stats.QueryRange(base, base.AddDate(0, 0, 3)); got.TotalRequests != 1 {
+		t.Fatalf("QueryRange requests = %d, want 1 after pruning", got.TotalRequests)
+	}
+
+	stats.SetRetention(-1, -1)
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", RequestedAt: base.AddDate(0, 0, 10)})
+	if got := len(stats.Snapshot().RequestsByDay); got != 2 {
+		t.Fatalf("len(RequestsByDay) = %d with retention disabled, want 2", got)
+	}
+}
+
+func TestRecordSplitsSuccessAndFailureTokens(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Detail: coreusage.Detail{TotalTokens: 30}})
+	stats.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Failed: true, Detail: coreusage.Detail{TotalTokens: 4}})
+
+	snapshot := stats.Snapshot()
+	if snapshot.SuccessTokens != 30 || snapshot.FailureTokens != 4 || snapshot.TotalTokens != 34 {
+		t.Fatalf("totals = %d success / %d failure / %d total, want 30 / 4 / 34", snapshot.SuccessTokens, snapshot.FailureTokens, snapshot.TotalTokens)
+	}
+	api := snapshot.APIs["key"]
+	model := api.Models["m"]
+	if api.SuccessTokens != 30 || api.FailureTokens != 4 || api.TotalTokens != 34 {
+		t.Fatalf("api = %+v, want 30 / 4 / 34 tokens", api)
+	}
+	if model.SuccessTokens != 30 || model.FailureTokens != 4 || model.TotalTokens != 34 {
+		t.Fatalf("model = %+v, want 30 / 4 / 34 tokens", model)
+	}
+}
+
+func TestRecordAggregatesProviders(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	ctx := context.Background()
+	stats.Record(ctx, coreusage.Record{APIKey: "a", Model: "m", Provider: "gemini", Detail: coreusage.Detail{TotalTokens: 10}})
+	stats.Record(ctx, coreusage.Record{APIKey: "b", Model: "m", Provider: "gemini", Failed: true, Detail: coreusage.Detail{TotalTokens: 2}})
+	stats.Record(ctx, coreusage.Record{APIKey: "a", Model: "unregistered-model", Detail: coreusage.Detail{TotalTokens: 5}})
+
+	snapshot := stats.Snapshot()
+	want := map[string]ProviderSnapshot{
+		"gemini":  {TotalRequests: 2, FailureCount: 1, TotalTokens: 12},
+		"unknown": {TotalRequests: 1, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(snapshot.Providers, want) {
+		t.Fatalf("Providers = %+v, want %+v", snapshot.Providers, want)
+	}
+
+	merged := NewRequestStatistics()
+	merged.MergeSnapshot(snapshot)
+	if got := merged.Snapshot().Providers; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Providers after merge = %+v, want %+v", got, want)
+	}
+}
+
+func TestPartialUsageReconciledByRecord(t *testing.T) {
+	SetStatisticsEnabled(true)
+	stats := NewRequestStatistics()
+	ctx := context.Background()
+	stats.UpdatePartial(ctx, "req-1", 20)
+	stats.UpdatePartial(ctx, "req-1", 10)
+	stats.UpdatePartial(ctx, "req-2", 7)
+
+	if got := stats.Snapshot(); got.PendingTokens != 37 || got.TotalTokens != 0 {
+		t.Fatalf("pending / total tokens = %d / %d, want 37 / 0", got.PendingTokens, got.TotalTokens)
+	}
+
+	stats.Record(ctx, coreusage.Record{APIKey: "key", Model: "m", RequestID: "req-1", Detail: coreusage.Detail{TotalTokens: 35}})
+	if got := stats.Snapshot(); got.PendingTokens != 7 || got.TotalTokens != 35 {
+		t.Fatalf("after final record pending / total tokens = %d / %d, want 7 / 35", got.PendingTokens, got.TotalTokens)
+	}
+
+	stats.mu.Lock()
+	stats.sweepPartialsLocked(time.Now().Add(partialUsageTimeout + time.Minute))
+	stats.mu.Unlock()
+	got := stats.Snapshot()
+	if got.PendingTokens != 0 || got.FailureTokens != 7 || got.TotalTokens != 42 {
+		t.Fatalf("after timeout pending / failure / total tokens = %d / %d / %d, want 0 / 7 / 42", got.PendingTokens, got.FailureTokens, got.TotalTokens)
+	}
+}
+
+func TestRedisRecordReleasesPartialUsage(t *testing.T) {
+	s := &redisStatsStorage{config: config.RedisCacheConfig{KeyPrefix: "p:"}, granularities: defaultGranularities}
+	hashes := map[string]map[string]float64{
+		s.key(statsPartialTokens):  {"req-1": 30, "req-2": 7},
+		s.key(statsPartialUpdated): {"req-1": 1, "req-2": 1},
+	}
+	lists := make(map[string][]any)
+	keys, args := s.recordScriptArgs("key", "m", RequestDetail{Timestamp: time.Now(), Tokens: TokenStats{TotalTokens: 35}})
+	keys, args = s.releasePartialArgs(keys, args, "req-1")
+	applyRecordScriptArgs(t, hashes, lists, keys, args)
+
+	want := map[string]float64{"req-2": 7}
+	if got := hashes[s.key(statsPartialTokens)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("partial tokens = %v, want %v", got, want)
+	}
+	if _, ok := hashes[s.key(statsPartialUpdated)]["req-1"]; ok {
+		t.Fatal("partial update time of req-1 was not released")
+	}
+	if got := hashes[s.key(statsTotalKey)]["total_tokens"]; got != 35 {
+		t.Fatalf("total_tokens = %v, want 35", got)
+	}
+}
+
+func TestRecordRefreshOutcomes(t *testing.T) {
+	SetStatisticsEnabled(true)
+	ctx := context.Background()
+	refresh := coreusage.Record{Provider: "kiro", Model: coreusage.RefreshModel, APIKey: "kiro", Source: coreusage.RefreshSource}
+	request := coreusage.Record{Provider: "kiro", Model: "m", APIKey: "key", Detail: coreusage.Detail{TotalTokens: 5}}
+
+	startTestRedis(t)
+	stores := map[string]StatsStorage{
+		"memory": NewStatsStorage(config.RedisCacheConfig{}),
+		"redis":  NewStatsStorage(config.RedisCacheConfig{Enable: true, KeyPrefix: "test:", TTL: -1}),
+	}
+	for name, s := range stores {
+		s.Record(ctx, request)
+		s.Record(ctx, refresh)
+		failed := refresh
+		failed.Failed = true
+		s.Record(ctx, failed)
+
+		snapshot := s.Snapshot()
+		if got := snapshot.Refreshes["kiro"]; got != (RefreshSnapshot{Attempts: 2, FailureCount: 1}) {
+			t.Fatalf("%s: Refreshes = %+v, want kiro with 2 attempts and 1 failure", name, snapshot.Refreshes)
+		}
+		if snapshot.TotalRequests != 1 || snapshot.SuccessCount != 1 || snapshot.FailureCount != 0 {
+			t.Fatalf("%s: requests = %d (%d ok / %d failed), want only the real request", name, snapshot.TotalRequests, snapshot.SuccessCount, snapshot.FailureCount)
+		}
+		if _, ok := snapshot.APIs["kiro"]; ok || len(snapshot.APIs) != 1 {
+			t.Fatalf("%s: APIs = %+v, want no refresh pseudo API key", name, snapshot.APIs)
+		}
+		if got := snapshot.Providers["kiro"].TotalRequests; got != 1 {
+			t.Fatalf("%s: kiro provider requests = %d, want 1", name, got)
+		}
+		if _, ok := snapshot.UnpricedRequests[coreusage.RefreshModel]; ok {
+			t.Fatalf("%s: UnpricedRequests = %+v, want no refresh entry", name, snapshot.UnpricedRequests)
+		}
+	}
+}
+
+func TestMergeSkipsRefreshDetails(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := StatisticsSnapshot{
+		APIs: map[string]APISnapshot{"kiro": {Models: map[string]ModelSnapshot{coreusage.RefreshModel: {Details: []RequestDetail{
+			{Timestamp: ts, Source: coreusage.RefreshSource},
+		}}}}},
+		Refreshes: map[string]RefreshSnapshot{"kiro": {Attempts: 1}},
+	}
+	target := StatisticsSnapshot{}
+	_, result := snapshotMerger{}.merge(&target, source)
+	if result != (MergeResult{}) || target.TotalRequests != 0 || len(target.Refreshes) != 0 {
+		t.Fatalf("merge = %+v, target = %+v; want refresh records left out", result, target)
+	}
+}
+
+func TestPrometheusKeyMetrics(t *testing.T) {
+	defer SetMetricsAPIKeyLabel("")
+	snapshot := StatisticsSnapshot{APIs: map[string]APISnapshot{
+		"sk-secret-key-1": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 3, FailureCount: 1, TotalTokens: 42}}},
+		"sk-secret-key-2": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 2, TotalTokens: 8}}},
+	}}
+
+	render := func(mode string) string {
+		SetMetricsAPIKeyLabel(mode)
+		var buf bytes.Buffer
+		if err := WritePrometheusScrape(&buf, snapshot); err != nil {
+			t.Fatalf("WritePrometheusScrape() error = %v", err)
+		}
+		return buf.String()
+	}
+
+	hashed := render("")
+	if strings.Contains(hashed, "sk-secret") {
+		t.Fatalf("hashed output leaks API keys:\n%s", hashed)
+	}
+	label := apiKeyLabelValue("sk-secret-key-1")
+	for _, want := range []string{
+		`cliproxy_requests_total{api_key="` + label + `",model="gpt-4o",status="failure"} 1`,
+		`cliproxy_requests_total{api_key="` + label + `",model="gpt-4o",status="success"} 2`,
+		`cliproxy_tokens_total{api_key="` + label + `",model="gpt-4o"} 42`,
+	} {
+		if !strings.Contains(hashed, want) {
+			t.Fatalf("hashed output missing %q:\n%s", want, hashed)
+		}
+	}
+
+	// Keys sharing a prefix collapse into one series rather than producing duplicates.
+	truncated := render(MetricsAPIKeyLabelTruncate)
+	if want := `cliproxy_requests_total{api_key="sk-secre...",model="gpt-4o",status="success"} 4`; !strings.Contains(truncated, want) {
+		t.Fatalf("truncated output missing %q:\n%s", want, truncated)
+	}
+	if want := `cliproxy_tokens_total{api_key="sk-secret-key-2",model="gpt-4o"} 8`; !strings.Contains(render(MetricsAPIKeyLabelRaw), want) {
+		t.Fatalf("raw output missing %q", want)
+	}
+}
+
+func TestMetricsPush(t *testing.T) {
+	snapshot := StatisticsSnapshot{
+		TotalRequests: 3,
+		SuccessCount:  2,
+		FailureCount:  1,
+		TotalTokens:   42,
+		APIs: map[string]APISnapshot{
+			"POST /v1/chat/completions": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 3, TotalTokens: 42}}},
+		},
+	}
+
+	var (
+		gotMethod, gotPath, gotType, gotAuth string
+		gotBody                              []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+		gotType, gotAuth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	pusher := newMetricsPusher(config.MetricsPushConfig{Mode: "pushgateway", URL: server.URL + "/", Headers: map[string]string{"Authorization": "Bearer t"}})
+	pusher.snapshot = func() StatisticsSnapshot { return snapshot }
+	if err := pusher.push(context.Background()); err != nil {
+		t.Fatalf("pushgateway push: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/cli-proxy-api" || gotAuth != "Bearer t" {
+		t.Fatalf("pushgateway request = %s %s auth=%q", gotMethod, gotPath, gotAuth)
+	}
+	if !strings.HasPrefix(gotType, "text/plain") {
+		t.Fatalf("pushgateway Content-Type = %q", gotType)
+	}
+	want := `cliproxy_tokens_total{api_key="` + apiKeyLabelValue("POST /v1/chat/completions") + `",model="gpt-4o"} 42`
+	if !strings.Contains(string(gotBody), want) || !strings.Contains(string(gotBody), "cliproxy_usage_requests_failure_total 1\n") {
+		t.Fatalf("pushgateway body missing series:\n%s", gotBody)
+	}
+
+	pusher = newMetricsPusher(config.MetricsPushConfig{Mode: "remote-write", URL: server.URL + "/api/v1/write", Job: "proxy"})
+	pusher.snapshot = func() StatisticsSnapshot { return snapshot }
+	pusher.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	if err := pusher.push(context.Background()); err != nil {
+		t.Fatalf("remote-write push: %v", err)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/api/v1/write" || gotType != "application/x-protobuf" {
+		t.Fatalf("remote-write request = %s %s type=%q", gotMethod, gotPath, gotType)
+	}
+	raw, err := snappy.Decode(nil, gotBody)
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+	series := 0
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 || num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d/%d", num, typ)
+		}
+		raw = raw[n:]
+		value, m := protowire.ConsumeBytes(raw)
+		if m < 0 {
+			t.Fatalf("truncated TimeSeries")
+		}
+		raw = raw[m:]
+		if !bytes.Contains(value, []byte("__name__")) || !bytes.Contains(value, []byte("proxy")) {
+			t.Fatalf("TimeSeries missing __name__ or job label")
+		}
+		series++
+	}
+	// five totals, success and failure requests, tokens and no tenant series
+	if series != 8 {
+		t.Fatalf("remote-write series = %d, want 8", series)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	})
+	if err := pusher.push(context.Background()); err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Fatalf("push error = %v, want upstream message", err)
+	}
+}
+
+// remoteWriteSeries decodes a remote-write payload into one text exposition line per series,
+// leaving out the job label, so it can be compared with the scrape output.
+func remoteWriteSeries(t *testing.T, payload []byte) []string {
+	t.Helper()
+	raw, err := snappy.Decode(nil, payload)
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+	consume := func(buf []byte, want protowire.Number) ([]byte, []byte) {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 || num != want || typ != protowire.BytesType {
+			t.Fatalf("unexpected field %d/%d, want %d", num, typ, want)
+		}
+		value, m := protowire.ConsumeBytes(buf[n:])
+		if m < 0 {
+			t.Fatal("truncated remote-write message")
+		}
+		return value, buf[n+m:]
+	}
+	var lines []string
+	for len(raw) > 0 {
+		var series []byte
+		series, raw = consume(raw, 1)
+		var name string
+		var labels []string
+		for len(series) > 0 {
+			num, _, _ := protowire.ConsumeTag(series)
+			if num == 2 {
+				sample, _ := consume(series, 2)
+				_, _, n := protowire.ConsumeTag(sample)
+				bits, _ := protowire.ConsumeFixed64(sample[n:])
+				line := name
+				if len(labels) > 0 {
+					line += "{" + strings.Join(labels, ",") + "}"
+				}
+				lines = append(lines, line+" "+formatSampleValue(math.Float64frombits(bits)))
+				break
+			}
+			var label []byte
+			label, series = consume(series, 1)
+			labelName, rest := consume(label, 1)
+			labelValue, _ := consume(rest, 2)
+			switch string(labelName) {
+			case "__name__":
+				name = string(labelValue)
+			case "job":
+			default:
+				labels = append(labels, string(labelName)+`="`+escapeLabelValue(string(labelValue))+`"`)
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestMetricsPushMatchesScrapeSeries(t *testing.T) {
+	defer SetMetricsAPIKeyLabel("")
+	snapshot := StatisticsSnapshot{TotalRequests: 4, SuccessCount: 3, FailureCount: 1, TotalTokens: 20, TotalCost: 0.5,
+		TenantCosts: map[string]float64{"team-a": 0.5},
+		APIs: map[string]APISnapshot{
+			"sk-secret-key-1": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 3, FailureCount: 1, TotalTokens: 12}}},
+			"sk-secret-key-2": {Models: map[string]ModelSnapshot{"claude": {TotalRequests: 1, TotalTokens: 8}}},
+		}}
+
+	for _, mode := range []string{MetricsAPIKeyLabelHash, MetricsAPIKeyLabelTruncate, MetricsAPIKeyLabelRaw} {
+		t.Run(mode, func(t *testing.T) {
+			SetMetricsAPIKeyLabel(mode)
+			var scrape, text bytes.Buffer
+			if err := WritePrometheusScrape(&scrape, snapshot); err != nil {
+				t.Fatalf("WritePrometheusScrape() error = %v", err)
+			}
+			if err := WritePrometheusText(&text, snapshot); err != nil {
+				t.Fatalf("WritePrometheusText() error = %v", err)
+			}
+			if text.String() != scrape.String() {
+				t.Fatalf("Pushgateway text differs from /metrics:\n%s\nwant:\n%s", text.String(), scrape.String())
+			}
+
+			var want []string
+			for _, line := range strings.Split(strings.TrimSpace(scrape.String()), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					want = append(want, line)
+				}
+			}
+			sort.Strings(want)
+			got := remoteWriteSeries(t, encodeRemoteWrite(snapshot, []metricLabel{{name: "job", value: "proxy"}}, 1700000000000))
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("remote-write series = %q, want the /metrics series %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMetricsPushKeepsAPIKeysOutOfLabels(t *testing.T) {
+	defer SetMetricsAPIKeyLabel("")
+	SetMetricsAPIKeyLabel("")
+	snapshot := StatisticsSnapshot{TotalRequests: 2, APIs: map[string]APISnapshot{
+		"sk-secret-key-1": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 1, TotalTokens: 5}}},
+		"sk-secret-key-2": {Models: map[string]ModelSnapshot{"gpt-4o": {TotalRequests: 1, TotalTokens: 7}}},
+	}}
+
+	var text bytes.Buffer
+	if err := WritePrometheusText(&text, snapshot); err != nil {
+		t.Fatalf("WritePrometheusText() error = %v", err)
+	}
+	if strings.Contains(text.String(), "sk-secret") {
+		t.Fatalf("Pushgateway text leaks API keys:\n%s", text.String())
+	}
+	if !strings.Contains(text.String(), `api_key="`+apiKeyLabelValue("sk-secret-key-1")+`"`) {
+		t.Fatalf("Pushgateway text missing hashed api_key label:\n%s", text.String())
+	}
+
+	raw, err := snappy.Decode(nil, encodeRemoteWrite(snapshot, []metricLabel{{name: "job", value: "proxy"}}, 1700000000000))
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+	if bytes.Contains(raw, []byte("sk-secret")) {
+		t.Fatal("remote-write payload leaks API keys")
+	}
+	if !bytes.Contains(raw, []byte(apiKeyLabelValue("sk-secret-key-2"))) {
+		t.Fatal("remote-write payload missing hashed api_key label")
+	}
+}
+
+var (
+	testRedisOnce sync.Once
+	testRedis     *miniredis.Miniredis
+	testRedisErr  error
+)
+
+// startTestRedis points the global Redis client at an in-process Redis, emptied for each test.
+func startTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	testRedisOnce.Do(func() {
+		testRedis, testRedisErr = miniredis.Run()
+		if testRedisErr == nil {
+			testRedisErr = cache.InitRedisCache(config.RedisCacheConfig{Enable: true, Addr: testRedis.Addr()})
+		}
+	})
+	if testRedisErr != nil {
+		t.Fatalf("start test redis: %v", testRedisErr)
+	}
+	testRedis.FlushAll()
+	return testRedis
+}
+
+func TestRedisStatsScripts(t *testing.T) {
+	mr := startTestRedis(t)
+	cfg := config.RedisCacheConfig{Enable: true, KeyPrefix: "test:", TTL: -1}
+	s := NewStatsStorage(cfg)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local)
+
+	s.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Provider: "codex", RequestedAt: ts,
+		Detail: coreusage.Detail{InputTokens: 7, OutputTokens: 3}, Latency: 120 * time.Millisecond})
+	s.Record(context.Background(), coreusage.Record{APIKey: "key", Model: "m", Provider: "codex", RequestedAt: ts.Add(time.Second),
+		Detail: coreusage.Detail{TotalTokens: 5}, Failed: true})
+
+	snapshot := s.Snapshot()
+	if snapshot.TotalRequests != 2 || snapshot.SuccessCount != 1 || snapshot.FailureCount != 1 || snapshot.TotalTokens != 15 {
+		t.Fatalf("totals = %+v, want 2 requests (1 failed) and 15 tokens", snapshot)
+	}
+	model := snapshot.APIs["key"].Models["m"]
+	if model.TotalRequests != 2 || model.FailureCount != 1 || model.FailureTokens != 5 || len(model.Details) != 2 {
+		t.Fatalf("key/m = %+v, want 2 requests, 1 failure of 5 tokens and 2 details", model)
+	}
+	if model.Latency == nil || model.Latency.Count != 1 {
+		t.Fatalf("latency = %+v, want one sample", model.Latency)
+	}
+	if got := snapshot.RequestsByDay["2025-01-02"]; got != 2 {
+		t.Fatalf("RequestsByDay = %+v, want 2 on 2025-01-02", snapshot.RequestsByDay)
+	}
+	if got := snapshot.Providers["codex"]; got.TotalRequests != 2 || got.FailureCount != 1 {
+		t.Fatalf("Providers = %+v, want codex with 2 requests and 1 failure", snapshot.Providers)
+	}
+	if got := s.QueryRange(ts, ts).TotalRequests; got != 2 {
+		t.Fatalf("QueryRange requests = %d, want 2", got)
+	}
+
+	exported, err := s.ExportAndReset(context.Background())
+	if err != nil {
+		t.Fatalf("ExportAndReset() error = %v", err)
+	}
+	if exported.TotalRequests != 2 || len(exported.APIs["key"].Models["m"].Details) != 2 {
+		t.Fatalf("exported = %+v, want the recorded requests", exported)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("keys left after ExportAndReset: %v", keys)
+	}
+	if got := s.Snapshot().TotalRequests; got != 0 {
+		t.Fatalf("TotalRequests after reset = %d, want 0", got)
+	}
+}
+
+func TestRedisConcurrentRecordsAreNotLost(t *testing.T) {
+	startTestRedis(t)
+	s := NewStatsStorage(config.RedisCacheConfig{Enable: true, KeyPrefix: "test:", TTL: -1})
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local)
+
+	const workers, perWorker = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				s.Record(context.Background(), coreusage.Record{APIKey: "key-" + strconv.Itoa(w%2), Model: "m",
+					RequestedAt: ts.Add(time.Duration(w*perWorker+i) * time.Millisecond), Detail: coreusage.Detail{TotalTokens: 2}})
+			}
+		}(w)
+	}
+	// Flush must return only once the records already running have been applied.
+	wg.Wait()
+	if err := s.(flusher).Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	snapshot := s.Snapshot()
+	if want := int64(workers * perWorker); snapshot.TotalRequests != want || snapshot.TotalTokens != 2*want {
+		t.Fatalf("totals = %d requests / %d tokens, want %d / %d", snapshot.TotalRequests, snapshot.TotalTokens, want, 2*want)
+	}
+	for _, key := range []string{"key-0", "key-1"} {
+		if got := len(snapshot.APIs[key].Models["m"].Details); got != workers*perWorker/2 {
+			t.Fatalf("%s details = %d, want %d", key, got, workers*perWorker/2)
+		}
+	}
+}
+
+func TestRedisStatsMigratesLegacyKeys(t *testing.T) {
+	mr := startTestRedis(t)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	legacyAPIs, _ := json.Marshal(map[string]APISnapshot{
+		"key": {TotalRequests: 2, Models: map[string]ModelSnapshot{"m": {TotalRequests: 2, Details: []RequestDetail{
+			{Timestamp: ts, Tokens: TokenStats{TotalTokens: 10}},
+			{Timestamp: ts.Add(time.Second), Tokens: TokenStats{TotalTokens: 20}, Failed: true},
+		}}}},
+	})
+	legacy := map[string]string{
+		"test:total":           `{"total_requests":2,"success_count":1,"failure_count":1,"total_tokens":30}`,
+		"test:apis":            string(legacyAPIs),
+		"test:requests_by_day": `{"2025-01-02":2}`,
+		"test:tenant_costs":    `{}`,
+	}
+	for key, value := range legacy {
+		if err := mr.Set(key, value); err != nil {
+			t.Fatalf("seed %s: %v", key, err)
+		}
+	}
+
+	cfg := config.RedisCacheConfig{Enable: true, KeyPrefix: "test:", TTL: -1}
+	snapshot := NewStatsStorage(cfg).Snapshot()
+	if snapshot.TotalRequests != 2 || snapshot.FailureCount != 1 || snapshot.TotalTokens != 30 {
+		t.Fatalf("migrated totals = %+v, want 2 requests, 1 failure and 30 tokens", snapshot)
+	}
+	if got := len(snapshot.APIs["key"].Models["m"].Details); got != 2 {
+		t.Fatalf("migrated details = %d, want 2", got)
+	}
+	for key := range legacy {
+		if mr.Exists(key) {
+			t.Fatalf("legacy key %s was not deleted", key)
+		}
+	}
+
+	// A second start finds nothing left to migrate.
+	if got := NewStatsStorage(cfg).Snapshot().TotalRequests; got != 2 {
+		t.Fatalf("TotalRequests after restart = %d, want 2", got)
+	}
+}
+
+func TestRegisteredCounterIsExported(t *testing.T) {
+	defer func() {
+		registeredCountersMu.Lock()
+		registeredCounters = nil
+		registeredCountersMu.Unlock()
+	}()
+	var count int64 = 2
+	RegisterCounter("cliproxy_test_missing_total", "Test counter.", func() float64 { return float64(count) })
+	count = 3
+
+	var scrape, text bytes.Buffer
+	if err := WritePrometheusScrape(&scrape, StatisticsSnapshot{}); err != nil {
+		t.Fatalf("WritePrometheusScrape() error = %v", err)
+	}
+	if err := WritePrometheusText(&text, StatisticsSnapshot{}); err != nil {
+		t.Fatalf("WritePrometheusText() error = %v", err)
+	}
+	for name, out := range map[string]string{"scrape": scrape.String(), "push": text.String()} {
+		if !strings.Contains(out, "# TYPE cliproxy_test_missing_total counter\ncliproxy_test_missing_total 3\n") {
+			t.Fatalf("%s output lacks the registered counter:\n%s", name, out)
+		}
+	}
+}
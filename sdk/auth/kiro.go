@@ -62,7 +62,7 @@ func (a *KiroAuthenticator) RefreshLead() *time.Duration {
 }
 
 // createAuthRecord creates an auth record from token data.
-func (a *KiroAuthenticator) createAuthRecord(tokenData *kiroauth.KiroTokenData, source string) (*coreauth.Auth, error) {
+func (a *KiroAuthenticator) createAuthRecord(cfg *config.Config, tokenData *kiroauth.KiroTokenData, source string) (*coreauth.Auth, error) {
 	// Parse expires_at
 	expiresAt, err := time.Parse(time.RFC3339, tokenData.ExpiresAt)
 	if err != nil {
@@ -115,6 +115,12 @@ func (a *KiroAuthenticator) createAuthRecord(tokenData *kiroauth.KiroTokenData,
 	if tokenData.StartURL != "" {
 		metadata["start_url"] = tokenData.StartURL
 	}
+	for key, value := range tokenData.ProvenanceMetadata() {
+		metadata[key] = value
+	}
+	if tokenData.NonRefreshable {
+		metadata["non_refreshable"] = true
+	}
 	if tokenData.Region != "" {
 		metadata["region"] = tokenData.Region
 	}
@@ -150,10 +156,11 @@ func (a *KiroAuthenticator) createAuthRecord(tokenData *kiroauth.KiroTokenData,
 		NextRefreshAfter: expiresAt.Add(-20 * time.Minute),
 	}
 
+	out := kiroauth.LoginOutput(cfg)
 	if tokenData.Email != "" {
-		fmt.Printf("\n✓ Kiro authentication completed successfully! (Account: %s)\n", tokenData.Email)
+		fmt.Fprintf(out, "\n✓ Kiro authentication completed successfully! (Account: %s)\n", tokenData.Email)
 	} else {
-		fmt.Println("\n✓ Kiro authentication completed successfully!")
+		fmt.Fprintln(out, "\n✓ Kiro authentication completed successfully!")
 	}
 
 	return record, nil
@@ -173,7 +180,7 @@ func (a *KiroAuthenticator) Login(ctx context.Context, cfg *config.Config, opts
 		return nil, fmt.Errorf("login failed: %w", err)
 	}
 
-	return a.createAuthRecord(tokenData, "aws")
+	return a.createAuthRecord(cfg, tokenData, "aws")
 }
 
 // LoginWithAuthCode performs OAuth login for Kiro with AWS Builder ID using authorization code flow.
@@ -231,11 +238,18 @@ func (a *KiroAuthenticator) LoginWithAuthCode(ctx context.Context, cfg *config.C
 		// NextRefreshAfter: 20 minutes before expiry
 		NextRefreshAfter: expiresAt.Add(-20 * time.Minute),
 	}
+	for key, value := range tokenData.ProvenanceMetadata() {
+		record.Metadata[key] = value
+	}
+	if tokenData.NonRefreshable {
+		record.Metadata["non_refreshable"] = true
+	}
 
+	out := kiroauth.LoginOutput(cfg)
 	if tokenData.Email != "" {
-		fmt.Printf("\n✓ Kiro authentication completed successfully! (Account: %s)\n", tokenData.Email)
+		fmt.Fprintf(out, "\n✓ Kiro authentication completed successfully! (Account: %s)\n", tokenData.Email)
 	} else {
-		fmt.Println("\n✓ Kiro authentication completed successfully!")
+		fmt.Fprintln(out, "\n✓ Kiro authentication completed successfully!")
 	}
 
 	return record, nil
@@ -255,6 +269,22 @@ func (a *KiroAuthenticator) LoginWithGitHub(ctx context.Context, cfg *config.Con
 	return nil, fmt.Errorf("GitHub login is not available for third-party applications due to AWS Cognito restrictions.\n\nAlternatives:\n  1. Use AWS Builder ID: cliproxy kiro --builder-id\n  2. Import token from Kiro IDE: cliproxy kiro --import\n\nTo get a token from Kiro IDE:\n  1. Open Kiro IDE and login with GitHub\n  2. Find: ~/.kiro/kiro-auth-token.json\n  3. Run: cliproxy kiro --import")
 }
 
+// LoginWithCognito performs OAuth login for a Kiro account created with email and password.
+// Unlike Google and GitHub, Kiro's auth service accepts these logins through its Cognito
+// hosted UI, with the callback delivered to a local HTTP server.
+func (a *KiroAuthenticator) LoginWithCognito(ctx context.Context, cfg *config.Config, opts *LoginOptions) (*coreauth.Auth, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("kiro auth: configuration is required")
+	}
+
+	tokenData, err := kiroauth.NewKiroOAuth(cfg).LoginWithCognito(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+
+	return a.createAuthRecord(cfg, tokenData, "cognito")
+}
+
 // ImportFromKiroIDE imports token from Kiro IDE's token file.
 func (a *KiroAuthenticator) ImportFromKiroIDE(ctx context.Context, cfg *config.Config) (*coreauth.Auth, error) {
 	tokenData, err := kiroauth.LoadKiroIDEToken()
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AvailabilityChecker is implemented by executors that track credential availability outside
+// the manager, such as provider-side rate limiting, cooldowns or suspensions. The manager
+// skips credentials reported unavailable when routing.
+type AvailabilityChecker interface {
+	// CredentialAvailability reports whether auth can serve a request now. When it cannot,
+	// retryAt is the time it is expected to recover, or zero if unknown.
+	CredentialAvailability(auth *Auth) (available bool, retryAt time.Time)
+}
+
+// providerUnavailableError is returned when every credential able to serve a request is
+// temporarily unavailable. It maps to a 503 with Retry-After set from the earliest recovery.
+type providerUnavailableError struct {
+	model    string
+	provider string
+	retryIn  time.Duration
+	// retryKnown reports whether any credential announced a recovery time.
+	retryKnown bool
+}
+
+func newProviderUnavailableError(model, provider string, earliest, now time.Time) *providerUnavailableError {
+	err := &providerUnavailableError{model: model, provider: provider}
+	if !earliest.IsZero() {
+		err.retryKnown = true
+		err.retryIn = earliest.Sub(now)
+		if err.retryIn < 0 {
+			err.retryIn = 0
+		}
+	}
+	return err
+}
+
+func (e *providerUnavailableError) retrySeconds() int {
+	seconds := int(math.Ceil(e.retryIn.Seconds()))
+	if seconds < 0 {
+		return 0
+	}
+	return seconds
+}
+
+func (e *providerUnavailableError) Error() string {
+	target := "provider " + e.provider
+	if e.provider == "" {
+		target = "the requested providers"
+	}
+	message := fmt.Sprintf("No available credential for %s", target)
+	if e.model != "" {
+		message = fmt.Sprintf("%s and model %s", message, e.model)
+	}
+	errorBody := map[string]any{
+		"code":    "provider_unavailable",
+		"message": message,
+		"model":   e.model,
+	}
+	if e.provider != "" {
+		errorBody["provider"] = e.provider
+	}
+	if e.retryKnown {
+		errorBody["retry_after_seconds"] = e.retrySeconds()
+	}
+	data, err := json.Marshal(map[string]any{"error": errorBody})
+	if err != nil {
+		return fmt.Sprintf(`{"error":{"code":"provider_unavailable","message":"%s"}}`, message)
+	}
+	return string(data)
+}
+
+func (e *providerUnavailableError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *providerUnavailableError) Headers() http.Header {
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	if e.retryKnown {
+		headers.Set("Retry-After", strconv.Itoa(e.retrySeconds()))
+	}
+	return headers
+}
+
+// checkCredentialAvailability consults the executor's AvailabilityChecker, if any.
+func checkCredentialAvailability(executor ProviderExecutor, auth *Auth) (bool, time.Time) {
+	checker, ok := executor.(AvailabilityChecker)
+	if !ok {
+		return true, time.Time{}
+	}
+	return checker.CredentialAvailability(auth)
+}
+
+// earlierTime returns the earlier of two times, ignoring zero values.
+func earlierTime(current, candidate time.Time) time.Time {
+	if candidate.IsZero() {
+		return current
+	}
+	if current.IsZero() || candidate.Before(current) {
+		return candidate
+	}
+	return current
+}
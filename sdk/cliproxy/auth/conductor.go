@@ -23,6 +23,7 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/thinking"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -613,7 +614,17 @@ func (m *Manager) executeMixedOnce(ctx context.Context, providers []string, req
 		execReq.Model = rewriteModelForAuth(routeModel, auth)
 		execReq.Model = m.applyOAuthModelAlias(auth, execReq.Model)
 		execReq.Model = m.applyAPIKeyModelAlias(auth, execReq.Model)
-		resp, errExec := executor.Execute(execCtx, auth, execReq, opts)
+		// Limits apply to the upstream model, so aliases of one model share its slots.
+		releaseSlot, errSlot := registry.GetGlobalRegistry().AcquireModelSlot(execCtx, canonicalModelKey(execReq.Model))
+		if errSlot != nil {
+			return cliproxyexecutor.Response{}, errSlot
+		}
+		upstreamStart := time.Now()
+		resp, errExec := func() (cliproxyexecutor.Response, error) {
+			defer releaseSlot()
+			return executor.Execute(execCtx, auth, execReq, opts)
+		}()
+		addUpstreamDuration(ctx, time.Since(upstreamStart))
 		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil}
 		if errExec != nil {
 			if errCtx := execCtx.Err(); errCtx != nil {
@@ -688,7 +699,17 @@ func (m *Manager) executeCountMixedOnce(ctx context.Context, providers []string,
 		execReq.Model = rewriteModelForAuth(routeModel, auth)
 		execReq.Model = m.applyOAuthModelAlias(auth, execReq.Model)
 		execReq.Model = m.applyAPIKeyModelAlias(auth, execReq.Model)
-		resp, errExec := executor.CountTokens(execCtx, auth, execReq, opts)
+		// Limits apply to the upstream model, so aliases of one model share its slots.
+		releaseSlot, errSlot := registry.GetGlobalRegistry().AcquireModelSlot(execCtx, canonicalModelKey(execReq.Model))
+		if errSlot != nil {
+			return cliproxyexecutor.Response{}, errSlot
+		}
+		upstreamStart := time.Now()
+		resp, errExec := func() (cliproxyexecutor.Response, error) {
+			defer releaseSlot()
+			return executor.CountTokens(execCtx, auth, execReq, opts)
+		}()
+		addUpstreamDuration(ctx, time.Since(upstreamStart))
 		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil}
 		if errExec != nil {
 			if errCtx := execCtx.Err(); errCtx != nil {
@@ -763,8 +784,15 @@ func (m *Manager) executeStreamMixedOnce(ctx context.Context, providers []string
 		execReq.Model = rewriteModelForAuth(routeModel, auth)
 		execReq.Model = m.applyOAuthModelAlias(auth, execReq.Model)
 		execReq.Model = m.applyAPIKeyModelAlias(auth, execReq.Model)
+		releaseSlot, errSlot := registry.GetGlobalRegistry().AcquireModelSlot(execCtx, canonicalModelKey(execReq.Model))
+		if errSlot != nil {
+			return nil, errSlot
+		}
+		upstreamStart := time.Now()
 		chunks, errStream := executor.ExecuteStream(execCtx, auth, execReq, opts)
+		addUpstreamDuration(ctx, time.Since(upstreamStart))
 		if errStream != nil {
+			releaseSlot()
 			if errCtx := execCtx.Err(); errCtx != nil {
 				return nil, errCtx
 			}
@@ -785,6 +813,8 @@ func (m *Manager) executeStreamMixedOnce(ctx context.Context, providers []string
 		out := make(chan cliproxyexecutor.StreamChunk)
 		go func(streamCtx context.Context, streamAuth *Auth, streamProvider string, streamChunks <-chan cliproxyexecutor.StreamChunk) {
 			defer close(out)
+			// The model slot is held until the upstream stream is drained.
+			defer releaseSlot()
 			var failed bool
 			forward := true
 			for chunk := range streamChunks {
@@ -1631,6 +1661,8 @@ func (m *Manager) pickNext(ctx context.Context, provider, model string, opts cli
 		}
 	}
 	registryRef := registry.GetGlobalRegistry()
+	unavailable := 0
+	var earliestRetry time.Time
 	for _, candidate := range m.auths {
 		if candidate.Provider != provider || candidate.Disabled {
 			continue
@@ -1641,10 +1673,18 @@ func (m *Manager) pickNext(ctx context.Context, provider, model string, opts cli
 		if modelKey != "" && registryRef != nil && !registryRef.ClientSupportsModel(candidate.ID, modelKey) {
 			continue
 		}
+		if available, retryAt := checkCredentialAvailability(executor, candidate); !available {
+			unavailable++
+			earliestRetry = earlierTime(earliestRetry, retryAt)
+			continue
+		}
 		candidates = append(candidates, candidate)
 	}
 	if len(candidates) == 0 {
 		m.mu.RUnlock()
+		if unavailable > 0 {
+			return nil, nil, newProviderUnavailableError(model, provider, earliestRetry, time.Now())
+		}
 		return nil, nil, &Error{Code: "auth_not_found", Message: "no auth available"}
 	}
 	selected, errPick := m.selector.Pick(ctx, provider, model, opts, candidates)
@@ -1693,6 +1733,8 @@ func (m *Manager) pickNextMixed(ctx context.Context, providers []string, model s
 		}
 	}
 	registryRef := registry.GetGlobalRegistry()
+	unavailable := 0
+	var earliestRetry time.Time
 	for _, candidate := range m.auths {
 		if candidate == nil || candidate.Disabled {
 			continue
@@ -1707,16 +1749,31 @@ func (m *Manager) pickNextMixed(ctx context.Context, providers []string, model s
 		if _, used := tried[candidate.ID]; used {
 			continue
 		}
-		if _, ok := m.executors[providerKey]; !ok {
+		executor, ok := m.executors[providerKey]
+		if !ok {
 			continue
 		}
 		if modelKey != "" && registryRef != nil && !registryRef.ClientSupportsModel(candidate.ID, modelKey) {
 			continue
 		}
+		if available, retryAt := checkCredentialAvailability(executor, candidate); !available {
+			unavailable++
+			earliestRetry = earlierTime(earliestRetry, retryAt)
+			continue
+		}
 		candidates = append(candidates, candidate)
 	}
 	if len(candidates) == 0 {
 		m.mu.RUnlock()
+		if unavailable > 0 {
+			providerLabel := ""
+			if len(providerSet) == 1 {
+				for p := range providerSet {
+					providerLabel = p
+				}
+			}
+			return nil, nil, "", newProviderUnavailableError(model, providerLabel, earliestRetry, time.Now())
+		}
 		return nil, nil, "", &Error{Code: "auth_not_found", Message: "no auth available"}
 	}
 	selected, errPick := m.selector.Pick(ctx, "mixed", model, opts, candidates)
@@ -2072,12 +2129,14 @@ func (m *Manager) refreshAuth(ctx context.Context, id string) {
 		return
 	}
 	cloned := auth.Clone()
+	startedAt := time.Now()
 	updated, err := exec.Refresh(ctx, cloned)
 	if err != nil && errors.Is(err, context.Canceled) {
 		log.Debugf("refresh canceled for %s, %s", auth.Provider, auth.ID)
 		return
 	}
 	log.Debugf("refreshed %s, %s, %v", auth.Provider, auth.ID, err)
+	coreusage.PublishRefresh(context.WithoutCancel(ctx), auth.Provider, auth.ID, auth.Index, startedAt, err != nil)
 	now := time.Now()
 	if err != nil {
 		m.mu.Lock()
@@ -2121,6 +2180,15 @@ const AccountInfoContextKey = "cliproxy.account_info"
 // ProviderContextKey is exported for use by logging middleware
 const ProviderContextKey = "cliproxy.provider"
 
+// addUpstreamDuration reports time spent waiting on an upstream call to the request logger of
+// the Gin request in ctx, if any. Streams report the time until the upstream stream opened:
+// the Gin context may be recycled before a stream is drained, so it is not touched later.
+func addUpstreamDuration(ctx context.Context, d time.Duration) {
+	if c, ok := ctx.Value("gin").(*gin.Context); ok {
+		logging.AddUpstreamDuration(c, d)
+	}
+}
+
 // roundTripperFor retrieves an HTTP RoundTripper for the given auth if a provider is registered.
 func (m *Manager) roundTripperFor(auth *Auth) http.RoundTripper {
 	m.mu.RLock()
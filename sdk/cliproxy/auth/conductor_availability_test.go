@@ -1,61 +1,107 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
 	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
 )
 
-func TestUpdateAggregatedAvailability_UnavailableWithoutNextRetryDoesNotBlockAuth(t *testing.T) {
-	t.Parallel()
+// availabilityExecutor reports the availability of each credential from a fixed table, the way
+// the Kiro executor reports its rate limiter state.
+type availabilityExecutor struct {
+	retryAt map[string]time.Time
+}
 
-	now := time.Now()
-	model := "test-model"
-	auth := &Auth{
-		ID: "a",
-		ModelStates: map[string]*ModelState{
-			model: {
-				Status:      StatusError,
-				Unavailable: true,
-			},
-		},
-	}
+func (e *availabilityExecutor) Identifier() string { return "availability-test" }
 
-	updateAggregatedAvailability(auth, now)
+func (e *availabilityExecutor) CredentialAvailability(auth *Auth) (bool, time.Time) {
+	retryAt, blocked := e.retryAt[auth.ID]
+	return !blocked, retryAt
+}
 
-	if auth.Unavailable {
-		t.Fatalf("auth.Unavailable = true, want false")
-	}
-	if !auth.NextRetryAfter.IsZero() {
-		t.Fatalf("auth.NextRetryAfter = %v, want zero", auth.NextRetryAfter)
-	}
+func (e *availabilityExecutor) Execute(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	return cliproxyexecutor.Response{}, nil
 }
 
-func TestUpdateAggregatedAvailability_FutureNextRetryBlocksAuth(t *testing.T) {
-	t.Parallel()
+func (e *availabilityExecutor) ExecuteStream(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (<-chan cliproxyexecutor.StreamChunk, error) {
+	return nil, nil
+}
 
-	now := time.Now()
-	model := "test-model"
-	next := now.Add(5 * time.Minute)
-	auth := &Auth{
-		ID: "a",
-		ModelStates: map[string]*ModelState{
-			model: {
-				Status:         StatusError,
-				Unavailable:    true,
-				NextRetryAfter: next,
-			},
-		},
-	}
+func (e *availabilityExecutor) Refresh(_ context.Context, auth *Auth) (*Auth, error) {
+	return auth, nil
+}
 
-	updateAggregatedAvailability(auth, now)
+func (e *availabilityExecutor) CountTokens(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	return cliproxyexecutor.Response{}, nil
+}
+
+func (e *availabilityExecutor) HttpRequest(context.Context, *Auth, *http.Request) (*http.Response, error) {
+	return nil, nil
+}
 
-	if !auth.Unavailable {
-		t.Fatalf("auth.Unavailable = false, want true")
+// newAvailabilityManager registers executor and one credential per ID serving model.
+func newAvailabilityManager(t *testing.T, executor *availabilityExecutor, model string, ids ...string) *Manager {
+	t.Helper()
+	mgr := NewManager(nil, nil, nil)
+	mgr.RegisterExecutor(executor)
+	reg := registry.GetGlobalRegistry()
+	for _, id := range ids {
+		reg.RegisterClient(id, executor.Identifier(), []*registry.ModelInfo{{ID: model}})
+		t.Cleanup(func() { reg.UnregisterClient(id) })
+		if _, err := mgr.Register(context.Background(), &Auth{ID: id, Provider: executor.Identifier(), Attributes: map[string]string{"runtime_only": "true"}}); err != nil {
+			t.Fatalf("Register(%s) error = %v", id, err)
+		}
 	}
-	if auth.NextRetryAfter.IsZero() {
-		t.Fatalf("auth.NextRetryAfter = zero, want %v", next)
+	return mgr
+}
+
+func TestPickNextAllCredentialsUnavailableReturnsRetryAfter(t *testing.T) {
+	const model = "availability-model"
+	now := time.Now()
+	executor := &availabilityExecutor{retryAt: map[string]time.Time{
+		"avail-a": now.Add(90 * time.Second),
+		"avail-b": now.Add(30 * time.Second),
+		"avail-c": {}, // unavailable without a known recovery time
+	}}
+	mgr := newAvailabilityManager(t, executor, model, "avail-a", "avail-b", "avail-c")
+
+	_, _, errPick := mgr.pickNext(context.Background(), executor.Identifier(), model, cliproxyexecutor.Options{}, map[string]struct{}{})
+	_, _, _, errMixed := mgr.pickNextMixed(context.Background(), []string{executor.Identifier()}, model, cliproxyexecutor.Options{}, map[string]struct{}{})
+	for name, err := range map[string]error{"pickNext": errPick, "pickNextMixed": errMixed} {
+		var unavailable *providerUnavailableError
+		if !errors.As(err, &unavailable) {
+			t.Fatalf("%s error = %v, want providerUnavailableError", name, err)
+		}
+		if unavailable.StatusCode() != http.StatusServiceUnavailable {
+			t.Fatalf("%s status = %d, want 503", name, unavailable.StatusCode())
+		}
+		if got := unavailable.Headers().Get("Retry-After"); got != "30" {
+			t.Fatalf("%s Retry-After = %q, want the earliest recovery of 30 seconds", name, got)
+		}
 	}
-	if auth.NextRetryAfter.Sub(next) > time.Second || next.Sub(auth.NextRetryAfter) > time.Second {
-		t.Fatalf("auth.NextRetryAfter = %v, want %v", auth.NextRetryAfter, next)
+}
+
+func TestPickNextSkipsUnavailableCredentials(t *testing.T) {
+	const model = "availability-model"
+	executor := &availabilityExecutor{retryAt: map[string]time.Time{
+		"mixed-a": time.Now().Add(time.Minute),
+		"mixed-c": time.Now().Add(time.Minute),
+	}}
+	mgr := newAvailabilityManager(t, executor, model, "mixed-a", "mixed-b", "mixed-c")
+
+	for i := 0; i < 3; i++ {
+		auth, _, err := mgr.pickNext(context.Background(), executor.Identifier(), model, cliproxyexecutor.Options{}, map[string]struct{}{})
+		if err != nil || auth.ID != "mixed-b" {
+			t.Fatalf("pickNext() = %v, %v; want the available mixed-b", auth, err)
+		}
+		auth, _, _, err = mgr.pickNextMixed(context.Background(), []string{executor.Identifier()}, model, cliproxyexecutor.Options{}, map[string]struct{}{})
+		if err != nil || auth.ID != "mixed-b" {
+			t.Fatalf("pickNextMixed() = %v, %v; want the available mixed-b", auth, err)
+		}
 	}
 }
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+// slotProbeExecutor reports the in-flight count of the upstream model while it runs.
+type slotProbeExecutor struct {
+	upstream string
+	models   []string
+	inFlight []int
+	panics   bool
+}
+
+func (e *slotProbeExecutor) Identifier() string { return "gemini" }
+
+func (e *slotProbeExecutor) observe(req cliproxyexecutor.Request) {
+	e.models = append(e.models, req.Model)
+	inFlight, _ := registry.GetGlobalRegistry().ModelConcurrency(e.upstream)
+	e.inFlight = append(e.inFlight, inFlight)
+	if e.panics {
+		panic("executor failed")
+	}
+}
+
+func (e *slotProbeExecutor) Execute(_ context.Context, _ *Auth, req cliproxyexecutor.Request, _ cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	e.observe(req)
+	return cliproxyexecutor.Response{}, nil
+}
+
+func (e *slotProbeExecutor) ExecuteStream(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (<-chan cliproxyexecutor.StreamChunk, error) {
+	return nil, nil
+}
+
+func (e *slotProbeExecutor) Refresh(_ context.Context, auth *Auth) (*Auth, error) { return auth, nil }
+
+func (e *slotProbeExecutor) CountTokens(_ context.Context, _ *Auth, req cliproxyexecutor.Request, _ cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	e.observe(req)
+	return cliproxyexecutor.Response{}, nil
+}
+
+func (e *slotProbeExecutor) HttpRequest(context.Context, *Auth, *http.Request) (*http.Response, error) {
+	return nil, nil
+}
+
+func TestExecuteHoldsModelSlotOfUpstreamModel(t *testing.T) {
+	const upstream = "gemini-2.5-pro"
+	reg := registry.GetGlobalRegistry()
+	reg.SetModelConcurrencyLimits(map[string]int{upstream: 1})
+	t.Cleanup(func() { reg.SetModelConcurrencyLimits(nil) })
+	reg.RegisterClient("slot-auth", "gemini", []*registry.ModelInfo{{ID: "g25p"}})
+	t.Cleanup(func() { reg.UnregisterClient("slot-auth") })
+
+	mgr := NewManager(nil, nil, nil)
+	mgr.SetConfig(&internalconfig.Config{GeminiKey: []internalconfig.GeminiKey{{
+		APIKey: "k",
+		Models: []internalconfig.GeminiModel{{Name: upstream, Alias: "g25p"}},
+	}}})
+	executor := &slotProbeExecutor{upstream: upstream}
+	mgr.RegisterExecutor(executor)
+	if _, err := mgr.Register(context.Background(), &Auth{ID: "slot-auth", Provider: "gemini", Attributes: map[string]string{"api_key": "k", "runtime_only": "true"}}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	req := cliproxyexecutor.Request{Model: "g25p"}
+	if _, err := mgr.Execute(context.Background(), []string{"gemini"}, req, cliproxyexecutor.Options{}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if _, err := mgr.ExecuteCount(context.Background(), []string{"gemini"}, req, cliproxyexecutor.Options{}); err != nil {
+		t.Fatalf("ExecuteCount() error = %v", err)
+	}
+	for i, model := range executor.models {
+		if model != upstream || executor.inFlight[i] != 1 {
+			t.Fatalf("call %d ran %q with %d in flight, want %q holding its slot", i, model, executor.inFlight[i], upstream)
+		}
+	}
+	if inFlight, _ := reg.ModelConcurrency(upstream); inFlight != 0 {
+		t.Fatalf("in flight after requests = %d, want 0", inFlight)
+	}
+
+	// A panicking executor still gives its slot back.
+	executor.panics = true
+	func() {
+		defer func() { _ = recover() }()
+		_, _ = mgr.Execute(context.Background(), []string{"gemini"}, req, cliproxyexecutor.Options{})
+	}()
+	if inFlight, _ := reg.ModelConcurrency(upstream); inFlight != 0 {
+		t.Fatalf("in flight after executor panic = %d, want 0", inFlight)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/thinking"
 	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
 )
@@ -134,7 +135,7 @@ func canonicalModelKey(model string) string {
 	return modelName
 }
 
-func collectAvailableByPriority(auths []*Auth, model string, now time.Time) (available map[int][]*Auth, cooldownCount int, earliest time.Time) {
+func collectAvailableByPriority(auths []*Auth, model string, now time.Time) (available map[int][]*Auth, cooldownCount int, earliest, earliestAny time.Time) {
 	available = make(map[int][]*Auth)
 	for i := 0; i < len(auths); i++ {
 		candidate := auths[i]
@@ -144,6 +145,7 @@ func collectAvailableByPriority(auths []*Auth, model string, now time.Time) (ava
 			available[priority] = append(available[priority], candidate)
 			continue
 		}
+		earliestAny = earlierTime(earliestAny, next)
 		if reason == blockReasonCooldown {
 			cooldownCount++
 			if !next.IsZero() && (earliest.IsZero() || next.Before(earliest)) {
@@ -151,28 +153,32 @@ func collectAvailableByPriority(auths []*Auth, model string, now time.Time) (ava
 			}
 		}
 	}
-	return available, cooldownCount, earliest
+	return available, cooldownCount, earliest, earliestAny
 }
 
+// getAvailableAuths returns the candidates of the best priority that can serve model now. When
+// none can, it returns a model cooldown error if every candidate is cooling down, and otherwise
+// a provider_unavailable 503 for any provider, not only Kiro.
 func getAvailableAuths(auths []*Auth, provider, model string, now time.Time) ([]*Auth, error) {
 	if len(auths) == 0 {
 		return nil, &Error{Code: "auth_not_found", Message: "no auth candidates"}
 	}
 
-	availableByPriority, cooldownCount, earliest := collectAvailableByPriority(auths, model, now)
+	availableByPriority, cooldownCount, earliest, earliestAny := collectAvailableByPriority(auths, model, now)
 	if len(availableByPriority) == 0 {
+		providerForError := provider
+		if providerForError == "mixed" {
+			providerForError = ""
+		}
 		if cooldownCount == len(auths) && !earliest.IsZero() {
-			providerForError := provider
-			if providerForError == "mixed" {
-				providerForError = ""
-			}
 			resetIn := earliest.Sub(now)
 			if resetIn < 0 {
 				resetIn = 0
 			}
 			return nil, newModelCooldownError(model, providerForError, resetIn)
 		}
-		return nil, &Error{Code: "auth_unavailable", Message: "no auth available"}
+		// Credentials are suspended or backing off for mixed reasons.
+		return nil, newProviderUnavailableError(model, providerForError, earliestAny, now)
 	}
 
 	bestPriority := 0
@@ -256,7 +262,8 @@ func isAuthBlockedForModel(auth *Auth, model string, now time.Time) (bool, block
 				if state.Status == StatusDisabled {
 					return true, blockReasonDisabled, time.Time{}
 				}
-				if state.Unavailable {
+				// Operator allow overrides ignore quota and suspension signals.
+				if state.Unavailable && !registry.GetGlobalRegistry().IsModelAllowed(canonicalModelKey(model)) {
 					if state.NextRetryAfter.IsZero() {
 						return false, blockReasonNone, time.Time{}
 					}
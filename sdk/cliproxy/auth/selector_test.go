@@ -281,6 +281,68 @@ func TestSelectorPick_AllCooldownReturnsModelCooldownError(t *testing.T) {
 	})
 }
 
+func TestSelectorPick_AllBlockedReturnsProviderUnavailableError(t *testing.T) {
+	t.Parallel()
+
+	model := "test-model"
+	now := time.Now()
+	cooldownEnd := now.Add(90 * time.Second)
+	auths := []*Auth{
+		{
+			ID: "a",
+			ModelStates: map[string]*ModelState{
+				model: {
+					Status:         StatusActive,
+					Unavailable:    true,
+					NextRetryAfter: cooldownEnd,
+					Quota: QuotaState{
+						Exceeded:      true,
+						NextRecoverAt: cooldownEnd,
+					},
+				},
+			},
+		},
+		{
+			ID: "b",
+			ModelStates: map[string]*ModelState{
+				model: {
+					Status:         StatusError,
+					Unavailable:    true,
+					NextRetryAfter: now.Add(30 * time.Minute),
+				},
+			},
+		},
+	}
+
+	selector := &RoundRobinSelector{}
+	_, err := selector.Pick(context.Background(), "kiro", model, cliproxyexecutor.Options{}, auths)
+	var pue *providerUnavailableError
+	if !errors.As(err, &pue) {
+		t.Fatalf("Pick() error = %T (%v), want *providerUnavailableError", err, err)
+	}
+	if pue.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("StatusCode() = %d, want %d", pue.StatusCode(), http.StatusServiceUnavailable)
+	}
+	if got := pue.Headers().Get("Retry-After"); got != "90" && got != "89" {
+		t.Fatalf("Headers().Get(Retry-After) = %q, want earliest cooldown end", got)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(pue.Error()), &payload); err != nil {
+		t.Fatalf("json.Unmarshal(Error()) error = %v", err)
+	}
+	rawErr, ok := payload["error"].(map[string]any)
+	if !ok {
+		t.Fatalf("Error() payload missing error object: %v", payload)
+	}
+	if got, _ := rawErr["code"].(string); got != "provider_unavailable" {
+		t.Fatalf("Error().error.code = %q, want %q", got, "provider_unavailable")
+	}
+	if got, _ := rawErr["provider"].(string); got != "kiro" {
+		t.Fatalf("Error().error.provider = %q, want %q", got, "kiro")
+	}
+}
+
 func TestIsAuthBlockedForModel_UnavailableWithoutNextRetryIsNotBlocked(t *testing.T) {
 	t.Parallel()
 
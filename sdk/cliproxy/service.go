@@ -16,7 +16,7 @@ import (
 	kiroauth "github.com/router-for-me/CLIProxyAPI/v6/internal/auth/kiro"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/runtime/executor"
-	_ "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	internalusage "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/watcher"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/wsrelay"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
@@ -709,7 +709,15 @@ func (s *Service) Shutdown(ctx context.Context) error {
 			}
 		}
 
-		usage.StopDefault()
+		// Drain queued usage records into the stats storage, then flush it and close Redis.
+		flushCtx, cancelFlush := context.WithTimeout(ctx, 10*time.Second)
+		defer cancelFlush()
+		if err := usage.ShutdownDefault(flushCtx); err != nil {
+			log.Errorf("failed to drain usage records: %v", err)
+		}
+		if err := internalusage.Shutdown(flushCtx); err != nil {
+			log.Errorf("failed to flush usage statistics: %v", err)
+		}
 	})
 	return shutdownErr
 }
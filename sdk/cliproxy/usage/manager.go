@@ -19,14 +19,45 @@ type Record struct {
 	RequestedAt time.Time
 	Failed      bool
 	Detail      Detail
+	// Tenant labels the customer or plan the request is billed to; empty falls back to APIKey.
+	Tenant string
+	// Pricing overrides the configured price table for this request when non-nil.
+	Pricing *Pricing
+	// Latency is the time from sending the request upstream to the end of its response.
+	Latency time.Duration
+	// RequestID links the record to the partial usage published for the request while it was
+	// streaming, which the record replaces; empty when no partial usage was published.
+	RequestID string
 }
 
+// Pricing holds per-1K-token prices used to estimate the cost of a request.
+type Pricing struct {
+	InputPer1K  float64
+	OutputPer1K float64
+	CachedPer1K float64
+}
+
+// Gin context keys a router can set to attribute a request to a tenant and override its pricing.
+// The built-in auth middleware sets them from the usage-api-keys configuration.
+const (
+	GinTenantKey  = "usageTenant"
+	GinPricingKey = "usagePricing"
+)
+
+// RefreshModel is the pseudo-model under which token refresh attempts are recorded,
+// so refresh volume and failure rate show up next to request traffic.
+const RefreshModel = "__refresh__"
+
+// RefreshSource is the Source of records published by PublishRefresh.
+const RefreshSource = "refresh"
+
 // Detail holds the token usage breakdown.
 type Detail struct {
 	InputTokens     int64
 	OutputTokens    int64
 	ReasoningTokens int64
 	CachedTokens    int64
+	ToolTokens      int64 // tool/function-call tokens, when reported by the upstream
 	TotalTokens     int64
 }
 
@@ -35,9 +66,23 @@ type Plugin interface {
 	HandleUsage(ctx context.Context, record Record)
 }
 
+// PartialPlugin is implemented by plugins that also consume the partial usage of responses
+// still streaming. The final Record of the request carries the same RequestID.
+type PartialPlugin interface {
+	HandlePartialUsage(ctx context.Context, requestID string, deltaTokens int64)
+}
+
+// partialUsage is a token increment published for a request before its final record.
+type partialUsage struct {
+	requestID   string
+	deltaTokens int64
+}
+
 type queueItem struct {
 	ctx    context.Context
 	record Record
+	// partial is set instead of record for partial usage updates.
+	partial *partialUsage
 }
 
 // Manager maintains a queue of usage records and delivers them to registered plugins.
@@ -45,11 +90,14 @@ type Manager struct {
 	once     sync.Once
 	stopOnce sync.Once
 	cancel   context.CancelFunc
+	// done is closed once the dispatcher has delivered every queued record and exited.
+	done chan struct{}
 
-	mu     sync.Mutex
-	cond   *sync.Cond
-	queue  []queueItem
-	closed bool
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []queueItem
+	closed  bool
+	started bool
 
 	pluginsMu sync.RWMutex
 	plugins   []Plugin
@@ -57,7 +105,7 @@ type Manager struct {
 
 // NewManager constructs a manager with a buffered queue.
 func NewManager(buffer int) *Manager {
-	m := &Manager{}
+	m := &Manager{done: make(chan struct{})}
 	m.cond = sync.NewCond(&m.mu)
 	return m
 }
@@ -73,6 +121,9 @@ func (m *Manager) Start(ctx context.Context) {
 		}
 		var workerCtx context.Context
 		workerCtx, m.cancel = context.WithCancel(ctx)
+		m.mu.Lock()
+		m.started = true
+		m.mu.Unlock()
 		go m.run(workerCtx)
 	})
 }
@@ -93,6 +144,27 @@ func (m *Manager) Stop() {
 	})
 }
 
+// Shutdown stops the dispatcher and waits until every queued record has been delivered,
+// or ctx is done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.Stop()
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+	if !started {
+		return nil
+	}
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Register appends a plugin to the delivery list.
 func (m *Manager) Register(plugin Plugin) {
 	if m == nil || plugin == nil {
@@ -121,7 +193,25 @@ func (m *Manager) Publish(ctx context.Context, record Record) {
 	m.cond.Signal()
 }
 
+// PublishPartial enqueues a token increment for a request that is still streaming. It shares
+// the record queue, so plugins see every partial update of a request before its final record.
+func (m *Manager) PublishPartial(ctx context.Context, requestID string, deltaTokens int64) {
+	if m == nil || requestID == "" || deltaTokens <= 0 {
+		return
+	}
+	m.Start(context.Background())
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.queue = append(m.queue, queueItem{ctx: ctx, partial: &partialUsage{requestID: requestID, deltaTokens: deltaTokens}})
+	m.mu.Unlock()
+	m.cond.Signal()
+}
+
 func (m *Manager) run(ctx context.Context) {
+	defer close(m.done)
 	for {
 		m.mu.Lock()
 		for !m.closed && len(m.queue) == 0 {
@@ -150,6 +240,12 @@ func (m *Manager) dispatch(item queueItem) {
 		if plugin == nil {
 			continue
 		}
+		if item.partial != nil {
+			if partialPlugin, ok := plugin.(PartialPlugin); ok {
+				safeInvokePartial(partialPlugin, item.ctx, item.partial)
+			}
+			continue
+		}
 		safeInvoke(plugin, item.ctx, item.record)
 	}
 }
@@ -163,6 +259,15 @@ func safeInvoke(plugin Plugin, ctx context.Context, record Record) {
 	plugin.HandleUsage(ctx, record)
 }
 
+func safeInvokePartial(plugin PartialPlugin, ctx context.Context, partial *partialUsage) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("usage: plugin panic recovered: %v", r)
+		}
+	}()
+	plugin.HandlePartialUsage(ctx, partial.requestID, partial.deltaTokens)
+}
+
 var defaultManager = NewManager(512)
 
 // DefaultManager returns the global usage manager instance.
@@ -174,8 +279,34 @@ func RegisterPlugin(plugin Plugin) { DefaultManager().Register(plugin) }
 // PublishRecord publishes a record using the default manager.
 func PublishRecord(ctx context.Context, record Record) { DefaultManager().Publish(ctx, record) }
 
+// PublishPartial publishes a partial token increment using the default manager.
+func PublishPartial(ctx context.Context, requestID string, deltaTokens int64) {
+	DefaultManager().PublishPartial(ctx, requestID, deltaTokens)
+}
+
+// PublishRefresh emits a usage record for a single token refresh attempt. The record is
+// keyed by provider and carries no tokens; usage statistics count it apart from requests.
+func PublishRefresh(ctx context.Context, provider, authID, authIndex string, requestedAt time.Time, failed bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	PublishRecord(ctx, Record{
+		Provider:    provider,
+		Model:       RefreshModel,
+		APIKey:      provider,
+		AuthID:      authID,
+		AuthIndex:   authIndex,
+		Source:      RefreshSource,
+		RequestedAt: requestedAt,
+		Failed:      failed,
+	})
+}
+
 // StartDefault starts the default manager's dispatcher.
 func StartDefault(ctx context.Context) { DefaultManager().Start(ctx) }
 
 // StopDefault stops the default manager's dispatcher.
 func StopDefault() { DefaultManager().Stop() }
+
+// ShutdownDefault stops the default manager and waits for queued records to be delivered.
+func ShutdownDefault(ctx context.Context) error { return DefaultManager().Shutdown(ctx) }